@@ -0,0 +1,81 @@
+// Command migrate manages the database schema outside of the service startup.
+//
+// Usage:
+//
+//	go run ./cmd/migrate              apply all pending migrations
+//	go run ./cmd/migrate -down 1      roll back the last migration
+//	go run ./cmd/migrate -status      list migrations and whether they are applied
+//	go run ./cmd/migrate -dry-run     log the statements without executing them
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/mystardustcaptain/mattodo/pkg/config"
+	"github.com/mystardustcaptain/mattodo/pkg/database"
+)
+
+func main() {
+	down := flag.Int("down", 0, "number of migrations to roll back")
+	status := flag.Bool("status", false, "list migrations and whether they are applied")
+	dryRun := flag.Bool("dry-run", false, "log the statements without executing them")
+	flag.Parse()
+
+	config.Load()
+
+	dialect, err := database.DialectFor(os.Getenv("DB_TYPE"))
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	db, err := database.OpenDB(os.Getenv("DB_TYPE"), os.Getenv("DB_PATH"))
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	defer db.Close()
+
+	migrator, err := database.NewMigrator(db, dialect)
+	if err != nil {
+		log.Fatalf("Failed to load migrations: %s", err.Error())
+	}
+	migrator.DryRun = *dryRun
+
+	switch {
+	case *status:
+		statuses, err := migrator.Status()
+		if err != nil {
+			log.Fatalf("Failed to read migration status: %s", err.Error())
+		}
+		for _, s := range statuses {
+			if s.Applied {
+				log.Printf("%04d_%s applied at %s", s.Version, s.Name, s.AppliedAt)
+			} else {
+				log.Printf("%04d_%s pending", s.Version, s.Name)
+			}
+		}
+
+	case *down > 0:
+		done, err := migrator.Down(*down)
+		if err != nil {
+			log.Fatalf("Failed to roll back migrations: %s", err.Error())
+		}
+		log.Printf("%d migration(s) rolled back%s", len(done), dryRunNote(*dryRun))
+
+	default:
+		done, err := migrator.Up()
+		if err != nil {
+			log.Fatalf("Failed to apply migrations: %s", err.Error())
+		}
+		log.Printf("%d migration(s) applied%s", len(done), dryRunNote(*dryRun))
+	}
+}
+
+// dryRunNote reminds in the summary that nothing was executed.
+func dryRunNote(dryRun bool) string {
+	if dryRun {
+		return " (dry run, nothing executed)"
+	}
+	return ""
+}
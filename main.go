@@ -8,21 +8,66 @@ import (
 	"os/signal"
 	"syscall"
 	"time"
+	// The time zones of the due today filter are embedded, the image has no tzdata
+	_ "time/tzdata"
 
-	_ "github.com/mystardustcaptain/mattodo/pkg/config"
+	"github.com/mystardustcaptain/mattodo/pkg/auth"
+	"github.com/mystardustcaptain/mattodo/pkg/config"
+	"github.com/mystardustcaptain/mattodo/pkg/controller"
 	"github.com/mystardustcaptain/mattodo/pkg/database"
+	"github.com/mystardustcaptain/mattodo/pkg/export"
+	"github.com/mystardustcaptain/mattodo/pkg/model"
 	"github.com/mystardustcaptain/mattodo/pkg/route"
 )
 
 func main() {
 	// Read configuration
+	config.Load()
 	port := os.Getenv("SERVICE_PORT")
 
-	// Initialize database
-	db := database.InitDB(os.Getenv("DB_TYPE"), os.Getenv("DB_PATH"))
+	// Built-in providers, e.g. GitHub
+	if err := auth.RegisterProvidersFromEnv(); err != nil {
+		log.Fatalf("Failed to register providers: %s\n", err)
+	}
+
+	// OpenID Connect providers configured next to the built-in ones
+	if err := auth.RegisterOIDCProvidersFromEnv(); err != nil {
+		log.Fatalf("Failed to register OIDC providers: %s\n", err)
+	}
+
+	// Initialize storage
+	c := initController(os.Getenv("DB_TYPE"), os.Getenv("DB_PATH"))
+
+	// Accounts deleted by their user can be restored for a while, e.g. DELETION_GRACE_PERIOD=72h
+	if gracePeriod := os.Getenv("DELETION_GRACE_PERIOD"); gracePeriod != "" {
+		d, err := time.ParseDuration(gracePeriod)
+		if err != nil {
+			log.Fatalf("Invalid DELETION_GRACE_PERIOD: %s\n", err)
+		}
+		c.DeletionGracePeriod = d
+	}
+
+	// Issuer and audience of the access tokens, checked by other services verifying them too
+	c.Auth.Issuer = os.Getenv("JWT_ISSUER")
+	c.Auth.Audience = os.Getenv("JWT_AUDIENCE")
+
+	// Browser front ends get the tokens in cookies after a login, e.g. SESSION_COOKIES=true
+	c.SessionCookies = os.Getenv("SESSION_COOKIES") == "true"
+	c.PostLoginURL = os.Getenv("POST_LOGIN_URL")
+
+	// The first admin, who can then give the role to others
+	c.BootstrapAdminEmail = os.Getenv("BOOTSTRAP_ADMIN_EMAIL")
+
+	// Keep the revocation list pruned and in sync with the other instances
+	syncCtx, stopSync := context.WithCancel(context.Background())
+	defer stopSync()
+	go c.Auth.Revocations.Sync(syncCtx, auth.DefaultRevocationSyncInterval)
+	go c.Auth.Keys.Sync(syncCtx, auth.DefaultKeySyncInterval)
+	go purgeDeletedUsers(syncCtx, c, time.Hour)
+	go c.Exports.PruneEvery(syncCtx, export.DefaultPruneInterval)
 
 	// Initialize router
-	r := route.InitializeRoutes(db)
+	r := route.InitializeRoutes(c)
 
 	// Create a new server
 	server := &http.Server{
@@ -58,3 +103,74 @@ func main() {
 
 	log.Println("Server gracefully stopped")
 }
+
+// initController creates the controller on top of the configured storage.
+// DB_TYPE=memory keeps everything in memory, handy for demo instances, data is lost on restart.
+// Other DB_TYPE values are handed to the database package.
+func initController(dbType string, dbPath string) *controller.Controller {
+	if dbType == "memory" {
+		log.Println("Using in-memory storage, data will be lost on shutdown")
+		c := controller.NewController(model.NewMemoryTodoStore(), model.NewMemoryUserStore())
+		c.Auth.Keys = loadKeys(model.NewMemorySigningKeyStore())
+		return c
+	}
+
+	// Initialize database
+	db := database.InitDB(dbType, dbPath)
+	dialect := database.Dialect(dbType)
+
+	c := controller.NewController(
+		&model.TodoItemCollection{DB: db, Dialect: dialect},
+		&model.UserCollection{DB: db, Dialect: dialect},
+	)
+
+	// Keep the pending logins, the refresh tokens and the revocations in the database so that any instance can use them
+	c.States = auth.NewLoginStates(&model.OAuthStateCollection{DB: db, Dialect: dialect})
+	c.RefreshTokens = auth.NewRefreshTokens(&model.RefreshTokenCollection{DB: db, Dialect: dialect})
+
+	// Revoked tokens must be refused right from the start, personal access tokens are looked up on every use
+	revocations := auth.NewRevocations(&model.TokenRevocationCollection{DB: db, Dialect: dialect}, auth.DefaultLeeway)
+	if err := revocations.Load(); err != nil {
+		log.Fatalf("Failed to load revoked tokens: %s\n", err)
+	}
+	c.Auth = &auth.Authenticator{
+		Keys:         loadKeys(&model.SigningKeyCollection{DB: db, Dialect: dialect}),
+		Leeway:       auth.DefaultLeeway,
+		Revocations:  revocations,
+		AccessTokens: auth.NewPersonalAccessTokens(&model.PersonalAccessTokenCollection{DB: db, Dialect: dialect}),
+		Users:        c.Users,
+	}
+
+	return c
+}
+
+// loadKeys loads the keys signing the access tokens configured by JWT_ALGORITHM, see auth.KeySetFromEnv.
+// The service refuses to start with a weak SIGNING_KEY.
+func loadKeys(store model.SigningKeyStore) *auth.KeySet {
+	keys, err := auth.KeySetFromEnv(store)
+	if err != nil {
+		log.Fatalf("Invalid signing keys: %s\n", err)
+	}
+	if err := keys.Load(); err != nil {
+		log.Fatalf("Failed to load signing keys: %s\n", err)
+	}
+
+	return keys
+}
+
+// purgeDeletedUsers deletes the accounts whose grace period ended, every interval until ctx is done.
+func purgeDeletedUsers(ctx context.Context, c *controller.Controller, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := c.PurgeDeletedUsers(time.Now()); err != nil {
+				log.Printf("Failed to purge deleted users: %s\n", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mystardustcaptain/mattodo/pkg/model"
+)
+
+// PersonalAccessTokenPrefix starts every personal access token,
+// telling them apart from the JWTs and making them easy to spot by secret scanners
+const PersonalAccessTokenPrefix = "mattodo_pat_"
+
+// Scopes a personal access token can be granted, each route accepting them requires one.
+// Scopes are independent, todo:write does not grant todo:read.
+const (
+	ScopeTodoRead  = "todo:read"
+	ScopeTodoWrite = "todo:write"
+)
+
+// Scopes are all the scopes a personal access token can be granted.
+var Scopes = []string{ScopeTodoRead, ScopeTodoWrite}
+
+// MaxPersonalAccessTokenNameLength is the maximum number of characters of the name of a personal access token.
+const MaxPersonalAccessTokenNameLength = 100
+
+// personalAccessTokenTouchInterval is how often the last use of a personal access token is recorded,
+// not to write on every request
+const personalAccessTokenTouchInterval = time.Minute
+
+// ErrInvalidAccessToken is returned when a personal access token is unknown, revoked or expired.
+var ErrInvalidAccessToken = errors.New("invalid personal access token")
+
+// PersonalAccessTokens issues and authenticates the personal access tokens of the users.
+type PersonalAccessTokens struct {
+	Store model.PersonalAccessTokenStore
+}
+
+// NewPersonalAccessTokens returns PersonalAccessTokens keeping the tokens in the given store.
+func NewPersonalAccessTokens(store model.PersonalAccessTokenStore) *PersonalAccessTokens {
+	return &PersonalAccessTokens{Store: store}
+}
+
+// Issue mints a personal access token for a User of a given userID, granted the scopes until expiresAt,
+// or forever if nil.
+// Returns the token to hand to the user, only its hash is stored so it cannot be shown again,
+// or a *model.ValidationError if the name, the scopes or the expiry are invalid.
+func (pt *PersonalAccessTokens) Issue(userID int, name string, scopes []string, expiresAt *time.Time) (string, *model.PersonalAccessToken, error) {
+	now := time.Now()
+
+	name = strings.TrimSpace(name)
+	verr := &model.ValidationError{}
+	if name == "" {
+		verr.Add("name", "must not be empty")
+	} else if utf8.RuneCountInString(name) > MaxPersonalAccessTokenNameLength {
+		verr.Add("name", fmt.Sprintf("must be at most %d characters", MaxPersonalAccessTokenNameLength))
+	}
+	granted, err := validateScopes(scopes)
+	if err != nil {
+		verr.Add("scopes", err.Error())
+	}
+	if expiresAt != nil && !expiresAt.After(now) {
+		verr.Add("expires_at", "must be in the future")
+	}
+	if len(verr.Fields) > 0 {
+		return "", nil, verr
+	}
+
+	secret, err := randomString(32)
+	if err != nil {
+		log.Printf("Failed to generate personal access token: %s\n", err.Error())
+		return "", nil, err
+	}
+	token := PersonalAccessTokenPrefix + secret
+
+	record := &model.PersonalAccessToken{
+		UserID:    userID,
+		Name:      name,
+		TokenHash: hashString(token),
+		Scopes:    granted,
+		ExpiresAt: expiresAt,
+		CreatedAt: now,
+	}
+	if err := pt.Store.CreatePersonalAccessToken(record); err != nil {
+		log.Printf("Failed to store personal access token: %s\n", err.Error())
+		return "", nil, err
+	}
+
+	return token, record, nil
+}
+
+// Authenticate returns the personal access token record of a token,
+// or ErrInvalidAccessToken if it is unknown, revoked or expired.
+func (pt *PersonalAccessTokens) Authenticate(token string) (*model.PersonalAccessToken, error) {
+	now := time.Now()
+
+	record, err := pt.Store.GetPersonalAccessTokenByHash(hashString(token))
+	if errors.Is(err, model.ErrNotFound) {
+		return nil, ErrInvalidAccessToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if record.ExpiresAt != nil && now.After(*record.ExpiresAt) {
+		return nil, ErrInvalidAccessToken
+	}
+
+	if record.LastUsedAt == nil || now.Sub(*record.LastUsedAt) >= personalAccessTokenTouchInterval {
+		// Only informative, the request goes on if it cannot be recorded
+		if err := pt.Store.TouchPersonalAccessToken(record.ID, now); err != nil {
+			log.Printf("Failed to record use of personal access token %d: %s\n", record.ID, err.Error())
+		} else {
+			record.LastUsedAt = &now
+		}
+	}
+
+	return record, nil
+}
+
+// RevokeAll revokes every personal access token of a User of a given userID.
+func (pt *PersonalAccessTokens) RevokeAll(userID int) error {
+	tokens, err := pt.Store.GetPersonalAccessTokens(userID)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range tokens {
+		// Revoked concurrently by the user, nothing left to do
+		if err := pt.Store.DeletePersonalAccessToken(userID, t.ID); err != nil && !errors.Is(err, model.ErrNotFound) {
+			log.Printf("Failed to revoke personal access token %d: %s\n", t.ID, err.Error())
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateScopes checks that the scopes exist and returns them without duplicates
+func validateScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return nil, errors.New("must not be empty")
+	}
+
+	granted := []string{}
+	for _, scope := range Scopes {
+		for _, s := range scopes {
+			if s == scope {
+				granted = append(granted, scope)
+				break
+			}
+		}
+	}
+
+	for _, s := range scopes {
+		known := false
+		for _, scope := range Scopes {
+			known = known || s == scope
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown scope %q, must be among %s", s, strings.Join(Scopes, ", "))
+		}
+	}
+
+	return granted, nil
+}
@@ -0,0 +1,159 @@
+package auth_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mystardustcaptain/mattodo/pkg/auth"
+	"github.com/mystardustcaptain/mattodo/pkg/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// serveScopedWithToken sends a request with the given token through a route requiring the scope
+// and returns the status code
+func serveScopedWithToken(a *auth.Authenticator, scope string, token string) int {
+	handler := a.RequireScope(scope, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/todo", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	handler.ServeHTTP(w, r)
+
+	return w.Code
+}
+
+// TestPersonalAccessTokens_IssueAndAuthenticate tests that an issued token authenticates its owner
+// with its scopes, and that only its hash is stored.
+func TestPersonalAccessTokens_IssueAndAuthenticate(t *testing.T) {
+	/// Arrange
+	///
+	pt := auth.NewPersonalAccessTokens(model.NewMemoryPersonalAccessTokenStore())
+
+	/// Act
+	///
+	token, issued, err := pt.Issue(2, "  CI  ", []string{auth.ScopeTodoWrite, auth.ScopeTodoRead, auth.ScopeTodoRead}, nil)
+	record, authErr := pt.Authenticate(token)
+
+	/// Assert
+	///
+	assert.NoError(t, err, "Expected no error but got one")
+	assert.NoError(t, authErr, "Expected no error but got one")
+	assert.True(t, strings.HasPrefix(token, auth.PersonalAccessTokenPrefix))
+	assert.NotContains(t, issued.TokenHash, token, "Expected the token not to be stored")
+	assert.Equal(t, "CI", issued.Name)
+	assert.Equal(t, []string{auth.ScopeTodoRead, auth.ScopeTodoWrite}, issued.Scopes)
+	assert.Equal(t, 2, record.UserID)
+	assert.Equal(t, issued.ID, record.ID)
+	assert.NotNil(t, record.LastUsedAt, "Expected the use to be recorded")
+}
+
+// TestPersonalAccessTokens_IssueRejectsInvalidRequests tests that Issue refuses
+// empty names, unknown scopes and past expiries.
+func TestPersonalAccessTokens_IssueRejectsInvalidRequests(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name      string
+		tokenName string
+		scopes    []string
+		expiresAt *time.Time
+		field     string
+	}{
+		{"empty name", " ", []string{auth.ScopeTodoRead}, nil, "name"},
+		{"too long name", strings.Repeat("a", auth.MaxPersonalAccessTokenNameLength+1), []string{auth.ScopeTodoRead}, nil, "name"},
+		{"no scopes", "CI", nil, nil, "scopes"},
+		{"unknown scope", "CI", []string{"admin"}, nil, "scopes"},
+		{"past expiry", "CI", []string{auth.ScopeTodoRead}, &past, "expires_at"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			/// Arrange
+			///
+			pt := auth.NewPersonalAccessTokens(model.NewMemoryPersonalAccessTokenStore())
+
+			/// Act
+			///
+			_, _, err := pt.Issue(2, tt.tokenName, tt.scopes, tt.expiresAt)
+
+			/// Assert
+			///
+			var verr *model.ValidationError
+			assert.True(t, errors.As(err, &verr), "Expected a validation error but got %v", err)
+			if verr != nil {
+				assert.Contains(t, verr.Fields, tt.field)
+			}
+		})
+	}
+}
+
+// TestPersonalAccessTokens_RejectsUnknownExpiredAndRevoked tests that Authenticate refuses
+// tokens that were never issued, have expired or were revoked.
+func TestPersonalAccessTokens_RejectsUnknownExpiredAndRevoked(t *testing.T) {
+	/// Arrange
+	///
+	store := model.NewMemoryPersonalAccessTokenStore()
+	pt := auth.NewPersonalAccessTokens(store)
+
+	soon := time.Now().Add(50 * time.Millisecond)
+	expired, _, _ := pt.Issue(2, "expired", []string{auth.ScopeTodoRead}, &soon)
+	revoked, record, _ := pt.Issue(2, "revoked", []string{auth.ScopeTodoRead}, nil)
+	store.DeletePersonalAccessToken(2, record.ID)
+	time.Sleep(100 * time.Millisecond)
+
+	/// Act & Assert
+	///
+	for _, token := range []string{auth.PersonalAccessTokenPrefix + "unknown", expired, revoked} {
+		_, err := pt.Authenticate(token)
+		assert.ErrorIs(t, err, auth.ErrInvalidAccessToken)
+	}
+}
+
+// TestRequireScope_EnforcesScopesOfPersonalAccessTokens tests that a personal access token
+// is only accepted on routes requiring one of its scopes, never on session-only routes,
+// while access tokens of a login are accepted everywhere.
+func TestRequireScope_EnforcesScopesOfPersonalAccessTokens(t *testing.T) {
+	/// Arrange
+	///
+	a := &auth.Authenticator{
+		Keys:         auth.NewKeySet(model.NewMemorySigningKeyStore(), auth.AlgorithmEdDSA),
+		Revocations:  auth.NewRevocations(model.NewMemoryTokenRevocationStore(), auth.DefaultLeeway),
+		AccessTokens: auth.NewPersonalAccessTokens(model.NewMemoryPersonalAccessTokenStore()),
+	}
+	readOnly, _, _ := a.AccessTokens.Issue(2, "read only", []string{auth.ScopeTodoRead}, nil)
+	session, _ := a.CreateToken("alice@example.com", 2, 1)
+
+	/// Act & Assert
+	///
+	assert.Equal(t, http.StatusOK, serveScopedWithToken(a, auth.ScopeTodoRead, readOnly))
+	assert.Equal(t, http.StatusForbidden, serveScopedWithToken(a, auth.ScopeTodoWrite, readOnly))
+	assert.Equal(t, http.StatusForbidden, serveWithToken(a, readOnly), "Expected session-only routes to refuse it")
+	assert.Equal(t, http.StatusUnauthorized, serveScopedWithToken(a, auth.ScopeTodoRead, auth.PersonalAccessTokenPrefix+"unknown"))
+	assert.Equal(t, http.StatusOK, serveScopedWithToken(a, auth.ScopeTodoWrite, session))
+	assert.Equal(t, http.StatusOK, serveWithToken(a, session))
+}
+
+// TestRequireScope_RefusesPersonalAccessTokensWhenDisabled tests that personal access tokens
+// are refused by an Authenticator without a store for them.
+func TestRequireScope_RefusesPersonalAccessTokensWhenDisabled(t *testing.T) {
+	/// Arrange
+	///
+	pt := auth.NewPersonalAccessTokens(model.NewMemoryPersonalAccessTokenStore())
+	token, _, _ := pt.Issue(2, "CI", []string{auth.ScopeTodoRead}, nil)
+
+	a := &auth.Authenticator{Keys: auth.NewKeySet(model.NewMemorySigningKeyStore(), auth.AlgorithmEdDSA), Revocations: auth.NewRevocations(model.NewMemoryTokenRevocationStore(), auth.DefaultLeeway)}
+
+	/// Act
+	///
+	code := serveScopedWithToken(a, auth.ScopeTodoRead, token)
+
+	/// Assert
+	///
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
@@ -5,22 +5,15 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
-	"os"
 	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
-	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/facebook"
-	"golang.org/x/oauth2/github"
-	"golang.org/x/oauth2/google"
-
-	_ "github.com/mystardustcaptain/mattodo/pkg/config"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/mystardustcaptain/mattodo/pkg/model"
 )
 
 // UserInfo represents the user's information returned from the OAuth provider
@@ -36,60 +29,133 @@ type contextKey string
 // userIDKey is the key for userID in context
 const ContextUserIDKey contextKey = "userID"
 
-// OAuthConfigurations for multiple providers
-var OAuthConfigs map[string]*oauth2.Config
-
-// Initialize OAuth configurations for multiple providers
-// required environment variables
-func init() {
-	OAuthConfigs = map[string]*oauth2.Config{
-		"google": {
-			RedirectURL:  os.Getenv("GOOGLE_REDIRECT_URL"),
-			ClientID:     os.Getenv("GOOGLE_CLIENT_ID"),
-			ClientSecret: os.Getenv("GOOGLE_CLIENT_SECRET"),
-			Scopes:       []string{"https://www.googleapis.com/auth/userinfo.email", "https://www.googleapis.com/auth/userinfo.profile"},
-			Endpoint:     google.Endpoint,
-		},
-		"facebook": {
-			RedirectURL:  os.Getenv("FACEBOOK_REDIRECT_URL"),
-			ClientID:     os.Getenv("FACEBOOK_CLIENT_ID"),
-			ClientSecret: os.Getenv("FACEBOOK_CLIENT_SECRET"),
-			Scopes:       []string{"email, name"},
-			Endpoint:     facebook.Endpoint,
-		},
-		"github": {
-			RedirectURL:  os.Getenv("GITHUB_REDIRECT_URL"),
-			ClientID:     os.Getenv("GITHUB_CLIENT_ID"),
-			ClientSecret: os.Getenv("GITHUB_CLIENT_SECRET"),
-			Scopes:       []string{"user:email"},
-			Endpoint:     github.Endpoint,
-		},
+// ContextTokenKey is the key for the *TokenInfo of the access token in context
+const ContextTokenKey contextKey = "token"
+
+// Claims are the claims of the access tokens.
+// The subject is the user ID, the issuer and the audience those of the Authenticator.
+type Claims struct {
+	jwt.RegisteredClaims
+	UserEmail string `json:"userEmail"`
+	UserID    int    `json:"userID"`
+	// Scopes limit the token to the routes requiring one of them, a token without is granted every scope
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// Validate checks the claims of the user and those required, once the registered claims are validated
+func (c *Claims) Validate() error {
+	if c.UserID <= 0 || c.UserEmail == "" {
+		return errors.New("userEmail or userID not found in the token")
+	}
+	if c.Subject != strconv.Itoa(c.UserID) {
+		return fmt.Errorf("subject %q is not the user %d", c.Subject, c.UserID)
+	}
+	// Required to tell whether the token was issued before its sessions were revoked
+	if c.IssuedAt == nil {
+		return errors.New("token without issue time")
 	}
+
+	return nil
 }
 
-// OAuthStateString is a randomly generated string to protect against CSRF attacks
-var OAuthStateString = "wzp-bdt*czm8GEQ9kuc"
+// TokenInfo is what the middleware learnt from a valid access token
+type TokenInfo struct {
+	ID        string // jti claim
+	UserID    int
+	Email     string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+
+	// PersonalAccessTokenID is set for personal access tokens, granted only their Scopes
+	PersonalAccessTokenID int
+	Scopes                []string
+
+	// Role is the role of the user when the request was made, set when the Authenticator looks up the users
+	Role string
+}
+
+// HasScope reports whether the token is granted the scope.
+// Access tokens of a login are granted every scope unless limited to some,
+// personal access tokens only theirs and never the empty scope of the routes not accepting them.
+func (t *TokenInfo) HasScope(scope string) bool {
+	if t.PersonalAccessTokenID == 0 && t.Scopes == nil {
+		return true
+	}
+
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Authenticator authenticates the requests to the routes requiring a user.
+type Authenticator struct {
+	// Keys signs the access tokens and verifies them
+	Keys *KeySet
+	// Issuer and Audience are the iss and aud claims of the access tokens, required to match.
+	// They default to DefaultIssuer and DefaultAudience.
+	Issuer   string
+	Audience string
+	// Leeway is the clock skew tolerated when checking exp, nbf and iat, e.g. between instances
+	Leeway time.Duration
+	// Revocations lists the access tokens revoked before their expiry, nil disables the check
+	Revocations *Revocations
+	// AccessTokens authenticates the personal access tokens, nil refuses them
+	AccessTokens *PersonalAccessTokens
+	// Users looks up the user of every request to refuse the suspended ones and learn their role,
+	// nil skips the check and grants no admin route
+	Users model.UserStore
+}
+
+// DefaultIssuer and DefaultAudience are the iss and aud claims of the access tokens if not configured
+const (
+	DefaultIssuer   = "mattodo"
+	DefaultAudience = "mattodo"
+)
+
+// DefaultLeeway is the clock skew tolerated by default when checking the times of the access tokens
+const DefaultLeeway = 30 * time.Second
+
+// issuer returns the iss claim of the access tokens
+func (a *Authenticator) issuer() string {
+	if a.Issuer == "" {
+		return DefaultIssuer
+	}
+	return a.Issuer
+}
+
+// audience returns the aud claim of the access tokens
+func (a *Authenticator) audience() string {
+	if a.Audience == "" {
+		return DefaultAudience
+	}
+	return a.Audience
+}
 
 // GetUserFromOAuthCode exchanges an OAuth code for a token, then fetches user information
-// provider: google, facebook, github
+// provider: name of a registered Provider, e.g. google, github
 // code: auth code returned from the OAuth provider
+// codeVerifier: PKCE verifier of the login attempt, proving the code is redeemed by whoever started the login
 // returns the user information or an error
-func GetUserFromOAuthCode(provider string, code string) (*UserInfo, error) {
-	config, ok := OAuthConfigs[provider]
+func GetUserFromOAuthCode(provider string, code string, codeVerifier string) (*UserInfo, error) {
+	p, ok := GetProvider(provider)
 	if !ok {
 		log.Printf("Unknown OAuth provider: %s\n", provider)
-		return nil, errors.New("unknown OAuth provider")
+		return nil, ErrUnknownProvider
 	}
 
 	// Exchange the OAuth code for a token
-	token, err := config.Exchange(context.Background(), code)
+	token, err := p.Exchange(context.Background(), code, codeVerifier)
 	if err != nil {
 		log.Printf("Failed to exchange token: %s\n", err.Error())
 		return nil, err
 	}
 
 	// Fetch user info from the OAuth provider
-	userInfo, err := fetchUserInfo(provider, token.AccessToken)
+	userInfo, err := p.FetchUser(context.Background(), token)
 	if err != nil {
 		log.Printf("Failed to fetch user info: %s\n", err.Error())
 		return nil, err
@@ -98,53 +164,42 @@ func GetUserFromOAuthCode(provider string, code string) (*UserInfo, error) {
 	return userInfo, nil
 }
 
-// fetchUserInfo retrieves the user information from the OAuth provider
-// provider: google, facebook, github
-// accessToken: token returned from the OAuth provider
-// returns the user information or an error
-func fetchUserInfo(provider, accessToken string) (*UserInfo, error) {
-	endpoint, err := getEndpoint(provider, accessToken)
-	if err != nil {
-		log.Printf("Failed to get endpoint: %s\n", err.Error())
-		return nil, err
+// CreateToken creates a JWT token for the user, signed with the current key
+// returns the token or an error
+// param userEmail: the user's email address
+// param userID: the user's ID in database
+// param hour: the validity of the token in hour, at most MaxTokenLifetime
+func (a *Authenticator) CreateToken(userEmail string, userID int, hour int) (string, error) {
+	validity := time.Hour * time.Duration(hour)
+	if validity > MaxTokenLifetime {
+		return "", fmt.Errorf("token validity %s exceeds %s", validity, MaxTokenLifetime)
 	}
 
-	userInfo, err := makeUserInfoRequest(endpoint, provider, accessToken)
+	// unique id of the token, so that it can be revoked on its own
+	jti, err := randomString(16)
 	if err != nil {
-		log.Printf("Failed to make user info request: %s\n", err.Error())
-		return nil, err
+		log.Printf("Failed to generate token id: %s\n", err.Error())
+		return "", err
 	}
 
-	if provider == "github" {
-		// Fetch additional email info, only required for GitHub
-		// As it is not included in the user info response
-		if err := fetchGitHubEmail(userInfo, accessToken); err != nil {
-			return nil, err
-		}
-
+	now := time.Now()
+	claims := &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Issuer:    a.issuer(),
+			Audience:  jwt.ClaimStrings{a.audience()},
+			Subject:   strconv.Itoa(userID),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			// token valid for x hour
+			ExpiresAt: jwt.NewNumericDate(now.Add(validity)),
+		},
+		// info about the user to be encoded in the token
+		UserEmail: userEmail,
+		UserID:    userID,
 	}
 
-	return userInfo, nil
-}
-
-// CreateToken creates a JWT token with the userEmail as the subject
-// returns the token or an error
-// param userEmail: the user's email address
-// param userID: the user's ID in database
-// param hour: the validity of the token in hour
-func CreateToken(userEmail string, userID int, hour int) (string, error) {
-	var mySigningKey = []byte(os.Getenv("SIGNING_KEY"))
-
-	// use HASH256 to sign the token
-	token := jwt.New(jwt.SigningMethodHS256)
-	claims := token.Claims.(jwt.MapClaims)
-	// token valid for x hour
-	claims["exp"] = time.Now().Add(time.Hour * time.Duration(hour)).Unix()
-	// info about the user to be encoded in the token
-	claims["userEmail"] = userEmail
-	claims["userID"] = userID
-
-	tokenString, err := token.SignedString(mySigningKey)
+	tokenString, err := a.Keys.Sign(claims)
 
 	if err != nil {
 		log.Printf("Failed to sign token: %s\n", err.Error())
@@ -154,218 +209,224 @@ func CreateToken(userEmail string, userID int, hour int) (string, error) {
 	return tokenString, nil
 }
 
-// ValidateTokenMiddleware validates the token from the Authorization header
-// every request with this middleware will require a valid token
+// ValidateTokenMiddleware validates the token from the Authorization header, or from the session cookie
+// every request with this middleware will require a valid token, not revoked
+// Requests authenticated by the session cookie that change state also require the CSRF token
+// Personal access tokens are refused, they can only be used on the routes requiring one of their scopes
 // Note: only appllies to routes that require authentication
-func ValidateTokenMiddleware(next http.Handler) http.Handler {
+func (a *Authenticator) ValidateTokenMiddleware(next http.Handler) http.Handler {
+	return a.authenticate("", next)
+}
+
+// RequireScope validates the token from the Authorization header like ValidateTokenMiddleware,
+// and accepts the personal access tokens granted the scope.
+// Access tokens of a login are granted every scope.
+func (a *Authenticator) RequireScope(scope string, next http.Handler) http.Handler {
+	return a.authenticate(scope, next)
+}
+
+// RequireAdmin validates the token from the Authorization header like ValidateTokenMiddleware,
+// and requires the user to be an admin. Personal access tokens are refused.
+func (a *Authenticator) RequireAdmin(next http.Handler) http.Handler {
+	return a.authenticate("", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info, ok := r.Context().Value(ContextTokenKey).(*TokenInfo)
+		if !ok || info.Role != model.RoleAdmin {
+			log.Printf("Admin route refused to a user who is not an admin\n")
+			respondForbidden(w, "Only admins can use this route")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}))
+}
+
+// authenticate is the middleware requiring a valid token, granted the scope for personal access tokens
+func (a *Authenticator) authenticate(scope string, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		tokenString := extractToken(r)
 
+		// Browsers authenticate with the session cookie instead, only ever holding an access token of a login
+		fromCookie := false
+		if tokenString == "" {
+			tokenString = sessionToken(r)
+			fromCookie = tokenString != ""
+		}
+
 		if tokenString == "" {
 			log.Printf("Authorization token is required\n")
-			http.Error(w, "Authorization token is required", http.StatusUnauthorized)
+			respondUnauthorized(w, "Authorization token is required")
 			return
 		}
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			// Validate the signing algorithm
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				log.Printf("Unexpected signing method: %v\n", token.Header["alg"])
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-
-			return []byte(os.Getenv("SIGNING_KEY")), nil
-		})
-
-		if err != nil {
-			log.Printf("Failed to parse token: %s\n", err.Error())
-			http.Error(w, "Invalid authorization token", http.StatusUnauthorized)
+		// The browser sends the cookie on requests made by other sites too
+		if fromCookie && !isSafeMethod(r.Method) && !VerifyCSRF(r) {
+			log.Printf("Missing or invalid CSRF token\n")
+			respondForbidden(w, "Missing or invalid CSRF token")
 			return
 		}
 
-		// The token is valid and not expired
-		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-			// Extract the user info from the token
-			userEmail := claims["userEmail"].(string)
-			userID := claims["userID"].(float64)
-
-			if userEmail == "" || userID <= 0 {
-				// Handle error: userEmail or userID not found in token
-				log.Printf("userEmail or userID not found in the token\n")
-				http.Error(w, "userEmail or userID not found in the token", http.StatusUnauthorized)
-				return
-			}
-
-			// Add the db userID to the request context
-			ctx := context.WithValue(r.Context(), ContextUserIDKey, int(userID))
-			next.ServeHTTP(w, r.WithContext(ctx))
-
+		var info *TokenInfo
+		var message string
+		if strings.HasPrefix(tokenString, PersonalAccessTokenPrefix) && !fromCookie {
+			info, message = a.personalAccessTokenInfo(tokenString)
 		} else {
-			log.Printf("Invalid authorization token\n")
-			http.Error(w, "Invalid authorization token", http.StatusUnauthorized)
+			info, message = a.accessTokenInfo(tokenString)
+		}
+		if info == nil {
+			respondUnauthorized(w, message)
 			return
 		}
-	})
-}
 
-// extractToken extracts the token from the Authorization header
-// expected format:
-// Authorization: Bearer {token-body}
-func extractToken(r *http.Request) string {
-	bearerToken := r.Header.Get("Authorization")
+		if !info.HasScope(scope) {
+			log.Printf("Token of user %d (personal access token %d) lacks scope %q\n", info.UserID, info.PersonalAccessTokenID, scope)
+			if scope == "" && info.PersonalAccessTokenID != 0 {
+				respondForbidden(w, "Personal access tokens cannot be used on this route")
+			} else if scope == "" {
+				respondForbidden(w, "Tokens limited to scopes cannot be used on this route")
+			} else {
+				respondForbidden(w, "The token is not granted the "+scope+" scope")
+			}
+			return
+		}
 
-	strArr := strings.Split(bearerToken, " ")
-	if len(strArr) == 2 {
-		return strArr[1]
-	}
+		// The token may have been issued before the account was suspended
+		if a.Users != nil {
+			user, err := a.Users.GetUserByID(info.UserID)
+			if errors.Is(err, model.ErrNotFound) {
+				log.Printf("Token of unknown user %d\n", info.UserID)
+				respondUnauthorized(w, "Invalid authorization token")
+				return
+			}
+			if err != nil {
+				log.Printf("Failed to get user %d: %s\n", info.UserID, err.Error())
+				respondInternalError(w)
+				return
+			}
+			if user.IsSuspended() {
+				log.Printf("Token of suspended user %d\n", info.UserID)
+				respondForbidden(w, "This account is suspended")
+				return
+			}
+			info.Role = user.Role
+		}
 
-	return ""
+		// Add the db userID and the token to the request context
+		ctx := context.WithValue(r.Context(), ContextUserIDKey, info.UserID)
+		ctx = context.WithValue(ctx, ContextTokenKey, info)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
 }
 
-// UserInfo UnmarshalJSON implements the json.Unmarshaler interface to handle the ID field based on its type
-// This will be called when unmarshaling the JSON data into the UserInfo struct instead of the default behavior
-// The ID field can be a string or a float64
-// Google: string
-// Github: int
-func (u *UserInfo) UnmarshalJSON(data []byte) error {
-	// Anonymous struct to avoid recursion into UnmarshalJSON
-	var raw struct {
-		ID    interface{} `json:"id"`
-		Email string      `json:"email"`
-		Name  string      `json:"name"`
+// accessTokenInfo validates an access token (JWT) issued at login, not revoked.
+// Returns nil with the reason to report if it is not valid.
+func (a *Authenticator) accessTokenInfo(tokenString string) (*TokenInfo, string) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, a.Keys.Keyfunc,
+		// The key of the kid header validates the signing algorithm too, never none
+		jwt.WithValidMethods([]string{AlgorithmEdDSA, AlgorithmRS256, AlgorithmHS256}),
+		jwt.WithIssuer(a.issuer()),
+		jwt.WithAudience(a.audience()),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(a.Leeway),
+	)
+	if err != nil {
+		log.Printf("Failed to parse token: %s\n", err.Error())
+		return nil, "Invalid authorization token"
 	}
 
-	if err := json.Unmarshal(data, &raw); err != nil {
-		log.Printf("Failed to unmarshal JSON: %s\n", err.Error())
-		return err
+	info := &TokenInfo{
+		ID:        claims.ID,
+		UserID:    claims.UserID,
+		Email:     claims.UserEmail,
+		IssuedAt:  claims.IssuedAt.Time,
+		ExpiresAt: claims.ExpiresAt.Time,
+		Scopes:    claims.Scopes,
 	}
 
-	// Handle the ID based on its type
-	switch value := raw.ID.(type) {
-	case float64: // Numeric ID from GitHub
-		u.ID = strconv.FormatFloat(value, 'f', -1, 64)
-	case string: // String ID (e.g., from Google)
-		u.ID = value
-	default:
-		log.Printf("ID type is not valid: %T\n", value)
-		return errors.New("id type is not valid")
+	if a.Revocations != nil && a.Revocations.IsRevoked(info) {
+		log.Printf("Revoked token used for user %d\n", info.UserID)
+		return nil, "Authorization token has been revoked"
 	}
 
-	// Assign other fields
-	u.Email = raw.Email
-	u.Name = raw.Name
-
-	return nil
-}
-
-// IsEmailValid checks if the email address is valid
-// returns true if valid, false otherwise
-func IsEmailValid(email string) bool {
-	// Regular expression for basic email validation
-	emailRegex := regexp.MustCompile(`^[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,4}$`)
-
-	return emailRegex.MatchString(email)
+	return info, ""
 }
 
-// getEndpoint - Returns the API endpoint for the given provider.
-func getEndpoint(provider, accessToken string) (string, error) {
-	var endpoint string
-
-	switch provider {
-	case "google":
-		endpoint = "https://www.googleapis.com/oauth2/v2/userinfo"
-	case "facebook":
-		endpoint = "https://graph.facebook.com/me?fields=id,name,email"
-	case "github":
-		endpoint = "https://api.github.com/user"
-	default:
-		return "", errors.New("unknown OAuth provider for user info")
+// personalAccessTokenInfo authenticates a personal access token.
+// Returns nil with the reason to report if it is not valid.
+func (a *Authenticator) personalAccessTokenInfo(tokenString string) (*TokenInfo, string) {
+	if a.AccessTokens == nil {
+		log.Printf("Personal access token used while they are disabled\n")
+		return nil, "Invalid authorization token"
 	}
 
-	return endpoint, nil
-}
-
-// makeUserInfoRequest - Makes the HTTP request to fetch user info.
-func makeUserInfoRequest(endpoint, provider, accessToken string) (*UserInfo, error) {
-	// Create a new request
-	req, err := http.NewRequest("GET", endpoint, nil)
+	record, err := a.AccessTokens.Authenticate(tokenString)
 	if err != nil {
-		log.Printf("Failed to create request: %s\n", err.Error())
-		return nil, err
-	}
-
-	// Set the Authorization header for GitHub
-	if provider == "github" {
-		req.Header.Set("Authorization", "token "+accessToken)
-	} else {
-		// For others, use access token in query params
-		q := req.URL.Query()
-		q.Add("access_token", accessToken)
-		req.URL.RawQuery = q.Encode()
+		log.Printf("Failed to authenticate personal access token: %s\n", err.Error())
+		return nil, "Invalid authorization token"
 	}
 
-	// Make the HTTP request
-	client := &http.Client{}
-	response, err := client.Do(req)
-	if err != nil {
-		log.Printf("Failed to make request: %s\n", err.Error())
-		return nil, err
-	}
-	defer response.Body.Close()
+	return &TokenInfo{
+		UserID:                record.UserID,
+		IssuedAt:              record.CreatedAt,
+		PersonalAccessTokenID: record.ID,
+		Scopes:                record.Scopes,
+	}, ""
+}
 
-	// Read the response body
-	data, err := io.ReadAll(response.Body)
-	if err != nil {
-		log.Printf("Failed to read response body: %s\n", err.Error())
-		return nil, err
-	}
+// respondUnauthorized responds with a 401 in the same error envelope as the controllers
+// {"error": {"code": "unauthorized", "message": "..."}}
+func respondUnauthorized(w http.ResponseWriter, message string) {
+	body, _ := json.Marshal(map[string]interface{}{
+		"error": map[string]string{"code": "unauthorized", "message": message},
+	})
 
-	// For logging purpose
-	log.Printf("Response body: %s\n", string(data))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	w.Write(body)
+}
 
-	// Unmarshal the JSON data into the UserInfo struct
-	var userInfo UserInfo
-	if err := json.Unmarshal(data, &userInfo); err != nil {
-		log.Printf("Failed to unmarshal JSON: %s\n", err.Error())
-		return nil, err
-	}
+// respondForbidden responds with a 403 in the same error envelope as the controllers
+func respondForbidden(w http.ResponseWriter, message string) {
+	body, _ := json.Marshal(map[string]interface{}{
+		"error": map[string]string{"code": "forbidden", "message": message},
+	})
 
-	return &userInfo, nil
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	w.Write(body)
 }
 
-// fetchGitHubEmail - Fetches the primary email for GitHub users.
-// Only the primary email is considered as the user's email.
-func fetchGitHubEmail(userInfo *UserInfo, accessToken string) error {
-	// Make the HTTP request
-	client := &http.Client{}
+// respondInternalError responds with a 500 in the same error envelope as the controllers
+func respondInternalError(w http.ResponseWriter) {
+	body, _ := json.Marshal(map[string]interface{}{
+		"error": map[string]string{"code": "internal", "message": "Internal server error"},
+	})
 
-	emailReq, _ := http.NewRequest("GET", "https://api.github.com/user/emails", nil)
-	emailReq.Header.Set("Authorization", "token "+accessToken)
-	emailResponse, err := client.Do(emailReq)
-	if err != nil {
-		log.Printf("Failed to fetch GitHub email: %s\n", err.Error())
-		return err
-	}
-	defer emailResponse.Body.Close()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusInternalServerError)
+	w.Write(body)
+}
 
-	var emails []struct {
-		Email    string `json:"email"`
-		Primary  bool   `json:"primary"`
-		Verified bool   `json:"verified"`
-	}
-	emailData, _ := io.ReadAll(emailResponse.Body)
+// extractToken extracts the token from the Authorization header
+// expected format:
+// Authorization: Bearer {token-body}
+func extractToken(r *http.Request) string {
+	bearerToken := r.Header.Get("Authorization")
 
-	log.Printf("Emails: %s", string(emailData))
+	strArr := strings.Split(bearerToken, " ")
+	if len(strArr) == 2 {
+		return strArr[1]
+	}
 
-	json.Unmarshal(emailData, &emails)
+	return ""
+}
 
-	//Process and find primary email
-	for _, email := range emails {
-		if email.Primary && email.Verified {
-			userInfo.Email = email.Email
-			break
-		}
-	}
+// IsEmailValid checks if the email address is valid
+// returns true if valid, false otherwise
+func IsEmailValid(email string) bool {
+	// Regular expression for basic email validation
+	emailRegex := regexp.MustCompile(`^[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,4}$`)
 
-	return nil
+	return emailRegex.MatchString(email)
 }
@@ -0,0 +1,238 @@
+package auth_test
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/mystardustcaptain/mattodo/pkg/auth"
+	"github.com/mystardustcaptain/mattodo/pkg/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// testSecret signs the tokens crafted by the tests, as a shared HS256 secret
+var testSecret = []byte(strings.Repeat("s", auth.MinHMACKeyLength))
+
+// newHMACAuthenticator returns an Authenticator verifying the tokens signed with testSecret
+func newHMACAuthenticator(t testing.TB) *auth.Authenticator {
+	keys, err := auth.NewHMACKeySet(testSecret)
+	if err != nil {
+		t.Fatalf("failed to create key set: %s", err)
+	}
+
+	return &auth.Authenticator{
+		Keys:        keys,
+		Revocations: auth.NewRevocations(model.NewMemoryTokenRevocationStore(), auth.DefaultLeeway),
+		Leeway:      auth.DefaultLeeway,
+	}
+}
+
+// signPayload signs a raw JSON payload with testSecret, whatever it holds
+func signPayload(payload string) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	signingString := header + "." + base64.RawURLEncoding.EncodeToString([]byte(payload))
+	signature, _ := jwt.SigningMethodHS256.Sign(signingString, testSecret)
+
+	return signingString + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// validClaims returns the claims of a token CreateToken would issue to user 2
+func validClaims() jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"jti":       "token-id",
+		"iss":       auth.DefaultIssuer,
+		"aud":       []string{auth.DefaultAudience},
+		"sub":       "2",
+		"iat":       now.Unix(),
+		"nbf":       now.Unix(),
+		"exp":       now.Add(time.Hour).Unix(),
+		"userEmail": "alice@example.com",
+		"userID":    2,
+	}
+}
+
+// TestCreateToken_IssuesTypedClaims tests that the access tokens carry the registered claims
+// and are accepted with the user in the context.
+func TestCreateToken_IssuesTypedClaims(t *testing.T) {
+	/// Arrange
+	///
+	a := newHMACAuthenticator(t)
+	a.Issuer = "https://todo.example.com"
+	a.Audience = "todo-api"
+
+	/// Act
+	///
+	token, err := a.CreateToken("alice@example.com", 2, 1)
+
+	/// Assert
+	///
+	assert.NoError(t, err, "Expected no error but got one")
+
+	claims := &auth.Claims{}
+	_, _, err = jwt.NewParser().ParseUnverified(token, claims)
+	assert.NoError(t, err, "Expected no error but got one")
+	assert.Equal(t, "https://todo.example.com", claims.Issuer)
+	assert.Equal(t, jwt.ClaimStrings{"todo-api"}, claims.Audience)
+	assert.Equal(t, "2", claims.Subject)
+	assert.NotEmpty(t, claims.ID)
+	assert.NotNil(t, claims.NotBefore)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), claims.ExpiresAt.Time, time.Minute)
+
+	info := tokenInfoOf(t, a, token)
+	assert.Equal(t, 2, info.UserID)
+	assert.Equal(t, "alice@example.com", info.Email)
+}
+
+// TestCreateToken_RefusesLongValidity tests that a token cannot outlive the keys verifying it.
+func TestCreateToken_RefusesLongValidity(t *testing.T) {
+	/// Arrange
+	///
+	a := newHMACAuthenticator(t)
+
+	/// Act
+	///
+	_, err := a.CreateToken("alice@example.com", 2, int(auth.MaxTokenLifetime/time.Hour)+1)
+
+	/// Assert
+	///
+	assert.Error(t, err, "Expected an error but got none")
+}
+
+// TestValidateTokenMiddleware_ValidatesClaims tests that the middleware checks the issuer, the audience,
+// the times with leeway and the claims of the user.
+func TestValidateTokenMiddleware_ValidatesClaims(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		tamper func(claims jwt.MapClaims)
+		code   int
+	}{
+		{"valid", func(claims jwt.MapClaims) {}, http.StatusOK},
+		{"audience as a string", func(claims jwt.MapClaims) { claims["aud"] = auth.DefaultAudience }, http.StatusOK},
+		{"expired within leeway", func(claims jwt.MapClaims) { claims["exp"] = now.Add(-10 * time.Second).Unix() }, http.StatusOK},
+		{"issued slightly in the future", func(claims jwt.MapClaims) { claims["iat"] = now.Add(10 * time.Second).Unix() }, http.StatusOK},
+		{"another issuer", func(claims jwt.MapClaims) { claims["iss"] = "https://evil.example.com" }, http.StatusUnauthorized},
+		{"no issuer", func(claims jwt.MapClaims) { delete(claims, "iss") }, http.StatusUnauthorized},
+		{"another audience", func(claims jwt.MapClaims) { claims["aud"] = []string{"another-api"} }, http.StatusUnauthorized},
+		{"no audience", func(claims jwt.MapClaims) { delete(claims, "aud") }, http.StatusUnauthorized},
+		{"expired", func(claims jwt.MapClaims) { claims["exp"] = now.Add(-time.Minute).Unix() }, http.StatusUnauthorized},
+		{"no expiry", func(claims jwt.MapClaims) { delete(claims, "exp") }, http.StatusUnauthorized},
+		{"not yet valid", func(claims jwt.MapClaims) { claims["nbf"] = now.Add(time.Minute).Unix() }, http.StatusUnauthorized},
+		{"issued in the future", func(claims jwt.MapClaims) { claims["iat"] = now.Add(time.Minute).Unix() }, http.StatusUnauthorized},
+		{"no issue time", func(claims jwt.MapClaims) { delete(claims, "iat") }, http.StatusUnauthorized},
+		{"subject of another user", func(claims jwt.MapClaims) { claims["sub"] = "3" }, http.StatusUnauthorized},
+		{"no email", func(claims jwt.MapClaims) { delete(claims, "userEmail") }, http.StatusUnauthorized},
+		{"user ID as a string", func(claims jwt.MapClaims) { claims["userID"] = "2" }, http.StatusUnauthorized},
+		{"email as a number", func(claims jwt.MapClaims) { claims["userEmail"] = 42 }, http.StatusUnauthorized},
+		{"expiry as a string", func(claims jwt.MapClaims) { claims["exp"] = "tomorrow" }, http.StatusUnauthorized},
+		{"limited to a scope", func(claims jwt.MapClaims) { claims["scopes"] = []string{auth.ScopeTodoRead} }, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			/// Arrange
+			///
+			a := newHMACAuthenticator(t)
+			claims := validClaims()
+			tt.tamper(claims)
+			token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(testSecret)
+
+			/// Act
+			///
+			code := serveWithToken(a, token)
+
+			/// Assert
+			///
+			assert.Equal(t, tt.code, code)
+		})
+	}
+}
+
+// TestRequireScope_AcceptsTokensLimitedToScope tests that an access token limited to scopes
+// is accepted on the routes requiring one of them only.
+func TestRequireScope_AcceptsTokensLimitedToScope(t *testing.T) {
+	/// Arrange
+	///
+	a := newHMACAuthenticator(t)
+	claims := validClaims()
+	claims["scopes"] = []string{auth.ScopeTodoRead}
+	token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(testSecret)
+
+	/// Act & Assert
+	///
+	assert.Equal(t, http.StatusOK, serveScopedWithToken(a, auth.ScopeTodoRead, token))
+	assert.Equal(t, http.StatusForbidden, serveScopedWithToken(a, auth.ScopeTodoWrite, token))
+}
+
+// serveFuzzedToken sends a request with the token through the middleware, failing on a status other than expected.
+// A panic fails the fuzz test by itself.
+func serveFuzzedToken(t *testing.T, a *auth.Authenticator, token string) {
+	handler := a.RequireScope(auth.ScopeTodoRead, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info, ok := r.Context().Value(auth.ContextTokenKey).(*auth.TokenInfo)
+		if !ok || info.UserID <= 0 {
+			t.Errorf("accepted token without a user: %q", token)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/todo", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	handler.ServeHTTP(w, r)
+
+	switch w.Code {
+	case http.StatusOK, http.StatusUnauthorized, http.StatusForbidden:
+	default:
+		t.Errorf("unexpected status %d for token %q", w.Code, token)
+	}
+}
+
+// FuzzValidateTokenMiddleware_MalformedTokens tests that malformed tokens never make the middleware panic.
+func FuzzValidateTokenMiddleware_MalformedTokens(f *testing.F) {
+	a := newHMACAuthenticator(f)
+	genuine, _ := a.CreateToken("alice@example.com", 2, 1)
+
+	f.Add(genuine)
+	f.Add("")
+	f.Add(".")
+	f.Add("..")
+	f.Add("a.b.c")
+	f.Add("eyJhbGciOiJub25lIn0.e30.")
+	f.Add(strings.Replace(genuine, ".", "..", 1))
+	f.Add(genuine[:len(genuine)/2])
+	f.Add(auth.PersonalAccessTokenPrefix)
+
+	f.Fuzz(func(t *testing.T, token string) {
+		serveFuzzedToken(t, a, token)
+	})
+}
+
+// FuzzValidateTokenMiddleware_CraftedClaims tests that validly signed tokens with claims of any shape
+// never make the middleware panic, e.g. a crafted token from a leaked key.
+func FuzzValidateTokenMiddleware_CraftedClaims(f *testing.F) {
+	a := newHMACAuthenticator(f)
+
+	now := time.Now().Unix()
+	f.Add(`{"iss":"mattodo","aud":"mattodo","sub":"2","iat":` + strconv.FormatInt(now, 10) + `,"exp":` + strconv.FormatInt(now+3600, 10) + `,"userEmail":"alice@example.com","userID":2}`)
+	f.Add(`{"userEmail":"alice@example.com","userID":"2"}`)
+	f.Add(`{"userEmail":null,"userID":null}`)
+	f.Add(`{"aud":5,"exp":"tomorrow","iat":[]}`)
+	f.Add(`{"exp":1e400}`)
+	f.Add(`{"scopes":"todo:read"}`)
+	f.Add(`{"scopes":[1,2]}`)
+	f.Add(`null`)
+	f.Add(`[]`)
+	f.Add(`"claims"`)
+	f.Add(``)
+
+	f.Fuzz(func(t *testing.T, payload string) {
+		serveFuzzedToken(t, a, signPayload(payload))
+	})
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"context"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/bitbucket"
+)
+
+// BitbucketProvider signs users in with their Bitbucket Cloud account.
+type BitbucketProvider struct {
+	OAuth2Provider
+	APIURL string
+}
+
+// NewBitbucketProvider returns the Bitbucket provider for the given client.
+func NewBitbucketProvider(cfg ProviderConfig) *BitbucketProvider {
+	return &BitbucketProvider{
+		OAuth2Provider: OAuth2Provider{
+			ProviderName: "bitbucket",
+			Config: &oauth2.Config{
+				RedirectURL:  cfg.RedirectURL,
+				ClientID:     cfg.ClientID,
+				ClientSecret: cfg.ClientSecret,
+				Scopes:       []string{"account", "email"},
+				Endpoint:     bitbucket.Endpoint,
+			},
+		},
+		APIURL: "https://api.bitbucket.org/2.0",
+	}
+}
+
+// FetchUser reads the Bitbucket account of the user.
+// The account is identified by its UUID, the email is the primary one, only if confirmed.
+func (p *BitbucketProvider) FetchUser(ctx context.Context, token *oauth2.Token) (*UserInfo, error) {
+	var account struct {
+		UUID        string `json:"uuid"`
+		Username    string `json:"username"`
+		DisplayName string `json:"display_name"`
+	}
+	if err := getJSON(ctx, p.APIURL+"/user", token.AccessToken, &account); err != nil {
+		return nil, err
+	}
+
+	var emails struct {
+		Values []struct {
+			Email       string `json:"email"`
+			IsPrimary   bool   `json:"is_primary"`
+			IsConfirmed bool   `json:"is_confirmed"`
+		} `json:"values"`
+	}
+	if err := getJSON(ctx, p.APIURL+"/user/emails", token.AccessToken, &emails); err != nil {
+		return nil, err
+	}
+
+	userInfo := &UserInfo{ID: account.UUID, Name: account.DisplayName}
+	if userInfo.Name == "" {
+		userInfo.Name = account.Username
+	}
+	for _, email := range emails.Values {
+		if email.IsPrimary && email.IsConfirmed {
+			userInfo.Email = email.Email
+			break
+		}
+	}
+
+	return userInfo, nil
+}
+
+// VerifiesEmail reports that only a confirmed primary email is returned.
+func (p *BitbucketProvider) VerifiesEmail() bool {
+	return true
+}
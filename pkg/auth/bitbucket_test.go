@@ -0,0 +1,48 @@
+package auth_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mystardustcaptain/mattodo/pkg/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestBitbucketProvider_FetchUser tests that the Bitbucket account is mapped into the user info,
+// identified by its UUID, with its primary email only if confirmed.
+func TestBitbucketProvider_FetchUser(t *testing.T) {
+	tests := []struct {
+		name   string
+		emails string
+		want   *auth.UserInfo
+	}{
+		{"primary confirmed email",
+			`{"values": [{"email": "old@example.com", "is_primary": false, "is_confirmed": true}, {"email": "alice@example.com", "is_primary": true, "is_confirmed": true}]}`,
+			&auth.UserInfo{ID: "{c4f1e0a2-7d1b-4b7e-9d1a-2f7e8b0c9a11}", Email: "alice@example.com", Name: "Alice"}},
+		{"primary email not confirmed",
+			`{"values": [{"email": "alice@example.com", "is_primary": true, "is_confirmed": false}]}`,
+			&auth.UserInfo{ID: "{c4f1e0a2-7d1b-4b7e-9d1a-2f7e8b0c9a11}", Email: "", Name: "Alice"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			/// Arrange
+			///
+			server := newStandIn(t, map[string]string{
+				"/2.0/user":        `{"uuid": "{c4f1e0a2-7d1b-4b7e-9d1a-2f7e8b0c9a11}", "username": "alice", "display_name": "Alice"}`,
+				"/2.0/user/emails": tc.emails,
+			})
+			p := auth.NewBitbucketProvider(auth.ProviderConfig{ClientID: "client"})
+			p.APIURL = server.URL + "/2.0"
+
+			/// Act
+			///
+			userInfo, err := p.FetchUser(context.Background(), fakeToken)
+
+			/// Assert
+			///
+			assert.NoError(t, err, "Expected no error but got one")
+			assert.Equal(t, tc.want, userInfo)
+		})
+	}
+}
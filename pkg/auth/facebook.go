@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"context"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/facebook"
+)
+
+// FacebookProvider signs users in with their Facebook account.
+type FacebookProvider struct {
+	OAuth2Provider
+	GraphURL string
+}
+
+// NewFacebookProvider returns the Facebook provider for the given client.
+func NewFacebookProvider(cfg ProviderConfig) *FacebookProvider {
+	return &FacebookProvider{
+		OAuth2Provider: OAuth2Provider{
+			ProviderName: "facebook",
+			Config: &oauth2.Config{
+				RedirectURL:  cfg.RedirectURL,
+				ClientID:     cfg.ClientID,
+				ClientSecret: cfg.ClientSecret,
+				Scopes:       []string{"email", "public_profile"},
+				Endpoint:     facebook.Endpoint,
+			},
+		},
+		GraphURL: "https://graph.facebook.com",
+	}
+}
+
+// FetchUser reads the Facebook account of the user.
+// The email is only returned by the Graph API when it is confirmed.
+func (p *FacebookProvider) FetchUser(ctx context.Context, token *oauth2.Token) (*UserInfo, error) {
+	var account struct {
+		ID    string `json:"id"`
+		Email string `json:"email"`
+		Name  string `json:"name"`
+	}
+	if err := getJSON(ctx, p.GraphURL+"/me?fields=id,name,email", token.AccessToken, &account); err != nil {
+		return nil, err
+	}
+
+	return &UserInfo{ID: account.ID, Email: account.Email, Name: account.Name}, nil
+}
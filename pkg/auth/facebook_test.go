@@ -0,0 +1,28 @@
+package auth_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mystardustcaptain/mattodo/pkg/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestFacebookProvider_FetchUser tests that the Facebook account is mapped into the user info.
+func TestFacebookProvider_FetchUser(t *testing.T) {
+	/// Arrange
+	///
+	server := newStandIn(t, map[string]string{"/me": `{"id": "10224", "email": "alice@example.com", "name": "Alice"}`})
+	p := auth.NewFacebookProvider(auth.ProviderConfig{ClientID: "client"})
+	p.GraphURL = server.URL
+
+	/// Act
+	///
+	userInfo, err := p.FetchUser(context.Background(), fakeToken)
+
+	/// Assert
+	///
+	assert.NoError(t, err, "Expected no error but got one")
+	assert.Equal(t, &auth.UserInfo{ID: "10224", Email: "alice@example.com", Name: "Alice"}, userInfo)
+	assert.Equal(t, []string{"email", "public_profile"}, p.Config.Scopes)
+}
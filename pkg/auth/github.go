@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"context"
+	"strconv"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/github"
+)
+
+// GitHubProvider signs users in with their GitHub account.
+type GitHubProvider struct {
+	OAuth2Provider
+	APIURL string
+}
+
+// NewGitHubProvider returns the GitHub provider for the given client.
+func NewGitHubProvider(cfg ProviderConfig) *GitHubProvider {
+	return &GitHubProvider{
+		OAuth2Provider: OAuth2Provider{
+			ProviderName: "github",
+			Config: &oauth2.Config{
+				RedirectURL:  cfg.RedirectURL,
+				ClientID:     cfg.ClientID,
+				ClientSecret: cfg.ClientSecret,
+				Scopes:       []string{"user:email"},
+				Endpoint:     github.Endpoint,
+			},
+		},
+		APIURL: "https://api.github.com",
+	}
+}
+
+// FetchUser reads the GitHub account of the user.
+// The email is the primary one, only if verified, the profile only has the public one.
+// The name falls back to the login, it is optional on GitHub.
+func (p *GitHubProvider) FetchUser(ctx context.Context, token *oauth2.Token) (*UserInfo, error) {
+	var account struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+		Name  string `json:"name"`
+	}
+	if err := getJSON(ctx, p.APIURL+"/user", token.AccessToken, &account); err != nil {
+		return nil, err
+	}
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := getJSON(ctx, p.APIURL+"/user/emails", token.AccessToken, &emails); err != nil {
+		return nil, err
+	}
+
+	userInfo := &UserInfo{ID: strconv.FormatInt(account.ID, 10), Name: account.Name}
+	if userInfo.Name == "" {
+		userInfo.Name = account.Login
+	}
+	for _, email := range emails {
+		if email.Primary && email.Verified {
+			userInfo.Email = email.Email
+			break
+		}
+	}
+
+	return userInfo, nil
+}
+
+// VerifiesEmail reports that only a verified primary email is returned.
+func (p *GitHubProvider) VerifiesEmail() bool {
+	return true
+}
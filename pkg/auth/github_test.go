@@ -0,0 +1,52 @@
+package auth_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mystardustcaptain/mattodo/pkg/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestGitHubProvider_FetchUser tests that the GitHub account is mapped into the user info,
+// with its numeric id, and its primary email only if verified.
+func TestGitHubProvider_FetchUser(t *testing.T) {
+	tests := []struct {
+		name   string
+		user   string
+		emails string
+		want   *auth.UserInfo
+	}{
+		{"primary verified email",
+			`{"id": 583231, "login": "alice", "name": "Alice"}`,
+			`[{"email": "old@example.com", "primary": false, "verified": true}, {"email": "alice@example.com", "primary": true, "verified": true}]`,
+			&auth.UserInfo{ID: "583231", Email: "alice@example.com", Name: "Alice"}},
+		{"primary email not verified",
+			`{"id": 583231, "login": "alice", "name": "Alice"}`,
+			`[{"email": "alice@example.com", "primary": true, "verified": false}]`,
+			&auth.UserInfo{ID: "583231", Email: "", Name: "Alice"}},
+		{"no name",
+			`{"id": 583231, "login": "alice", "name": null}`,
+			`[{"email": "alice@example.com", "primary": true, "verified": true}]`,
+			&auth.UserInfo{ID: "583231", Email: "alice@example.com", Name: "alice"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			/// Arrange
+			///
+			server := newStandIn(t, map[string]string{"/user": tc.user, "/user/emails": tc.emails})
+			p := auth.NewGitHubProvider(auth.ProviderConfig{ClientID: "client"})
+			p.APIURL = server.URL
+
+			/// Act
+			///
+			userInfo, err := p.FetchUser(context.Background(), fakeToken)
+
+			/// Assert
+			///
+			assert.NoError(t, err, "Expected no error but got one")
+			assert.Equal(t, tc.want, userInfo)
+		})
+	}
+}
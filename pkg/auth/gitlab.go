@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// GitLabProvider signs users in with their account on gitlab.com or a self-managed GitLab instance.
+type GitLabProvider struct {
+	OAuth2Provider
+	APIURL string
+}
+
+// NewGitLabProvider returns the GitLab provider for the given client,
+// of the instance at baseURL, gitlab.com if empty.
+func NewGitLabProvider(cfg ProviderConfig, baseURL string) *GitLabProvider {
+	if baseURL == "" {
+		baseURL = "https://gitlab.com"
+	}
+	baseURL = strings.TrimSuffix(baseURL, "/")
+
+	return &GitLabProvider{
+		OAuth2Provider: OAuth2Provider{
+			ProviderName: "gitlab",
+			Config: &oauth2.Config{
+				RedirectURL:  cfg.RedirectURL,
+				ClientID:     cfg.ClientID,
+				ClientSecret: cfg.ClientSecret,
+				Scopes:       []string{"read_user"},
+				Endpoint: oauth2.Endpoint{
+					AuthURL:  baseURL + "/oauth/authorize",
+					TokenURL: baseURL + "/oauth/token",
+				},
+			},
+		},
+		APIURL: baseURL + "/api/v4",
+	}
+}
+
+// FetchUser reads the GitLab account of the user.
+// The email is the primary one, which GitLab requires to be confirmed.
+// The name falls back to the username.
+func (p *GitLabProvider) FetchUser(ctx context.Context, token *oauth2.Token) (*UserInfo, error) {
+	var account struct {
+		ID       int64  `json:"id"`
+		Username string `json:"username"`
+		Name     string `json:"name"`
+		Email    string `json:"email"`
+	}
+	if err := getJSON(ctx, p.APIURL+"/user", token.AccessToken, &account); err != nil {
+		return nil, err
+	}
+
+	userInfo := &UserInfo{ID: strconv.FormatInt(account.ID, 10), Email: account.Email, Name: account.Name}
+	if userInfo.Name == "" {
+		userInfo.Name = account.Username
+	}
+
+	return userInfo, nil
+}
@@ -0,0 +1,41 @@
+package auth_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mystardustcaptain/mattodo/pkg/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestGitLabProvider_FetchUser tests that the account of a self-managed GitLab instance
+// is mapped into the user info, with the endpoints of the instance.
+func TestGitLabProvider_FetchUser(t *testing.T) {
+	/// Arrange
+	///
+	server := newStandIn(t, map[string]string{"/api/v4/user": `{"id": 1337, "username": "alice", "name": "Alice", "email": "alice@example.com"}`})
+	p := auth.NewGitLabProvider(auth.ProviderConfig{ClientID: "client"}, server.URL+"/")
+
+	/// Act
+	///
+	userInfo, err := p.FetchUser(context.Background(), fakeToken)
+
+	/// Assert
+	///
+	assert.NoError(t, err, "Expected no error but got one")
+	assert.Equal(t, &auth.UserInfo{ID: "1337", Email: "alice@example.com", Name: "Alice"}, userInfo)
+	assert.Equal(t, server.URL+"/oauth/authorize", p.Config.Endpoint.AuthURL)
+	assert.Equal(t, server.URL+"/oauth/token", p.Config.Endpoint.TokenURL)
+}
+
+// TestNewGitLabProvider_DefaultsToGitLabCom tests that gitlab.com is used without an instance URL.
+func TestNewGitLabProvider_DefaultsToGitLabCom(t *testing.T) {
+	/// Act
+	///
+	p := auth.NewGitLabProvider(auth.ProviderConfig{ClientID: "client"}, "")
+
+	/// Assert
+	///
+	assert.Equal(t, "https://gitlab.com/oauth/authorize", p.Config.Endpoint.AuthURL)
+	assert.Equal(t, "https://gitlab.com/api/v4", p.APIURL)
+}
@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// GoogleProvider signs users in with their Google account.
+type GoogleProvider struct {
+	OAuth2Provider
+	UserInfoURL string
+}
+
+// NewGoogleProvider returns the Google provider for the given client.
+func NewGoogleProvider(cfg ProviderConfig) *GoogleProvider {
+	return &GoogleProvider{
+		OAuth2Provider: OAuth2Provider{
+			ProviderName: "google",
+			Config: &oauth2.Config{
+				RedirectURL:  cfg.RedirectURL,
+				ClientID:     cfg.ClientID,
+				ClientSecret: cfg.ClientSecret,
+				Scopes:       []string{"https://www.googleapis.com/auth/userinfo.email", "https://www.googleapis.com/auth/userinfo.profile"},
+				Endpoint:     google.Endpoint,
+			},
+		},
+		UserInfoURL: "https://www.googleapis.com/oauth2/v2/userinfo",
+	}
+}
+
+// FetchUser reads the Google account of the user.
+// Emails Google has not verified are refused.
+func (p *GoogleProvider) FetchUser(ctx context.Context, token *oauth2.Token) (*UserInfo, error) {
+	var account struct {
+		ID            string `json:"id"`
+		Email         string `json:"email"`
+		VerifiedEmail bool   `json:"verified_email"`
+		Name          string `json:"name"`
+	}
+	if err := getJSON(ctx, p.UserInfoURL, token.AccessToken, &account); err != nil {
+		return nil, err
+	}
+
+	if !account.VerifiedEmail {
+		return nil, fmt.Errorf("email %s not verified by Google", account.Email)
+	}
+
+	return &UserInfo{ID: account.ID, Email: account.Email, Name: account.Name}, nil
+}
+
+// VerifiesEmail reports that the emails Google has not verified are refused.
+func (p *GoogleProvider) VerifiesEmail() bool {
+	return true
+}
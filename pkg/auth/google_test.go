@@ -0,0 +1,46 @@
+package auth_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mystardustcaptain/mattodo/pkg/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestGoogleProvider_FetchUser tests that the Google account is mapped into the user info,
+// and that an email Google has not verified is refused.
+func TestGoogleProvider_FetchUser(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+		want     *auth.UserInfo
+	}{
+		{"verified email", `{"id": "1089", "email": "alice@gmail.com", "verified_email": true, "name": "Alice"}`,
+			&auth.UserInfo{ID: "1089", Email: "alice@gmail.com", Name: "Alice"}},
+		{"unverified email", `{"id": "1089", "email": "alice@gmail.com", "verified_email": false, "name": "Alice"}`, nil},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			/// Arrange
+			///
+			server := newStandIn(t, map[string]string{"/oauth2/v2/userinfo": tc.document})
+			p := auth.NewGoogleProvider(auth.ProviderConfig{ClientID: "client"})
+			p.UserInfoURL = server.URL + "/oauth2/v2/userinfo"
+
+			/// Act
+			///
+			userInfo, err := p.FetchUser(context.Background(), fakeToken)
+
+			/// Assert
+			///
+			if tc.want == nil {
+				assert.Error(t, err, "Expected the account to be refused")
+				return
+			}
+			assert.NoError(t, err, "Expected no error but got one")
+			assert.Equal(t, tc.want, userInfo)
+		})
+	}
+}
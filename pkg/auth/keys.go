@@ -0,0 +1,459 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/mystardustcaptain/mattodo/pkg/model"
+)
+
+// Algorithms the access tokens can be signed with, chosen with JWT_ALGORITHM
+const (
+	AlgorithmEdDSA = "EdDSA"
+	AlgorithmRS256 = "RS256"
+	AlgorithmHS256 = "HS256"
+)
+
+// MinHMACKeyLength is the minimum length in bytes of SIGNING_KEY with HS256,
+// a shorter secret can be brute-forced from any token (RFC 7518 requires at least the hash size)
+const MinHMACKeyLength = 32
+
+// DefaultKeyRotationInterval is how long a key signs the access tokens before a new one replaces it
+const DefaultKeyRotationInterval = 30 * 24 * time.Hour
+
+// DefaultKeySyncInterval is how often the keys are pruned, reloaded from the store and rotated when due
+const DefaultKeySyncInterval = time.Minute
+
+// MaxTokenLifetime is the longest validity of an access token.
+// A key remains valid for verification that long after it stopped signing.
+const MaxTokenLifetime = 24 * time.Hour
+
+// rsaKeyBits is the size of the RSA keys generated
+const rsaKeyBits = 2048
+
+// keyReloadInterval is how often the keys may be reloaded from the store for a token signed by an unknown key,
+// e.g. a key just created by another instance
+const keyReloadInterval = time.Second
+
+// ErrWeakSigningKey is returned for an HMAC secret too short to be safe.
+var ErrWeakSigningKey = fmt.Errorf("SIGNING_KEY must be at least %d bytes with HS256", MinHMACKeyLength)
+
+// KeySet signs the access tokens and verifies them by the key identified in their kid header.
+// Asymmetric keys (EdDSA, RS256) are generated and rotated every RotationInterval,
+// a retired key keeps verifying until the last token it signed has expired.
+// The keys are persisted in the store, so that every instance signs and verifies with the same keys,
+// and their public part is published with JWKS for other services to verify the tokens.
+// An HS256 KeySet has a single secret, never rotated nor published.
+type KeySet struct {
+	Store            model.SigningKeyStore // nil for HS256
+	Algorithm        string
+	RotationInterval time.Duration
+
+	mu       sync.RWMutex
+	keys     map[string]*signingKey // kid -> key, not expired
+	current  *signingKey            // newest key, signing
+	loadedAt time.Time
+
+	rotateMu sync.Mutex
+}
+
+// signingKey is a key of the set, parsed
+type signingKey struct {
+	id         string
+	method     jwt.SigningMethod
+	privateKey interface{} // ed25519.PrivateKey, *rsa.PrivateKey or []byte
+	publicKey  interface{} // ed25519.PublicKey, *rsa.PublicKey or []byte
+	createdAt  time.Time
+	expiresAt  time.Time // zero never expires
+}
+
+// NewKeySet returns a KeySet generating keys of the algorithm, EdDSA or RS256, persisted in the store.
+// Load should be called to pick up the keys already in the store before signing.
+func NewKeySet(store model.SigningKeyStore, algorithm string) *KeySet {
+	return &KeySet{
+		Store:            store,
+		Algorithm:        algorithm,
+		RotationInterval: DefaultKeyRotationInterval,
+		keys:             map[string]*signingKey{},
+	}
+}
+
+// NewHMACKeySet returns a KeySet signing with HS256 with a secret shared with every instance.
+// Returns ErrWeakSigningKey if the secret is shorter than MinHMACKeyLength.
+func NewHMACKeySet(secret []byte) (*KeySet, error) {
+	if len(secret) < MinHMACKeyLength {
+		return nil, ErrWeakSigningKey
+	}
+
+	// Without a kid, the tokens issued before the key set was introduced remain valid
+	key := &signingKey{method: jwt.SigningMethodHS256, privateKey: secret, publicKey: secret}
+
+	return &KeySet{
+		Algorithm: AlgorithmHS256,
+		keys:      map[string]*signingKey{key.id: key},
+		current:   key,
+	}, nil
+}
+
+// KeySetFromEnv returns the KeySet configured by the environment, persisting its keys in the store.
+// JWT_ALGORITHM is EdDSA (default), RS256 or HS256, the latter signing with SIGNING_KEY.
+// JWT_KEY_ROTATION_INTERVAL sets how often the asymmetric keys are rotated, e.g. 720h.
+// Returns an error for an unknown algorithm, an invalid interval or a weak SIGNING_KEY.
+func KeySetFromEnv(store model.SigningKeyStore) (*KeySet, error) {
+	algorithm := os.Getenv("JWT_ALGORITHM")
+	if algorithm == "" {
+		algorithm = AlgorithmEdDSA
+	}
+
+	switch algorithm {
+	case AlgorithmHS256:
+		return NewHMACKeySet([]byte(os.Getenv("SIGNING_KEY")))
+	case AlgorithmEdDSA, AlgorithmRS256:
+	default:
+		return nil, fmt.Errorf("unsupported JWT_ALGORITHM %q, must be EdDSA, RS256 or HS256", algorithm)
+	}
+
+	ks := NewKeySet(store, algorithm)
+	if interval := os.Getenv("JWT_KEY_ROTATION_INTERVAL"); interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWT_KEY_ROTATION_INTERVAL: %w", err)
+		}
+		if d <= 0 {
+			return nil, errors.New("invalid JWT_KEY_ROTATION_INTERVAL: must be positive")
+		}
+		ks.RotationInterval = d
+	}
+
+	return ks, nil
+}
+
+// Load reloads the keys of the store, and rotates the keys if none can sign anymore.
+func (ks *KeySet) Load() error {
+	if ks.Store == nil {
+		return nil
+	}
+
+	if err := ks.load(); err != nil {
+		return err
+	}
+
+	return ks.rotateIfDue(time.Now())
+}
+
+// load replaces the keys by those of the store
+func (ks *KeySet) load() error {
+	now := time.Now()
+
+	records, err := ks.Store.GetSigningKeys(now)
+	if err != nil {
+		log.Printf("Failed to load signing keys: %s\n", err.Error())
+		return err
+	}
+
+	keys := map[string]*signingKey{}
+	var current *signingKey
+	for _, record := range records {
+		key, err := parseSigningKey(record)
+		if err != nil {
+			// A corrupted key is skipped, the tokens it signed are refused
+			log.Printf("Skipping signing key %s: %s\n", record.ID, err.Error())
+			continue
+		}
+		keys[key.id] = key
+		// Ordered from the oldest to the newest
+		current = key
+	}
+
+	ks.mu.Lock()
+	ks.keys = keys
+	ks.current = current
+	ks.loadedAt = now
+	ks.mu.Unlock()
+
+	return nil
+}
+
+// Rotate generates a new key signing the access tokens from now on.
+// The previous keys keep verifying the tokens they signed until they expire.
+func (ks *KeySet) Rotate() error {
+	ks.rotateMu.Lock()
+	defer ks.rotateMu.Unlock()
+
+	return ks.rotateLocked(time.Now())
+}
+
+// rotateIfDue rotates the keys if the current key is too old to sign a token valid until now + MaxTokenLifetime
+func (ks *KeySet) rotateIfDue(now time.Time) error {
+	if ks.Store == nil || !ks.rotationDue(now) {
+		return nil
+	}
+
+	ks.rotateMu.Lock()
+	defer ks.rotateMu.Unlock()
+
+	// Checked again, another goroutine may have rotated them meanwhile
+	if !ks.rotationDue(now) {
+		return nil
+	}
+
+	return ks.rotateLocked(now)
+}
+
+// rotationDue reports whether the current key should no longer sign.
+// It also covers keys created by an instance with a longer RotationInterval.
+func (ks *KeySet) rotationDue(now time.Time) bool {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	return ks.current == nil ||
+		!now.Before(ks.current.createdAt.Add(ks.RotationInterval)) ||
+		now.Add(MaxTokenLifetime).After(ks.current.expiresAt)
+}
+
+// rotateLocked generates and stores a new key, the caller holds rotateMu
+func (ks *KeySet) rotateLocked(now time.Time) error {
+	if ks.Store == nil {
+		return fmt.Errorf("%s keys cannot be rotated", ks.Algorithm)
+	}
+
+	record, err := generateSigningKey(ks.Algorithm, now, now.Add(ks.RotationInterval+MaxTokenLifetime))
+	if err != nil {
+		log.Printf("Failed to generate signing key: %s\n", err.Error())
+		return err
+	}
+
+	key, err := parseSigningKey(record)
+	if err != nil {
+		return err
+	}
+
+	if err := ks.Store.CreateSigningKey(record); err != nil {
+		log.Printf("Failed to store signing key: %s\n", err.Error())
+		return err
+	}
+
+	ks.mu.Lock()
+	ks.keys[key.id] = key
+	ks.current = key
+	ks.mu.Unlock()
+
+	log.Printf("Signing key rotated, now signing with %s\n", key.id)
+	return nil
+}
+
+// Prune forgets the keys expired at now, every token they signed has expired too.
+func (ks *KeySet) Prune(now time.Time) error {
+	if ks.Store == nil {
+		return nil
+	}
+
+	ks.mu.Lock()
+	for kid, key := range ks.keys {
+		if key != ks.current && key.expiresAt.Before(now) {
+			delete(ks.keys, kid)
+		}
+	}
+	ks.mu.Unlock()
+
+	if err := ks.Store.DeleteExpiredSigningKeys(now); err != nil {
+		log.Printf("Failed to delete expired signing keys: %s\n", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// Sync prunes and reloads the keys every interval until ctx is done, rotating them when due.
+// Meant to run in its own goroutine.
+func (ks *KeySet) Sync(ctx context.Context, interval time.Duration) {
+	if ks.Store == nil {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// Errors are logged, the keys in memory keep serving until the next attempt
+			if err := ks.Prune(time.Now()); err == nil {
+				ks.Load()
+			}
+		}
+	}
+}
+
+// Sign signs the claims with the current key, rotating the keys first if due.
+// The kid header identifies the key, except with HS256.
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	if err := ks.rotateIfDue(time.Now()); err != nil {
+		return "", err
+	}
+
+	ks.mu.RLock()
+	key := ks.current
+	ks.mu.RUnlock()
+
+	token := jwt.NewWithClaims(key.method, claims)
+	if key.id != "" {
+		token.Header["kid"] = key.id
+	}
+
+	return token.SignedString(key.privateKey)
+}
+
+// Keyfunc returns the key verifying a token, the one of its kid header signing with its algorithm.
+// Keys created by other instances are reloaded from the store.
+func (ks *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	key := ks.find(kid)
+	if key == nil && ks.Store != nil {
+		ks.mu.RLock()
+		stale := time.Since(ks.loadedAt) >= keyReloadInterval
+		ks.mu.RUnlock()
+
+		if stale {
+			ks.load()
+			key = ks.find(kid)
+		}
+	}
+	if key == nil {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	// Otherwise a public key could be used as an HMAC secret
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	if !key.expiresAt.IsZero() && time.Now().After(key.expiresAt) {
+		return nil, fmt.Errorf("signing key %q expired", kid)
+	}
+
+	return key.publicKey, nil
+}
+
+// find returns the key of a kid, nil if unknown
+func (ks *KeySet) find(kid string) *signingKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	return ks.keys[kid]
+}
+
+// JWKS returns the public keys verifying the access tokens, for other services to verify them.
+// It is empty with HS256, the secret cannot be published.
+func (ks *KeySet) JWKS() *JSONWebKeySet {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	set := &JSONWebKeySet{Keys: []JSONWebKey{}}
+	for _, key := range ks.keys {
+		jwk := JSONWebKey{Kid: key.id, Use: "sig", Alg: key.method.Alg()}
+		switch pub := key.publicKey.(type) {
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	return set
+}
+
+// generateSigningKey generates a key of the algorithm, valid from createdAt until expiresAt
+func generateSigningKey(algorithm string, createdAt time.Time, expiresAt time.Time) (*model.SigningKey, error) {
+	var privateKey interface{}
+	var err error
+	switch algorithm {
+	case AlgorithmEdDSA:
+		_, privateKey, err = ed25519.GenerateKey(rand.Reader)
+	case AlgorithmRS256:
+		privateKey, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	kid, err := randomString(16)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.SigningKey{
+		ID:         kid,
+		Algorithm:  algorithm,
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		CreatedAt:  createdAt,
+		ExpiresAt:  expiresAt,
+	}, nil
+}
+
+// parseSigningKey decodes a key of the store
+func parseSigningKey(record *model.SigningKey) (*signingKey, error) {
+	block, _ := pem.Decode([]byte(record.PrivateKey))
+	if block == nil {
+		return nil, errors.New("invalid PEM")
+	}
+
+	privateKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &signingKey{
+		id:         record.ID,
+		privateKey: privateKey,
+		createdAt:  record.CreatedAt,
+		expiresAt:  record.ExpiresAt,
+	}
+	switch k := privateKey.(type) {
+	case ed25519.PrivateKey:
+		if record.Algorithm != AlgorithmEdDSA {
+			return nil, fmt.Errorf("Ed25519 key for algorithm %s", record.Algorithm)
+		}
+		key.method = jwt.SigningMethodEdDSA
+		key.publicKey = k.Public()
+	case *rsa.PrivateKey:
+		if record.Algorithm != AlgorithmRS256 {
+			return nil, fmt.Errorf("RSA key for algorithm %s", record.Algorithm)
+		}
+		key.method = jwt.SigningMethodRS256
+		key.publicKey = &k.PublicKey
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", privateKey)
+	}
+
+	return key, nil
+}
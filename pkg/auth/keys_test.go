@@ -0,0 +1,281 @@
+package auth_test
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/mystardustcaptain/mattodo/pkg/auth"
+	"github.com/mystardustcaptain/mattodo/pkg/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// kidOf returns the kid header of a token, without verifying it
+func kidOf(t *testing.T, token string) string {
+	parsed, _, err := new(jwt.Parser).ParseUnverified(token, jwt.MapClaims{})
+	if err != nil {
+		t.Fatalf("failed to parse token: %s", err)
+	}
+	kid, _ := parsed.Header["kid"].(string)
+	return kid
+}
+
+// TestKeySet_SignsAndVerifiesWithKid tests that the access tokens signed with an asymmetric key
+// are identified by their kid and accepted by the middleware.
+func TestKeySet_SignsAndVerifiesWithKid(t *testing.T) {
+	for _, algorithm := range []string{auth.AlgorithmEdDSA, auth.AlgorithmRS256} {
+		t.Run(algorithm, func(t *testing.T) {
+			/// Arrange
+			///
+			keys := auth.NewKeySet(model.NewMemorySigningKeyStore(), algorithm)
+			a := &auth.Authenticator{Keys: keys}
+
+			/// Act
+			///
+			err := keys.Load()
+			token, errCreate := a.CreateToken("alice@example.com", 2, 1)
+
+			/// Assert
+			///
+			assert.NoError(t, err, "Expected no error but got one")
+			assert.NoError(t, errCreate, "Expected no error but got one")
+			assert.NotEmpty(t, kidOf(t, token))
+			assert.Equal(t, http.StatusOK, serveWithToken(a, token))
+		})
+	}
+}
+
+// TestKeySet_RotationKeepsOldKeysForVerification tests that the tokens signed before a rotation
+// stay valid while the new ones are signed with the new key, both published in the JWKS.
+func TestKeySet_RotationKeepsOldKeysForVerification(t *testing.T) {
+	/// Arrange
+	///
+	keys := auth.NewKeySet(model.NewMemorySigningKeyStore(), auth.AlgorithmEdDSA)
+	a := &auth.Authenticator{Keys: keys}
+	before, _ := a.CreateToken("alice@example.com", 2, 1)
+
+	/// Act
+	///
+	err := keys.Rotate()
+	after, _ := a.CreateToken("alice@example.com", 2, 1)
+
+	/// Assert
+	///
+	assert.NoError(t, err, "Expected no error but got one")
+	assert.NotEqual(t, kidOf(t, before), kidOf(t, after), "Expected the new tokens to be signed with the new key")
+	assert.Equal(t, http.StatusOK, serveWithToken(a, before))
+	assert.Equal(t, http.StatusOK, serveWithToken(a, after))
+
+	kids := []string{}
+	for _, jwk := range keys.JWKS().Keys {
+		kids = append(kids, jwk.Kid)
+	}
+	assert.ElementsMatch(t, []string{kidOf(t, before), kidOf(t, after)}, kids)
+}
+
+// TestKeySet_RotatesWhenDue tests that a key older than the rotation interval no longer signs.
+func TestKeySet_RotatesWhenDue(t *testing.T) {
+	/// Arrange
+	///
+	store := model.NewMemorySigningKeyStore()
+	keys := auth.NewKeySet(store, auth.AlgorithmEdDSA)
+	keys.RotationInterval = 100 * time.Millisecond
+	a := &auth.Authenticator{Keys: keys}
+	first, _ := a.CreateToken("alice@example.com", 2, 1)
+
+	/// Act
+	///
+	time.Sleep(150 * time.Millisecond)
+	second, _ := a.CreateToken("alice@example.com", 2, 1)
+
+	/// Assert
+	///
+	assert.NotEqual(t, kidOf(t, first), kidOf(t, second), "Expected the key to be rotated")
+	assert.Equal(t, http.StatusOK, serveWithToken(a, first), "Expected the old key to keep verifying")
+	stored, _ := store.GetSigningKeys(time.Now())
+	assert.Equal(t, 2, len(stored))
+}
+
+// TestKeySet_VerifiesKeysOfOtherInstances tests that a token signed with a key created by another instance
+// sharing the store is verified.
+func TestKeySet_VerifiesKeysOfOtherInstances(t *testing.T) {
+	/// Arrange
+	///
+	store := model.NewMemorySigningKeyStore()
+	signer := &auth.Authenticator{Keys: auth.NewKeySet(store, auth.AlgorithmEdDSA)}
+	verifier := &auth.Authenticator{Keys: auth.NewKeySet(store, auth.AlgorithmEdDSA)}
+
+	/// Act
+	///
+	token, _ := signer.CreateToken("alice@example.com", 2, 1)
+
+	/// Assert
+	///
+	assert.Equal(t, http.StatusOK, serveWithToken(verifier, token))
+}
+
+// TestKeySet_RefusesExpiredAndUnknownKeys tests that a token is refused once its key expired and was pruned,
+// and that a token of an unknown key is refused.
+func TestKeySet_RefusesExpiredAndUnknownKeys(t *testing.T) {
+	/// Arrange
+	///
+	store := model.NewMemorySigningKeyStore()
+	keys := auth.NewKeySet(store, auth.AlgorithmEdDSA)
+	a := &auth.Authenticator{Keys: keys}
+	token, _ := a.CreateToken("alice@example.com", 2, 1)
+	other := &auth.Authenticator{Keys: auth.NewKeySet(model.NewMemorySigningKeyStore(), auth.AlgorithmEdDSA)}
+	unknown, _ := other.CreateToken("alice@example.com", 2, 1)
+
+	/// Act
+	///
+	keys.Rotate()
+	err := keys.Prune(time.Now().Add(keys.RotationInterval + auth.MaxTokenLifetime + time.Minute))
+
+	/// Assert
+	///
+	assert.NoError(t, err, "Expected no error but got one")
+	assert.Equal(t, http.StatusUnauthorized, serveWithToken(a, token), "Expected the expired key to be forgotten")
+	assert.Equal(t, http.StatusUnauthorized, serveWithToken(a, unknown))
+}
+
+// TestKeySet_RefusesAlgorithmOfAnotherKey tests that a token cannot pick an algorithm other than its key's,
+// e.g. HS256 with the public key as the secret.
+func TestKeySet_RefusesAlgorithmOfAnotherKey(t *testing.T) {
+	/// Arrange
+	///
+	keys := auth.NewKeySet(model.NewMemorySigningKeyStore(), auth.AlgorithmEdDSA)
+	a := &auth.Authenticator{Keys: keys}
+	genuine, _ := a.CreateToken("alice@example.com", 2, 1)
+	jwk := keys.JWKS().Keys[0]
+	publicKey, _ := base64.RawURLEncoding.DecodeString(jwk.X)
+
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"userEmail": "alice@example.com",
+		"userID":    2,
+		"exp":       time.Now().Add(time.Hour).Unix(),
+	})
+	forged.Header["kid"] = kidOf(t, genuine)
+	token, _ := forged.SignedString(publicKey)
+
+	/// Act
+	///
+	code := serveWithToken(a, token)
+
+	/// Assert
+	///
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
+
+// TestKeySet_JWKSVerifiesTokens tests that another service can verify the tokens with the published keys.
+func TestKeySet_JWKSVerifiesTokens(t *testing.T) {
+	/// Arrange
+	///
+	keys := auth.NewKeySet(model.NewMemorySigningKeyStore(), auth.AlgorithmEdDSA)
+	a := &auth.Authenticator{Keys: keys}
+	token, _ := a.CreateToken("alice@example.com", 2, 1)
+
+	/// Act
+	///
+	set := keys.JWKS()
+
+	/// Assert
+	///
+	assert.Equal(t, 1, len(set.Keys))
+	jwk := set.Keys[0]
+	assert.Equal(t, "OKP", jwk.Kty)
+	assert.Equal(t, "Ed25519", jwk.Crv)
+	assert.Equal(t, "EdDSA", jwk.Alg)
+	assert.Equal(t, kidOf(t, token), jwk.Kid)
+
+	x, _ := base64.RawURLEncoding.DecodeString(jwk.X)
+	parsed, err := jwt.Parse(token, func(*jwt.Token) (interface{}, error) { return ed25519.PublicKey(x), nil })
+	assert.NoError(t, err, "Expected the published key to verify the token")
+	assert.True(t, parsed.Valid)
+}
+
+// TestNewHMACKeySet_RefusesWeakKeys tests that HS256 requires a secret of at least MinHMACKeyLength bytes,
+// and that its tokens have no kid, so that the tokens issued before remain valid.
+func TestNewHMACKeySet_RefusesWeakKeys(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret string
+		weak   bool
+	}{
+		{"empty", "", true},
+		{"sample", "1234", true},
+		{"one byte short", strings.Repeat("k", auth.MinHMACKeyLength-1), true},
+		{"long enough", strings.Repeat("k", auth.MinHMACKeyLength), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			/// Act
+			///
+			keys, err := auth.NewHMACKeySet([]byte(tt.secret))
+
+			/// Assert
+			///
+			if tt.weak {
+				assert.ErrorIs(t, err, auth.ErrWeakSigningKey)
+				return
+			}
+			assert.NoError(t, err, "Expected no error but got one")
+
+			a := &auth.Authenticator{Keys: keys}
+			token, _ := a.CreateToken("alice@example.com", 2, 1)
+			assert.Empty(t, kidOf(t, token))
+			assert.Equal(t, http.StatusOK, serveWithToken(a, token))
+			assert.Empty(t, keys.JWKS().Keys, "Expected the secret not to be published")
+		})
+	}
+}
+
+// TestKeySetFromEnv tests the algorithms and rotation intervals accepted from the environment.
+func TestKeySetFromEnv(t *testing.T) {
+	tests := []struct {
+		name       string
+		algorithm  string
+		signingKey string
+		interval   string
+		valid      bool
+	}{
+		{"default", "", "", "", true},
+		{"RS256 with interval", "RS256", "", "168h", true},
+		{"HS256 with strong key", "HS256", strings.Repeat("k", 32), "", true},
+		{"HS256 with weak key", "HS256", "1234", "", false},
+		{"unknown algorithm", "none", "", "", false},
+		{"invalid interval", "EdDSA", "", "monthly", false},
+		{"negative interval", "EdDSA", "", "-1h", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			/// Arrange
+			///
+			t.Setenv("JWT_ALGORITHM", tt.algorithm)
+			t.Setenv("SIGNING_KEY", tt.signingKey)
+			t.Setenv("JWT_KEY_ROTATION_INTERVAL", tt.interval)
+
+			/// Act
+			///
+			keys, err := auth.KeySetFromEnv(model.NewMemorySigningKeyStore())
+
+			/// Assert
+			///
+			if !tt.valid {
+				assert.Error(t, err, "Expected an error but got none")
+				return
+			}
+			assert.NoError(t, err, "Expected no error but got one")
+			if tt.algorithm != "" {
+				assert.Equal(t, tt.algorithm, keys.Algorithm)
+			} else {
+				assert.Equal(t, auth.AlgorithmEdDSA, keys.Algorithm)
+			}
+		})
+	}
+}
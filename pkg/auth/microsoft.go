@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/microsoft"
+)
+
+// microsoftConsumersTenant is the tenant of the personal Microsoft accounts, whose emails Microsoft verifies
+const microsoftConsumersTenant = "9188040d-6c67-4c5b-b112-36a304b66dad"
+
+// MicrosoftProvider signs users in with their Microsoft account, personal or of an organization.
+// It is an OpenID Connect provider whose issuer depends on the tenant of the user.
+type MicrosoftProvider struct {
+	OIDCProvider
+	GraphURL string
+}
+
+// NewMicrosoftProvider returns the Microsoft provider for the given client.
+// tenant restricts the sign in to the accounts of an organization, any account if empty (common).
+func NewMicrosoftProvider(cfg ProviderConfig, tenant string) *MicrosoftProvider {
+	if tenant == "" {
+		tenant = "common"
+	}
+
+	return &MicrosoftProvider{
+		OIDCProvider: OIDCProvider{
+			OAuth2Provider: OAuth2Provider{
+				ProviderName: "microsoft",
+				Config: &oauth2.Config{
+					RedirectURL:  cfg.RedirectURL,
+					ClientID:     cfg.ClientID,
+					ClientSecret: cfg.ClientSecret,
+					Scopes:       []string{"openid", "email", "profile", "User.Read"},
+					Endpoint:     microsoft.AzureADEndpoint(tenant),
+				},
+			},
+			Issuer:  "https://login.microsoftonline.com/" + tenantPlaceholder + "/v2.0",
+			JWKSURL: "https://login.microsoftonline.com/" + tenant + "/discovery/v2.0/keys",
+		},
+		GraphURL: "https://graph.microsoft.com/v1.0",
+	}
+}
+
+// FetchUser reads the Microsoft account of the user from Microsoft Graph, and its email from the ID token.
+// The admins of a tenant can set the mail of their users to any address, so the email must be verified:
+// the one of a personal account, or of a domain whose owner Microsoft verified (the optional claim xms_edov).
+func (p *MicrosoftProvider) FetchUser(ctx context.Context, token *oauth2.Token) (*UserInfo, error) {
+	claims, err := p.idTokenClaims(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	email, _ := claims["email"].(string)
+	if email == "" {
+		return nil, errors.New("no email in the ID token of Microsoft")
+	}
+	if !microsoftEmailVerified(claims) {
+		return nil, fmt.Errorf("email %s not verified by Microsoft", email)
+	}
+
+	var account struct {
+		ID          string `json:"id"`
+		DisplayName string `json:"displayName"`
+	}
+	if err := getJSON(ctx, p.GraphURL+"/me", token.AccessToken, &account); err != nil {
+		return nil, err
+	}
+
+	return &UserInfo{ID: account.ID, Email: email, Name: account.DisplayName}, nil
+}
+
+// VerifiesEmail reports that the emails are checked against the claims Microsoft verified.
+func (p *MicrosoftProvider) VerifiesEmail() bool {
+	return true
+}
+
+// microsoftEmailVerified reports whether the email of an ID token of Microsoft was verified
+func microsoftEmailVerified(claims jwt.MapClaims) bool {
+	if claims["tid"] == microsoftConsumersTenant {
+		return true
+	}
+
+	verified, _ := claims["xms_edov"].(bool)
+	return verified
+}
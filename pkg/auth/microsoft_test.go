@@ -0,0 +1,131 @@
+package auth_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/mystardustcaptain/mattodo/pkg/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+// newMicrosoftStandIn returns a Microsoft provider for the client, calling a stand-in for Graph and for the keys,
+// and a function signing ID tokens with the key of the stand-in
+func newMicrosoftStandIn(t *testing.T) (*auth.MicrosoftProvider, func(claims jwt.MapClaims) string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when generating the key", err)
+	}
+
+	keys := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "key-1",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+			}},
+		})
+	}))
+	t.Cleanup(keys.Close)
+	graph := newStandIn(t, map[string]string{"/v1.0/me": `{"id": "87d349ed", "displayName": "Alice", "mail": "ceo@contoso.com"}`})
+
+	p := auth.NewMicrosoftProvider(auth.ProviderConfig{ClientID: "client"}, "")
+	p.GraphURL = graph.URL + "/v1.0"
+	p.JWKSURL = keys.URL
+
+	sign := func(claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "key-1"
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when signing the ID token", err)
+		}
+		return signed
+	}
+
+	return p, sign
+}
+
+// TestMicrosoftProvider_FetchUser tests that the Microsoft account is read from Graph,
+// with the email of the ID token only if Microsoft verified it, never the mail set by the tenant.
+func TestMicrosoftProvider_FetchUser(t *testing.T) {
+	const tenant = "72f988bf-86f1-41af-91ab-2d7cd011db47"
+	const consumers = "9188040d-6c67-4c5b-b112-36a304b66dad"
+
+	tests := []struct {
+		name    string
+		tamper  func(claims jwt.MapClaims)
+		want    *auth.UserInfo
+		wantErr bool
+	}{
+		{"domain owner verified", func(claims jwt.MapClaims) {}, &auth.UserInfo{ID: "87d349ed", Email: "alice@contoso.com", Name: "Alice"}, false},
+		{"personal account", func(claims jwt.MapClaims) {
+			claims["tid"] = consumers
+			claims["iss"] = "https://login.microsoftonline.com/" + consumers + "/v2.0"
+			delete(claims, "xms_edov")
+		}, &auth.UserInfo{ID: "87d349ed", Email: "alice@contoso.com", Name: "Alice"}, false},
+		{"domain owner not verified", func(claims jwt.MapClaims) { claims["xms_edov"] = false }, nil, true},
+		{"verification not reported", func(claims jwt.MapClaims) { delete(claims, "xms_edov") }, nil, true},
+		{"no email", func(claims jwt.MapClaims) { delete(claims, "email") }, nil, true},
+		{"issuer of another tenant", func(claims jwt.MapClaims) { claims["iss"] = "https://login.microsoftonline.com/" + consumers + "/v2.0" }, nil, true},
+		{"another audience", func(claims jwt.MapClaims) { claims["aud"] = "another-client" }, nil, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			/// Arrange
+			///
+			p, sign := newMicrosoftStandIn(t)
+			claims := jwt.MapClaims{
+				"iss":      "https://login.microsoftonline.com/" + tenant + "/v2.0",
+				"aud":      "client",
+				"sub":      "AAAAAAAAAAAAAAAAAAAAAIkzqFVrSaSaFHy782bbtaQ",
+				"tid":      tenant,
+				"email":    "alice@contoso.com",
+				"xms_edov": true,
+				"exp":      time.Now().Add(5 * time.Minute).Unix(),
+			}
+			tc.tamper(claims)
+			token := fakeToken.WithExtra(map[string]interface{}{"id_token": sign(claims)})
+
+			/// Act
+			///
+			userInfo, err := p.FetchUser(context.Background(), token)
+
+			/// Assert
+			///
+			if tc.wantErr {
+				assert.Error(t, err, "Expected the user info to be refused")
+				return
+			}
+			assert.NoError(t, err, "Expected no error but got one")
+			assert.Equal(t, tc.want, userInfo)
+		})
+	}
+}
+
+// TestNewMicrosoftProvider_Tenant tests that the sign in is restricted to the tenant given, any account otherwise.
+func TestNewMicrosoftProvider_Tenant(t *testing.T) {
+	/// Act
+	///
+	common := auth.NewMicrosoftProvider(auth.ProviderConfig{ClientID: "client"}, "")
+	contoso := auth.NewMicrosoftProvider(auth.ProviderConfig{ClientID: "client"}, "contoso.onmicrosoft.com")
+
+	/// Assert
+	///
+	assert.Equal(t, "https://login.microsoftonline.com/common/oauth2/v2.0/authorize", common.Config.Endpoint.AuthURL)
+	assert.Equal(t, "https://login.microsoftonline.com/common/discovery/v2.0/keys", common.JWKSURL)
+	assert.Equal(t, "https://login.microsoftonline.com/contoso.onmicrosoft.com/oauth2/v2.0/token", contoso.Config.Endpoint.TokenURL)
+	assert.Equal(t, []string{"openid", "email", "profile", "User.Read"}, contoso.Config.Scopes)
+	assert.True(t, common.VerifiesEmail(), "Expected the emails to be verified")
+}
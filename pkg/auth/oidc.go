@@ -0,0 +1,486 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+// OIDCConfig configures an OpenID Connect provider, its endpoints are found in its discovery document.
+type OIDCConfig struct {
+	Name         string // used as the provider of the login URLs and of the identities, e.g. keycloak
+	Issuer       string // e.g. https://sso.example.com/realms/main
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string // defaults to openid, email and profile
+}
+
+// OIDCProvider is an OpenID Connect provider registered with RegisterOIDCProvider.
+// The user information is read from the ID token, verified against the keys the provider publishes.
+type OIDCProvider struct {
+	OAuth2Provider
+	Issuer      string
+	UserInfoURL string
+	JWKSURL     string
+
+	mu            sync.Mutex
+	keys          map[string]interface{} // kid -> *rsa.PublicKey or *ecdsa.PublicKey
+	keysFetchedAt time.Time
+}
+
+// jwksRefreshInterval is the minimum time between two fetches of the keys of a provider,
+// a token signed with an unknown key only triggers a fetch when the keys are older
+const jwksRefreshInterval = time.Minute
+
+// tenantPlaceholder stands for the tenant of the user in the issuer of multi-tenant providers,
+// e.g. https://login.microsoftonline.com/{tenantid}/v2.0, it is replaced with the tid claim of the ID token
+const tenantPlaceholder = "{tenantid}"
+
+// oidcLeeway is the clock skew tolerated with the providers when checking the times of the ID tokens
+const oidcLeeway = time.Minute
+
+// discoveryDocument holds the fields used of the provider's /.well-known/openid-configuration
+type discoveryDocument struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserInfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// RegisterOIDCProvider fetches the discovery document of an OpenID Connect provider
+// and registers it next to the built-in providers.
+func RegisterOIDCProvider(cfg OIDCConfig) (*OIDCProvider, error) {
+	if !providerName.MatchString(cfg.Name) {
+		return nil, fmt.Errorf("invalid OAuth provider name %q", cfg.Name)
+	}
+	if _, ok := GetProvider(cfg.Name); ok {
+		return nil, fmt.Errorf("OAuth provider %s already registered", cfg.Name)
+	}
+	if cfg.Issuer == "" || cfg.ClientID == "" {
+		return nil, fmt.Errorf("OIDC provider %s: issuer and client id are required", cfg.Name)
+	}
+
+	ctx := context.Background()
+
+	var doc discoveryDocument
+	if err := getJSON(ctx, strings.TrimSuffix(cfg.Issuer, "/")+"/.well-known/openid-configuration", "", &doc); err != nil {
+		log.Printf("Failed to fetch discovery document of %s: %s\n", cfg.Name, err.Error())
+		return nil, err
+	}
+
+	// The issuer must be exactly the one configured, it is checked again in every ID token
+	if doc.Issuer != cfg.Issuer {
+		return nil, fmt.Errorf("OIDC provider %s: discovery document issued by %q, expected %q", cfg.Name, doc.Issuer, cfg.Issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return nil, fmt.Errorf("OIDC provider %s: incomplete discovery document", cfg.Name)
+	}
+
+	scopes := cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+
+	p := &OIDCProvider{
+		OAuth2Provider: OAuth2Provider{
+			ProviderName: cfg.Name,
+			Config: &oauth2.Config{
+				RedirectURL:  cfg.RedirectURL,
+				ClientID:     cfg.ClientID,
+				ClientSecret: cfg.ClientSecret,
+				Scopes:       scopes,
+				Endpoint:     oauth2.Endpoint{AuthURL: doc.AuthorizationEndpoint, TokenURL: doc.TokenEndpoint},
+			},
+		},
+		Issuer:      doc.Issuer,
+		UserInfoURL: doc.UserInfoEndpoint,
+		JWKSURL:     doc.JWKSURI,
+	}
+	if err := p.fetchKeys(ctx); err != nil {
+		log.Printf("Failed to fetch keys of %s: %s\n", cfg.Name, err.Error())
+		return nil, err
+	}
+
+	if err := RegisterProvider(p); err != nil {
+		return nil, err
+	}
+
+	log.Printf("OIDC provider %s registered for %s\n", cfg.Name, cfg.Issuer)
+
+	return p, nil
+}
+
+// RegisterOIDCProvidersFromEnv registers the OIDC providers listed in OIDC_PROVIDERS, e.g. OIDC_PROVIDERS=keycloak,dex
+// Each one is configured with the variables prefixed with its name:
+// OIDC_KEYCLOAK_ISSUER, OIDC_KEYCLOAK_CLIENT_ID, OIDC_KEYCLOAK_CLIENT_SECRET, OIDC_KEYCLOAK_REDIRECT_URL
+// and optionally OIDC_KEYCLOAK_SCOPES, separated by spaces.
+func RegisterOIDCProvidersFromEnv() error {
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+		client := providerConfigFromEnv(prefix)
+		cfg := OIDCConfig{
+			Name:         name,
+			Issuer:       os.Getenv(prefix + "_ISSUER"),
+			ClientID:     client.ClientID,
+			ClientSecret: client.ClientSecret,
+			RedirectURL:  client.RedirectURL,
+			Scopes:       strings.Fields(os.Getenv(prefix + "_SCOPES")),
+		}
+
+		if _, err := RegisterOIDCProvider(cfg); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// oidcNonce is the nonce of a login attempt, derived from its PKCE verifier
+// so that an ID token is only accepted by the login it was issued for
+func oidcNonce(codeVerifier string) string {
+	return hashString("oidc-nonce:" + codeVerifier)
+}
+
+// AuthURL returns the provider's login page URL for a login attempt,
+// with the nonce the ID token must carry back.
+func (p *OIDCProvider) AuthURL(state string, codeVerifier string) string {
+	return p.Config.AuthCodeURL(state,
+		oauth2.S256ChallengeOption(codeVerifier),
+		oauth2.SetAuthURLParam("nonce", oidcNonce(codeVerifier)),
+	)
+}
+
+// Exchange redeems the code returned to the callback, the ID token of the response
+// must have been issued for the login attempt of the verifier.
+func (p *OIDCProvider) Exchange(ctx context.Context, code string, codeVerifier string) (*oauth2.Token, error) {
+	token, err := p.OAuth2Provider.Exchange(ctx, code, codeVerifier)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := p.idTokenClaims(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if claims["nonce"] != oidcNonce(codeVerifier) {
+		return nil, errors.New("ID token issued for another login")
+	}
+
+	return token, nil
+}
+
+// FetchUser maps the claims of the ID token into a UserInfo.
+// The email is read from the user info endpoint if the ID token does not have it.
+func (p *OIDCProvider) FetchUser(ctx context.Context, token *oauth2.Token) (*UserInfo, error) {
+	claims, err := p.idTokenClaims(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := claims["email"]; !ok && p.UserInfoURL != "" {
+		var userInfoClaims map[string]interface{}
+		if err := getJSON(ctx, p.UserInfoURL, token.AccessToken, &userInfoClaims); err != nil {
+			log.Printf("Failed to fetch user info of %s: %s\n", p.Name(), err.Error())
+			return nil, err
+		}
+
+		// The user info must be about the user of the ID token
+		if userInfoClaims["sub"] != claims["sub"] {
+			return nil, errors.New("user info of another subject than the ID token")
+		}
+		for _, claim := range []string{"email", "email_verified", "name", "preferred_username"} {
+			if value, ok := userInfoClaims[claim]; ok {
+				claims[claim] = value
+			}
+		}
+	}
+
+	return userInfoFromClaims(claims)
+}
+
+// idTokenClaims returns the claims of the ID token of the token response, once verified
+func (p *OIDCProvider) idTokenClaims(ctx context.Context, token *oauth2.Token) (jwt.MapClaims, error) {
+	rawIDToken, _ := token.Extra("id_token").(string)
+	if rawIDToken == "" {
+		return nil, errors.New("no ID token in the token response")
+	}
+
+	claims, err := p.verifyIDToken(ctx, rawIDToken)
+	if err != nil {
+		log.Printf("Invalid ID token from %s: %s\n", p.Name(), err.Error())
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// VerifiesEmail reports that the emails are only accepted when the provider verified them, see userInfoFromClaims.
+func (p *OIDCProvider) VerifiesEmail() bool {
+	return true
+}
+
+// userInfoFromClaims maps the standard claims into a UserInfo:
+// sub is the account id, the name falls back to preferred_username.
+// Returns an error unless email_verified is true.
+func userInfoFromClaims(claims map[string]interface{}) (*UserInfo, error) {
+	userInfo := &UserInfo{}
+	userInfo.ID, _ = claims["sub"].(string)
+	userInfo.Email, _ = claims["email"].(string)
+	userInfo.Name, _ = claims["name"].(string)
+	if userInfo.Name == "" {
+		userInfo.Name, _ = claims["preferred_username"].(string)
+	}
+
+	// Providers letting users set any email report it, it must not be trusted unless explicitly verified
+	if verified, _ := claims["email_verified"].(bool); !verified {
+		return nil, fmt.Errorf("email %s not verified by the provider", userInfo.Email)
+	}
+
+	return userInfo, nil
+}
+
+// verifyIDToken checks the signature of an ID token against the keys of the provider,
+// its expiry, its issuer and that it was issued to this client.
+func (p *OIDCProvider) verifyIDToken(ctx context.Context, rawIDToken string) (jwt.MapClaims, error) {
+	keyfunc := func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, err := p.key(ctx, kid)
+		if err != nil {
+			return nil, err
+		}
+
+		// The algorithm must match the type of the key, never none nor HMAC
+		switch key.(type) {
+		case *rsa.PublicKey:
+			if _, ok := token.Method.(*jwt.SigningMethodRSA); ok {
+				return key, nil
+			}
+		case *ecdsa.PublicKey:
+			if _, ok := token.Method.(*jwt.SigningMethodECDSA); ok {
+				return key, nil
+			}
+		}
+
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+
+	token, err := jwt.Parse(rawIDToken, keyfunc, jwt.WithExpirationRequired(), jwt.WithLeeway(oidcLeeway))
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid ID token")
+	}
+
+	if iss, _ := claims["iss"].(string); iss == "" || iss != p.issuerOf(claims) {
+		return nil, fmt.Errorf("ID token issued by %v", claims["iss"])
+	}
+	if !hasAudience(claims, p.Config.ClientID) {
+		return nil, fmt.Errorf("ID token issued to %v", claims["aud"])
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, errors.New("ID token without subject")
+	}
+
+	return claims, nil
+}
+
+// issuerOf returns the issuer the ID token must have been issued by,
+// the one of the provider with the tenant of the token in place of its placeholder if any
+func (p *OIDCProvider) issuerOf(claims jwt.MapClaims) string {
+	if !strings.Contains(p.Issuer, tenantPlaceholder) {
+		return p.Issuer
+	}
+
+	tid, _ := claims["tid"].(string)
+	if tid == "" {
+		return ""
+	}
+
+	return strings.ReplaceAll(p.Issuer, tenantPlaceholder, tid)
+}
+
+// hasAudience reports whether the token was issued to the client,
+// a token for several audiences must have been authorized for it (azp)
+func hasAudience(claims jwt.MapClaims, clientID string) bool {
+	switch aud := claims["aud"].(type) {
+	case string:
+		return aud == clientID
+	case []interface{}:
+		found := false
+		for _, a := range aud {
+			if a == clientID {
+				found = true
+			}
+		}
+		if len(aud) > 1 {
+			return found && claims["azp"] == clientID
+		}
+		return found
+	}
+
+	return false
+}
+
+// key returns the key of the provider with the given kid.
+// Keys are fetched again when the kid is unknown, as the provider may have rotated them.
+func (p *OIDCProvider) key(ctx context.Context, kid string) (interface{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key := p.findKey(kid); key != nil {
+		return key, nil
+	}
+
+	if time.Since(p.keysFetchedAt) < jwksRefreshInterval {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	if err := p.fetchKeysLocked(ctx); err != nil {
+		return nil, err
+	}
+
+	if key := p.findKey(kid); key != nil {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+// findKey looks up a key by kid, a token without kid can only use the provider's only key
+func (p *OIDCProvider) findKey(kid string) interface{} {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key
+		}
+	}
+
+	return p.keys[kid]
+}
+
+// fetchKeys fetches the keys published by the provider
+func (p *OIDCProvider) fetchKeys(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.fetchKeysLocked(ctx)
+}
+
+// fetchKeysLocked fetches the keys published by the provider, the caller holds the lock
+func (p *OIDCProvider) fetchKeysLocked(ctx context.Context) error {
+	p.keysFetchedAt = time.Now()
+
+	var set JSONWebKeySet
+	if err := getJSON(ctx, p.JWKSURL, "", &set); err != nil {
+		return err
+	}
+
+	keys := map[string]interface{}{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		key, err := jwk.publicKey()
+		if err != nil {
+			// Keys of unsupported types are skipped, the others remain usable
+			log.Printf("Skipping key %s of %s: %s\n", jwk.Kid, p.Name(), err.Error())
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	p.keys = keys
+
+	return nil
+}
+
+// JSONWebKeySet is a set of public keys (RFC 7517), as published at a jwks_uri
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// JSONWebKey holds the fields used of a JSON Web Key (RFC 7517) of type RSA, EC or OKP
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// publicKey decodes the JSON Web Key into a *rsa.PublicKey or a *ecdsa.PublicKey
+func (jwk *JSONWebKey) publicKey() (interface{}, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() {
+			return nil, errors.New("invalid RSA exponent")
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, ok := curves[jwk.Crv]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point not on curve")
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+}
+
+// decodeBigInt decodes a base64url encoded big-endian integer of a JSON Web Key
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("invalid key parameter")
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,361 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/mystardustcaptain/mattodo/pkg/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeOIDCServer is a local OpenID Connect provider, publishing its discovery document and its key.
+// Codes are granted with authorize, the ID token returned for a code is built by idToken.
+type fakeOIDCServer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+	kid string
+
+	mu     sync.Mutex
+	nonces map[string]string // code -> nonce of the login attempt
+
+	// idToken returns the ID token issued for the nonce, defaults to validClaims signed with key
+	idToken func(nonce string) string
+	// userInfo is served by the user info endpoint
+	userInfo map[string]interface{}
+}
+
+func newFakeOIDCServer(t *testing.T) *fakeOIDCServer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when generating the key", err)
+	}
+
+	f := &fakeOIDCServer{key: key, kid: "key-1", nonces: map[string]string{}}
+	f.idToken = func(nonce string) string { return f.sign(t, key, f.validClaims(nonce)) }
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 f.URL,
+			"authorization_endpoint": f.URL + "/authorize",
+			"token_endpoint":         f.URL + "/token",
+			"userinfo_endpoint":      f.URL + "/userinfo",
+			"jwks_uri":               f.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": f.kid,
+				"use": "sig",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+
+		f.mu.Lock()
+		nonce, ok := f.nonces[r.Form.Get("code")]
+		delete(f.nonces, r.Form.Get("code"))
+		f.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error": "invalid_grant"}`)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "fake-token",
+			"token_type":   "bearer",
+			"id_token":     f.idToken(nonce),
+		})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer fake-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(f.userInfo)
+	})
+
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+
+	return f
+}
+
+// validClaims are the claims of an ID token issued to the client for the login attempt
+func (f *fakeOIDCServer) validClaims(nonce string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":            f.URL,
+		"aud":            "client",
+		"sub":            "5f1c-alice",
+		"email":          "alice@example.com",
+		"email_verified": true,
+		"name":           "Alice",
+		"nonce":          nonce,
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(5 * time.Minute).Unix(),
+	}
+}
+
+// sign signs the claims with the key, under the kid of the server
+func (f *fakeOIDCServer) sign(t *testing.T, key *rsa.PrivateKey, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = f.kid
+
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when signing the ID token", err)
+	}
+
+	return signed
+}
+
+// authorize plays the user logging in at the provider:
+// it grants a code for the nonce found in the login URL.
+func (f *fakeOIDCServer) authorize(t *testing.T, loginURL string) string {
+	u, err := url.Parse(loginURL)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when parsing the login URL", err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	code := fmt.Sprintf("code-%d", len(f.nonces)+1)
+	f.nonces[code] = u.Query().Get("nonce")
+
+	return code
+}
+
+// registerFakeOIDCProvider registers the fake server as an OIDC provider for the duration of the test
+func registerFakeOIDCProvider(t *testing.T, f *fakeOIDCServer) string {
+	name := "fake-oidc"
+	t.Cleanup(func() {
+		UnregisterProvider(name)
+	})
+
+	_, err := RegisterOIDCProvider(OIDCConfig{
+		Name:        name,
+		Issuer:      f.URL,
+		ClientID:    "client",
+		RedirectURL: "http://localhost/auth/callback?provider=" + name,
+	})
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when registering the provider", err)
+	}
+
+	return name
+}
+
+// loginWithOIDC goes through a login at the provider and returns the user info of the callback
+func loginWithOIDC(t *testing.T, f *fakeOIDCServer, provider string) (*UserInfo, error) {
+	ls := NewLoginStates(model.NewMemoryOAuthStateStore())
+	state, binding, _ := ls.Issue(provider)
+	loginURL, _ := LoginURL(provider, state)
+	code := f.authorize(t, loginURL)
+
+	verified, err := ls.Verify(state.State, provider, binding)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when verifying the state", err)
+	}
+
+	return GetUserFromOAuthCode(provider, code, verified.CodeVerifier)
+}
+
+// TestOIDC_LoginMapsClaimsOfIDToken tests that a provider is set up from its discovery document
+// and that the claims of the ID token are mapped into the user info.
+func TestOIDC_LoginMapsClaimsOfIDToken(t *testing.T) {
+	/// Arrange
+	///
+	f := newFakeOIDCServer(t)
+	provider := registerFakeOIDCProvider(t, f)
+
+	/// Act
+	///
+	userInfo, err := loginWithOIDC(t, f, provider)
+
+	/// Assert
+	///
+	assert.NoError(t, err, "Expected no error but got one")
+	assert.Equal(t, &UserInfo{ID: "5f1c-alice", Email: "alice@example.com", Name: "Alice"}, userInfo)
+	p, _ := GetProvider(provider)
+	if assert.IsType(t, &OIDCProvider{}, p) {
+		assert.Equal(t, f.URL+"/authorize", p.(*OIDCProvider).Config.Endpoint.AuthURL)
+		assert.Equal(t, []string{"openid", "email", "profile"}, p.(*OIDCProvider).Config.Scopes)
+	}
+}
+
+// TestOIDC_MissingEmailReadFromUserInfo tests that the email is read from the user info endpoint
+// when the ID token does not have it, and only if it is about the same subject.
+func TestOIDC_MissingEmailReadFromUserInfo(t *testing.T) {
+	tests := []struct {
+		name      string
+		userInfo  map[string]interface{}
+		wantEmail string
+		wantErr   bool
+	}{
+		{"same subject", map[string]interface{}{"sub": "5f1c-alice", "email": "alice@example.com", "email_verified": true}, "alice@example.com", false},
+		{"another subject", map[string]interface{}{"sub": "mallory", "email": "mallory@example.com", "email_verified": true}, "", true},
+		{"unverified email", map[string]interface{}{"sub": "5f1c-alice", "email": "alice@example.com", "email_verified": false}, "", true},
+		{"verification not reported", map[string]interface{}{"sub": "5f1c-alice", "email": "alice@example.com"}, "", true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			/// Arrange
+			///
+			f := newFakeOIDCServer(t)
+			provider := registerFakeOIDCProvider(t, f)
+			f.idToken = func(nonce string) string {
+				claims := f.validClaims(nonce)
+				delete(claims, "email")
+				delete(claims, "email_verified")
+				return f.sign(t, f.key, claims)
+			}
+			f.userInfo = tc.userInfo
+
+			/// Act
+			///
+			userInfo, err := loginWithOIDC(t, f, provider)
+
+			/// Assert
+			///
+			if tc.wantErr {
+				assert.Error(t, err, "Expected the user info to be refused")
+				return
+			}
+			assert.NoError(t, err, "Expected no error but got one")
+			assert.Equal(t, tc.wantEmail, userInfo.Email)
+		})
+	}
+}
+
+// TestOIDC_InvalidIDTokenRefused tests that ID tokens not issued by the provider, to the client,
+// for the login attempt, or expired, are refused.
+func TestOIDC_InvalidIDTokenRefused(t *testing.T) {
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	tests := []struct {
+		name   string
+		tamper func(f *fakeOIDCServer, claims jwt.MapClaims) *rsa.PrivateKey
+	}{
+		{"signed with another key", func(f *fakeOIDCServer, claims jwt.MapClaims) *rsa.PrivateKey { return otherKey }},
+		{"another issuer", func(f *fakeOIDCServer, claims jwt.MapClaims) *rsa.PrivateKey {
+			claims["iss"] = "https://evil.example.com"
+			return f.key
+		}},
+		{"another audience", func(f *fakeOIDCServer, claims jwt.MapClaims) *rsa.PrivateKey {
+			claims["aud"] = "another-client"
+			return f.key
+		}},
+		{"several audiences without azp", func(f *fakeOIDCServer, claims jwt.MapClaims) *rsa.PrivateKey {
+			claims["aud"] = []string{"client", "another-client"}
+			return f.key
+		}},
+		{"another login", func(f *fakeOIDCServer, claims jwt.MapClaims) *rsa.PrivateKey {
+			claims["nonce"] = oidcNonce("verifier-of-another-login")
+			return f.key
+		}},
+		{"expired", func(f *fakeOIDCServer, claims jwt.MapClaims) *rsa.PrivateKey {
+			claims["exp"] = time.Now().Add(-time.Minute).Unix()
+			return f.key
+		}},
+		{"unverified email", func(f *fakeOIDCServer, claims jwt.MapClaims) *rsa.PrivateKey {
+			claims["email_verified"] = false
+			return f.key
+		}},
+		{"verification not reported", func(f *fakeOIDCServer, claims jwt.MapClaims) *rsa.PrivateKey {
+			delete(claims, "email_verified")
+			return f.key
+		}},
+		{"verification not a boolean", func(f *fakeOIDCServer, claims jwt.MapClaims) *rsa.PrivateKey {
+			claims["email_verified"] = "true"
+			return f.key
+		}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			/// Arrange
+			///
+			f := newFakeOIDCServer(t)
+			provider := registerFakeOIDCProvider(t, f)
+			f.idToken = func(nonce string) string {
+				claims := f.validClaims(nonce)
+				return f.sign(t, tc.tamper(f, claims), claims)
+			}
+
+			/// Act
+			///
+			_, err := loginWithOIDC(t, f, provider)
+
+			/// Assert
+			///
+			assert.Error(t, err, "Expected the ID token to be refused")
+		})
+	}
+}
+
+// TestOIDC_IssuerMismatchRefused tests that a discovery document of another issuer is refused.
+func TestOIDC_IssuerMismatchRefused(t *testing.T) {
+	/// Arrange
+	///
+	f := newFakeOIDCServer(t)
+	t.Cleanup(func() {
+		UnregisterProvider("mismatch")
+	})
+
+	/// Act
+	///
+	_, err := RegisterOIDCProvider(OIDCConfig{Name: "mismatch", Issuer: f.URL + "/realms/other", ClientID: "client"})
+
+	/// Assert
+	///
+	assert.Error(t, err, "Expected the provider to be refused")
+	assert.NotContains(t, ProviderNames(), "mismatch")
+}
+
+// TestOIDC_RegisterFromEnv tests that the providers listed in OIDC_PROVIDERS are registered
+// with the variables prefixed with their name.
+func TestOIDC_RegisterFromEnv(t *testing.T) {
+	/// Arrange
+	///
+	f := newFakeOIDCServer(t)
+	t.Cleanup(func() {
+		UnregisterProvider("my-sso")
+	})
+	t.Setenv("OIDC_PROVIDERS", "my-sso")
+	t.Setenv("OIDC_MY_SSO_ISSUER", f.URL)
+	t.Setenv("OIDC_MY_SSO_CLIENT_ID", "client")
+	t.Setenv("OIDC_MY_SSO_SCOPES", "openid email")
+
+	/// Act
+	///
+	err := RegisterOIDCProvidersFromEnv()
+
+	/// Assert
+	///
+	assert.NoError(t, err, "Expected no error but got one")
+	p, ok := GetProvider("my-sso")
+	if assert.True(t, ok, "Expected the provider to be registered") {
+		assert.Equal(t, []string{"openid", "email"}, p.(*OIDCProvider).Config.Scopes)
+	}
+}
@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/mystardustcaptain/mattodo/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+)
+
+// fakeAuthServer is a local authorization server enforcing PKCE (S256).
+// Codes are granted with authorize and can only be redeemed with the matching verifier.
+type fakeAuthServer struct {
+	*httptest.Server
+	mu         sync.Mutex
+	challenges map[string]string // code -> code_challenge
+}
+
+func newFakeAuthServer() *fakeAuthServer {
+	f := &fakeAuthServer{challenges: map[string]string{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+
+		f.mu.Lock()
+		challenge, ok := f.challenges[r.Form.Get("code")]
+		delete(f.challenges, r.Form.Get("code"))
+		f.mu.Unlock()
+
+		sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != challenge {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error": "invalid_grant"}`)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token": "fake-token", "token_type": "bearer"}`)
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer fake-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"id": "1234", "email": "alice@example.com", "verified_email": true, "name": "Alice"}`)
+	})
+
+	f.Server = httptest.NewServer(mux)
+	return f
+}
+
+// authorize plays the user logging in at the provider:
+// it grants a code for the challenge found in the login URL.
+func (f *fakeAuthServer) authorize(t *testing.T, loginURL string) string {
+	u, err := url.Parse(loginURL)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when parsing the login URL", err)
+	}
+
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		t.Fatalf("expected a S256 code challenge in the login URL: %s", loginURL)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	code := fmt.Sprintf("code-%d", len(f.challenges)+1)
+	f.challenges[code] = q.Get("code_challenge")
+
+	return code
+}
+
+// useFakeProvider points the google provider at the fake server for the duration of the test
+func useFakeProvider(t *testing.T, f *fakeAuthServer) {
+	original, _ := GetProvider("google")
+	t.Cleanup(func() {
+		UnregisterProvider("google")
+		RegisterProvider(original)
+	})
+
+	p := NewGoogleProvider(ProviderConfig{ClientID: "client", RedirectURL: "http://localhost/auth/callback?provider=google"})
+	p.Config.Endpoint = oauth2.Endpoint{AuthURL: f.URL + "/authorize", TokenURL: f.URL + "/token"}
+	p.UserInfoURL = f.URL + "/userinfo"
+
+	UnregisterProvider("google")
+	RegisterProvider(p)
+}
+
+// TestPKCE_CodeRedeemedWithVerifierOfLogin tests that a code granted for a login attempt
+// is exchanged with the verifier stored alongside its state.
+func TestPKCE_CodeRedeemedWithVerifierOfLogin(t *testing.T) {
+	/// Arrange
+	///
+	f := newFakeAuthServer()
+	defer f.Close()
+	useFakeProvider(t, f)
+
+	ls := NewLoginStates(model.NewMemoryOAuthStateStore())
+	state, binding, _ := ls.Issue("google")
+	loginURL, _ := LoginURL("google", state)
+	code := f.authorize(t, loginURL)
+
+	/// Act
+	///
+	verified, err := ls.Verify(state.State, "google", binding)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when verifying the state", err)
+	}
+	userInfo, err := GetUserFromOAuthCode("google", code, verified.CodeVerifier)
+
+	/// Assert
+	///
+	assert.NoError(t, err, "Expected no error but got one")
+	assert.Equal(t, "alice@example.com", userInfo.Email)
+}
+
+// TestPKCE_InterceptedCodeCannotBeRedeemed tests that a code intercepted by an attacker
+// cannot be exchanged without the verifier of the login attempt it was granted for.
+func TestPKCE_InterceptedCodeCannotBeRedeemed(t *testing.T) {
+	/// Arrange
+	///
+	f := newFakeAuthServer()
+	defer f.Close()
+	useFakeProvider(t, f)
+
+	ls := NewLoginStates(model.NewMemoryOAuthStateStore())
+	victim, _, _ := ls.Issue("google")
+	victimURL, _ := LoginURL("google", victim)
+	code := f.authorize(t, victimURL)
+
+	// The attacker starts their own login, getting a verifier of their own
+	attacker, _, _ := ls.Issue("google")
+
+	/// Act
+	///
+	_, err := GetUserFromOAuthCode("google", code, attacker.CodeVerifier)
+
+	/// Assert
+	///
+	assert.Error(t, err, "Expected the code exchange to be refused")
+}
@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// Provider is an OAuth provider users can sign in with.
+// Each provider knows its own endpoints and how to read the user's account from its API.
+type Provider interface {
+	// Name identifies the provider in the login URLs and in the identities of the users, e.g. github
+	Name() string
+	// AuthURL returns the provider's login page URL for a login attempt,
+	// with the state and the PKCE challenge derived from the verifier of the attempt
+	AuthURL(state string, codeVerifier string) string
+	// Exchange redeems the code returned to the callback with the verifier of the login attempt
+	Exchange(ctx context.Context, code string, codeVerifier string) (*oauth2.Token, error)
+	// FetchUser reads the account of the user the token was issued for
+	FetchUser(ctx context.Context, token *oauth2.Token) (*UserInfo, error)
+}
+
+// EmailVerifier is implemented by the providers checking that the email of the user is verified
+// from what the provider reports, rather than relying on its policy.
+type EmailVerifier interface {
+	// VerifiesEmail reports whether FetchUser only returns emails the provider verified
+	VerifiesEmail() bool
+}
+
+// VerifiesEmail reports whether the provider registered with the given name only returns verified emails.
+func VerifiesEmail(name string) bool {
+	p, ok := GetProvider(name)
+	if !ok {
+		return false
+	}
+
+	v, ok := p.(EmailVerifier)
+	return ok && v.VerifiesEmail()
+}
+
+// ProviderConfig is the client registered for the application at a provider.
+type ProviderConfig struct {
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+}
+
+// providerConfigFromEnv reads the client of a provider from the variables with the given prefix,
+// e.g. GITHUB_CLIENT_ID, GITHUB_CLIENT_SECRET and GITHUB_REDIRECT_URL
+func providerConfigFromEnv(prefix string) ProviderConfig {
+	return ProviderConfig{
+		ClientID:     os.Getenv(prefix + "_CLIENT_ID"),
+		ClientSecret: os.Getenv(prefix + "_CLIENT_SECRET"),
+		RedirectURL:  os.Getenv(prefix + "_REDIRECT_URL"),
+	}
+}
+
+// HTTPClient is the client used to call the providers
+var HTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// ErrUnknownProvider is returned for a provider that is not registered.
+var ErrUnknownProvider = errors.New("unknown OAuth provider")
+
+// providers are the registered providers, by name
+var (
+	providersMu sync.RWMutex
+	providers   = map[string]Provider{}
+)
+
+// providerName is the format of the names of providers, they end up in URLs and environment variables
+var providerName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// RegisterProvidersFromEnv registers the built-in providers, configured with their environment variables,
+// e.g. GITHUB_CLIENT_ID, see providerConfigFromEnv.
+func RegisterProvidersFromEnv() error {
+	for _, p := range []Provider{
+		NewGoogleProvider(providerConfigFromEnv("GOOGLE")),
+		NewFacebookProvider(providerConfigFromEnv("FACEBOOK")),
+		NewGitHubProvider(providerConfigFromEnv("GITHUB")),
+		NewGitLabProvider(providerConfigFromEnv("GITLAB"), os.Getenv("GITLAB_URL")),
+		NewMicrosoftProvider(providerConfigFromEnv("MICROSOFT"), os.Getenv("MICROSOFT_TENANT")),
+		NewBitbucketProvider(providerConfigFromEnv("BITBUCKET")),
+	} {
+		if err := RegisterProvider(p); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RegisterProvider adds a provider users can sign in with.
+// Returns an error if the name is invalid or already taken.
+func RegisterProvider(p Provider) error {
+	if !providerName.MatchString(p.Name()) {
+		return fmt.Errorf("invalid OAuth provider name %q", p.Name())
+	}
+
+	providersMu.Lock()
+	defer providersMu.Unlock()
+
+	if _, ok := providers[p.Name()]; ok {
+		return fmt.Errorf("OAuth provider %s already registered", p.Name())
+	}
+	providers[p.Name()] = p
+
+	return nil
+}
+
+// UnregisterProvider removes a provider, users can no longer sign in with it.
+func UnregisterProvider(name string) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+
+	delete(providers, name)
+}
+
+// GetProvider returns the provider registered with the given name.
+func GetProvider(name string) (Provider, bool) {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+
+	p, ok := providers[name]
+	return p, ok
+}
+
+// ProviderNames returns the names of the registered providers, sorted.
+func ProviderNames() []string {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// OAuth2Provider implements the login of a Provider with a standard OAuth 2.0 authorization code flow
+// with PKCE (S256). Providers embed it and only implement FetchUser.
+type OAuth2Provider struct {
+	ProviderName string
+	Config       *oauth2.Config
+}
+
+// Name identifies the provider.
+func (p *OAuth2Provider) Name() string {
+	return p.ProviderName
+}
+
+// AuthURL returns the provider's login page URL for a login attempt.
+func (p *OAuth2Provider) AuthURL(state string, codeVerifier string) string {
+	return p.Config.AuthCodeURL(state, oauth2.S256ChallengeOption(codeVerifier))
+}
+
+// Exchange redeems the code returned to the callback with the verifier of the login attempt.
+func (p *OAuth2Provider) Exchange(ctx context.Context, code string, codeVerifier string) (*oauth2.Token, error) {
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, HTTPClient)
+	return p.Config.Exchange(ctx, code, oauth2.VerifierOption(codeVerifier))
+}
+
+// getJSON fetches a JSON document, with the access token as bearer if given
+func getJSON(ctx context.Context, url string, accessToken string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+
+	response, err := HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, response.Status)
+	}
+
+	return json.NewDecoder(response.Body).Decode(v)
+}
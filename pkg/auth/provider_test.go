@@ -0,0 +1,139 @@
+package auth_test
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/mystardustcaptain/mattodo/pkg/auth"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+)
+
+// TestMain registers the built-in providers, as the service does on startup
+func TestMain(m *testing.M) {
+	if err := auth.RegisterProvidersFromEnv(); err != nil {
+		log.Fatalf("Failed to register providers: %s\n", err)
+	}
+
+	os.Exit(m.Run())
+}
+
+// newStandIn starts a local stand-in for the API of a provider,
+// serving the JSON documents by path to the requests bearing the access token fake-token
+func newStandIn(t *testing.T, documents map[string]string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer fake-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		document, ok := documents[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, document)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// fakeToken is the token the stand-ins accept
+var fakeToken = &oauth2.Token{AccessToken: "fake-token", TokenType: "bearer"}
+
+// staticProvider is a Provider signing in the same user whatever the code
+type staticProvider struct {
+	name string
+	user *auth.UserInfo
+}
+
+func (p *staticProvider) Name() string { return p.name }
+
+func (p *staticProvider) AuthURL(state string, codeVerifier string) string {
+	return "https://sso.example.com/login?state=" + state
+}
+
+func (p *staticProvider) Exchange(ctx context.Context, code string, codeVerifier string) (*oauth2.Token, error) {
+	return fakeToken, nil
+}
+
+func (p *staticProvider) FetchUser(ctx context.Context, token *oauth2.Token) (*auth.UserInfo, error) {
+	return p.user, nil
+}
+
+// TestProviders_BuiltInsRegistered tests that every built-in provider is registered.
+func TestProviders_BuiltInsRegistered(t *testing.T) {
+	/// Act
+	///
+	names := auth.ProviderNames()
+
+	/// Assert
+	///
+	for _, name := range []string{"bitbucket", "facebook", "github", "gitlab", "google", "microsoft"} {
+		assert.Contains(t, names, name)
+	}
+}
+
+// TestRegisterProvider_SignsUsersIn tests that a provider registered is used for the login.
+func TestRegisterProvider_SignsUsersIn(t *testing.T) {
+	/// Arrange
+	///
+	p := &staticProvider{name: "static", user: &auth.UserInfo{ID: "1", Email: "alice@example.com", Name: "Alice"}}
+	t.Cleanup(func() { auth.UnregisterProvider("static") })
+
+	/// Act
+	///
+	err := auth.RegisterProvider(p)
+	userInfo, exchangeErr := auth.GetUserFromOAuthCode("static", "code", "verifier")
+
+	/// Assert
+	///
+	assert.NoError(t, err, "Expected no error but got one")
+	assert.NoError(t, exchangeErr, "Expected no error but got one")
+	assert.Equal(t, p.user, userInfo)
+}
+
+// TestRegisterProvider_InvalidOrTakenNameRefused tests that a provider cannot take the name of another one,
+// nor a name unfit for URLs.
+func TestRegisterProvider_InvalidOrTakenNameRefused(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider string
+	}{
+		{"taken", "github"},
+		{"empty", ""},
+		{"upper case", "MyProvider"},
+		{"with a slash", "my/provider"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			/// Act
+			///
+			err := auth.RegisterProvider(&staticProvider{name: tc.provider})
+
+			/// Assert
+			///
+			assert.Error(t, err, "Expected the provider to be refused")
+		})
+	}
+}
+
+// TestGetUserFromOAuthCode_UnknownProvider tests that a provider not registered is refused.
+func TestGetUserFromOAuthCode_UnknownProvider(t *testing.T) {
+	/// Act
+	///
+	_, err := auth.GetUserFromOAuthCode("myspace", "code", "verifier")
+
+	/// Assert
+	///
+	assert.ErrorIs(t, err, auth.ErrUnknownProvider)
+}
@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/mystardustcaptain/mattodo/pkg/model"
+)
+
+// DefaultRefreshTokenTTL is how long a refresh token can be used, each rotation starts it again
+const DefaultRefreshTokenTTL = 30 * 24 * time.Hour
+
+// ErrInvalidRefreshToken is returned when a refresh token is unknown, expired or revoked.
+var ErrInvalidRefreshToken = errors.New("invalid refresh token")
+
+// ErrRefreshTokenReused is returned when an already rotated refresh token is presented again.
+// The token has likely been stolen, so its whole family has been revoked.
+var ErrRefreshTokenReused = errors.New("refresh token reused")
+
+// RefreshTokens issues and rotates the refresh tokens exchanged for new access tokens.
+type RefreshTokens struct {
+	Store model.RefreshTokenStore
+	TTL   time.Duration
+}
+
+// NewRefreshTokens returns RefreshTokens keeping the tokens in the given store.
+func NewRefreshTokens(store model.RefreshTokenStore) *RefreshTokens {
+	return &RefreshTokens{Store: store, TTL: DefaultRefreshTokenTTL}
+}
+
+// Issue creates the first refresh token of a new family, for a login of a User of a given userID.
+// Returns the token to hand to the user, only its hash is stored.
+func (rt *RefreshTokens) Issue(userID int) (string, error) {
+	familyID, err := randomString(16)
+	if err != nil {
+		log.Printf("Failed to generate family id: %s\n", err.Error())
+		return "", err
+	}
+
+	return rt.issue(userID, familyID)
+}
+
+// Rotate exchanges a refresh token for a new one of the same family.
+// Returns the userID the token belongs to and the new token,
+// ErrInvalidRefreshToken if the token cannot be used,
+// or ErrRefreshTokenReused if it had already been rotated, in which case the whole family is revoked.
+func (rt *RefreshTokens) Rotate(token string) (int, string, error) {
+	now := time.Now()
+
+	current, err := rt.Store.GetRefreshTokenByHash(hashString(token))
+	if errors.Is(err, model.ErrNotFound) {
+		return 0, "", ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return 0, "", err
+	}
+
+	if current.RevokedAt != nil || now.After(current.ExpiresAt) {
+		return 0, "", ErrInvalidRefreshToken
+	}
+	// A token issued while its family was being revoked escapes the revocation, the family tells
+	revoked, err := rt.Store.IsRefreshTokenFamilyRevoked(current.FamilyID)
+	if err != nil {
+		return 0, "", err
+	}
+	if revoked {
+		return 0, "", ErrInvalidRefreshToken
+	}
+
+	// A rotated token showing up again means two parties hold it,
+	// there is no telling which one is legitimate so the family is revoked for both
+	if current.RotatedAt != nil {
+		return 0, "", rt.revokeReused(current, now)
+	}
+
+	next, record, err := rt.newToken(current.UserID, current.FamilyID)
+	if err != nil {
+		return 0, "", err
+	}
+	err = rt.Store.RotateRefreshToken(current.ID, now, record)
+	if errors.Is(err, model.ErrConflict) {
+		// Lost a race against another use of the same token
+		return 0, "", rt.revokeReused(current, now)
+	}
+	if err != nil {
+		log.Printf("Failed to rotate refresh token: %s\n", err.Error())
+		return 0, "", err
+	}
+
+	// The family may have been revoked by a replay while rotating, the new token must go with it
+	revoked, err = rt.Store.IsRefreshTokenFamilyRevoked(current.FamilyID)
+	if err != nil {
+		return 0, "", err
+	}
+	if revoked {
+		if err := rt.Store.RevokeRefreshTokenFamily(current.FamilyID, now); err != nil {
+			return 0, "", err
+		}
+		return 0, "", ErrInvalidRefreshToken
+	}
+
+	return current.UserID, next, nil
+}
+
+// Revoke revokes a refresh token of a User of a given userID, along with every token of its family.
+// Returns ErrInvalidRefreshToken if the token is unknown or belongs to another user.
+func (rt *RefreshTokens) Revoke(userID int, token string) error {
+	current, err := rt.Store.GetRefreshTokenByHash(hashString(token))
+	if errors.Is(err, model.ErrNotFound) {
+		return ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return err
+	}
+
+	if current.UserID != userID {
+		return ErrInvalidRefreshToken
+	}
+
+	return rt.Store.RevokeRefreshTokenFamily(current.FamilyID, time.Now())
+}
+
+// RevokeAll revokes every refresh token of a User of a given userID.
+func (rt *RefreshTokens) RevokeAll(userID int) error {
+	return rt.Store.RevokeUserRefreshTokens(userID, time.Now())
+}
+
+// issue creates a refresh token in the given family
+func (rt *RefreshTokens) issue(userID int, familyID string) (string, error) {
+	token, record, err := rt.newToken(userID, familyID)
+	if err != nil {
+		return "", err
+	}
+	if err := rt.Store.CreateRefreshToken(record); err != nil {
+		log.Printf("Failed to store refresh token: %s\n", err.Error())
+		return "", err
+	}
+
+	return token, nil
+}
+
+// newToken generates a refresh token in the given family, and the record to store for it
+func (rt *RefreshTokens) newToken(userID int, familyID string) (string, *model.RefreshToken, error) {
+	token, err := randomString(32)
+	if err != nil {
+		log.Printf("Failed to generate refresh token: %s\n", err.Error())
+		return "", nil, err
+	}
+
+	now := time.Now()
+	record := &model.RefreshToken{
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: hashString(token),
+		ExpiresAt: now.Add(rt.TTL),
+		CreatedAt: now,
+	}
+
+	return token, record, nil
+}
+
+// revokeReused revokes the family of a replayed token and returns ErrRefreshTokenReused
+func (rt *RefreshTokens) revokeReused(reused *model.RefreshToken, now time.Time) error {
+	log.Printf("Refresh token reuse detected for user %d, revoking family\n", reused.UserID)
+
+	if err := rt.Store.RevokeRefreshTokenFamily(reused.FamilyID, now); err != nil {
+		return err
+	}
+
+	return ErrRefreshTokenReused
+}
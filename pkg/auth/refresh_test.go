@@ -0,0 +1,105 @@
+package auth_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mystardustcaptain/mattodo/pkg/auth"
+	"github.com/mystardustcaptain/mattodo/pkg/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRefreshTokens_RotateIssuesNewToken tests that Rotate exchanges a refresh token
+// for a new one of the same user, and that the new one can be rotated in turn.
+func TestRefreshTokens_RotateIssuesNewToken(t *testing.T) {
+	/// Arrange
+	///
+	rt := auth.NewRefreshTokens(model.NewMemoryRefreshTokenStore())
+	first, _ := rt.Issue(2)
+
+	/// Act
+	///
+	userID, second, err := rt.Rotate(first)
+	_, third, errNext := rt.Rotate(second)
+
+	/// Assert
+	///
+	assert.NoError(t, err, "Expected no error but got one")
+	assert.Equal(t, 2, userID)
+	assert.NotEqual(t, first, second)
+	assert.NoError(t, errNext, "Expected the rotated token to be usable")
+	assert.NotEmpty(t, third)
+}
+
+// TestRefreshTokens_ReuseRevokesFamily tests that replaying a rotated refresh token
+// is detected and revokes the tokens rotated from it, while other logins keep working.
+func TestRefreshTokens_ReuseRevokesFamily(t *testing.T) {
+	/// Arrange
+	///
+	rt := auth.NewRefreshTokens(model.NewMemoryRefreshTokenStore())
+	stolen, _ := rt.Issue(2)
+	otherLogin, _ := rt.Issue(2)
+	_, legitimate, _ := rt.Rotate(stolen)
+
+	/// Act
+	///
+	_, _, errReplay := rt.Rotate(stolen)
+	_, _, errLegitimate := rt.Rotate(legitimate)
+	_, _, errOther := rt.Rotate(otherLogin)
+
+	/// Assert
+	///
+	assert.ErrorIs(t, errReplay, auth.ErrRefreshTokenReused)
+	assert.ErrorIs(t, errLegitimate, auth.ErrInvalidRefreshToken, "Expected the whole family to be revoked")
+	assert.NoError(t, errOther, "Expected another login of the user not to be affected")
+}
+
+// TestRefreshTokens_RejectsUnknownExpiredAndRevoked tests that Rotate refuses tokens
+// that do not exist, have expired or have been revoked.
+func TestRefreshTokens_RejectsUnknownExpiredAndRevoked(t *testing.T) {
+	rt := auth.NewRefreshTokens(model.NewMemoryRefreshTokenStore())
+
+	_, _, err := rt.Rotate("made-up")
+	assert.ErrorIs(t, err, auth.ErrInvalidRefreshToken)
+
+	rt.TTL = -time.Second
+	expired, _ := rt.Issue(2)
+	_, _, err = rt.Rotate(expired)
+	assert.ErrorIs(t, err, auth.ErrInvalidRefreshToken)
+
+	rt.TTL = time.Hour
+	revoked, _ := rt.Issue(2)
+	rt.RevokeAll(2)
+	_, _, err = rt.Rotate(revoked)
+	assert.ErrorIs(t, err, auth.ErrInvalidRefreshToken)
+}
+
+// replayingStore revokes the family of a refresh token while it is rotated,
+// as a replay of the token handled at the same time would, before the next token is stored
+type replayingStore struct {
+	*model.MemoryRefreshTokenStore
+}
+
+func (s *replayingStore) RotateRefreshToken(id int, at time.Time, next *model.RefreshToken) error {
+	s.RevokeRefreshTokenFamily(next.FamilyID, at)
+	return s.MemoryRefreshTokenStore.RotateRefreshToken(id, at, next)
+}
+
+// TestRefreshTokens_RotationRacingRevocationRefused tests that a token rotated while its family is revoked
+// is not handed out.
+func TestRefreshTokens_RotationRacingRevocationRefused(t *testing.T) {
+	/// Arrange
+	///
+	store := &replayingStore{MemoryRefreshTokenStore: model.NewMemoryRefreshTokenStore()}
+	rt := auth.NewRefreshTokens(store)
+	token, _ := rt.Issue(2)
+
+	/// Act
+	///
+	_, next, err := rt.Rotate(token)
+
+	/// Assert
+	///
+	assert.ErrorIs(t, err, auth.ErrInvalidRefreshToken)
+	assert.Empty(t, next, "Expected no token to be handed out")
+}
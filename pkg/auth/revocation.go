@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mystardustcaptain/mattodo/pkg/model"
+)
+
+// DefaultRevocationSyncInterval is how often the revocations are pruned and reloaded from the store,
+// which is also how long a revocation made by another instance may take to be enforced
+const DefaultRevocationSyncInterval = time.Minute
+
+// Revocations is the list of the access tokens revoked before their expiry.
+// Checking a token only reads the in-memory cache, revoking writes through to the store,
+// which Sync reloads periodically to pick up the revocations made by other instances.
+type Revocations struct {
+	Store model.TokenRevocationStore
+	// Leeway is the clock skew tolerated on the expiry of the tokens, they are kept revoked that long past it
+	Leeway time.Duration
+
+	mu       sync.RWMutex
+	tokens   map[string]time.Time // jti -> expiry of the token
+	sessions map[int]time.Time    // userID -> tokens issued before are revoked
+}
+
+// NewRevocations returns Revocations persisted in the given store,
+// for tokens accepted up to leeway past their expiry, see Authenticator.Leeway.
+// Load must be called to pick up the revocations already in the store.
+func NewRevocations(store model.TokenRevocationStore, leeway time.Duration) *Revocations {
+	return &Revocations{
+		Store:    store,
+		Leeway:   leeway,
+		tokens:   map[string]time.Time{},
+		sessions: map[int]time.Time{},
+	}
+}
+
+// RevokeToken revokes a single access token, e.g. when logging out.
+// Tokens issued without a jti cannot be revoked individually.
+func (rv *Revocations) RevokeToken(token *TokenInfo) error {
+	if token.ID == "" {
+		return nil
+	}
+
+	record := &model.RevokedToken{
+		JTI:       token.ID,
+		UserID:    token.UserID,
+		ExpiresAt: token.ExpiresAt,
+		RevokedAt: time.Now(),
+	}
+	if err := rv.Store.RevokeToken(record); err != nil {
+		log.Printf("Failed to store revoked token: %s\n", err.Error())
+		return err
+	}
+
+	rv.mu.Lock()
+	rv.tokens[token.ID] = token.ExpiresAt
+	rv.mu.Unlock()
+
+	return nil
+}
+
+// RevokeSessions revokes every access token issued so far to a User of a given userID.
+func (rv *Revocations) RevokeSessions(userID int) error {
+	// Issue times are in whole seconds, a token issued within the current second is revoked too
+	before := time.Now().Truncate(time.Second).Add(time.Second)
+
+	if err := rv.Store.RevokeSessions(&model.SessionRevocation{UserID: userID, RevokedBefore: before}); err != nil {
+		log.Printf("Failed to store session revocation: %s\n", err.Error())
+		return err
+	}
+
+	rv.mu.Lock()
+	rv.sessions[userID] = before
+	rv.mu.Unlock()
+
+	return nil
+}
+
+// IsRevoked reports whether an access token has been revoked.
+func (rv *Revocations) IsRevoked(token *TokenInfo) bool {
+	rv.mu.RLock()
+	defer rv.mu.RUnlock()
+
+	if _, ok := rv.tokens[token.ID]; ok && token.ID != "" {
+		return true
+	}
+
+	before, ok := rv.sessions[token.UserID]
+	return ok && token.IssuedAt.Before(before)
+}
+
+// Load adds the revocations of the store to the cache.
+// Revocations are only ever added, concurrent revocations cannot be lost to a reload.
+func (rv *Revocations) Load() error {
+	tokens, err := rv.Store.GetRevokedTokens(time.Now().Add(-rv.Leeway))
+	if err != nil {
+		log.Printf("Failed to load revoked tokens: %s\n", err.Error())
+		return err
+	}
+
+	sessions, err := rv.Store.GetSessionRevocations()
+	if err != nil {
+		log.Printf("Failed to load session revocations: %s\n", err.Error())
+		return err
+	}
+
+	rv.mu.Lock()
+	defer rv.mu.Unlock()
+
+	for _, t := range tokens {
+		rv.tokens[t.JTI] = t.ExpiresAt
+	}
+	for _, s := range sessions {
+		if s.RevokedBefore.After(rv.sessions[s.UserID]) {
+			rv.sessions[s.UserID] = s.RevokedBefore
+		}
+	}
+
+	return nil
+}
+
+// Prune forgets the revoked tokens expired at now, beyond the leeway, they are refused for their expiry anyway.
+func (rv *Revocations) Prune(now time.Time) error {
+	// A token is still accepted within the leeway past its expiry, its revocation must outlive it
+	now = now.Add(-rv.Leeway)
+
+	rv.mu.Lock()
+	for jti, expiresAt := range rv.tokens {
+		if expiresAt.Before(now) {
+			delete(rv.tokens, jti)
+		}
+	}
+	rv.mu.Unlock()
+
+	if err := rv.Store.DeleteExpiredRevokedTokens(now); err != nil {
+		log.Printf("Failed to delete expired revoked tokens: %s\n", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// Sync prunes and reloads the revocations every interval until ctx is done.
+// Meant to run in its own goroutine.
+func (rv *Revocations) Sync(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// Errors are logged, the cache keeps serving until the next attempt
+			if err := rv.Prune(time.Now()); err == nil {
+				rv.Load()
+			}
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package auth_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mystardustcaptain/mattodo/pkg/auth"
+	"github.com/mystardustcaptain/mattodo/pkg/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// serveWithToken sends a request with the given access token through the middleware
+// and returns the status code
+func serveWithToken(a *auth.Authenticator, token string) int {
+	handler := a.ValidateTokenMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/todo", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	handler.ServeHTTP(w, r)
+
+	return w.Code
+}
+
+// tokenInfoOf returns the TokenInfo the middleware puts in the context for the given access token
+func tokenInfoOf(t *testing.T, a *auth.Authenticator, token string) *auth.TokenInfo {
+	var info *auth.TokenInfo
+	handler := a.ValidateTokenMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info, _ = r.Context().Value(auth.ContextTokenKey).(*auth.TokenInfo)
+	}))
+
+	r := httptest.NewRequest("GET", "/todo", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	if info == nil {
+		t.Fatalf("expected the token to be accepted")
+	}
+	return info
+}
+
+// TestValidateTokenMiddleware_RefusesRevokedToken tests that a revoked access token is refused
+// before its expiry, while other tokens of the same user keep working.
+func TestValidateTokenMiddleware_RefusesRevokedToken(t *testing.T) {
+	/// Arrange
+	///
+	a := &auth.Authenticator{Keys: auth.NewKeySet(model.NewMemorySigningKeyStore(), auth.AlgorithmEdDSA), Revocations: auth.NewRevocations(model.NewMemoryTokenRevocationStore(), auth.DefaultLeeway)}
+	revoked, _ := a.CreateToken("alice@example.com", 2, 1)
+	other, _ := a.CreateToken("alice@example.com", 2, 1)
+
+	info := tokenInfoOf(t, a, revoked)
+
+	/// Act
+	///
+	err := a.Revocations.RevokeToken(info)
+
+	/// Assert
+	///
+	assert.NoError(t, err, "Expected no error but got one")
+	assert.NotEmpty(t, info.ID, "Expected the token to have a jti")
+	assert.Equal(t, http.StatusUnauthorized, serveWithToken(a, revoked))
+	assert.Equal(t, http.StatusOK, serveWithToken(a, other))
+}
+
+// TestRevocations_RevokeSessionsRefusesEveryTokenOfUser tests that revoking the sessions of a user
+// refuses all their tokens issued so far, and only theirs.
+func TestRevocations_RevokeSessionsRefusesEveryTokenOfUser(t *testing.T) {
+	/// Arrange
+	///
+	a := &auth.Authenticator{Keys: auth.NewKeySet(model.NewMemorySigningKeyStore(), auth.AlgorithmEdDSA), Revocations: auth.NewRevocations(model.NewMemoryTokenRevocationStore(), auth.DefaultLeeway)}
+	first, _ := a.CreateToken("alice@example.com", 2, 1)
+	second, _ := a.CreateToken("alice@example.com", 2, 1)
+	otherUser, _ := a.CreateToken("bob@example.com", 3, 1)
+
+	/// Act
+	///
+	err := a.Revocations.RevokeSessions(2)
+
+	/// Assert
+	///
+	assert.NoError(t, err, "Expected no error but got one")
+	assert.Equal(t, http.StatusUnauthorized, serveWithToken(a, first))
+	assert.Equal(t, http.StatusUnauthorized, serveWithToken(a, second))
+	assert.Equal(t, http.StatusOK, serveWithToken(a, otherUser))
+}
+
+// TestRevocations_LoadPicksUpRevocationsOfStore tests that revocations made through another instance
+// sharing the store are enforced once loaded, and that expired ones are pruned.
+func TestRevocations_LoadPicksUpRevocationsOfStore(t *testing.T) {
+	/// Arrange
+	///
+	store := model.NewMemoryTokenRevocationStore()
+	instanceA := auth.NewRevocations(store, auth.DefaultLeeway)
+	instanceB := auth.NewRevocations(store, auth.DefaultLeeway)
+
+	now := time.Now()
+	live := &auth.TokenInfo{ID: "live", UserID: 2, IssuedAt: now, ExpiresAt: now.Add(time.Hour)}
+	expired := &auth.TokenInfo{ID: "expired", UserID: 2, IssuedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour)}
+	instanceA.RevokeToken(live)
+	instanceA.RevokeToken(expired)
+
+	/// Act
+	///
+	errPrune := instanceB.Prune(now)
+	errLoad := instanceB.Load()
+
+	/// Assert
+	///
+	assert.NoError(t, errPrune, "Expected no error but got one")
+	assert.NoError(t, errLoad, "Expected no error but got one")
+	assert.True(t, instanceB.IsRevoked(live), "Expected the revocation of the other instance to be loaded")
+
+	remaining, _ := store.GetRevokedTokens(now.Add(-24 * time.Hour))
+	assert.Equal(t, 1, len(remaining), "Expected the expired revocation to be pruned from the store")
+}
+
+// TestRevocations_PruneKeepsTokensWithinLeeway tests that a revoked token stays refused
+// while it is still accepted for the leeway past its expiry, whichever instance prunes and reloads.
+func TestRevocations_PruneKeepsTokensWithinLeeway(t *testing.T) {
+	/// Arrange
+	///
+	store := model.NewMemoryTokenRevocationStore()
+	instanceA := auth.NewRevocations(store, auth.DefaultLeeway)
+	instanceB := auth.NewRevocations(store, auth.DefaultLeeway)
+
+	expiresAt := time.Now()
+	token := &auth.TokenInfo{ID: "just-expired", UserID: 2, IssuedAt: expiresAt.Add(-time.Hour), ExpiresAt: expiresAt}
+	instanceA.RevokeToken(token)
+
+	/// Act
+	///
+	errPrune := instanceA.Prune(expiresAt.Add(time.Second))
+	errLoad := instanceB.Load()
+
+	/// Assert
+	///
+	assert.NoError(t, errPrune, "Expected no error but got one")
+	assert.NoError(t, errLoad, "Expected no error but got one")
+	assert.True(t, instanceA.IsRevoked(token), "Expected the token to stay revoked within the leeway")
+	assert.True(t, instanceB.IsRevoked(token), "Expected the revocation to be kept in the store within the leeway")
+
+	instanceA.Prune(expiresAt.Add(auth.DefaultLeeway + time.Second))
+	assert.False(t, instanceA.IsRevoked(token), "Expected the revocation to be forgotten past the leeway")
+}
@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+// Cookies of the browser sessions, set after a login instead of returning the tokens
+const (
+	// SessionCookieName holds the access token, sent on every request and never readable by scripts
+	SessionCookieName = "mattodo_session"
+	// RefreshCookieName holds the refresh token, only sent to the /auth routes
+	RefreshCookieName = "mattodo_refresh"
+	// CSRFCookieName holds the CSRF token, read by the front end to send it back in CSRFHeaderName
+	CSRFCookieName = "mattodo_csrf"
+)
+
+// CSRFHeaderName is the header in which the requests authenticated by the session cookie
+// repeat the CSRF token of the cookie, on every method other than GET, HEAD and OPTIONS
+const CSRFHeaderName = "X-CSRF-Token"
+
+// NewCSRFToken returns a random token for CSRFCookieName
+func NewCSRFToken() (string, error) {
+	return randomString(32)
+}
+
+// VerifyCSRF reports whether the request repeats the CSRF token of its cookie in CSRFHeaderName.
+// Another site can make the browser send the cookie, but cannot read it to set the header (double-submit).
+func VerifyCSRF(r *http.Request) bool {
+	cookie, err := r.Cookie(CSRFCookieName)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(r.Header.Get(CSRFHeaderName))) == 1
+}
+
+// isSafeMethod reports whether the method does not change any state, needing no CSRF protection
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// sessionToken returns the access token of the session cookie, or "" if there is none
+func sessionToken(r *http.Request) string {
+	cookie, err := r.Cookie(SessionCookieName)
+	if err != nil {
+		return ""
+	}
+
+	return cookie.Value
+}
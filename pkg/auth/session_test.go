@@ -0,0 +1,117 @@
+package auth_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mystardustcaptain/mattodo/pkg/auth"
+	"github.com/mystardustcaptain/mattodo/pkg/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestValidateTokenMiddleware_SessionCookie tests that the middleware accepts the access token of the session cookie,
+// requiring the CSRF token on the methods changing state.
+func TestValidateTokenMiddleware_SessionCookie(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		csrfCookie string
+		csrfHeader string
+		code       int
+	}{
+		{"read without CSRF token", "GET", "", "", http.StatusOK},
+		{"HEAD without CSRF token", "HEAD", "", "", http.StatusOK},
+		{"write with CSRF token", "POST", "csrf-token", "csrf-token", http.StatusOK},
+		{"delete with CSRF token", "DELETE", "csrf-token", "csrf-token", http.StatusOK},
+		{"write without CSRF token", "POST", "", "", http.StatusForbidden},
+		{"write without CSRF header", "PATCH", "csrf-token", "", http.StatusForbidden},
+		{"write without CSRF cookie", "PUT", "", "csrf-token", http.StatusForbidden},
+		{"write with another CSRF token", "DELETE", "csrf-token", "attacker", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			/// Arrange
+			///
+			a := newHMACAuthenticator(t)
+			token, _ := a.CreateToken("alice@example.com", 2, 1)
+
+			handler := a.ValidateTokenMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			r := httptest.NewRequest(tt.method, "/todo", nil)
+			r.AddCookie(&http.Cookie{Name: auth.SessionCookieName, Value: token})
+			if tt.csrfCookie != "" {
+				r.AddCookie(&http.Cookie{Name: auth.CSRFCookieName, Value: tt.csrfCookie})
+			}
+			if tt.csrfHeader != "" {
+				r.Header.Set(auth.CSRFHeaderName, tt.csrfHeader)
+			}
+
+			/// Act
+			///
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			/// Assert
+			///
+			assert.Equal(t, tt.code, w.Code)
+		})
+	}
+}
+
+// TestValidateTokenMiddleware_BearerNeedsNoCSRFToken tests that a request with the Authorization header
+// needs no CSRF token, even with a session cookie, since other sites cannot set the header.
+func TestValidateTokenMiddleware_BearerNeedsNoCSRFToken(t *testing.T) {
+	/// Arrange
+	///
+	a := newHMACAuthenticator(t)
+	token, _ := a.CreateToken("alice@example.com", 2, 1)
+
+	handler := a.ValidateTokenMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	r := httptest.NewRequest("POST", "/todo", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	r.AddCookie(&http.Cookie{Name: auth.SessionCookieName, Value: "stale"})
+
+	/// Act
+	///
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	/// Assert
+	///
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+// TestRequireScope_RefusesPersonalAccessTokenInCookie tests that a personal access token
+// is never accepted from the session cookie.
+func TestRequireScope_RefusesPersonalAccessTokenInCookie(t *testing.T) {
+	/// Arrange
+	///
+	a := newHMACAuthenticator(t)
+	a.AccessTokens = auth.NewPersonalAccessTokens(model.NewMemoryPersonalAccessTokenStore())
+	expiresAt := time.Now().Add(time.Hour)
+	token, _, _ := a.AccessTokens.Issue(2, "CI", []string{auth.ScopeTodoRead}, &expiresAt)
+
+	handler := a.RequireScope(auth.ScopeTodoRead, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	r := httptest.NewRequest("GET", "/todo", nil)
+	r.AddCookie(&http.Cookie{Name: auth.SessionCookieName, Value: token})
+
+	/// Act
+	///
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	/// Assert
+	///
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
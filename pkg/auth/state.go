@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/mystardustcaptain/mattodo/pkg/model"
+	"golang.org/x/oauth2"
+)
+
+// StateCookieName is the cookie binding a login attempt to the browser that started it
+const StateCookieName = "oauth_binding"
+
+// DefaultStateTTL is how long a user has to complete the login at the provider
+const DefaultStateTTL = 10 * time.Minute
+
+// ErrInvalidState is returned when the state of a callback is unknown, expired, already used,
+// or does not match the provider or the browser of the login attempt.
+var ErrInvalidState = errors.New("invalid OAuth state")
+
+// LoginStates issues and verifies the OAuth state parameter protecting the login against CSRF.
+// Every login attempt gets its own random state, bound to the provider and to a random value
+// kept in a browser cookie, which the callback must present back exactly once before the TTL.
+type LoginStates struct {
+	Store model.OAuthStateStore
+	TTL   time.Duration
+}
+
+// NewLoginStates returns LoginStates keeping the pending logins in the given store.
+func NewLoginStates(store model.OAuthStateStore) *LoginStates {
+	return &LoginStates{Store: store, TTL: DefaultStateTTL}
+}
+
+// Issue starts a login attempt for the provider, with its own PKCE verifier.
+// Returns the state to send to the provider and the binding to set in the browser cookie.
+func (ls *LoginStates) Issue(provider string) (*model.OAuthState, string, error) {
+	return ls.issue(provider, 0)
+}
+
+// IssueLink starts an attempt of a User of a given userID to link the provider to their account,
+// the same way as Issue.
+func (ls *LoginStates) IssueLink(provider string, userID int) (*model.OAuthState, string, error) {
+	return ls.issue(provider, userID)
+}
+
+// issue starts a login or link attempt
+func (ls *LoginStates) issue(provider string, linkUserID int) (*model.OAuthState, string, error) {
+	state, err := randomString(32)
+	if err != nil {
+		log.Printf("Failed to generate state: %s\n", err.Error())
+		return nil, "", err
+	}
+
+	binding, err := randomString(32)
+	if err != nil {
+		log.Printf("Failed to generate binding: %s\n", err.Error())
+		return nil, "", err
+	}
+
+	now := time.Now()
+
+	// Logins that were never completed are cleaned up as new ones come in
+	if err := ls.Store.DeleteExpiredOAuthStates(now); err != nil {
+		log.Printf("Failed to delete expired states: %s\n", err.Error())
+	}
+
+	s := &model.OAuthState{
+		State:        state,
+		Provider:     provider,
+		BindingHash:  hashString(binding),
+		CodeVerifier: oauth2.GenerateVerifier(),
+		LinkUserID:   linkUserID,
+		ExpiresAt:    now.Add(ls.TTL),
+		CreatedAt:    now,
+	}
+	if err := ls.Store.SaveOAuthState(s); err != nil {
+		log.Printf("Failed to save state: %s\n", err.Error())
+		return nil, "", err
+	}
+
+	return s, binding, nil
+}
+
+// LoginURL returns the provider's login page URL for a login attempt.
+// The PKCE challenge (S256) derived from the verifier of the attempt is sent along the state.
+func LoginURL(provider string, s *model.OAuthState) (string, error) {
+	p, ok := GetProvider(provider)
+	if !ok {
+		log.Printf("Unknown OAuth provider: %s\n", provider)
+		return "", ErrUnknownProvider
+	}
+
+	return p.AuthURL(s.State, s.CodeVerifier), nil
+}
+
+// Verify consumes the state returned to the callback.
+// The state is consumed even if the verification fails, so it can never be replayed.
+// Returns the login attempt, or ErrInvalidState.
+func (ls *LoginStates) Verify(state string, provider string, binding string) (*model.OAuthState, error) {
+	if state == "" || binding == "" {
+		return nil, ErrInvalidState
+	}
+
+	s, err := ls.Store.ConsumeOAuthState(state)
+	if errors.Is(err, model.ErrNotFound) {
+		log.Printf("Unknown or already used state\n")
+		return nil, ErrInvalidState
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if time.Now().After(s.ExpiresAt) {
+		log.Printf("Expired state\n")
+		return nil, ErrInvalidState
+	}
+
+	if s.Provider != provider {
+		log.Printf("State issued for provider %s used for %s\n", s.Provider, provider)
+		return nil, ErrInvalidState
+	}
+
+	if subtle.ConstantTimeCompare([]byte(s.BindingHash), []byte(hashString(binding))) != 1 {
+		log.Printf("State used from another browser\n")
+		return nil, ErrInvalidState
+	}
+
+	return s, nil
+}
+
+// randomString returns n bytes from the cryptographic random source, base64url encoded
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashString returns the hex encoded sha256 of s
+func hashString(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
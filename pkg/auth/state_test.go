@@ -0,0 +1,78 @@
+package auth_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mystardustcaptain/mattodo/pkg/auth"
+	"github.com/mystardustcaptain/mattodo/pkg/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestLoginStates_VerifyConsumesState tests that a state issued for a login
+// is accepted once from the same browser and provider, and never again.
+func TestLoginStates_VerifyConsumesState(t *testing.T) {
+	/// Arrange
+	///
+	ls := auth.NewLoginStates(model.NewMemoryOAuthStateStore())
+	state, binding, err := ls.Issue("github")
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when issuing a state", err)
+	}
+
+	/// Act
+	///
+	_, err = ls.Verify(state.State, "github", binding)
+	_, errReplay := ls.Verify(state.State, "github", binding)
+
+	/// Assert
+	///
+	assert.NoError(t, err, "Expected no error but got one")
+	assert.ErrorIs(t, errReplay, auth.ErrInvalidState, "Expected a state to be single-use")
+}
+
+// TestLoginStates_IssueIsRandom tests that every login attempt gets its own state and binding.
+func TestLoginStates_IssueIsRandom(t *testing.T) {
+	ls := auth.NewLoginStates(model.NewMemoryOAuthStateStore())
+
+	first, firstBinding, _ := ls.Issue("google")
+	second, secondBinding, _ := ls.Issue("google")
+
+	assert.NotEqual(t, first.State, second.State)
+	assert.NotEqual(t, firstBinding, secondBinding)
+	assert.NotEqual(t, firstBinding, first.BindingHash, "Expected the binding to be stored hashed")
+}
+
+// TestLoginStates_VerifyRejectsMismatch tests that a state is rejected
+// when used for another provider, from another browser, or after it expired.
+func TestLoginStates_VerifyRejectsMismatch(t *testing.T) {
+	cases := []struct {
+		name     string
+		ttl      time.Duration
+		provider string
+		binding  func(issued string) string
+	}{
+		{"other provider", time.Minute, "google", func(issued string) string { return issued }},
+		{"other browser", time.Minute, "github", func(issued string) string { return "someone-else" }},
+		{"no cookie", time.Minute, "github", func(issued string) string { return "" }},
+		{"expired", -time.Second, "github", func(issued string) string { return issued }},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			/// Arrange
+			///
+			ls := auth.NewLoginStates(model.NewMemoryOAuthStateStore())
+			ls.TTL = tc.ttl
+			state, binding, _ := ls.Issue("github")
+
+			/// Act
+			///
+			_, err := ls.Verify(state.State, tc.provider, tc.binding(binding))
+
+			/// Assert
+			///
+			assert.ErrorIs(t, err, auth.ErrInvalidState)
+		})
+	}
+}
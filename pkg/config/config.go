@@ -7,7 +7,9 @@ import (
 	"github.com/joho/godotenv"
 )
 
-func init() {
+// Load loads the environment variables from the .env file, unless running in Docker.
+// It is called first by the commands, before any configuration is read.
+func Load() {
 	// Load environment variables from .env file
 	// if running locally
 	if os.Getenv("DOCKER_ENV_SET") != "true" {
@@ -1,20 +1,80 @@
 package controller
 
 import (
-	"database/sql"
 	"encoding/json"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/mystardustcaptain/mattodo/pkg/auth"
+	"github.com/mystardustcaptain/mattodo/pkg/export"
+	"github.com/mystardustcaptain/mattodo/pkg/model"
 )
 
+// Controller serves the HTTP API on top of the storage backends it is given.
 type Controller struct {
-	Database *sql.DB
+	Todos model.TodoStore
+	Users model.UserStore
+
+	// States protects the OAuth logins, pending logins are kept in memory unless replaced
+	States *auth.LoginStates
+	// RefreshTokens issues the long-lived tokens exchanged for new access tokens
+	RefreshTokens *auth.RefreshTokens
+	// Auth authenticates the requests to the routes requiring a user
+	Auth *auth.Authenticator
+
+	// SessionCookies sets the tokens in cookies after a login, for a browser front end,
+	// and redirects to PostLoginURL instead of responding with them
+	SessionCookies bool
+	// PostLoginURL is where the browser is redirected after a login with SessionCookies, "/" if empty
+	PostLoginURL string
+
+	// BootstrapAdminEmail makes the user signing in with this email an admin, as long as there is none
+	BootstrapAdminEmail string
+
+	// DeletionGracePeriod is how long a deleted account can be restored before it is purged,
+	// 0 purges it right away
+	DeletionGracePeriod time.Duration
+
+	// Exports generates the large exports of personal data in the background
+	Exports *export.Jobs
+	// ExportAsyncThreshold is the number of todo items above which an export is generated in the background
+	ExportAsyncThreshold int
 }
 
-func NewController(db *sql.DB) *Controller {
+// DefaultDeletionGracePeriod is how long a deleted account can be restored by default
+const DefaultDeletionGracePeriod = 7 * 24 * time.Hour
+
+// NewController returns a Controller using the given stores,
+// e.g. the SQL collections or the in-memory stores.
+// The other dependencies default to in-memory implementations, suitable for a single instance.
+func NewController(todos model.TodoStore, users model.UserStore) *Controller {
+	refreshTokens := model.NewMemoryRefreshTokenStore()
+	accessTokens := model.NewMemoryPersonalAccessTokenStore()
+
+	// In memory, the data of a deleted user has to be deleted store by store
+	if memoryUsers, ok := users.(*model.MemoryUserStore); ok {
+		memoryUsers.Cascade = append(memoryUsers.Cascade, refreshTokens, accessTokens)
+		if memoryTodos, ok := todos.(model.UserDataDeleter); ok {
+			memoryUsers.Cascade = append(memoryUsers.Cascade, memoryTodos)
+		}
+	}
+
 	return &Controller{
-		Database: db,
+		Todos:         todos,
+		Users:         users,
+		States:        auth.NewLoginStates(model.NewMemoryOAuthStateStore()),
+		RefreshTokens: auth.NewRefreshTokens(refreshTokens),
+		Auth: &auth.Authenticator{
+			Keys:         auth.NewKeySet(model.NewMemorySigningKeyStore(), auth.AlgorithmEdDSA),
+			Leeway:       auth.DefaultLeeway,
+			Revocations:  auth.NewRevocations(model.NewMemoryTokenRevocationStore(), auth.DefaultLeeway),
+			AccessTokens: auth.NewPersonalAccessTokens(accessTokens),
+			Users:        users,
+		},
+		DeletionGracePeriod:  DefaultDeletionGracePeriod,
+		Exports:              export.NewJobs(),
+		ExportAsyncThreshold: DefaultExportAsyncThreshold,
 	}
 }
 
@@ -27,11 +87,6 @@ func (c *Controller) Index(w http.ResponseWriter, r *http.Request) {
 	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Server is up and running"})
 }
 
-// respondWithError is a helper function to respond with an error and a status code
-func respondWithError(w http.ResponseWriter, code int, message string) {
-	respondWithJSON(w, code, map[string]string{"error": message})
-}
-
 // respondWithJSON is a helper function to respond with JSON and a status code
 // payload can be nil
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
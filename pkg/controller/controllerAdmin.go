@@ -0,0 +1,316 @@
+package controller
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/mystardustcaptain/mattodo/pkg/auth"
+	"github.com/mystardustcaptain/mattodo/pkg/model"
+)
+
+// RegisterAdminRoutes registers the routes managing the users, restricted to the admins
+func (c *Controller) RegisterAdminRoutes(router *mux.Router) {
+	router.Handle("/admin/users", c.Auth.RequireAdmin(http.HandlerFunc(c.GetUsers))).Methods("GET")
+	router.Handle("/admin/users/{id}", c.Auth.RequireAdmin(http.HandlerFunc(c.GetUser))).Methods("GET")
+	router.Handle("/admin/users/{id}/role", c.Auth.RequireAdmin(http.HandlerFunc(c.SetUserRole))).Methods("PUT")
+	router.Handle("/admin/users/{id}/suspend", c.Auth.RequireAdmin(http.HandlerFunc(c.SuspendUser))).Methods("POST")
+	router.Handle("/admin/users/{id}/reactivate", c.Auth.RequireAdmin(http.HandlerFunc(c.ReactivateUser))).Methods("POST")
+	router.Handle("/admin/users/{id}/revoke-tokens", c.Auth.RequireAdmin(http.HandlerFunc(c.RevokeUserTokens))).Methods("POST")
+}
+
+// Paging of GET /admin/users
+const (
+	defaultUsersLimit = 50
+	maxUsersLimit     = 100
+)
+
+// roleRequest is the body of PUT /admin/users/{id}/role
+type roleRequest struct {
+	Role string `json:"role"`
+}
+
+// userUsage counts what a user keeps in the service
+type userUsage struct {
+	TodoItems            int `json:"todo_items"`
+	CompletedTodoItems   int `json:"completed_todo_items"`
+	Identities           int `json:"identities"`
+	PersonalAccessTokens int `json:"personal_access_tokens"`
+}
+
+// adminUserResponse is a user as seen by the admins, with their usage
+type adminUserResponse struct {
+	*model.User
+	Usage userUsage `json:"usage"`
+}
+
+// GetUsers lists the users, ordered by ID.
+// Query parameters: q matches the name or the email, role only lists the users of a role,
+// limit (at most 100, 50 by default) and offset page through the users.
+func (c *Controller) GetUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := &model.UserFilter{Query: strings.TrimSpace(query.Get("q")), Role: query.Get("role"), Limit: defaultUsersLimit}
+
+	verr := &model.ValidationError{}
+	if filter.Role != "" && model.ValidateRole(filter.Role) != nil {
+		verr.Add("role", "must be among "+strings.Join(model.Roles, ", "))
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxUsersLimit {
+			verr.Add("limit", "must be a number between 1 and "+strconv.Itoa(maxUsersLimit))
+		}
+		filter.Limit = n
+	}
+	if offset := query.Get("offset"); offset != "" {
+		n, err := strconv.Atoi(offset)
+		if err != nil || n < 0 {
+			verr.Add("offset", "must be a positive number")
+		}
+		filter.Offset = n
+	}
+	if len(verr.Fields) > 0 {
+		respondWithModelError(w, verr)
+		return
+	}
+
+	users, err := c.Users.SearchUsers(filter)
+	if err != nil {
+		log.Printf("Failed to search users: %s", err.Error())
+		respondWithModelError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, users)
+}
+
+// GetUser retrieves a user with their usage
+func (c *Controller) GetUser(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := c.Users.GetUserByID(userID)
+	if err != nil {
+		log.Printf("Failed to get user entry: %s", err.Error())
+		respondWithModelError(w, err)
+		return
+	}
+
+	usage, err := c.usageOf(userID)
+	if err != nil {
+		respondWithModelError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, adminUserResponse{User: user, Usage: *usage})
+}
+
+// SetUserRole gives a role to a user.
+// Admins cannot change their own role, so that there is always one left.
+func (c *Controller) SetUserRole(w http.ResponseWriter, r *http.Request) {
+	iam, ok := r.Context().Value(auth.ContextUserIDKey).(int)
+	if !ok {
+		log.Printf("Failed to read context")
+		respondWithError(w, http.StatusInternalServerError, "Failed to read context")
+		return
+	}
+
+	userID, ok := userIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	var req roleRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		log.Printf("Invalid request body: %s", err.Error())
+		respondWithDecodeError(w, err)
+		return
+	}
+
+	if userID == iam {
+		respondWithError(w, http.StatusConflict, "Admins cannot change their own role")
+		return
+	}
+
+	if err := c.Users.SetUserRole(userID, req.Role); err != nil {
+		log.Printf("Failed to set user role: %s", err.Error())
+		respondWithModelError(w, err)
+		return
+	}
+	log.Printf("User %d given the %s role by %d", userID, req.Role, iam)
+
+	c.respondWithUser(w, userID)
+}
+
+// SuspendUser suspends a user: their tokens are refused and they cannot sign in until reactivated.
+// Their refresh tokens are revoked, a reactivated user signs in again.
+// Admins cannot suspend themselves.
+func (c *Controller) SuspendUser(w http.ResponseWriter, r *http.Request) {
+	iam, ok := r.Context().Value(auth.ContextUserIDKey).(int)
+	if !ok {
+		log.Printf("Failed to read context")
+		respondWithError(w, http.StatusInternalServerError, "Failed to read context")
+		return
+	}
+
+	userID, ok := userIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	if userID == iam {
+		respondWithError(w, http.StatusConflict, "Admins cannot suspend themselves")
+		return
+	}
+
+	now := model.Now()
+	if err := c.Users.SuspendUser(userID, &now); err != nil {
+		log.Printf("Failed to suspend user: %s", err.Error())
+		respondWithModelError(w, err)
+		return
+	}
+	if err := c.RefreshTokens.RevokeAll(userID); err != nil {
+		log.Printf("Failed to revoke refresh tokens: %s", err.Error())
+		respondWithModelError(w, err)
+		return
+	}
+	log.Printf("User %d suspended by %d", userID, iam)
+
+	c.respondWithUser(w, userID)
+}
+
+// ReactivateUser lifts the suspension of a user
+func (c *Controller) ReactivateUser(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	if err := c.Users.SuspendUser(userID, nil); err != nil {
+		log.Printf("Failed to reactivate user: %s", err.Error())
+		respondWithModelError(w, err)
+		return
+	}
+	log.Printf("User %d reactivated", userID)
+
+	c.respondWithUser(w, userID)
+}
+
+// RevokeUserTokens revokes every token of a user: access tokens, refresh tokens and personal access tokens,
+// e.g. after their account was compromised.
+func (c *Controller) RevokeUserTokens(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := c.Users.GetUserByID(userID); err != nil {
+		log.Printf("Failed to get user entry: %s", err.Error())
+		respondWithModelError(w, err)
+		return
+	}
+
+	// Refresh tokens first, so that no new access token can be issued after the cutoff
+	if err := c.RefreshTokens.RevokeAll(userID); err != nil {
+		log.Printf("Failed to revoke refresh tokens: %s", err.Error())
+		respondWithModelError(w, err)
+		return
+	}
+	if err := c.Auth.Revocations.RevokeSessions(userID); err != nil {
+		log.Printf("Failed to revoke sessions: %s", err.Error())
+		respondWithModelError(w, err)
+		return
+	}
+	if err := c.Auth.AccessTokens.RevokeAll(userID); err != nil {
+		log.Printf("Failed to revoke personal access tokens: %s", err.Error())
+		respondWithModelError(w, err)
+		return
+	}
+	log.Printf("Tokens of user %d revoked", userID)
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
+
+// bootstrapAdmin makes the user an admin if they signed in with BootstrapAdminEmail and there is no admin yet.
+// The email alone grants the role, so it must come from a provider verifying it, see auth.EmailVerifier.
+// A failure is only logged, the sign in goes on.
+func (c *Controller) bootstrapAdmin(user *model.User, provider string) {
+	if c.BootstrapAdminEmail == "" || !strings.EqualFold(user.Email, c.BootstrapAdminEmail) || user.IsAdmin() {
+		return
+	}
+	if !auth.VerifiesEmail(provider) {
+		log.Printf("Not making user %d an admin, %s does not verify emails", user.ID, provider)
+		return
+	}
+
+	admins, err := c.Users.SearchUsers(&model.UserFilter{Role: model.RoleAdmin, Limit: 1})
+	if err != nil {
+		log.Printf("Failed to look for admins: %s", err.Error())
+		return
+	}
+	if len(admins) > 0 {
+		return
+	}
+
+	if err := c.Users.SetUserRole(user.ID, model.RoleAdmin); err != nil {
+		log.Printf("Failed to make user %d an admin: %s", user.ID, err.Error())
+		return
+	}
+	user.Role = model.RoleAdmin
+	log.Printf("User %d is the first admin", user.ID)
+}
+
+// usageOf counts what a User of a given userID keeps in the service
+func (c *Controller) usageOf(userID int) (*userUsage, error) {
+	usage := &userUsage{}
+
+	var err error
+	if usage.TodoItems, usage.CompletedTodoItems, err = c.Todos.CountTodoItems(userID); err != nil {
+		log.Printf("Failed to count todo items: %s", err.Error())
+		return nil, err
+	}
+
+	identities, err := c.Users.GetUserIdentities(userID)
+	if err != nil {
+		log.Printf("Failed to get identities: %s", err.Error())
+		return nil, err
+	}
+	usage.Identities = len(identities)
+
+	tokens, err := c.Auth.AccessTokens.Store.GetPersonalAccessTokens(userID)
+	if err != nil {
+		log.Printf("Failed to get personal access tokens: %s", err.Error())
+		return nil, err
+	}
+	usage.PersonalAccessTokens = len(tokens)
+
+	return usage, nil
+}
+
+// respondWithUser responds with the current state of a User of a given userID
+func (c *Controller) respondWithUser(w http.ResponseWriter, userID int) {
+	user, err := c.Users.GetUserByID(userID)
+	if err != nil {
+		log.Printf("Failed to get user entry: %s", err.Error())
+		respondWithModelError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, user)
+}
+
+// userIDParam reads the user ID of the route, responding with a 400 if it is not a number
+func userIDParam(w http.ResponseWriter, r *http.Request) (int, bool) {
+	userID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		log.Printf("Invalid user ID: %s", err.Error())
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return 0, false
+	}
+
+	return userID, true
+}
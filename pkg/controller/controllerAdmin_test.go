@@ -0,0 +1,370 @@
+package controller_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mystardustcaptain/mattodo/pkg/auth"
+	"github.com/mystardustcaptain/mattodo/pkg/controller"
+	"github.com/mystardustcaptain/mattodo/pkg/model"
+	"github.com/mystardustcaptain/mattodo/pkg/route"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+)
+
+// staticProvider is a Provider signing in the same user whatever the code
+type staticProvider struct {
+	name          string
+	user          *auth.UserInfo
+	verifiesEmail bool
+}
+
+func (p *staticProvider) Name() string { return p.name }
+
+func (p *staticProvider) VerifiesEmail() bool { return p.verifiesEmail }
+
+func (p *staticProvider) AuthURL(state string, codeVerifier string) string {
+	return "https://sso.example.com/login?state=" + state
+}
+
+func (p *staticProvider) Exchange(ctx context.Context, code string, codeVerifier string) (*oauth2.Token, error) {
+	return &oauth2.Token{AccessToken: "provider-token"}, nil
+}
+
+func (p *staticProvider) FetchUser(ctx context.Context, token *oauth2.Token) (*auth.UserInfo, error) {
+	return p.user, nil
+}
+
+// signIn goes through the login of a provider and returns the response of the callback
+func signIn(c *controller.Controller, provider string) *httptest.ResponseRecorder {
+	login := httptest.NewRecorder()
+	c.HandleLogin(login, httptest.NewRequest("GET", "/auth/login?provider="+provider, nil))
+	location, _ := url.Parse(login.Header().Get("Location"))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/auth/callback?provider="+provider+"&code=abc&state="+url.QueryEscape(location.Query().Get("state")), nil)
+	for _, cookie := range login.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+	c.HandleCallback(w, r)
+
+	return w
+}
+
+// newAdminRouter returns the router of a Controller holding the admin Alice and the user Bob,
+// with the access tokens of both
+func newAdminRouter() (*controller.Controller, *mux.Router, *model.User, string, *model.User, string) {
+	users := model.NewMemoryUserStore()
+	alice := &model.User{OAuthProvider: "github", OAuthID: "1", Name: "Alice", Email: "alice@example.com", Role: model.RoleAdmin}
+	bob := &model.User{OAuthProvider: "github", OAuthID: "2", Name: "Bob", Email: "bob@example.com"}
+	users.CreateUser(alice)
+	users.CreateUser(bob)
+
+	c := controller.NewController(model.NewMemoryTodoStore(), users)
+	adminToken, _ := c.Auth.CreateToken(alice.Email, alice.ID, 1)
+	userToken, _ := c.Auth.CreateToken(bob.Email, bob.ID, 1)
+
+	return c, route.InitializeRoutes(c), alice, adminToken, bob, userToken
+}
+
+// serveWithBearer serves a request with the access token through the router
+func serveWithBearer(router *mux.Router, method string, target string, body string, token string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+token)
+	router.ServeHTTP(w, r)
+
+	return w
+}
+
+// TestAdminRoutes_RefusedToUsers tests that the admin routes are refused to the users who are not admins.
+func TestAdminRoutes_RefusedToUsers(t *testing.T) {
+	/// Arrange
+	///
+	_, router, alice, _, _, userToken := newAdminRouter()
+	target := "/admin/users/" + strconv.Itoa(alice.ID)
+
+	tests := []struct {
+		method string
+		target string
+	}{
+		{"GET", "/admin/users"},
+		{"GET", target},
+		{"PUT", target + "/role"},
+		{"POST", target + "/suspend"},
+		{"POST", target + "/reactivate"},
+		{"POST", target + "/revoke-tokens"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.target, func(t *testing.T) {
+			/// Act
+			///
+			w := serveWithBearer(router, tt.method, tt.target, `{"role": "user"}`, userToken)
+
+			/// Assert
+			///
+			assert.Equal(t, http.StatusForbidden, w.Code)
+		})
+	}
+}
+
+// TestGetUsers_SearchesUsers tests that the admins can search the users by name or email, and page through them.
+func TestGetUsers_SearchesUsers(t *testing.T) {
+	/// Arrange
+	///
+	_, router, alice, adminToken, bob, _ := newAdminRouter()
+
+	tests := []struct {
+		name  string
+		query string
+		ids   []int
+	}{
+		{"everyone", "", []int{alice.ID, bob.ID}},
+		{"by name, any case", "?q=BO", []int{bob.ID}},
+		{"by email", "?q=alice@", []int{alice.ID}},
+		{"wildcards are literal", "?q=%25", []int{}},
+		{"by role", "?role=admin", []int{alice.ID}},
+		{"paged", "?limit=1&offset=1", []int{bob.ID}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			/// Act
+			///
+			w := serveWithBearer(router, "GET", "/admin/users"+tt.query, "", adminToken)
+
+			/// Assert
+			///
+			assert.Equal(t, http.StatusOK, w.Code)
+
+			var users []model.User
+			json.Unmarshal(w.Body.Bytes(), &users)
+			ids := []int{}
+			for _, u := range users {
+				ids = append(ids, u.ID)
+			}
+			assert.Equal(t, tt.ids, ids)
+		})
+	}
+
+	invalid := serveWithBearer(router, "GET", "/admin/users?limit=1000&role=root", "", adminToken)
+	assert.Equal(t, http.StatusUnprocessableEntity, invalid.Code)
+}
+
+// TestGetUser_ReportsUsage tests that an admin sees the usage of a user.
+func TestGetUser_ReportsUsage(t *testing.T) {
+	/// Arrange
+	///
+	c, router, _, adminToken, bob, _ := newAdminRouter()
+	c.Todos.CreateTodoItem(bob.ID, &model.TodoItem{Title: "Buy milk"})
+	c.Todos.CreateTodoItem(bob.ID, &model.TodoItem{Title: "Walk the dog", Completed: true})
+	c.Auth.AccessTokens.Issue(bob.ID, "CI", []string{auth.ScopeTodoRead}, nil)
+
+	/// Act
+	///
+	w := serveWithBearer(router, "GET", "/admin/users/"+strconv.Itoa(bob.ID), "", adminToken)
+
+	/// Assert
+	///
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var user struct {
+		Email string         `json:"email"`
+		Role  string         `json:"role"`
+		Usage map[string]int `json:"usage"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &user)
+	assert.Equal(t, bob.Email, user.Email)
+	assert.Equal(t, model.RoleUser, user.Role)
+	assert.Equal(t, map[string]int{"todo_items": 2, "completed_todo_items": 1, "identities": 1, "personal_access_tokens": 1}, user.Usage)
+}
+
+// TestSuspendUser_RefusesTokensUntilReactivated tests that a suspended user is refused on every route
+// with the tokens issued before, and accepted again once reactivated.
+func TestSuspendUser_RefusesTokensUntilReactivated(t *testing.T) {
+	/// Arrange
+	///
+	c, router, _, adminToken, bob, userToken := newAdminRouter()
+	refreshToken, _ := c.RefreshTokens.Issue(bob.ID)
+	target := "/admin/users/" + strconv.Itoa(bob.ID)
+
+	/// Act
+	///
+	suspend := serveWithBearer(router, "POST", target+"/suspend", "", adminToken)
+	suspended := serveWithBearer(router, "GET", "/todo", "", userToken)
+	reactivate := serveWithBearer(router, "POST", target+"/reactivate", "", adminToken)
+	reactivated := serveWithBearer(router, "GET", "/todo", "", userToken)
+
+	/// Assert
+	///
+	assert.Equal(t, http.StatusOK, suspend.Code)
+	var user model.User
+	json.Unmarshal(suspend.Body.Bytes(), &user)
+	assert.NotNil(t, user.SuspendedAt)
+
+	assert.Equal(t, http.StatusForbidden, suspended.Code)
+	assert.Equal(t, http.StatusOK, reactivate.Code)
+	assert.Equal(t, http.StatusOK, reactivated.Code)
+
+	_, _, err := c.RefreshTokens.Rotate(refreshToken)
+	assert.ErrorIs(t, err, auth.ErrInvalidRefreshToken, "Expected the refresh tokens to be revoked on suspension")
+}
+
+// TestSuspendUser_RefusesSignIn tests that a suspended user cannot sign in.
+func TestSuspendUser_RefusesSignIn(t *testing.T) {
+	/// Arrange
+	///
+	p := &staticProvider{name: "static-suspended", user: &auth.UserInfo{ID: "7", Email: "carol@example.com", Name: "Carol"}}
+	auth.RegisterProvider(p)
+	t.Cleanup(func() { auth.UnregisterProvider(p.name) })
+
+	users := model.NewMemoryUserStore()
+	carol := &model.User{OAuthProvider: p.name, OAuthID: "7", Name: "Carol", Email: "carol@example.com"}
+	users.CreateUser(carol)
+	suspendedAt := time.Now()
+	users.SuspendUser(carol.ID, &suspendedAt)
+
+	c := controller.NewController(model.NewMemoryTodoStore(), users)
+
+	/// Act
+	///
+	w := signIn(c, p.name)
+
+	/// Assert
+	///
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+// TestAdminRoutes_AdminsCannotLockThemselvesOut tests that an admin can neither suspend themselves
+// nor give up their role.
+func TestAdminRoutes_AdminsCannotLockThemselvesOut(t *testing.T) {
+	/// Arrange
+	///
+	_, router, alice, adminToken, _, _ := newAdminRouter()
+	target := "/admin/users/" + strconv.Itoa(alice.ID)
+
+	/// Act
+	///
+	suspend := serveWithBearer(router, "POST", target+"/suspend", "", adminToken)
+	demote := serveWithBearer(router, "PUT", target+"/role", `{"role": "user"}`, adminToken)
+
+	/// Assert
+	///
+	assert.Equal(t, http.StatusConflict, suspend.Code)
+	assert.Equal(t, http.StatusConflict, demote.Code)
+}
+
+// TestSetUserRole_GrantsAdminRoutes tests that a user given the admin role by an admin can use the admin routes,
+// and that an unknown role is refused.
+func TestSetUserRole_GrantsAdminRoutes(t *testing.T) {
+	/// Arrange
+	///
+	_, router, _, adminToken, bob, userToken := newAdminRouter()
+	target := "/admin/users/" + strconv.Itoa(bob.ID) + "/role"
+
+	/// Act
+	///
+	unknown := serveWithBearer(router, "PUT", target, `{"role": "root"}`, adminToken)
+	promote := serveWithBearer(router, "PUT", target, `{"role": "admin"}`, adminToken)
+	list := serveWithBearer(router, "GET", "/admin/users", "", userToken)
+
+	/// Assert
+	///
+	assert.Equal(t, http.StatusUnprocessableEntity, unknown.Code)
+	assert.Equal(t, http.StatusOK, promote.Code)
+	assert.Equal(t, http.StatusOK, list.Code, "Expected the role to apply to the tokens issued before")
+}
+
+// TestRevokeUserTokens_RevokesEveryToken tests that an admin revokes the access tokens,
+// the refresh tokens and the personal access tokens of a user.
+func TestRevokeUserTokens_RevokesEveryToken(t *testing.T) {
+	/// Arrange
+	///
+	c, router, _, adminToken, bob, userToken := newAdminRouter()
+	refreshToken, _ := c.RefreshTokens.Issue(bob.ID)
+	personalToken, _, _ := c.Auth.AccessTokens.Issue(bob.ID, "CI", []string{auth.ScopeTodoRead}, nil)
+
+	/// Act
+	///
+	w := serveWithBearer(router, "POST", "/admin/users/"+strconv.Itoa(bob.ID)+"/revoke-tokens", "", adminToken)
+
+	/// Assert
+	///
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, http.StatusUnauthorized, serveWithBearer(router, "GET", "/todo", "", userToken).Code)
+	assert.Equal(t, http.StatusUnauthorized, serveWithBearer(router, "GET", "/todo", "", personalToken).Code)
+
+	_, _, err := c.RefreshTokens.Rotate(refreshToken)
+	assert.ErrorIs(t, err, auth.ErrInvalidRefreshToken, "Expected the refresh tokens to be revoked")
+
+	unknown := serveWithBearer(router, "POST", "/admin/users/999/revoke-tokens", "", adminToken)
+	assert.Equal(t, http.StatusNotFound, unknown.Code)
+}
+
+// TestHandleCallback_BootstrapsFirstAdmin tests that the user signing in with the bootstrap email
+// becomes an admin as long as there is none.
+func TestHandleCallback_BootstrapsFirstAdmin(t *testing.T) {
+	/// Arrange
+	///
+	first := &staticProvider{name: "static-first", user: &auth.UserInfo{ID: "1", Email: "owner@example.com", Name: "Owner"}, verifiesEmail: true}
+	second := &staticProvider{name: "static-second", user: &auth.UserInfo{ID: "2", Email: "owner@example.org", Name: "Impostor"}, verifiesEmail: true}
+	for _, p := range []*staticProvider{first, second} {
+		auth.RegisterProvider(p)
+		p := p
+		t.Cleanup(func() { auth.UnregisterProvider(p.name) })
+	}
+
+	users := model.NewMemoryUserStore()
+	c := controller.NewController(model.NewMemoryTodoStore(), users)
+	c.BootstrapAdminEmail = "Owner@example.com"
+
+	/// Act
+	///
+	w := signIn(c, first.name)
+	c.BootstrapAdminEmail = "owner@example.org"
+	other := signIn(c, second.name)
+
+	/// Assert
+	///
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, http.StatusOK, other.Code)
+
+	owner, _ := users.GetUserByEmail("owner@example.com")
+	assert.Equal(t, model.RoleAdmin, owner.Role)
+
+	impostor, _ := users.GetUserByEmail("owner@example.org")
+	assert.Equal(t, model.RoleUser, impostor.Role, "Expected no other admin once there is one")
+}
+
+// TestHandleCallback_BootstrapsAdminOnlyWithVerifiedEmail tests that the bootstrap email does not grant the admin role
+// through a provider which does not verify the emails.
+func TestHandleCallback_BootstrapsAdminOnlyWithVerifiedEmail(t *testing.T) {
+	/// Arrange
+	///
+	p := &staticProvider{name: "static-unverified", user: &auth.UserInfo{ID: "1", Email: "owner@example.com", Name: "Owner"}}
+	auth.RegisterProvider(p)
+	t.Cleanup(func() { auth.UnregisterProvider(p.name) })
+
+	users := model.NewMemoryUserStore()
+	c := controller.NewController(model.NewMemoryTodoStore(), users)
+	c.BootstrapAdminEmail = "owner@example.com"
+
+	/// Act
+	///
+	w := signIn(c, p.name)
+
+	/// Assert
+	///
+	assert.Equal(t, http.StatusOK, w.Code)
+	owner, _ := users.GetUserByEmail("owner@example.com")
+	assert.Equal(t, model.RoleUser, owner.Role, "Expected no admin from an email the provider did not verify")
+}
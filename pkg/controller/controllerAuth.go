@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"errors"
 	"log"
 	"net/http"
 
@@ -12,49 +13,101 @@ import (
 // RegisterAuthRoutes registers the authentication routes to the router
 // URL: /auth/login?provider=google
 // URL: /auth/callback?provider=google
-// Other providers: facebook, github
+// URL: /auth/logout, /auth/logout/all with a valid access token
+// URL: /.well-known/jwks.json, the public keys verifying the access tokens
+// Other providers: facebook, github, and the OIDC providers configured
 func (c *Controller) RegisterAuthRoutes(router *mux.Router) {
 	router.HandleFunc("/auth", c.AuthIndex).Methods("GET")
 	router.HandleFunc("/auth/login", c.HandleLogin).Methods("GET")
 	router.HandleFunc("/auth/callback", c.HandleCallback).Methods("GET")
+	router.HandleFunc("/auth/refresh", c.HandleRefresh).Methods("POST")
+	router.Handle("/auth/logout", c.Auth.ValidateTokenMiddleware(http.HandlerFunc(c.HandleLogout))).Methods("POST")
+	router.Handle("/auth/logout/all", c.Auth.ValidateTokenMiddleware(http.HandlerFunc(c.HandleLogoutAll))).Methods("POST")
+	router.HandleFunc("/.well-known/jwks.json", c.GetJWKS).Methods("GET")
+}
+
+// accessTokenHours is the validity of the access tokens in hour
+const accessTokenHours = 1
+
+// tokenResponse is returned after a login or a refresh
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"` // seconds
+	RefreshToken string `json:"refresh_token"`
+}
+
+// refreshRequest is the body of POST /auth/refresh
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
 }
 
 // HandleLogin initiates the OAuth login process for a given provider
-// It redirects the user to the provider's login page
+// It issues a single-use state and PKCE verifier for this login attempt, bound to the browser with a cookie,
+// and redirects the user to the provider's login page
 func (c *Controller) HandleLogin(w http.ResponseWriter, r *http.Request) {
 	provider := r.URL.Query().Get("provider")
-	config, ok := auth.OAuthConfigs[provider]
-	if !ok {
+	if _, ok := auth.GetProvider(provider); !ok {
 		log.Printf("Unknown OAuth provider")
 		respondWithError(w, http.StatusBadRequest, "Unknown OAuth provider")
 		return
 	}
 
-	url := config.AuthCodeURL(auth.OAuthStateString)
+	state, binding, err := c.States.Issue(provider)
+	if err != nil {
+		log.Printf("Failed to issue state: %s", err.Error())
+		respondWithError(w, http.StatusInternalServerError, "Failed to start login")
+		return
+	}
+
+	c.setStateCookie(w, r, binding)
+
+	url, err := auth.LoginURL(provider, state)
+	if err != nil {
+		log.Printf("Failed to build login URL: %s", err.Error())
+		respondWithError(w, http.StatusBadRequest, "Unknown OAuth provider")
+		return
+	}
 	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
 }
 
 // HandleCallback handles the callback from the OAuth provider
-// It exchanges the OAuth code for an access token
+// It verifies the state of the login attempt,
+// then exchanges the OAuth code for an access token
 // and then exchanges the access token for user info.
-// User info is made sure available in the database.
-// If not, create a new user entry in the database.
-// Finally, it creates a JWT token and returns it to the user.
+// The user is looked up by their identity at the provider (provider + subject),
+// if there is none a new user entry is created in the database.
+// Finally, it creates a JWT access token and a refresh token and returns them to the user,
+// or sets them in the session cookies and redirects to PostLoginURL with SessionCookies.
+// Attempts started to link a provider to an account link the identity instead.
 func (c *Controller) HandleCallback(w http.ResponseWriter, r *http.Request) {
 	provider := r.URL.Query().Get("provider")
-	state := r.FormValue("state")
-	if state != auth.OAuthStateString {
-		log.Printf("Invalid state parameter")
+
+	// The state must have been issued to this browser for this provider, and is consumed here
+	binding := ""
+	if cookie, err := r.Cookie(auth.StateCookieName); err == nil {
+		binding = cookie.Value
+	}
+	clearStateCookie(w, r)
+
+	state, err := c.States.Verify(r.FormValue("state"), provider, binding)
+	if err != nil {
+		log.Printf("Invalid state parameter: %s", err.Error())
 		respondWithError(w, http.StatusBadRequest, "Invalid state parameter")
 		return
 	}
 
 	// get userinfo from token exchanged from OAuth code
 	code := r.FormValue("code")
-	userInfo, err := auth.GetUserFromOAuthCode(provider, code)
+	userInfo, err := auth.GetUserFromOAuthCode(provider, code, state.CodeVerifier)
 	if err != nil {
 		log.Printf("Failed to get user info: %s", err.Error())
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+		respondWithError(w, http.StatusInternalServerError, "Failed to get user info from OAuth provider")
+		return
+	}
+	if userInfo.ID == "" {
+		log.Printf("No account id found from OAuth provider")
+		respondWithError(w, http.StatusBadRequest, "No account id found from OAuth provider")
 		return
 	}
 	// check if userInfo.Email is a valid email address format
@@ -64,46 +117,378 @@ func (c *Controller) HandleCallback(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	uc := model.UserCollection{DB: c.Database}
+	if state.LinkUserID != 0 {
+		c.linkIdentity(w, r, state.LinkUserID, provider, userInfo)
+		return
+	}
 
-	// Try getting user from the database
-	user, _ := uc.GetUserByEmail(userInfo.Email)
-	if err != nil {
+	// The provider account is what identifies the user, the email may change
+	user, err := c.Users.GetUserByIdentity(provider, userInfo.ID)
+	if err != nil && !errors.Is(err, model.ErrNotFound) {
 		log.Printf("Failed to get user entry: %s", err.Error())
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+		respondWithModelError(w, err)
 		return
 	}
 
 	if user == nil {
+		// An account with the same email has to link this provider first,
+		// the email alone does not prove the provider account belongs to its owner
+		_, err := c.Users.GetUserByEmail(userInfo.Email)
+		if err == nil {
+			log.Printf("Sign in with an unlinked provider for %s", userInfo.Email)
+			respondWithError(w, http.StatusConflict, "An account already exists for this email, sign in with a linked provider and link this one")
+			return
+		}
+		if !errors.Is(err, model.ErrNotFound) {
+			log.Printf("Failed to get user entry: %s", err.Error())
+			respondWithModelError(w, err)
+			return
+		}
+
 		// If not exist, create a user entry in the database
 		log.Printf("User not found, registering user entry.")
 
 		user = &model.User{OAuthProvider: provider, OAuthID: userInfo.ID, Name: userInfo.Name, Email: userInfo.Email}
-		if err := uc.CreateUser(user); err != nil {
+		if err := c.Users.CreateUser(user); err != nil {
 			log.Printf("Failed to create user entry: %s", err.Error())
-			respondWithError(w, http.StatusInternalServerError, err.Error())
+			respondWithModelError(w, err)
 			return
 		}
 
 		log.Printf("User entry created for %s", userInfo.Email)
+
+		// The todo items of the user go to their inbox unless given another project.
+		// The SQL stores create it along with the user, the others when asked; it exists right after either way.
+		if _, err := c.Todos.CreateInbox(user.ID); err != nil {
+			log.Printf("Failed to create inbox of user %d: %s", user.ID, err.Error())
+			respondWithModelError(w, err)
+			return
+		}
+	}
+
+	if user.IsSuspended() {
+		log.Printf("Sign in of suspended user %d", user.ID)
+		respondWithError(w, http.StatusForbidden, "This account is suspended")
+		return
+	}
+
+	c.bootstrapAdmin(user, provider)
+
+	// Start a new refresh token family for this login
+	refreshToken, err := c.RefreshTokens.Issue(user.ID)
+	if err != nil {
+		log.Printf("Failed to create refresh token: %s", err.Error())
+		respondWithError(w, http.StatusInternalServerError, "Failed to create token")
+		return
+	}
+
+	if c.SessionCookies {
+		if err := c.setSessionCookies(w, user, refreshToken); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to create token")
+			return
+		}
+		http.Redirect(w, r, c.postLoginURL(), http.StatusSeeOther)
+		return
+	}
+
+	c.respondWithTokens(w, user, refreshToken)
+}
+
+// linkIdentity completes a link attempt, linking the provider account to the user who started it
+func (c *Controller) linkIdentity(w http.ResponseWriter, r *http.Request, userID int, provider string, userInfo *auth.UserInfo) {
+	identity := &model.UserIdentity{Provider: provider, Subject: userInfo.ID, Email: userInfo.Email}
+	if err := c.Users.LinkIdentity(userID, identity); err != nil {
+		log.Printf("Failed to link identity: %s", err.Error())
+		if errors.Is(err, model.ErrConflict) {
+			respondWithError(w, http.StatusConflict, "This provider account is already linked to an account")
+			return
+		}
+		respondWithModelError(w, err)
+		return
+	}
+
+	log.Printf("Identity %s linked to user %d", provider, userID)
+	if c.SessionCookies {
+		http.Redirect(w, r, c.postLoginURL(), http.StatusSeeOther)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, identity)
+}
+
+// HandleRefresh exchanges a refresh token for a new access token and a new refresh token.
+// The refresh token presented is rotated and cannot be used again,
+// presenting it again revokes every token rotated from the same login.
+// Browser sessions send no body, the refresh cookie is rotated along with the session cookie.
+func (c *Controller) HandleRefresh(w http.ResponseWriter, r *http.Request) {
+	var req refreshRequest
+	fromCookie := false
+	if cookie, err := r.Cookie(auth.RefreshCookieName); err == nil && r.ContentLength == 0 {
+		// The browser sends the cookie on requests made by other sites too
+		if !auth.VerifyCSRF(r) {
+			log.Printf("Missing or invalid CSRF token")
+			respondWithError(w, http.StatusForbidden, "Missing or invalid CSRF token")
+			return
+		}
+		req.RefreshToken = cookie.Value
+		fromCookie = true
+	} else if err := decodeJSON(w, r, &req); err != nil {
+		log.Printf("Invalid request body: %s", err.Error())
+		respondWithDecodeError(w, err)
+		return
+	}
+
+	if req.RefreshToken == "" {
+		respondWithModelError(w, model.NewValidationError("refresh_token", "must not be empty"))
+		return
+	}
+
+	userID, refreshToken, err := c.RefreshTokens.Rotate(req.RefreshToken)
+	if errors.Is(err, auth.ErrInvalidRefreshToken) || errors.Is(err, auth.ErrRefreshTokenReused) {
+		log.Printf("Refresh refused: %s", err.Error())
+		respondWithError(w, http.StatusUnauthorized, "Invalid refresh token")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to rotate refresh token: %s", err.Error())
+		respondWithModelError(w, err)
+		return
+	}
+
+	user, err := c.Users.GetUserByID(userID)
+	if err != nil {
+		log.Printf("Failed to get user entry: %s", err.Error())
+		respondWithModelError(w, err)
+		return
+	}
+	if user.IsSuspended() {
+		log.Printf("Refresh of suspended user %d", user.ID)
+		respondWithError(w, http.StatusForbidden, "This account is suspended")
+		return
+	}
+
+	if fromCookie {
+		if err := c.setSessionCookies(w, user, refreshToken); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to create token")
+			return
+		}
+		respondWithJSON(w, http.StatusNoContent, nil)
+		return
+	}
+
+	c.respondWithTokens(w, user, refreshToken)
+}
+
+// HandleLogout revokes the access token of the request.
+// The refresh token of the session can be given in the body to be revoked as well,
+// otherwise it can still be exchanged for new access tokens.
+// Browser sessions revoke the refresh token of their cookie, and the session cookies are cleared.
+func (c *Controller) HandleLogout(w http.ResponseWriter, r *http.Request) {
+	token, ok := r.Context().Value(auth.ContextTokenKey).(*auth.TokenInfo)
+	if !ok {
+		log.Printf("Failed to read context")
+		respondWithError(w, http.StatusInternalServerError, "Failed to read context")
+		return
+	}
+
+	// The body is optional
+	var req refreshRequest
+	if r.ContentLength != 0 {
+		if err := decodeJSON(w, r, &req); err != nil {
+			log.Printf("Invalid request body: %s", err.Error())
+			respondWithDecodeError(w, err)
+			return
+		}
+	}
+
+	fromCookie := false
+	if req.RefreshToken == "" {
+		if cookie, err := r.Cookie(auth.RefreshCookieName); err == nil && cookie.Value != "" {
+			req.RefreshToken = cookie.Value
+			fromCookie = true
+		}
+	}
+
+	if req.RefreshToken != "" {
+		err := c.RefreshTokens.Revoke(token.UserID, req.RefreshToken)
+		if errors.Is(err, auth.ErrInvalidRefreshToken) && !fromCookie {
+			respondWithModelError(w, model.NewValidationError("refresh_token", "is not a refresh token of the user"))
+			return
+		}
+		// A stale cookie holds nothing left to revoke
+		if err != nil && !errors.Is(err, auth.ErrInvalidRefreshToken) {
+			log.Printf("Failed to revoke refresh token: %s", err.Error())
+			respondWithModelError(w, err)
+			return
+		}
+	}
+
+	if err := c.Auth.Revocations.RevokeToken(token); err != nil {
+		log.Printf("Failed to revoke token: %s", err.Error())
+		respondWithModelError(w, err)
+		return
+	}
+
+	if c.SessionCookies {
+		clearSessionCookies(w)
+	}
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
+
+// HandleLogoutAll revokes every access token and every refresh token of the user,
+// logging them out of all their sessions, including the current one.
+func (c *Controller) HandleLogoutAll(w http.ResponseWriter, r *http.Request) {
+	iam, ok := r.Context().Value(auth.ContextUserIDKey).(int)
+	if !ok {
+		log.Printf("Failed to read context")
+		respondWithError(w, http.StatusInternalServerError, "Failed to read context")
+		return
+	}
+
+	// Refresh tokens first, so that no new access token can be issued after the cutoff
+	if err := c.RefreshTokens.RevokeAll(iam); err != nil {
+		log.Printf("Failed to revoke refresh tokens: %s", err.Error())
+		respondWithModelError(w, err)
+		return
+	}
+
+	if err := c.Auth.Revocations.RevokeSessions(iam); err != nil {
+		log.Printf("Failed to revoke sessions: %s", err.Error())
+		respondWithModelError(w, err)
+		return
 	}
 
-	// TODO: Choosing EMAIL as the checking condition is a simple approach.
-	// Unhandled Scenario like, user authenticate using one Provider previously (OAuthProvider + OAuthID)
-	// But changed the associated EMAIL, ...
+	if c.SessionCookies {
+		clearSessionCookies(w)
+	}
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
 
-	// Create a JWT token valid for 1 hour
-	token, err := auth.CreateToken(user.Email, user.ID, 1)
+// respondWithTokens creates an access token for the user and responds with it and the refresh token
+func (c *Controller) respondWithTokens(w http.ResponseWriter, user *model.User, refreshToken string) {
+	token, err := c.Auth.CreateToken(user.Email, user.ID, accessTokenHours)
 	if err != nil {
 		log.Printf("Failed to create token: %s", err.Error())
 		respondWithError(w, http.StatusInternalServerError, "Failed to create token")
 		return
 	}
 
-	// Return the token to the user
-	respondWithJSON(w, http.StatusOK, token)
+	respondWithJSON(w, http.StatusOK, tokenResponse{
+		AccessToken:  token,
+		TokenType:    "Bearer",
+		ExpiresIn:    accessTokenHours * 3600,
+		RefreshToken: refreshToken,
+	})
+}
+
+// setSessionCookies creates an access token for the user and sets it in the session cookie,
+// along with the refresh token and a new CSRF token.
+// The cookies are always Secure, browsers accept them from http://localhost during development.
+func (c *Controller) setSessionCookies(w http.ResponseWriter, user *model.User, refreshToken string) error {
+	token, err := c.Auth.CreateToken(user.Email, user.ID, accessTokenHours)
+	if err != nil {
+		log.Printf("Failed to create token: %s", err.Error())
+		return err
+	}
+
+	csrfToken, err := auth.NewCSRFToken()
+	if err != nil {
+		log.Printf("Failed to create CSRF token: %s", err.Error())
+		return err
+	}
+
+	// Lax lets the session follow a link from another site, the CSRF token protects the other methods
+	http.SetCookie(w, &http.Cookie{
+		Name:     auth.SessionCookieName,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   accessTokenHours * 3600,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	// Only ever needed by the front end itself calling /auth/refresh and /auth/logout
+	http.SetCookie(w, &http.Cookie{
+		Name:     auth.RefreshCookieName,
+		Value:    refreshToken,
+		Path:     "/auth",
+		MaxAge:   int(c.RefreshTokens.TTL.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	// Readable by the front end, to repeat it in the CSRF header
+	http.SetCookie(w, &http.Cookie{
+		Name:     auth.CSRFCookieName,
+		Value:    csrfToken,
+		Path:     "/",
+		MaxAge:   int(c.RefreshTokens.TTL.Seconds()),
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return nil
+}
+
+// clearSessionCookies removes the cookies of the browser session
+func clearSessionCookies(w http.ResponseWriter) {
+	for _, cookie := range []*http.Cookie{
+		{Name: auth.SessionCookieName, Path: "/", HttpOnly: true, SameSite: http.SameSiteLaxMode},
+		{Name: auth.RefreshCookieName, Path: "/auth", HttpOnly: true, SameSite: http.SameSiteStrictMode},
+		{Name: auth.CSRFCookieName, Path: "/", SameSite: http.SameSiteLaxMode},
+	} {
+		cookie.MaxAge = -1
+		cookie.Secure = true
+		http.SetCookie(w, cookie)
+	}
+}
+
+// postLoginURL returns where the browser is redirected after a login with SessionCookies
+func (c *Controller) postLoginURL() string {
+	if c.PostLoginURL == "" {
+		return "/"
+	}
+	return c.PostLoginURL
+}
+
+// GetJWKS publishes the public keys verifying the access tokens, for other services to verify them.
+// A new key signs right away, verifiers should refetch the set for a token of an unknown kid.
+func (c *Controller) GetJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithJSON(w, http.StatusOK, c.Auth.Keys.JWKS())
 }
 
 func (c *Controller) AuthIndex(w http.ResponseWriter, r *http.Request) {
 	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Auth Index"})
 }
+
+// setStateCookie sets the cookie binding the login attempt to the browser
+// Lax is required for the cookie to come back with the redirect from the provider
+func (c *Controller) setStateCookie(w http.ResponseWriter, r *http.Request, binding string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     auth.StateCookieName,
+		Value:    binding,
+		Path:     "/auth",
+		MaxAge:   int(c.States.TTL.Seconds()),
+		HttpOnly: true,
+		Secure:   isSecureRequest(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// clearStateCookie removes the cookie binding the login attempt to the browser
+func clearStateCookie(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     auth.StateCookieName,
+		Value:    "",
+		Path:     "/auth",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   isSecureRequest(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// isSecureRequest reports whether the request came over HTTPS, directly or through a proxy
+func isSecureRequest(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
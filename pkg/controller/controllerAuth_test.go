@@ -0,0 +1,326 @@
+package controller_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/mystardustcaptain/mattodo/pkg/auth"
+	"github.com/mystardustcaptain/mattodo/pkg/controller"
+	"github.com/mystardustcaptain/mattodo/pkg/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMain registers the built-in providers, as the service does on startup
+func TestMain(m *testing.M) {
+	if err := auth.RegisterProvidersFromEnv(); err != nil {
+		log.Fatalf("Failed to register providers: %s\n", err)
+	}
+
+	os.Exit(m.Run())
+}
+
+// TestHandleLogin_IssuesStateBoundToBrowser tests that HandleLogin redirects to the provider
+// with a fresh state and sets the binding cookie for the callback.
+func TestHandleLogin_IssuesStateBoundToBrowser(t *testing.T) {
+	/// Arrange
+	///
+	c := controller.NewController(model.NewMemoryTodoStore(), model.NewMemoryUserStore())
+
+	/// Act
+	///
+	w := httptest.NewRecorder()
+	c.HandleLogin(w, httptest.NewRequest("GET", "/auth/login?provider=github", nil))
+
+	/// Assert
+	///
+	assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+
+	location, _ := url.Parse(w.Header().Get("Location"))
+	assert.NotEmpty(t, location.Query().Get("state"))
+
+	cookies := w.Result().Cookies()
+	assert.Equal(t, 1, len(cookies))
+	assert.Equal(t, auth.StateCookieName, cookies[0].Name)
+	assert.True(t, cookies[0].HttpOnly)
+}
+
+// TestHandleCallback_RejectsStateFromAnotherBrowser tests that HandleCallback refuses a state
+// presented without the cookie of the browser that started the login.
+func TestHandleCallback_RejectsStateFromAnotherBrowser(t *testing.T) {
+	/// Arrange
+	///
+	c := controller.NewController(model.NewMemoryTodoStore(), model.NewMemoryUserStore())
+
+	login := httptest.NewRecorder()
+	c.HandleLogin(login, httptest.NewRequest("GET", "/auth/login?provider=github", nil))
+	location, _ := url.Parse(login.Header().Get("Location"))
+	state := location.Query().Get("state")
+
+	/// Act
+	///
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/auth/callback?provider=github&code=abc&state="+state, nil)
+	r.AddCookie(&http.Cookie{Name: auth.StateCookieName, Value: "attacker"})
+	c.HandleCallback(w, r)
+
+	/// Assert
+	///
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// TestHandleRefresh_ReturnsNewTokenPair tests that HandleRefresh exchanges a refresh token
+// for a new access token and a new refresh token, and refuses the old refresh token afterwards.
+func TestHandleRefresh_ReturnsNewTokenPair(t *testing.T) {
+	/// Arrange
+	///
+	users := model.NewMemoryUserStore()
+	user := model.User{OAuthProvider: "github", OAuthID: "42", Name: "Alice", Email: "alice@example.com"}
+	users.CreateUser(&user)
+
+	c := controller.NewController(model.NewMemoryTodoStore(), users)
+	refreshToken, _ := c.RefreshTokens.Issue(user.ID)
+
+	/// Act
+	///
+	w := httptest.NewRecorder()
+	c.HandleRefresh(w, httptest.NewRequest("POST", "/auth/refresh", strings.NewReader(`{"refresh_token": "`+refreshToken+`"}`)))
+
+	replay := httptest.NewRecorder()
+	c.HandleRefresh(replay, httptest.NewRequest("POST", "/auth/refresh", strings.NewReader(`{"refresh_token": "`+refreshToken+`"}`)))
+
+	/// Assert
+	///
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var tokens map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &tokens)
+	assert.NotEmpty(t, tokens["access_token"])
+	assert.Equal(t, "Bearer", tokens["token_type"])
+	assert.NotEmpty(t, tokens["refresh_token"])
+	assert.NotEqual(t, refreshToken, tokens["refresh_token"])
+
+	assert.Equal(t, http.StatusUnauthorized, replay.Code, "Expected a rotated refresh token to be refused")
+}
+
+// TestHandleLogout_RevokesTokens tests that logging out through the router refuses the access token
+// and the refresh token of the session afterwards.
+func TestHandleLogout_RevokesTokens(t *testing.T) {
+	/// Arrange
+	///
+	c, alice := newControllerWithAlice()
+	router := mux.NewRouter()
+	c.RegisterAuthRoutes(router)
+	c.RegisterTodoRoutes(router)
+
+	accessToken, _ := c.Auth.CreateToken(alice.Email, alice.ID, 1)
+	refreshToken, _ := c.RefreshTokens.Issue(alice.ID)
+
+	/// Act
+	///
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/auth/logout", strings.NewReader(`{"refresh_token": "`+refreshToken+`"}`))
+	r.Header.Set("Authorization", "Bearer "+accessToken)
+	router.ServeHTTP(w, r)
+
+	/// Assert
+	///
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	after := httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "/todo", nil)
+	r.Header.Set("Authorization", "Bearer "+accessToken)
+	router.ServeHTTP(after, r)
+	assert.Equal(t, http.StatusUnauthorized, after.Code, "Expected the access token to be revoked")
+
+	_, _, err := c.RefreshTokens.Rotate(refreshToken)
+	assert.ErrorIs(t, err, auth.ErrInvalidRefreshToken, "Expected the refresh token to be revoked")
+}
+
+// TestHandleLogoutAll_RevokesEverySession tests that logging out of all sessions
+// refuses every access token and refresh token of the user.
+func TestHandleLogoutAll_RevokesEverySession(t *testing.T) {
+	/// Arrange
+	///
+	c, alice := newControllerWithAlice()
+	router := mux.NewRouter()
+	c.RegisterAuthRoutes(router)
+	c.RegisterTodoRoutes(router)
+
+	current, _ := c.Auth.CreateToken(alice.Email, alice.ID, 1)
+	otherDevice, _ := c.Auth.CreateToken(alice.Email, alice.ID, 1)
+	refreshToken, _ := c.RefreshTokens.Issue(alice.ID)
+
+	/// Act
+	///
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/auth/logout/all", nil)
+	r.Header.Set("Authorization", "Bearer "+current)
+	router.ServeHTTP(w, r)
+
+	/// Assert
+	///
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	after := httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "/todo", nil)
+	r.Header.Set("Authorization", "Bearer "+otherDevice)
+	router.ServeHTTP(after, r)
+	assert.Equal(t, http.StatusUnauthorized, after.Code, "Expected the tokens of the other devices to be revoked")
+
+	_, _, err := c.RefreshTokens.Rotate(refreshToken)
+	assert.ErrorIs(t, err, auth.ErrInvalidRefreshToken, "Expected the refresh tokens to be revoked")
+}
+
+// TestGetJWKS_PublishesSigningKeys tests that the JWKS endpoint publishes the key of the access tokens issued,
+// without any private part.
+func TestGetJWKS_PublishesSigningKeys(t *testing.T) {
+	/// Arrange
+	///
+	c := controller.NewController(model.NewMemoryTodoStore(), model.NewMemoryUserStore())
+	router := mux.NewRouter()
+	c.RegisterAuthRoutes(router)
+
+	accessToken, _ := c.Auth.CreateToken("alice@example.com", 2, 1)
+	header, _ := base64.RawURLEncoding.DecodeString(strings.Split(accessToken, ".")[0])
+	var jose struct {
+		Kid string `json:"kid"`
+	}
+	json.Unmarshal(header, &jose)
+
+	/// Act
+	///
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+
+	/// Assert
+	///
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var set auth.JSONWebKeySet
+	json.Unmarshal(w.Body.Bytes(), &set)
+	assert.Equal(t, 1, len(set.Keys))
+	assert.Equal(t, jose.Kid, set.Keys[0].Kid)
+	assert.Equal(t, "sig", set.Keys[0].Use)
+	assert.NotContains(t, w.Body.String(), `"d"`, "Expected no private key material")
+}
+
+// cookiesOf returns the cookies set by a response by name
+func cookiesOf(w *httptest.ResponseRecorder) map[string]*http.Cookie {
+	cookies := map[string]*http.Cookie{}
+	for _, cookie := range w.Result().Cookies() {
+		cookies[cookie.Name] = cookie
+	}
+	return cookies
+}
+
+// TestHandleRefresh_RenewsSessionCookies tests that a browser session refreshes with its cookies,
+// requiring the CSRF token, and that the new session cookie authenticates the todo routes.
+func TestHandleRefresh_RenewsSessionCookies(t *testing.T) {
+	/// Arrange
+	///
+	users := model.NewMemoryUserStore()
+	user := model.User{OAuthProvider: "github", OAuthID: "42", Name: "Alice", Email: "alice@example.com"}
+	users.CreateUser(&user)
+
+	c := controller.NewController(model.NewMemoryTodoStore(), users)
+	c.SessionCookies = true
+	router := mux.NewRouter()
+	c.RegisterAuthRoutes(router)
+	c.RegisterTodoRoutes(router)
+
+	refreshToken, _ := c.RefreshTokens.Issue(user.ID)
+
+	/// Act
+	///
+	forged := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/auth/refresh", nil)
+	r.AddCookie(&http.Cookie{Name: auth.RefreshCookieName, Value: refreshToken})
+	router.ServeHTTP(forged, r)
+
+	w := httptest.NewRecorder()
+	r = httptest.NewRequest("POST", "/auth/refresh", nil)
+	r.AddCookie(&http.Cookie{Name: auth.RefreshCookieName, Value: refreshToken})
+	r.AddCookie(&http.Cookie{Name: auth.CSRFCookieName, Value: "csrf-token"})
+	r.Header.Set(auth.CSRFHeaderName, "csrf-token")
+	router.ServeHTTP(w, r)
+
+	/// Assert
+	///
+	assert.Equal(t, http.StatusForbidden, forged.Code, "Expected a refresh without the CSRF token to be refused")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	cookies := cookiesOf(w)
+	session, refresh, csrf := cookies[auth.SessionCookieName], cookies[auth.RefreshCookieName], cookies[auth.CSRFCookieName]
+	if assert.NotNil(t, session) && assert.NotNil(t, refresh) && assert.NotNil(t, csrf) {
+		assert.True(t, session.HttpOnly && session.Secure)
+		assert.Equal(t, http.SameSiteLaxMode, session.SameSite)
+		assert.True(t, refresh.HttpOnly && refresh.Secure)
+		assert.Equal(t, "/auth", refresh.Path)
+		assert.NotEqual(t, refreshToken, refresh.Value)
+		assert.False(t, csrf.HttpOnly, "Expected the CSRF token to be readable by the front end")
+
+		create := httptest.NewRecorder()
+		r = httptest.NewRequest("POST", "/todo", strings.NewReader(`{"title": "Buy milk"}`))
+		r.AddCookie(session)
+		r.AddCookie(csrf)
+		r.Header.Set(auth.CSRFHeaderName, csrf.Value)
+		router.ServeHTTP(create, r)
+		assert.Equal(t, http.StatusOK, create.Code)
+	}
+}
+
+// TestHandleLogout_ClearsSessionCookies tests that logging out of a browser session
+// revokes the refresh token of its cookie and clears the cookies.
+func TestHandleLogout_ClearsSessionCookies(t *testing.T) {
+	/// Arrange
+	///
+	c, alice := newControllerWithAlice()
+	c.SessionCookies = true
+	router := mux.NewRouter()
+	c.RegisterAuthRoutes(router)
+
+	accessToken, _ := c.Auth.CreateToken(alice.Email, alice.ID, 1)
+	refreshToken, _ := c.RefreshTokens.Issue(alice.ID)
+
+	/// Act
+	///
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/auth/logout", nil)
+	r.AddCookie(&http.Cookie{Name: auth.SessionCookieName, Value: accessToken})
+	r.AddCookie(&http.Cookie{Name: auth.RefreshCookieName, Value: refreshToken})
+	r.AddCookie(&http.Cookie{Name: auth.CSRFCookieName, Value: "csrf-token"})
+	r.Header.Set(auth.CSRFHeaderName, "csrf-token")
+	router.ServeHTTP(w, r)
+
+	/// Assert
+	///
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	cookies := cookiesOf(w)
+	for _, name := range []string{auth.SessionCookieName, auth.RefreshCookieName, auth.CSRFCookieName} {
+		if assert.NotNil(t, cookies[name], "Expected %s to be cleared", name) {
+			assert.Equal(t, -1, cookies[name].MaxAge)
+		}
+	}
+
+	_, _, err := c.RefreshTokens.Rotate(refreshToken)
+	assert.ErrorIs(t, err, auth.ErrInvalidRefreshToken, "Expected the refresh token to be revoked")
+}
+
+// newControllerWithAlice returns a Controller on in-memory stores holding the user Alice,
+// the middleware refusing the tokens of unknown users
+func newControllerWithAlice() (*controller.Controller, *model.User) {
+	users := model.NewMemoryUserStore()
+	alice := &model.User{OAuthProvider: "github", OAuthID: "42", Name: "Alice", Email: "alice@example.com"}
+	users.CreateUser(alice)
+
+	return controller.NewController(model.NewMemoryTodoStore(), users), alice
+}
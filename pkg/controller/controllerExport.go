@@ -0,0 +1,126 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mystardustcaptain/mattodo/pkg/auth"
+	"github.com/mystardustcaptain/mattodo/pkg/export"
+)
+
+// RegisterExportRoutes registers the routes to download the personal data of the user
+// URL: /me/export with a valid access token
+// URL: /export/{token} one-time download link of a large export, no access token needed
+func (c *Controller) RegisterExportRoutes(router *mux.Router) {
+	router.Handle("/me/export", c.Auth.ValidateTokenMiddleware(http.HandlerFunc(c.ExportMe))).Methods("GET")
+	router.HandleFunc("/export/{token}", c.DownloadExport).Methods("GET")
+}
+
+// DefaultExportAsyncThreshold is the number of todo items above which an export is generated in the background
+const DefaultExportAsyncThreshold = 1000
+
+// exportJobResponse tells where to download an export generated in the background
+type exportJobResponse struct {
+	DownloadURL string    `json:"download_url"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// ExportMe responds with a ZIP archive of everything stored about the authenticated user.
+// Large exports are generated in the background instead,
+// the response then gives a link to download the archive once, when it is ready.
+func (c *Controller) ExportMe(w http.ResponseWriter, r *http.Request) {
+	iam, ok := r.Context().Value(auth.ContextUserIDKey).(int)
+	if !ok {
+		log.Printf("Failed to read context")
+		respondWithError(w, http.StatusInternalServerError, "Failed to read context")
+		return
+	}
+
+	// Count first, so that a large export is not loaded in memory by the request
+	total, _, err := c.Todos.CountTodoItems(iam)
+	if err != nil {
+		log.Printf("Failed to count todo items: %s", err.Error())
+		respondWithModelError(w, err)
+		return
+	}
+
+	if total > c.ExportAsyncThreshold {
+		generate := func(w io.Writer) error {
+			archive, err := export.Load(c.Users, c.Todos, iam)
+			if err != nil {
+				return err
+			}
+			return archive.WriteZip(w)
+		}
+
+		token, expiresAt, err := c.Exports.Start(iam, generate)
+		if errors.Is(err, export.ErrJobRunning) {
+			respondWithError(w, http.StatusConflict, "An export is already being generated, retry later")
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to start export: %s", err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Failed to start export")
+			return
+		}
+
+		respondWithJSON(w, http.StatusAccepted, exportJobResponse{DownloadURL: "/export/" + token, ExpiresAt: expiresAt})
+		return
+	}
+
+	archive, err := export.Load(c.Users, c.Todos, iam)
+	if err != nil {
+		log.Printf("Failed to load personal data: %s", err.Error())
+		respondWithModelError(w, err)
+		return
+	}
+
+	setExportHeaders(w, iam)
+	if err := archive.WriteZip(w); err != nil {
+		// Headers are gone already, the client gets a truncated archive
+		log.Printf("Failed to stream export: %s", err.Error())
+	}
+}
+
+// DownloadExport responds with an export generated in the background, only once.
+// It responds 202 while the export is still being generated.
+func (c *Controller) DownloadExport(w http.ResponseWriter, r *http.Request) {
+	file, err := c.Exports.Open(mux.Vars(r)["token"])
+	if errors.Is(err, export.ErrJobPending) {
+		w.Header().Set("Retry-After", "5")
+		respondWithJSON(w, http.StatusAccepted, map[string]string{"message": "Export is being generated, retry later"})
+		return
+	}
+	if errors.Is(err, export.ErrJobNotFound) {
+		respondWithError(w, http.StatusNotFound, "Unknown, expired or already downloaded export")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to open export: %s", err.Error())
+		respondWithError(w, http.StatusInternalServerError, "Failed to generate export")
+		return
+	}
+	defer file.Close()
+
+	setExportHeaders(w, 0)
+	if _, err := io.Copy(w, file); err != nil {
+		log.Printf("Failed to send export: %s", err.Error())
+	}
+}
+
+// setExportHeaders sets the headers of a ZIP archive download
+func setExportHeaders(w http.ResponseWriter, userID int) {
+	filename := "mattodo-export.zip"
+	if userID != 0 {
+		filename = fmt.Sprintf("mattodo-export-%d.zip", userID)
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Header().Set("Cache-Control", "no-store")
+}
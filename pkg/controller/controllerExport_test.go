@@ -0,0 +1,106 @@
+package controller_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mystardustcaptain/mattodo/pkg/controller"
+	"github.com/mystardustcaptain/mattodo/pkg/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestExportMe_StreamsArchive tests that ExportMe responds with a ZIP archive of the user's data.
+func TestExportMe_StreamsArchive(t *testing.T) {
+	/// Arrange
+	///
+	todos := model.NewMemoryTodoStore()
+	users := model.NewMemoryUserStore()
+	user := model.User{OAuthProvider: "github", OAuthID: "42", Name: "Alice", Email: "alice@example.com"}
+	users.CreateUser(&user)
+	todos.CreateTodoItem(user.ID, &model.TodoItem{Title: "Buy milk"})
+
+	c := controller.NewController(todos, users)
+
+	/// Act
+	///
+	w := httptest.NewRecorder()
+	c.ExportMe(w, newAuthenticatedRequest("GET", "/me/export", "", user.ID))
+
+	/// Assert
+	///
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/zip", w.Header().Get("Content-Type"))
+
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	assert.NoError(t, err, "Expected a valid ZIP archive")
+	names := []string{}
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	assert.ElementsMatch(t, []string{"user.json", "todos.json", "todos.csv", "tags.json", "projects.json", "identities.json"}, names)
+}
+
+// TestExportMe_LargeExportDownloadedOnce tests that a large export is generated in the background
+// and downloadable once through the link returned.
+func TestExportMe_LargeExportDownloadedOnce(t *testing.T) {
+	/// Arrange
+	///
+	todos := model.NewMemoryTodoStore()
+	users := model.NewMemoryUserStore()
+	user := model.User{OAuthProvider: "github", OAuthID: "42", Name: "Alice", Email: "alice@example.com"}
+	users.CreateUser(&user)
+	todos.CreateTodoItem(user.ID, &model.TodoItem{Title: "Buy milk"})
+	todos.CreateTodoItem(user.ID, &model.TodoItem{Title: "Buy eggs"})
+
+	c := controller.NewController(todos, users)
+	c.ExportAsyncThreshold = 1
+	c.Exports.Dir = t.TempDir()
+	router := mux.NewRouter()
+	router.HandleFunc("/export/{token}", c.DownloadExport)
+
+	download := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		return w
+	}
+
+	/// Act
+	///
+	w := httptest.NewRecorder()
+	c.ExportMe(w, newAuthenticatedRequest("GET", "/me/export", "", user.ID))
+
+	var job map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &job)
+	url, _ := job["download_url"].(string)
+
+	var first *httptest.ResponseRecorder
+	assert.Eventually(t, func() bool {
+		first = download(url)
+		return first.Code != http.StatusAccepted
+	}, time.Second, 10*time.Millisecond)
+	second := download(url)
+
+	/// Assert
+	///
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.NotEmpty(t, url)
+	assert.Equal(t, http.StatusOK, first.Code)
+	assert.Equal(t, "application/zip", first.Header().Get("Content-Type"))
+	zr, err := zip.NewReader(bytes.NewReader(first.Body.Bytes()), int64(first.Body.Len()))
+	if assert.NoError(t, err, "Expected a valid ZIP archive") {
+		file, err := zr.Open("todos.json")
+		if assert.NoError(t, err) {
+			var exported []*model.TodoItem
+			assert.NoError(t, json.NewDecoder(file).Decode(&exported))
+			assert.Equal(t, 2, len(exported), "Expected the todo items loaded by the background job")
+			file.Close()
+		}
+	}
+	assert.Equal(t, http.StatusNotFound, second.Code, "Expected the link to work only once")
+}
@@ -0,0 +1,281 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mystardustcaptain/mattodo/pkg/auth"
+	"github.com/mystardustcaptain/mattodo/pkg/model"
+)
+
+// RegisterMeRoutes registers the routes of the account of the authenticated user
+func (c *Controller) RegisterMeRoutes(router *mux.Router) {
+	router.Handle("/me", c.Auth.ValidateTokenMiddleware(http.HandlerFunc(c.GetMe))).Methods("GET")
+	router.Handle("/me", c.Auth.ValidateTokenMiddleware(http.HandlerFunc(c.UpdateMe))).Methods("PATCH")
+	router.Handle("/me", c.Auth.ValidateTokenMiddleware(http.HandlerFunc(c.DeleteMe))).Methods("DELETE")
+	router.Handle("/me/deletion/cancel", c.Auth.ValidateTokenMiddleware(http.HandlerFunc(c.CancelDeleteMe))).Methods("POST")
+	router.Handle("/me/identities", c.Auth.ValidateTokenMiddleware(http.HandlerFunc(c.GetIdentities))).Methods("GET")
+	router.Handle("/me/identities", c.Auth.ValidateTokenMiddleware(http.HandlerFunc(c.LinkIdentity))).Methods("POST")
+	router.Handle("/me/identities/{id}", c.Auth.ValidateTokenMiddleware(http.HandlerFunc(c.UnlinkIdentity))).Methods("DELETE")
+}
+
+// linkRequest is the body of POST /me/identities
+type linkRequest struct {
+	Provider string `json:"provider"`
+}
+
+// linkResponse tells where to send the user to link the provider
+type linkResponse struct {
+	URL string `json:"url"`
+}
+
+// GetMe retrieves the profile of the authenticated user
+func (c *Controller) GetMe(w http.ResponseWriter, r *http.Request) {
+	iam, ok := r.Context().Value(auth.ContextUserIDKey).(int)
+	if !ok {
+		log.Printf("Failed to read context")
+		respondWithError(w, http.StatusInternalServerError, "Failed to read context")
+		return
+	}
+
+	user, err := c.Users.GetUserByID(iam)
+	if err != nil {
+		log.Printf("Failed to get user entry: %s", err.Error())
+		respondWithModelError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, user)
+}
+
+// UpdateMe applies a partial update to the profile of the authenticated user.
+// Only the name and the preferences can be changed, preferences are merged into the current ones.
+func (c *Controller) UpdateMe(w http.ResponseWriter, r *http.Request) {
+	iam, ok := r.Context().Value(auth.ContextUserIDKey).(int)
+	if !ok {
+		log.Printf("Failed to read context")
+		respondWithError(w, http.StatusInternalServerError, "Failed to read context")
+		return
+	}
+
+	var p userUpdatePayload
+	if err := decodeJSON(w, r, &p); err != nil {
+		log.Printf("Invalid request body: %s", err.Error())
+		respondWithDecodeError(w, err)
+		return
+	}
+
+	// Fields like email come from the provider and are rejected rather than ignored
+	if verr := p.readOnlyError(); verr != nil {
+		respondWithModelError(w, verr)
+		return
+	}
+
+	u := p.UserUpdate
+	if u.IsEmpty() {
+		log.Printf("No fields to update")
+		respondWithError(w, http.StatusBadRequest, "No fields to update")
+		return
+	}
+	if err := u.Validate(); err != nil {
+		respondWithModelError(w, err)
+		return
+	}
+
+	user, err := c.Users.UpdateUser(iam, &u)
+	if err != nil {
+		log.Printf("Failed to update user entry: %s", err.Error())
+		respondWithModelError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, user)
+}
+
+// DeleteMe deletes the account of the authenticated user with all their data.
+// The account is only purged after the grace period, during which the deletion can be cancelled,
+// it is purged right away if there is none.
+func (c *Controller) DeleteMe(w http.ResponseWriter, r *http.Request) {
+	iam, ok := r.Context().Value(auth.ContextUserIDKey).(int)
+	if !ok {
+		log.Printf("Failed to read context")
+		respondWithError(w, http.StatusInternalServerError, "Failed to read context")
+		return
+	}
+
+	if c.DeletionGracePeriod <= 0 {
+		if err := c.deleteUser(iam); err != nil {
+			log.Printf("Failed to delete user entry: %s", err.Error())
+			respondWithModelError(w, err)
+			return
+		}
+
+		respondWithJSON(w, http.StatusNoContent, nil)
+		return
+	}
+
+	scheduledAt := model.Now().Add(c.DeletionGracePeriod)
+	if err := c.Users.ScheduleUserDeletion(iam, &scheduledAt); err != nil {
+		log.Printf("Failed to schedule user deletion: %s", err.Error())
+		respondWithModelError(w, err)
+		return
+	}
+
+	user, err := c.Users.GetUserByID(iam)
+	if err != nil {
+		log.Printf("Failed to get user entry: %s", err.Error())
+		respondWithModelError(w, err)
+		return
+	}
+
+	// Accepted, the deletion happens at user.DeletionScheduledAt
+	respondWithJSON(w, http.StatusAccepted, user)
+}
+
+// CancelDeleteMe cancels the deletion of the account of the authenticated user during the grace period.
+func (c *Controller) CancelDeleteMe(w http.ResponseWriter, r *http.Request) {
+	iam, ok := r.Context().Value(auth.ContextUserIDKey).(int)
+	if !ok {
+		log.Printf("Failed to read context")
+		respondWithError(w, http.StatusInternalServerError, "Failed to read context")
+		return
+	}
+
+	user, err := c.Users.GetUserByID(iam)
+	if err != nil {
+		log.Printf("Failed to get user entry: %s", err.Error())
+		respondWithModelError(w, err)
+		return
+	}
+	if user.DeletionScheduledAt == nil {
+		respondWithError(w, http.StatusConflict, "The account is not scheduled for deletion")
+		return
+	}
+
+	if err := c.Users.ScheduleUserDeletion(iam, nil); err != nil {
+		log.Printf("Failed to cancel user deletion: %s", err.Error())
+		respondWithModelError(w, err)
+		return
+	}
+	user.DeletionScheduledAt = nil
+
+	respondWithJSON(w, http.StatusOK, user)
+}
+
+// PurgeDeletedUsers deletes the accounts whose grace period ended before now.
+// Returns how many accounts were deleted.
+func (c *Controller) PurgeDeletedUsers(now time.Time) (int, error) {
+	userIDs, err := c.Users.GetUsersDueForDeletion(now)
+	if err != nil {
+		return 0, err
+	}
+
+	for n, userID := range userIDs {
+		if err := c.deleteUser(userID); err != nil && !errors.Is(err, model.ErrNotFound) {
+			return n, err
+		}
+		log.Printf("User %d purged", userID)
+	}
+
+	return len(userIDs), nil
+}
+
+// deleteUser deletes a user with all their data.
+// Their access tokens are revoked first, they would otherwise stay valid until they expire.
+func (c *Controller) deleteUser(userID int) error {
+	if err := c.Auth.Revocations.RevokeSessions(userID); err != nil {
+		return err
+	}
+
+	return c.Users.DeleteUser(userID)
+}
+
+// GetIdentities retrieves the provider accounts the authenticated user can sign in with
+func (c *Controller) GetIdentities(w http.ResponseWriter, r *http.Request) {
+	iam, ok := r.Context().Value(auth.ContextUserIDKey).(int)
+	if !ok {
+		log.Printf("Failed to read context")
+		respondWithError(w, http.StatusInternalServerError, "Failed to read context")
+		return
+	}
+
+	identities, err := c.Users.GetUserIdentities(iam)
+	if err != nil {
+		log.Printf("Failed to get identities: %s", err.Error())
+		respondWithModelError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, identities)
+}
+
+// LinkIdentity starts linking a provider to the account of the authenticated user.
+// It responds with the provider's login page URL to open in the browser,
+// along with the cookie binding the attempt to the browser.
+// The callback then links the provider account instead of logging in.
+func (c *Controller) LinkIdentity(w http.ResponseWriter, r *http.Request) {
+	iam, ok := r.Context().Value(auth.ContextUserIDKey).(int)
+	if !ok {
+		log.Printf("Failed to read context")
+		respondWithError(w, http.StatusInternalServerError, "Failed to read context")
+		return
+	}
+
+	var req linkRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		log.Printf("Invalid request body: %s", err.Error())
+		respondWithDecodeError(w, err)
+		return
+	}
+
+	if _, ok := auth.GetProvider(req.Provider); !ok {
+		respondWithModelError(w, model.NewValidationError("provider", "is not a supported provider"))
+		return
+	}
+
+	state, binding, err := c.States.IssueLink(req.Provider, iam)
+	if err != nil {
+		log.Printf("Failed to issue state: %s", err.Error())
+		respondWithError(w, http.StatusInternalServerError, "Failed to start linking")
+		return
+	}
+
+	url, err := auth.LoginURL(req.Provider, state)
+	if err != nil {
+		log.Printf("Failed to build login URL: %s", err.Error())
+		respondWithError(w, http.StatusInternalServerError, "Failed to start linking")
+		return
+	}
+
+	c.setStateCookie(w, r, binding)
+	respondWithJSON(w, http.StatusOK, linkResponse{URL: url})
+}
+
+// UnlinkIdentity unlinks a provider account from the account of the authenticated user.
+// The last one cannot be unlinked, the user would not be able to sign in anymore.
+func (c *Controller) UnlinkIdentity(w http.ResponseWriter, r *http.Request) {
+	iam, ok := r.Context().Value(auth.ContextUserIDKey).(int)
+	if !ok {
+		log.Printf("Failed to read context")
+		respondWithError(w, http.StatusInternalServerError, "Failed to read context")
+		return
+	}
+
+	identityID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid identity ID")
+		return
+	}
+
+	if err := c.Users.UnlinkIdentity(iam, identityID); err != nil {
+		log.Printf("Failed to unlink identity: %s", err.Error())
+		respondWithModelError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
@@ -0,0 +1,226 @@
+package controller_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mystardustcaptain/mattodo/pkg/auth"
+	"github.com/mystardustcaptain/mattodo/pkg/controller"
+	"github.com/mystardustcaptain/mattodo/pkg/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestLinkIdentity_StartsLinkAttemptForCaller tests that LinkIdentity responds with the provider's login URL
+// and binds a link attempt of the caller to the browser.
+func TestLinkIdentity_StartsLinkAttemptForCaller(t *testing.T) {
+	/// Arrange
+	///
+	c := controller.NewController(model.NewMemoryTodoStore(), model.NewMemoryUserStore())
+
+	/// Act
+	///
+	w := httptest.NewRecorder()
+	c.LinkIdentity(w, newAuthenticatedRequest("POST", "/me/identities", `{"provider": "github"}`, 2))
+
+	/// Assert
+	///
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var body map[string]string
+	json.Unmarshal(w.Body.Bytes(), &body)
+	location, _ := url.Parse(body["url"])
+	state := location.Query().Get("state")
+	assert.NotEmpty(t, state)
+
+	cookies := w.Result().Cookies()
+	assert.Equal(t, 1, len(cookies))
+	assert.Equal(t, auth.StateCookieName, cookies[0].Name)
+
+	attempt, err := c.States.Verify(state, "github", cookies[0].Value)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, attempt.LinkUserID, "Expected the attempt to link to the caller's account")
+}
+
+// TestLinkIdentity_RejectsUnknownProvider tests that LinkIdentity refuses providers that are not configured.
+func TestLinkIdentity_RejectsUnknownProvider(t *testing.T) {
+	/// Arrange
+	///
+	c := controller.NewController(model.NewMemoryTodoStore(), model.NewMemoryUserStore())
+
+	/// Act
+	///
+	w := httptest.NewRecorder()
+	c.LinkIdentity(w, newAuthenticatedRequest("POST", "/me/identities", `{"provider": "myspace"}`, 2))
+
+	/// Assert
+	///
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+}
+
+// TestUnlinkIdentity_KeepsLastIdentity tests that an identity can be unlinked
+// as long as the user has another one to sign in with.
+func TestUnlinkIdentity_KeepsLastIdentity(t *testing.T) {
+	/// Arrange
+	///
+	users := model.NewMemoryUserStore()
+	user := model.User{OAuthProvider: "github", OAuthID: "42", Name: "Alice", Email: "alice@example.com"}
+	users.CreateUser(&user)
+	google := model.UserIdentity{Provider: "google", Subject: "abc", Email: "alice@example.com"}
+	users.LinkIdentity(user.ID, &google)
+
+	c := controller.NewController(model.NewMemoryTodoStore(), users)
+	router := mux.NewRouter()
+	router.HandleFunc("/me/identities/{id}", c.UnlinkIdentity).Methods("DELETE")
+
+	unlink := func(identityID int) int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, newAuthenticatedRequest("DELETE", "/me/identities/"+strconv.Itoa(identityID), "", user.ID))
+		return w.Code
+	}
+
+	/// Act
+	///
+	first := unlink(google.ID)
+	identities, _ := users.GetUserIdentities(user.ID)
+	last := unlink(identities[0].ID)
+
+	/// Assert
+	///
+	assert.Equal(t, http.StatusNoContent, first)
+	assert.Equal(t, http.StatusConflict, last, "Expected the last identity to be kept")
+}
+
+// TestUpdateMe_MergesPreferencesAndRejectsEmail tests that UpdateMe renames the user,
+// merges the preferences and refuses to change the email given by the provider.
+func TestUpdateMe_MergesPreferencesAndRejectsEmail(t *testing.T) {
+	/// Arrange
+	///
+	users := model.NewMemoryUserStore()
+	user := model.User{OAuthProvider: "github", OAuthID: "42", Name: "Alice", Email: "alice@example.com", Preferences: map[string]interface{}{"theme": "dark"}}
+	users.CreateUser(&user)
+	c := controller.NewController(model.NewMemoryTodoStore(), users)
+
+	/// Act
+	///
+	w := httptest.NewRecorder()
+	c.UpdateMe(w, newAuthenticatedRequest("PATCH", "/me", `{"name": " Alice Liddell ", "preferences": {"week_start": "monday"}}`, user.ID))
+
+	rejected := httptest.NewRecorder()
+	c.UpdateMe(rejected, newAuthenticatedRequest("PATCH", "/me", `{"email": "mallory@example.com"}`, user.ID))
+
+	escalated := httptest.NewRecorder()
+	c.UpdateMe(escalated, newAuthenticatedRequest("PATCH", "/me", `{"role": "admin"}`, user.ID))
+
+	/// Assert
+	///
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var updated model.User
+	json.Unmarshal(w.Body.Bytes(), &updated)
+	assert.Equal(t, "Alice Liddell", updated.Name)
+	assert.Equal(t, map[string]interface{}{"theme": "dark", "week_start": "monday"}, updated.Preferences)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, rejected.Code)
+	stored, _ := users.GetUserByID(user.ID)
+	assert.Equal(t, "alice@example.com", stored.Email)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, escalated.Code)
+	assert.Contains(t, escalated.Body.String(), "is read-only")
+	assert.Equal(t, model.RoleUser, stored.Role)
+}
+
+// TestDeleteMe_CanBeCancelledDuringGracePeriod tests that DeleteMe only schedules the deletion,
+// that it can be cancelled, and that the account is purged once the grace period is over otherwise.
+func TestDeleteMe_CanBeCancelledDuringGracePeriod(t *testing.T) {
+	/// Arrange
+	///
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	model.Now = func() time.Time { return now }
+	defer func() { model.Now = time.Now }()
+
+	users := model.NewMemoryUserStore()
+	user := model.User{OAuthProvider: "github", OAuthID: "42", Name: "Alice", Email: "alice@example.com"}
+	users.CreateUser(&user)
+	c := controller.NewController(model.NewMemoryTodoStore(), users)
+	c.DeletionGracePeriod = 24 * time.Hour
+
+	/// Act
+	///
+	deleted := httptest.NewRecorder()
+	c.DeleteMe(deleted, newAuthenticatedRequest("DELETE", "/me", "", user.ID))
+
+	cancelled := httptest.NewRecorder()
+	c.CancelDeleteMe(cancelled, newAuthenticatedRequest("POST", "/me/deletion/cancel", "", user.ID))
+	purgedAfterCancel, _ := c.PurgeDeletedUsers(now.Add(48 * time.Hour))
+
+	c.DeleteMe(httptest.NewRecorder(), newAuthenticatedRequest("DELETE", "/me", "", user.ID))
+	purgedEarly, _ := c.PurgeDeletedUsers(now.Add(time.Hour))
+	purged, err := c.PurgeDeletedUsers(now.Add(48 * time.Hour))
+
+	/// Assert
+	///
+	assert.Equal(t, http.StatusAccepted, deleted.Code)
+	var scheduled model.User
+	json.Unmarshal(deleted.Body.Bytes(), &scheduled)
+	assert.NotNil(t, scheduled.DeletionScheduledAt)
+	assert.True(t, now.Add(24*time.Hour).Equal(*scheduled.DeletionScheduledAt))
+
+	assert.Equal(t, http.StatusOK, cancelled.Code)
+	assert.Equal(t, 0, purgedAfterCancel, "Expected a cancelled deletion not to be purged")
+	assert.Equal(t, 0, purgedEarly, "Expected the account to be kept during the grace period")
+
+	assert.NoError(t, err, "Expected no error but got one")
+	assert.Equal(t, 1, purged)
+	_, err = users.GetUserByID(user.ID)
+	assert.ErrorIs(t, err, model.ErrNotFound)
+}
+
+// TestDeleteMe_WithoutGracePeriodPurgesEverything tests that DeleteMe without grace period
+// deletes the todo items and refuses the tokens of the user right away.
+func TestDeleteMe_WithoutGracePeriodPurgesEverything(t *testing.T) {
+	/// Arrange
+	///
+	todos := model.NewMemoryTodoStore()
+	users := model.NewMemoryUserStore()
+	user := model.User{OAuthProvider: "github", OAuthID: "42", Name: "Alice", Email: "alice@example.com"}
+	users.CreateUser(&user)
+	todos.CreateTodoItem(user.ID, &model.TodoItem{Title: "Secret"})
+
+	c := controller.NewController(todos, users)
+	c.DeletionGracePeriod = 0
+	router := mux.NewRouter()
+	c.RegisterMeRoutes(router)
+	c.RegisterTodoRoutes(router)
+
+	accessToken, _ := c.Auth.CreateToken(user.Email, user.ID, 1)
+	refreshToken, _ := c.RefreshTokens.Issue(user.ID)
+
+	/// Act
+	///
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("DELETE", "/me", nil)
+	r.Header.Set("Authorization", "Bearer "+accessToken)
+	router.ServeHTTP(w, r)
+
+	/// Assert
+	///
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	remaining, _ := todos.GetAllTodoItems(user.ID)
+	assert.Empty(t, remaining, "Expected the todo items to be deleted")
+
+	after := httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "/todo", nil)
+	r.Header.Set("Authorization", "Bearer "+accessToken)
+	router.ServeHTTP(after, r)
+	assert.Equal(t, http.StatusUnauthorized, after.Code, "Expected the access token to be refused")
+
+	_, _, err := c.RefreshTokens.Rotate(refreshToken)
+	assert.ErrorIs(t, err, auth.ErrInvalidRefreshToken, "Expected the refresh token to be deleted")
+}
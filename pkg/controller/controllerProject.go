@@ -0,0 +1,232 @@
+package controller
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/mystardustcaptain/mattodo/pkg/auth"
+	"github.com/mystardustcaptain/mattodo/pkg/model"
+)
+
+// RegisterProjectRoutes registers the routes managing the projects of the authenticated user and listing their todo items.
+// Personal access tokens can be used with the todo:read scope to read, todo:write to change
+func (c *Controller) RegisterProjectRoutes(router *mux.Router) {
+	router.Handle("/projects", c.Auth.RequireScope(auth.ScopeTodoRead, http.HandlerFunc(c.GetProjects))).Methods("GET")
+	router.Handle("/projects", c.Auth.RequireScope(auth.ScopeTodoWrite, http.HandlerFunc(c.CreateProject))).Methods("POST")
+	router.Handle("/projects/{id}", c.Auth.RequireScope(auth.ScopeTodoRead, http.HandlerFunc(c.GetProjectById))).Methods("GET")
+	router.Handle("/projects/{id}", c.Auth.RequireScope(auth.ScopeTodoWrite, http.HandlerFunc(c.UpdateProjectById))).Methods("PATCH")
+	router.Handle("/projects/{id}", c.Auth.RequireScope(auth.ScopeTodoWrite, http.HandlerFunc(c.DeleteProjectById))).Methods("DELETE")
+	router.Handle("/projects/{id}/todo", c.Auth.RequireScope(auth.ScopeTodoRead, http.HandlerFunc(c.GetProjectTodos))).Methods("GET")
+}
+
+// GetProjects lists the projects of the authenticated user, the inbox first and then by name
+func (c *Controller) GetProjects(w http.ResponseWriter, r *http.Request) {
+	iam, ok := r.Context().Value(auth.ContextUserIDKey).(int)
+	if !ok {
+		log.Printf("Failed to read context")
+		respondWithError(w, http.StatusInternalServerError, "Failed to read context")
+		return
+	}
+
+	projects, err := c.Todos.GetProjects(iam)
+	if err != nil {
+		log.Printf("Failed to get projects: %s", err.Error())
+		respondWithModelError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, projects)
+}
+
+// GetProjectById gets a project of the authenticated user
+func (c *Controller) GetProjectById(w http.ResponseWriter, r *http.Request) {
+	iam, ok := r.Context().Value(auth.ContextUserIDKey).(int)
+	if !ok {
+		log.Printf("Failed to read context")
+		respondWithError(w, http.StatusInternalServerError, "Failed to read context")
+		return
+	}
+
+	projectID, ok := projectIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	project, err := c.Todos.GetProject(iam, projectID)
+	if err != nil {
+		log.Printf("Failed to get project: %s", err.Error())
+		respondWithModelError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, project)
+}
+
+// CreateProject creates a project for the authenticated user.
+// Responds with a 409 if they already have a project of that name, whatever its case.
+func (c *Controller) CreateProject(w http.ResponseWriter, r *http.Request) {
+	iam, ok := r.Context().Value(auth.ContextUserIDKey).(int)
+	if !ok {
+		log.Printf("Failed to read context")
+		respondWithError(w, http.StatusInternalServerError, "Failed to read context")
+		return
+	}
+
+	var p projectCreatePayload
+	if err := decodeJSON(w, r, &p); err != nil {
+		log.Printf("Invalid request body: %s", err.Error())
+		respondWithDecodeError(w, err)
+		return
+	}
+
+	if verr := p.readOnlyError(); verr != nil {
+		respondWithModelError(w, verr)
+		return
+	}
+
+	project := model.Project{Name: p.Name}
+	if err := project.Validate(); err != nil {
+		respondWithModelError(w, err)
+		return
+	}
+
+	if err := c.Todos.CreateProject(iam, &project); err != nil {
+		log.Printf("Failed to create project: %s", err.Error())
+		respondWithModelError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, project)
+}
+
+// UpdateProjectById renames a project of the authenticated user, the inbox included
+func (c *Controller) UpdateProjectById(w http.ResponseWriter, r *http.Request) {
+	iam, ok := r.Context().Value(auth.ContextUserIDKey).(int)
+	if !ok {
+		log.Printf("Failed to read context")
+		respondWithError(w, http.StatusInternalServerError, "Failed to read context")
+		return
+	}
+
+	projectID, ok := projectIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	var p projectUpdatePayload
+	if err := decodeJSON(w, r, &p); err != nil {
+		log.Printf("Invalid request body: %s", err.Error())
+		respondWithDecodeError(w, err)
+		return
+	}
+
+	if verr := p.readOnlyError(); verr != nil {
+		respondWithModelError(w, verr)
+		return
+	}
+
+	u := p.ProjectUpdate
+	if u.IsEmpty() {
+		log.Printf("No fields to update")
+		respondWithError(w, http.StatusBadRequest, "No fields to update")
+		return
+	}
+	if err := u.Validate(); err != nil {
+		respondWithModelError(w, err)
+		return
+	}
+
+	project, err := c.Todos.UpdateProject(iam, projectID, &u)
+	if err != nil {
+		log.Printf("Failed to update project: %s", err.Error())
+		respondWithModelError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, project)
+}
+
+// DeleteProjectById deletes a project of the authenticated user, its todo items are moved to the inbox.
+// Responds with a 409 for the inbox, which cannot be deleted.
+func (c *Controller) DeleteProjectById(w http.ResponseWriter, r *http.Request) {
+	iam, ok := r.Context().Value(auth.ContextUserIDKey).(int)
+	if !ok {
+		log.Printf("Failed to read context")
+		respondWithError(w, http.StatusInternalServerError, "Failed to read context")
+		return
+	}
+
+	projectID, ok := projectIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	if err := c.Todos.DeleteProject(iam, projectID); err != nil {
+		log.Printf("Failed to delete project: %s", err.Error())
+		respondWithModelError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
+
+// GetProjectTodos lists the todo items of a project of the authenticated user.
+// The query parameters of GET /todo filter and nest them the same, see GetTodos.
+func (c *Controller) GetProjectTodos(w http.ResponseWriter, r *http.Request) {
+	iam, ok := r.Context().Value(auth.ContextUserIDKey).(int)
+	if !ok {
+		log.Printf("Failed to read context")
+		respondWithError(w, http.StatusInternalServerError, "Failed to read context")
+		return
+	}
+
+	projectID, ok := projectIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	filter, err := todoFilterOf(r)
+	if err != nil {
+		respondWithModelError(w, err)
+		return
+	}
+	tree, err := boolParam(r, "tree")
+	if err != nil {
+		respondWithModelError(w, err)
+		return
+	}
+
+	// The project of another user is not found rather than empty
+	if _, err := c.Todos.GetProject(iam, projectID); err != nil {
+		log.Printf("Failed to get project: %s", err.Error())
+		respondWithModelError(w, err)
+		return
+	}
+
+	filter.ProjectID = &projectID
+	todoItems, err := c.Todos.FindTodoItems(iam, filter)
+	if err != nil {
+		log.Printf("Failed to get project todo items: %s", err.Error())
+		respondWithModelError(w, err)
+		return
+	}
+	if tree {
+		todoItems = model.BuildTree(todoItems)
+	}
+
+	respondWithJSON(w, http.StatusOK, todoItems)
+}
+
+// projectIDParam reads the project ID of the route, responding with a 400 if it is not a number
+func projectIDParam(w http.ResponseWriter, r *http.Request) (int, bool) {
+	projectID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		log.Printf("Invalid project ID: %s", err.Error())
+		respondWithError(w, http.StatusBadRequest, "Invalid project ID")
+		return 0, false
+	}
+
+	return projectID, true
+}
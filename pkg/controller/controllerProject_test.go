@@ -0,0 +1,180 @@
+package controller_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/mystardustcaptain/mattodo/pkg/auth"
+	"github.com/mystardustcaptain/mattodo/pkg/controller"
+	"github.com/mystardustcaptain/mattodo/pkg/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestHandleCallback_CreatesInbox tests that a user gets their inbox when they register, and only then.
+func TestHandleCallback_CreatesInbox(t *testing.T) {
+	/// Arrange
+	///
+	p := &staticProvider{name: "static-inbox", user: &auth.UserInfo{ID: "1", Email: "new@example.com", Name: "New"}}
+	auth.RegisterProvider(p)
+	t.Cleanup(func() { auth.UnregisterProvider(p.name) })
+
+	todos, users := model.NewMemoryTodoStore(), model.NewMemoryUserStore()
+	c := controller.NewController(todos, users)
+
+	/// Act
+	///
+	w := signIn(c, p.name)
+	again := signIn(c, p.name)
+
+	/// Assert
+	///
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, http.StatusOK, again.Code)
+
+	user, err := users.GetUserByEmail("new@example.com")
+	if !assert.NoError(t, err) {
+		return
+	}
+	projects, _ := todos.GetProjects(user.ID)
+	if assert.Equal(t, 1, len(projects), "Expected one inbox, whatever the number of sign ins") {
+		assert.Equal(t, model.InboxName, projects[0].Name)
+		assert.True(t, projects[0].Inbox)
+	}
+}
+
+// TestProjects_ListMoveAndDelete tests that the todo items go to the inbox unless given a project of the caller,
+// are listed per project, can be moved between projects, and go back to the inbox when their project is deleted.
+func TestProjects_ListMoveAndDelete(t *testing.T) {
+	/// Arrange
+	///
+	todos := model.NewMemoryTodoStore()
+	c := controller.NewController(todos, model.NewMemoryUserStore())
+	inbox, _ := todos.CreateInbox(2)
+	theirs, _ := todos.CreateInbox(3)
+
+	w := httptest.NewRecorder()
+	c.CreateProject(w, newAuthenticatedRequest("POST", "/projects", `{"name": " Work "}`, 2))
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var work model.Project
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &work))
+	assert.Equal(t, "Work", work.Name, "Expected the name to be trimmed")
+	assert.False(t, work.Inbox)
+
+	w = httptest.NewRecorder()
+	c.CreateProject(w, newAuthenticatedRequest("POST", "/projects", `{"name": "inbox"}`, 2))
+	assert.Equal(t, http.StatusConflict, w.Code, "Expected the names to be unique whatever their case")
+
+	create := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c.CreateTodo(w, newAuthenticatedRequest("POST", "/todo", body, 2))
+		return w
+	}
+	listed := func(projectID int) []string {
+		id := strconv.Itoa(projectID)
+		w := httptest.NewRecorder()
+		r := newAuthenticatedRequest("GET", "/projects/"+id+"/todo", "", 2)
+		c.GetProjectTodos(w, mux.SetURLVars(r, map[string]string{"id": id}))
+		var list []model.TodoItem
+		json.Unmarshal(w.Body.Bytes(), &list)
+		titles := []string{}
+		for _, item := range list {
+			titles = append(titles, item.Title)
+		}
+		return titles
+	}
+
+	/// Act & Assert
+	///
+	w = create(`{"title": "Groceries"}`)
+	assert.JSONEq(t, strconv.Itoa(inbox.ID), string(mustField(t, w, "project_id")), "Expected the item to go to the inbox")
+	w = create(`{"title": "Report", "project_id": ` + strconv.Itoa(work.ID) + `}`)
+	assert.JSONEq(t, strconv.Itoa(work.ID), string(mustField(t, w, "project_id")))
+	var report model.TodoItem
+	json.Unmarshal(w.Body.Bytes(), &report)
+
+	w = create(`{"title": "Theirs", "project_id": ` + strconv.Itoa(theirs.ID) + `}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.JSONEq(t, `{"error": {"code": "validation_failed", "message": "validation failed", "details": {"project_id": "must be one of your projects"}}}`, w.Body.String())
+
+	assert.Equal(t, []string{"Groceries"}, listed(inbox.ID))
+	assert.Equal(t, []string{"Report"}, listed(work.ID))
+
+	id := strconv.Itoa(theirs.ID)
+	w = httptest.NewRecorder()
+	r := newAuthenticatedRequest("GET", "/projects/"+id+"/todo", "", 2)
+	c.GetProjectTodos(w, mux.SetURLVars(r, map[string]string{"id": id}))
+	assert.Equal(t, http.StatusNotFound, w.Code, "Expected the project of another user not to be listed")
+
+	id = strconv.Itoa(report.ID)
+	w = httptest.NewRecorder()
+	r = newAuthenticatedRequest("PATCH", "/todo/"+id, `{"project_id": null}`, 2)
+	c.UpdateTodoById(w, mux.SetURLVars(r, map[string]string{"id": id}))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `null`, string(mustField(t, w, "project_id")), "Expected null to take the item out of its project")
+	assert.Equal(t, []string{}, listed(work.ID))
+
+	w = httptest.NewRecorder()
+	r = newAuthenticatedRequest("PATCH", "/todo/"+id, `{"project_id": `+strconv.Itoa(work.ID)+`}`, 2)
+	c.UpdateTodoById(w, mux.SetURLVars(r, map[string]string{"id": id}))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"Report"}, listed(work.ID))
+
+	id = strconv.Itoa(inbox.ID)
+	w = httptest.NewRecorder()
+	r = newAuthenticatedRequest("DELETE", "/projects/"+id, "", 2)
+	c.DeleteProjectById(w, mux.SetURLVars(r, map[string]string{"id": id}))
+	assert.Equal(t, http.StatusConflict, w.Code, "Expected the inbox not to be deleted")
+
+	id = strconv.Itoa(work.ID)
+	w = httptest.NewRecorder()
+	r = newAuthenticatedRequest("DELETE", "/projects/"+id, "", 2)
+	c.DeleteProjectById(w, mux.SetURLVars(r, map[string]string{"id": id}))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, []string{"Groceries", "Report"}, listed(inbox.ID), "Expected the items of the deleted project in the inbox")
+
+	w = httptest.NewRecorder()
+	c.GetProjects(w, newAuthenticatedRequest("GET", "/projects", "", 2))
+	var projects []model.Project
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &projects))
+	if assert.Equal(t, 1, len(projects)) {
+		assert.Equal(t, inbox.ID, projects[0].ID)
+	}
+}
+
+// TestUpdateProjectById_RenamesProject tests that a project can be renamed by its owner only, and refuses the server fields.
+func TestUpdateProjectById_RenamesProject(t *testing.T) {
+	/// Arrange
+	///
+	todos := model.NewMemoryTodoStore()
+	c := controller.NewController(todos, model.NewMemoryUserStore())
+	inbox, _ := todos.CreateInbox(2)
+	id := strconv.Itoa(inbox.ID)
+
+	update := func(body string, userID int) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := newAuthenticatedRequest("PATCH", "/projects/"+id, body, userID)
+		c.UpdateProjectById(w, mux.SetURLVars(r, map[string]string{"id": id}))
+		return w
+	}
+
+	/// Act
+	///
+	w := update(`{"name": "Later"}`, 2)
+
+	/// Assert
+	///
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `"Later"`, string(mustField(t, w, "name")))
+	assert.JSONEq(t, `true`, string(mustField(t, w, "inbox")), "Expected the renamed inbox to stay the inbox")
+
+	assert.Equal(t, http.StatusNotFound, update(`{"name": "Mine"}`, 3).Code, "Expected another user not to rename the project")
+	assert.Equal(t, http.StatusBadRequest, update(`{}`, 2).Code)
+
+	readOnly := update(`{"inbox": false}`, 2)
+	assert.Equal(t, http.StatusUnprocessableEntity, readOnly.Code)
+	assert.JSONEq(t, `{"error": {"code": "validation_failed", "message": "validation failed", "details": {"inbox": "is read-only"}}}`, readOnly.Body.String())
+}
@@ -0,0 +1,209 @@
+package controller
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/mystardustcaptain/mattodo/pkg/auth"
+	"github.com/mystardustcaptain/mattodo/pkg/model"
+)
+
+// RegisterTagRoutes registers the routes managing the tags of the authenticated user and the tags of their todo items.
+// Personal access tokens can be used with the todo:read scope to read, todo:write to change
+func (c *Controller) RegisterTagRoutes(router *mux.Router) {
+	router.Handle("/tags", c.Auth.RequireScope(auth.ScopeTodoRead, http.HandlerFunc(c.GetTags))).Methods("GET")
+	router.Handle("/tags", c.Auth.RequireScope(auth.ScopeTodoWrite, http.HandlerFunc(c.CreateTag))).Methods("POST")
+	router.Handle("/tags/{id}", c.Auth.RequireScope(auth.ScopeTodoWrite, http.HandlerFunc(c.UpdateTagById))).Methods("PATCH")
+	router.Handle("/tags/{id}", c.Auth.RequireScope(auth.ScopeTodoWrite, http.HandlerFunc(c.DeleteTagById))).Methods("DELETE")
+	router.Handle("/todo/{id}/tags/{tagID}", c.Auth.RequireScope(auth.ScopeTodoWrite, http.HandlerFunc(c.AttachTagToTodo))).Methods("PUT")
+	router.Handle("/todo/{id}/tags/{tagID}", c.Auth.RequireScope(auth.ScopeTodoWrite, http.HandlerFunc(c.DetachTagFromTodo))).Methods("DELETE")
+}
+
+// GetTags lists the tags of the authenticated user, ordered by name
+func (c *Controller) GetTags(w http.ResponseWriter, r *http.Request) {
+	iam, ok := r.Context().Value(auth.ContextUserIDKey).(int)
+	if !ok {
+		log.Printf("Failed to read context")
+		respondWithError(w, http.StatusInternalServerError, "Failed to read context")
+		return
+	}
+
+	tags, err := c.Todos.GetTags(iam)
+	if err != nil {
+		log.Printf("Failed to get tags: %s", err.Error())
+		respondWithModelError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, tags)
+}
+
+// CreateTag creates a tag for the authenticated user.
+// Responds with a 409 if they already have a tag of that name, whatever its case.
+func (c *Controller) CreateTag(w http.ResponseWriter, r *http.Request) {
+	iam, ok := r.Context().Value(auth.ContextUserIDKey).(int)
+	if !ok {
+		log.Printf("Failed to read context")
+		respondWithError(w, http.StatusInternalServerError, "Failed to read context")
+		return
+	}
+
+	var p tagCreatePayload
+	if err := decodeJSON(w, r, &p); err != nil {
+		log.Printf("Invalid request body: %s", err.Error())
+		respondWithDecodeError(w, err)
+		return
+	}
+
+	if verr := p.readOnlyError(); verr != nil {
+		respondWithModelError(w, verr)
+		return
+	}
+
+	tag := model.Tag{Name: p.Name, Color: p.Color}
+	if err := tag.Validate(); err != nil {
+		respondWithModelError(w, err)
+		return
+	}
+
+	if err := c.Todos.CreateTag(iam, &tag); err != nil {
+		log.Printf("Failed to create tag: %s", err.Error())
+		respondWithModelError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, tag)
+}
+
+// UpdateTagById renames or colors a tag of the authenticated user, an empty color removes it.
+// The todo items keep the tag under its new name.
+func (c *Controller) UpdateTagById(w http.ResponseWriter, r *http.Request) {
+	iam, ok := r.Context().Value(auth.ContextUserIDKey).(int)
+	if !ok {
+		log.Printf("Failed to read context")
+		respondWithError(w, http.StatusInternalServerError, "Failed to read context")
+		return
+	}
+
+	tagID, ok := tagIDParam(w, r, "id")
+	if !ok {
+		return
+	}
+
+	var p tagUpdatePayload
+	if err := decodeJSON(w, r, &p); err != nil {
+		log.Printf("Invalid request body: %s", err.Error())
+		respondWithDecodeError(w, err)
+		return
+	}
+
+	if verr := p.readOnlyError(); verr != nil {
+		respondWithModelError(w, verr)
+		return
+	}
+
+	u := p.TagUpdate
+	if u.IsEmpty() {
+		log.Printf("No fields to update")
+		respondWithError(w, http.StatusBadRequest, "No fields to update")
+		return
+	}
+	if err := u.Validate(); err != nil {
+		respondWithModelError(w, err)
+		return
+	}
+
+	tag, err := c.Todos.UpdateTag(iam, tagID, &u)
+	if err != nil {
+		log.Printf("Failed to update tag: %s", err.Error())
+		respondWithModelError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, tag)
+}
+
+// DeleteTagById deletes a tag of the authenticated user, the todo items it was attached to are kept
+func (c *Controller) DeleteTagById(w http.ResponseWriter, r *http.Request) {
+	iam, ok := r.Context().Value(auth.ContextUserIDKey).(int)
+	if !ok {
+		log.Printf("Failed to read context")
+		respondWithError(w, http.StatusInternalServerError, "Failed to read context")
+		return
+	}
+
+	tagID, ok := tagIDParam(w, r, "id")
+	if !ok {
+		return
+	}
+
+	if err := c.Todos.DeleteTag(iam, tagID); err != nil {
+		log.Printf("Failed to delete tag: %s", err.Error())
+		respondWithModelError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
+
+// AttachTagToTodo tags a todo item of the authenticated user with one of their tags.
+// Attaching a tag twice is not an error. Responds with the todo item and its tags.
+func (c *Controller) AttachTagToTodo(w http.ResponseWriter, r *http.Request) {
+	c.tagTodo(w, r, c.Todos.AttachTag)
+}
+
+// DetachTagFromTodo removes a tag from a todo item of the authenticated user.
+// Detaching a tag the item does not have is not an error. Responds with the todo item and its tags.
+func (c *Controller) DetachTagFromTodo(w http.ResponseWriter, r *http.Request) {
+	c.tagTodo(w, r, c.Todos.DetachTag)
+}
+
+// tagTodo attaches or detaches the tag of the route from the todo item of the route with change
+func (c *Controller) tagTodo(w http.ResponseWriter, r *http.Request, change func(userID int, todoItemID int, tagID int) error) {
+	iam, ok := r.Context().Value(auth.ContextUserIDKey).(int)
+	if !ok {
+		log.Printf("Failed to read context")
+		respondWithError(w, http.StatusInternalServerError, "Failed to read context")
+		return
+	}
+
+	todoItemID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		log.Printf("Invalid todo ID")
+		respondWithError(w, http.StatusBadRequest, "Invalid todo ID")
+		return
+	}
+	tagID, ok := tagIDParam(w, r, "tagID")
+	if !ok {
+		return
+	}
+
+	if err := change(iam, todoItemID, tagID); err != nil {
+		log.Printf("Failed to tag todo item: %s", err.Error())
+		respondWithModelError(w, err)
+		return
+	}
+
+	tdi, err := c.Todos.GetTodoItem(iam, todoItemID)
+	if err != nil {
+		log.Printf("Failed to retrieve item after tagging: %s", err.Error())
+		respondWithModelError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, tdi)
+}
+
+// tagIDParam reads a tag ID of the route, responding with a 400 if it is not a number
+func tagIDParam(w http.ResponseWriter, r *http.Request, name string) (int, bool) {
+	tagID, err := strconv.Atoi(mux.Vars(r)[name])
+	if err != nil {
+		log.Printf("Invalid tag ID: %s", err.Error())
+		respondWithError(w, http.StatusBadRequest, "Invalid tag ID")
+		return 0, false
+	}
+
+	return tagID, true
+}
@@ -0,0 +1,186 @@
+package controller_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/mystardustcaptain/mattodo/pkg/controller"
+	"github.com/mystardustcaptain/mattodo/pkg/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestTags_CreateRenameAndDelete tests that a tag is created for the caller, its name unique whatever its case,
+// can be renamed and colored, and is detached from the todo items when deleted.
+func TestTags_CreateRenameAndDelete(t *testing.T) {
+	/// Arrange
+	///
+	todos := model.NewMemoryTodoStore()
+	c := controller.NewController(todos, model.NewMemoryUserStore())
+
+	/// Act & Assert
+	///
+	w := httptest.NewRecorder()
+	c.CreateTag(w, newAuthenticatedRequest("POST", "/tags", `{"name": " Work ", "color": "#1E90FF"}`, 2))
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var created model.Tag
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.Equal(t, "Work", created.Name, "Expected the name to be trimmed")
+	assert.Equal(t, "#1e90ff", created.Color, "Expected the color in lower case")
+	assert.Equal(t, 2, created.UserID)
+
+	w = httptest.NewRecorder()
+	c.CreateTag(w, newAuthenticatedRequest("POST", "/tags", `{"name": "work"}`, 2))
+	assert.Equal(t, http.StatusConflict, w.Code, "Expected the names to be unique whatever their case")
+
+	w = httptest.NewRecorder()
+	c.CreateTag(w, newAuthenticatedRequest("POST", "/tags", `{"name": "work"}`, 3))
+	assert.Equal(t, http.StatusCreated, w.Code, "Expected the names to be unique per user only")
+
+	id := strconv.Itoa(created.ID)
+	w = httptest.NewRecorder()
+	r := newAuthenticatedRequest("PATCH", "/tags/"+id, `{"name": "Office", "color": ""}`, 2)
+	c.UpdateTagById(w, mux.SetURLVars(r, map[string]string{"id": id}))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `"Office"`, string(mustField(t, w, "name")))
+	assert.JSONEq(t, `""`, string(mustField(t, w, "color")), "Expected an empty color to remove it")
+
+	w = httptest.NewRecorder()
+	r = newAuthenticatedRequest("PATCH", "/tags/"+id, `{"name": "Office"}`, 3)
+	c.UpdateTagById(w, mux.SetURLVars(r, map[string]string{"id": id}))
+	assert.Equal(t, http.StatusNotFound, w.Code, "Expected another user not to rename the tag")
+
+	todo := model.TodoItem{Title: "Report"}
+	todos.CreateTodoItem(2, &todo)
+	todos.AttachTag(2, todo.ID, created.ID)
+
+	w = httptest.NewRecorder()
+	r = newAuthenticatedRequest("DELETE", "/tags/"+id, "", 2)
+	c.DeleteTagById(w, mux.SetURLVars(r, map[string]string{"id": id}))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	stored, err := todos.GetTodoItem(2, todo.ID)
+	assert.NoError(t, err, "Expected the todo item to be kept")
+	assert.Empty(t, stored.Tags, "Expected the deleted tag to be detached")
+
+	w = httptest.NewRecorder()
+	c.GetTags(w, newAuthenticatedRequest("GET", "/tags", "", 2))
+	assert.JSONEq(t, `[]`, w.Body.String())
+}
+
+// mustField returns a field of the JSON object of a response
+func mustField(t *testing.T, w *httptest.ResponseRecorder, field string) json.RawMessage {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(w.Body.Bytes(), &fields); err != nil {
+		t.Fatalf("an error '%s' was not expected when decoding the response", err)
+	}
+
+	return fields[field]
+}
+
+// TestCreateTag_RejectsInvalidPayloads tests that CreateTag refuses invalid names and colors, and the server fields.
+func TestCreateTag_RejectsInvalidPayloads(t *testing.T) {
+	cases := []struct {
+		name         string
+		body         string
+		expectedBody string
+	}{
+		{"empty name", `{"name": "  "}`,
+			`{"error": {"code": "validation_failed", "message": "validation failed", "details": {"name": "must not be empty"}}}`},
+		{"invalid color", `{"name": "Work", "color": "blue"}`,
+			`{"error": {"code": "validation_failed", "message": "validation failed", "details": {"color": "must be a hex color like #1e90ff, or empty"}}}`},
+		{"server field", `{"name": "Work", "user_id": 3}`,
+			`{"error": {"code": "validation_failed", "message": "validation failed", "details": {"user_id": "is read-only"}}}`},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := controller.NewController(model.NewMemoryTodoStore(), model.NewMemoryUserStore())
+
+			w := httptest.NewRecorder()
+			c.CreateTag(w, newAuthenticatedRequest("POST", "/tags", tc.body, 2))
+
+			assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+			assert.JSONEq(t, tc.expectedBody, w.Body.String())
+		})
+	}
+}
+
+// TestAttachTagToTodo_ListsTaggedItems tests that the tags attached to the todo items are in their JSON,
+// and select them with any or all of the tags given.
+func TestAttachTagToTodo_ListsTaggedItems(t *testing.T) {
+	/// Arrange
+	///
+	todos := model.NewMemoryTodoStore()
+	c := controller.NewController(todos, model.NewMemoryUserStore())
+
+	report, groceries, theirs := model.TodoItem{Title: "Report"}, model.TodoItem{Title: "Groceries"}, model.TodoItem{Title: "Theirs"}
+	todos.CreateTodoItem(2, &report)
+	todos.CreateTodoItem(2, &groceries)
+	todos.CreateTodoItem(3, &theirs)
+	work, urgent, home := model.Tag{Name: "Work"}, model.Tag{Name: "Urgent"}, model.Tag{Name: "Home"}
+	todos.CreateTag(2, &work)
+	todos.CreateTag(2, &urgent)
+	todos.CreateTag(2, &home)
+
+	attach := func(todoItemID int, tagID int, userID int) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := newAuthenticatedRequest("PUT", "/todo/"+strconv.Itoa(todoItemID)+"/tags/"+strconv.Itoa(tagID), "", userID)
+		c.AttachTagToTodo(w, mux.SetURLVars(r, map[string]string{"id": strconv.Itoa(todoItemID), "tagID": strconv.Itoa(tagID)}))
+		return w
+	}
+
+	/// Act
+	///
+	w := attach(report.ID, work.ID, 2)
+	attach(report.ID, urgent.ID, 2)
+	attach(groceries.ID, home.ID, 2)
+	attach(groceries.ID, urgent.ID, 2)
+
+	/// Assert
+	///
+	assert.Equal(t, http.StatusOK, w.Code)
+	var tagged model.TodoItem
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &tagged))
+	if assert.Equal(t, 1, len(tagged.Tags)) {
+		assert.Equal(t, "Work", tagged.Tags[0].Name)
+	}
+
+	assert.Equal(t, http.StatusNotFound, attach(theirs.ID, work.ID, 3).Code, "Expected the tag of another user to be refused")
+	assert.Equal(t, http.StatusNotFound, attach(theirs.ID, work.ID, 2).Code, "Expected the todo item of another user to be refused")
+
+	cases := []struct {
+		query    string
+		expected []string
+	}{
+		{"", []string{"Report", "Groceries"}},
+		{"?tag=work", []string{"Report"}},
+		{"?tag=Work&tag=home", []string{"Report", "Groceries"}},
+		{"?tag=work&tag=urgent&tag_match=all", []string{"Report"}},
+		{"?tag=work&tag=home&tag_match=all", []string{}},
+	}
+	for _, tc := range cases {
+		listed := httptest.NewRecorder()
+		c.GetTodos(listed, newAuthenticatedRequest("GET", "/todo"+tc.query, "", 2))
+		var list []model.TodoItem
+		assert.NoError(t, json.Unmarshal(listed.Body.Bytes(), &list))
+		titles := []string{}
+		for _, item := range list {
+			titles = append(titles, item.Title)
+			assert.NotNil(t, item.Tags, "Expected the tags of every item")
+		}
+		assert.Equal(t, tc.expected, titles, tc.query)
+	}
+
+	detached := httptest.NewRecorder()
+	r := newAuthenticatedRequest("DELETE", "/todo/"+strconv.Itoa(report.ID)+"/tags/"+strconv.Itoa(work.ID), "", 2)
+	c.DetachTagFromTodo(detached, mux.SetURLVars(r, map[string]string{"id": strconv.Itoa(report.ID), "tagID": strconv.Itoa(work.ID)}))
+	assert.Equal(t, http.StatusOK, detached.Code)
+	assert.NoError(t, json.Unmarshal(detached.Body.Bytes(), &tagged))
+	if assert.Equal(t, 1, len(tagged.Tags)) {
+		assert.Equal(t, "Urgent", tagged.Tags[0].Name)
+	}
+}
@@ -1,11 +1,11 @@
 package controller
 
 import (
-	"encoding/json"
-	"io"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/mystardustcaptain/mattodo/pkg/auth"
@@ -13,15 +13,21 @@ import (
 )
 
 // Register routes for the controller related to todo items
+// Personal access tokens can be used with the todo:read scope to read, todo:write to change
 func (c *Controller) RegisterTodoRoutes(router *mux.Router) {
-	router.Handle("/todo", auth.ValidateTokenMiddleware(http.HandlerFunc(c.GetTodos))).Methods("GET")
-	router.Handle("/todo", auth.ValidateTokenMiddleware(http.HandlerFunc(c.CreateTodo))).Methods("POST")
-	router.Handle("/todo/{id}", auth.ValidateTokenMiddleware(http.HandlerFunc(c.DeleteTodoById))).Methods("DELETE")
-	router.Handle("/todo/{id}/complete", auth.ValidateTokenMiddleware(http.HandlerFunc(c.MarkTodoCompleteById))).Methods("PUT")
+	router.Handle("/todo", c.Auth.RequireScope(auth.ScopeTodoRead, http.HandlerFunc(c.GetTodos))).Methods("GET")
+	router.Handle("/todo", c.Auth.RequireScope(auth.ScopeTodoWrite, http.HandlerFunc(c.CreateTodo))).Methods("POST")
+	router.Handle("/todo/{id}", c.Auth.RequireScope(auth.ScopeTodoWrite, http.HandlerFunc(c.UpdateTodoById))).Methods("PATCH")
+	router.Handle("/todo/{id}", c.Auth.RequireScope(auth.ScopeTodoWrite, http.HandlerFunc(c.DeleteTodoById))).Methods("DELETE")
+	router.Handle("/todo/{id}/complete", c.Auth.RequireScope(auth.ScopeTodoWrite, http.HandlerFunc(c.MarkTodoCompleteById))).Methods("PUT")
+	router.Handle("/todo/{id}/move", c.Auth.RequireScope(auth.ScopeTodoWrite, http.HandlerFunc(c.MoveTodoById))).Methods("PUT")
+	router.Handle("/todo/{id}/children", c.Auth.RequireScope(auth.ScopeTodoRead, http.HandlerFunc(c.GetTodoChildrenById))).Methods("GET")
 }
 
-// GetTodos retrieves all todo items for the authenticated user
-// with userID saved in the request context
+// GetTodos retrieves the todo items for the authenticated user
+// with userID saved in the request context.
+// Query parameters filter them by due date, see todoFilterOf.
+// tree=true nests the subtasks under their parent, see model.BuildTree.
 func (c *Controller) GetTodos(w http.ResponseWriter, r *http.Request) {
 	// Retrieve iam / db userID from the request context
 	iam, ok := r.Context().Value(auth.ContextUserIDKey).(int)
@@ -31,19 +37,73 @@ func (c *Controller) GetTodos(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tc := model.TodoItemCollection{DB: c.Database}
+	filter, err := todoFilterOf(r)
+	if err != nil {
+		respondWithModelError(w, err)
+		return
+	}
+	tree, err := boolParam(r, "tree")
+	if err != nil {
+		respondWithModelError(w, err)
+		return
+	}
 
-	// Retrieve all todo items for the user
-	todoItems, err := tc.GetAllTodoItems(iam)
+	// Retrieve the todo items for the user
+	todoItems, err := c.Todos.FindTodoItems(iam, filter)
 	if err != nil {
 		log.Printf("Failed to get all todo items: %s", err.Error())
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+		respondWithModelError(w, err)
 		return
 	}
+	if tree {
+		todoItems = model.BuildTree(todoItems)
+	}
 
 	respondWithJSON(w, http.StatusOK, todoItems)
 }
 
+// GetTodoChildrenById lists the subtasks of a todo item for the authenticated user,
+// each with its own subtasks nested, whatever their depth.
+func (c *Controller) GetTodoChildrenById(w http.ResponseWriter, r *http.Request) {
+	iam, ok := r.Context().Value(auth.ContextUserIDKey).(int)
+	if !ok {
+		log.Printf("Failed to read context")
+		respondWithError(w, http.StatusInternalServerError, "Failed to read context")
+		return
+	}
+
+	todoItemID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		log.Printf("Invalid todo ID")
+		respondWithError(w, http.StatusBadRequest, "Invalid todo ID")
+		return
+	}
+
+	if _, err := c.Todos.GetTodoItem(iam, todoItemID); err != nil {
+		log.Printf("Failed to get todo item: %s", err.Error())
+		respondWithModelError(w, err)
+		return
+	}
+
+	// The subtasks of any depth are nested from the list of the user, loaded with a single query
+	todoItems, err := c.Todos.GetAllTodoItems(iam)
+	if err != nil {
+		log.Printf("Failed to get all todo items: %s", err.Error())
+		respondWithModelError(w, err)
+		return
+	}
+	model.BuildTree(todoItems)
+
+	children := []*model.TodoItem{}
+	for _, t := range todoItems {
+		if t.ID == todoItemID {
+			children = t.Children
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, children)
+}
+
 // CreateTodo creates a new todo item for the authenticated user
 // with userID saved in the request context
 func (c *Controller) CreateTodo(w http.ResponseWriter, r *http.Request) {
@@ -55,20 +115,38 @@ func (c *Controller) CreateTodo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var t model.TodoItem
-
-	// Decode the request body into a TodoItem struct
+	// Decode the request body
 	// content provided will be used to create a new todo item
-	reqBody, _ := io.ReadAll(r.Body)
-	json.Unmarshal(reqBody, &t)
+	var p todoCreatePayload
+	if err := decodeJSON(w, r, &p); err != nil {
+		log.Printf("Invalid request body: %s", err.Error())
+		respondWithDecodeError(w, err)
+		return
+	}
+
+	// Server fields like id and user_id are rejected rather than ignored
+	if verr := p.readOnlyError(); verr != nil {
+		respondWithModelError(w, verr)
+		return
+	}
 
-	tc := model.TodoItemCollection{DB: c.Database}
+	dueAt, _, err := parseDueAt(p.DueAt)
+	if err != nil {
+		respondWithModelError(w, err)
+		return
+	}
+
+	t := model.TodoItem{Title: p.Title, Completed: p.Completed, Priority: p.Priority, DueAt: dueAt, ProjectID: p.ProjectID, ParentID: p.ParentID}
+	if err := t.Validate(); err != nil {
+		respondWithModelError(w, err)
+		return
+	}
 
 	// Create the todo item in the database
-	err := tc.CreateTodoItem(iam, &t)
+	err = c.Todos.CreateTodoItem(iam, &t)
 	if err != nil {
 		log.Printf("Failed to create todo item: %s", err.Error())
-		respondWithError(w, http.StatusInternalServerError, "Failed to create todo item: "+err.Error())
+		respondWithModelError(w, err)
 		return
 	}
 
@@ -96,13 +174,11 @@ func (c *Controller) DeleteTodoById(w http.ResponseWriter, r *http.Request) {
 	}
 	itemID := todoItemID
 
-	tc := model.TodoItemCollection{DB: c.Database}
-
 	// Delete the todo item from the database
-	err = tc.DeleteTodoItem(iam, itemID)
+	err = c.Todos.DeleteTodoItem(iam, itemID)
 	if err != nil {
 		log.Printf("Failed to delete todo item: %s", err.Error())
-		respondWithError(w, http.StatusInternalServerError, "Failed to delete todo item: "+err.Error())
+		respondWithModelError(w, err)
 		return
 	}
 
@@ -111,7 +187,8 @@ func (c *Controller) DeleteTodoById(w http.ResponseWriter, r *http.Request) {
 }
 
 // MarkTodoCompleteById marks a todo item as complete for the authenticated user
-// with userID saved in the request context
+// with userID saved in the request context.
+// subtasks=true completes all its subtasks too, whatever their depth.
 func (c *Controller) MarkTodoCompleteById(w http.ResponseWriter, r *http.Request) {
 	// Retrieve iam from the request context
 	iam, ok := r.Context().Value(auth.ContextUserIDKey).(int)
@@ -131,24 +208,246 @@ func (c *Controller) MarkTodoCompleteById(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	tc := model.TodoItemCollection{DB: c.Database}
+	subtasks, err := boolParam(r, "subtasks")
+	if err != nil {
+		respondWithModelError(w, err)
+		return
+	}
 
-	// Mark the todo item as complete in the database
-	err = tc.MarkComplete(iam, todoItemID)
+	// Mark the todo item as complete in the database, with its subtasks if asked
+	err = c.Todos.MarkComplete(iam, todoItemID, subtasks)
 	if err != nil {
 		log.Printf("Failed to mark complete todo item: %s", err.Error())
-		respondWithError(w, http.StatusInternalServerError, "Failed to mark complete todo item: "+err.Error())
+		respondWithModelError(w, err)
 		return
 	}
 
 	// Retrieve the todo item from the database
 	// to return to the user
-	tdi, err := tc.GetTodoItem(iam, todoItemID)
+	tdi, err := c.Todos.GetTodoItem(iam, todoItemID)
 	if err != nil {
 		log.Printf("Failed to retrieve item after mark complete: %s", err.Error())
-		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve item after mark complete: "+err.Error())
+		respondWithModelError(w, err)
 		return
 	}
 
 	respondWithJSON(w, http.StatusOK, tdi)
 }
+
+// UpdateTodoById applies a partial update to a todo item for the authenticated user
+// with userID saved in the request context.
+// Only the fields present in the request body are changed.
+func (c *Controller) UpdateTodoById(w http.ResponseWriter, r *http.Request) {
+	// Retrieve iam from the request context
+	iam, ok := r.Context().Value(auth.ContextUserIDKey).(int)
+	if !ok {
+		log.Printf("Failed to read context")
+		respondWithError(w, http.StatusInternalServerError, "Failed to read context")
+		return
+	}
+
+	// Retrieve the todo item id from the request path
+	// This is the target todo item to be updated
+	vars := mux.Vars(r)
+	todoItemID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		log.Printf("Invalid todo ID")
+		respondWithError(w, http.StatusBadRequest, "Invalid todo ID")
+		return
+	}
+
+	// Decode the request body
+	// fields not present in the body are left untouched
+	var p todoUpdatePayload
+	if err := decodeJSON(w, r, &p); err != nil {
+		log.Printf("Invalid request body: %s", err.Error())
+		respondWithDecodeError(w, err)
+		return
+	}
+
+	// Server fields like id and user_id are rejected rather than ignored
+	if verr := p.readOnlyError(); verr != nil {
+		respondWithModelError(w, verr)
+		return
+	}
+
+	u := p.TodoItemUpdate
+	dueAt, dueAtSet, err := parseDueAt(p.DueAt)
+	if err != nil {
+		respondWithModelError(w, err)
+		return
+	}
+	u.DueAt = dueAt
+	u.ClearDueAt = dueAtSet && dueAt == nil
+
+	projectID, projectIDSet, err := parseNullableID(p.ProjectID, "project_id")
+	if err != nil {
+		respondWithModelError(w, err)
+		return
+	}
+	u.ProjectID = projectID
+	u.ClearProjectID = projectIDSet && projectID == nil
+
+	parentID, parentIDSet, err := parseNullableID(p.ParentID, "parent_id")
+	if err != nil {
+		respondWithModelError(w, err)
+		return
+	}
+	u.ParentID = parentID
+	u.ClearParentID = parentIDSet && parentID == nil
+
+	if u.IsEmpty() {
+		log.Printf("No fields to update")
+		respondWithError(w, http.StatusBadRequest, "No fields to update")
+		return
+	}
+
+	if err := u.Validate(); err != nil {
+		respondWithModelError(w, err)
+		return
+	}
+
+	// Update the todo item in the database
+	tdi, err := c.Todos.UpdateTodoItem(iam, todoItemID, &u)
+	if err != nil {
+		log.Printf("Failed to update todo item: %s", err.Error())
+		respondWithModelError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, tdi)
+}
+
+// MoveTodoById moves a todo item of the authenticated user
+// right after or right before another of their todo items, e.g. when it is dragged and dropped.
+func (c *Controller) MoveTodoById(w http.ResponseWriter, r *http.Request) {
+	// Retrieve iam from the request context
+	iam, ok := r.Context().Value(auth.ContextUserIDKey).(int)
+	if !ok {
+		log.Printf("Failed to read context")
+		respondWithError(w, http.StatusInternalServerError, "Failed to read context")
+		return
+	}
+
+	// Retrieve the todo item id from the request path
+	// This is the target todo item to be moved
+	vars := mux.Vars(r)
+	todoItemID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		log.Printf("Invalid todo ID")
+		respondWithError(w, http.StatusBadRequest, "Invalid todo ID")
+		return
+	}
+
+	var m model.TodoItemMove
+	if err := decodeJSON(w, r, &m); err != nil {
+		log.Printf("Invalid request body: %s", err.Error())
+		respondWithDecodeError(w, err)
+		return
+	}
+
+	if err := m.Validate(todoItemID); err != nil {
+		respondWithModelError(w, err)
+		return
+	}
+
+	// Move the todo item in the database
+	tdi, err := c.Todos.MoveTodoItem(iam, todoItemID, &m)
+	if err != nil {
+		log.Printf("Failed to move todo item: %s", err.Error())
+		respondWithModelError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, tdi)
+}
+
+// boolParam reads an optional true or false query parameter, false if missing.
+// Returns a ValidationError if it is neither.
+func boolParam(r *http.Request, param string) (bool, error) {
+	value := r.URL.Query().Get(param)
+	if value == "" {
+		return false, nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, model.NewValidationError(param, "must be true or false")
+	}
+
+	return b, nil
+}
+
+// todoFilterOf reads the filters of GET /todo from the query parameters:
+// priority only lists the items of a priority,
+// tag only lists the items with a tag, given several times the items with any of them, or all of them with tag_match=all,
+// due_after and due_before bound the due date with RFC 3339 times,
+// overdue=true only lists the pending items past their due date,
+// due_today=true only lists the items due today in the time zone given by tz, e.g. tz=Europe/Paris, UTC by default.
+// Returns a ValidationError listing the invalid parameters.
+func todoFilterOf(r *http.Request) (*model.TodoFilter, error) {
+	query := r.URL.Query()
+	filter := &model.TodoFilter{Priority: query.Get("priority")}
+	verr := &model.ValidationError{}
+
+	if filter.Priority != "" && model.ValidatePriority(filter.Priority) != nil {
+		verr.Add("priority", "must be among "+strings.Join(model.Priorities, ", "))
+	}
+
+	for _, name := range query["tag"] {
+		name, err := model.ValidateTagName(name)
+		if err != nil {
+			verr.Add("tag", "must be tag names of at most "+strconv.Itoa(model.MaxTagNameLength)+" characters")
+			continue
+		}
+		filter.Tags = append(filter.Tags, name)
+	}
+	switch query.Get("tag_match") {
+	case "", "any":
+	case "all":
+		filter.AllTags = true
+	default:
+		verr.Add("tag_match", "must be any or all")
+	}
+
+	for param, bound := range map[string]**time.Time{"due_after": &filter.DueAfter, "due_before": &filter.DueBefore} {
+		if value := query.Get(param); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				verr.Add(param, dueAtFormat)
+				continue
+			}
+			*bound = &t
+		}
+	}
+
+	overdue, dueToday := false, false
+	for param, flag := range map[string]*bool{"overdue": &overdue, "due_today": &dueToday} {
+		if value := query.Get(param); value != "" {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				verr.Add(param, "must be true or false")
+			}
+			*flag = b
+		}
+	}
+
+	location, err := time.LoadLocation(query.Get("tz"))
+	if err != nil {
+		verr.Add("tz", "must be a time zone of the IANA database, e.g. Europe/Paris")
+	}
+
+	if len(verr.Fields) > 0 {
+		return nil, verr
+	}
+
+	now := model.Now()
+	if overdue {
+		filter.Overdue(now)
+	}
+	if dueToday {
+		filter.DueOn(now.In(location))
+	}
+
+	return filter, nil
+}
@@ -0,0 +1,532 @@
+package controller_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mystardustcaptain/mattodo/pkg/auth"
+	"github.com/mystardustcaptain/mattodo/pkg/controller"
+	"github.com/mystardustcaptain/mattodo/pkg/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// newAuthenticatedRequest creates a request as if it went through ValidateTokenMiddleware for the given user.
+func newAuthenticatedRequest(method string, target string, body string, userID int) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	return r.WithContext(context.WithValue(r.Context(), auth.ContextUserIDKey, userID))
+}
+
+// TestCreateTodo_StoresItemForCaller tests that CreateTodo creates the todo item
+// for the user of the request context and returns it.
+func TestCreateTodo_StoresItemForCaller(t *testing.T) {
+	/// Arrange
+	///
+	todos := model.NewMemoryTodoStore()
+	c := controller.NewController(todos, model.NewMemoryUserStore())
+
+	w := httptest.NewRecorder()
+	r := newAuthenticatedRequest("POST", "/todo", `{"title": "New Task"}`, 2)
+
+	/// Act
+	///
+	c.CreateTodo(w, r)
+
+	/// Assert
+	///
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var created model.TodoItem
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.Equal(t, "New Task", created.Title)
+	assert.Equal(t, 2, created.UserID)
+
+	stored, err := todos.GetAllTodoItems(2)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(stored))
+}
+
+// TestUpdateTodoById_ChangesOnlyGivenFields tests that UpdateTodoById
+// renames the todo item without touching its completed status.
+func TestUpdateTodoById_ChangesOnlyGivenFields(t *testing.T) {
+	/// Arrange
+	///
+	todos := model.NewMemoryTodoStore()
+	c := controller.NewController(todos, model.NewMemoryUserStore())
+
+	todo := model.TodoItem{Title: "Old", Completed: true}
+	todos.CreateTodoItem(2, &todo)
+
+	w := httptest.NewRecorder()
+	r := newAuthenticatedRequest("PATCH", "/todo/"+strconv.Itoa(todo.ID), `{"title": "New"}`, 2)
+	r = mux.SetURLVars(r, map[string]string{"id": strconv.Itoa(todo.ID)})
+
+	/// Act
+	///
+	c.UpdateTodoById(w, r)
+
+	/// Assert
+	///
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	stored, _ := todos.GetTodoItem(2, todo.ID)
+	assert.Equal(t, "New", stored.Title)
+	assert.True(t, stored.Completed, "Expected completed to be left untouched")
+}
+
+// TestDeleteTodoById_RejectsOtherUsersItem tests that DeleteTodoById
+// does not delete a todo item belonging to someone else,
+// and reports it as not found.
+func TestDeleteTodoById_RejectsOtherUsersItem(t *testing.T) {
+	/// Arrange
+	///
+	todos := model.NewMemoryTodoStore()
+	c := controller.NewController(todos, model.NewMemoryUserStore())
+
+	todo := model.TodoItem{Title: "Theirs"}
+	todos.CreateTodoItem(3, &todo)
+
+	w := httptest.NewRecorder()
+	r := newAuthenticatedRequest("DELETE", "/todo/"+strconv.Itoa(todo.ID), "", 2)
+	r = mux.SetURLVars(r, map[string]string{"id": strconv.Itoa(todo.ID)})
+
+	/// Act
+	///
+	c.DeleteTodoById(w, r)
+
+	/// Assert
+	///
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.JSONEq(t, `{"error": {"code": "not_found", "message": "todo item `+strconv.Itoa(todo.ID)+`: not found"}}`, w.Body.String())
+
+	_, err := todos.GetTodoItem(3, todo.ID)
+	assert.NoError(t, err, "Expected the todo item to still exist")
+}
+
+// failingTodoStore is a TodoStore whose every call fails with a database error.
+type failingTodoStore struct {
+	model.TodoStore
+}
+
+func (failingTodoStore) GetAllTodoItems(userID int) ([]*model.TodoItem, error) {
+	return nil, errors.New("pq: relation \"todos\" does not exist")
+}
+
+func (failingTodoStore) FindTodoItems(userID int, f *model.TodoFilter) ([]*model.TodoItem, error) {
+	return nil, errors.New("pq: relation \"todos\" does not exist")
+}
+
+// TestGetTodos_DoesNotLeakDatabaseErrors tests that an unexpected store error
+// is reported as an internal error without the database message.
+func TestGetTodos_DoesNotLeakDatabaseErrors(t *testing.T) {
+	/// Arrange
+	///
+	c := controller.NewController(failingTodoStore{}, model.NewMemoryUserStore())
+
+	w := httptest.NewRecorder()
+	r := newAuthenticatedRequest("GET", "/todo", "", 2)
+
+	/// Act
+	///
+	c.GetTodos(w, r)
+
+	/// Assert
+	///
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.JSONEq(t, `{"error": {"code": "internal", "message": "Internal server error"}}`, w.Body.String())
+}
+
+// TestCreateTodo_RejectsInvalidPayloads tests that CreateTodo refuses malformed or invalid payloads
+// with field-level details, and creates nothing.
+func TestCreateTodo_RejectsInvalidPayloads(t *testing.T) {
+	cases := []struct {
+		name         string
+		body         string
+		expectedCode int
+		expectedBody string
+	}{
+		{"malformed JSON", `{"title": "New Task"`, http.StatusBadRequest,
+			`{"error": {"code": "bad_request", "message": "Malformed JSON body"}}`},
+		{"empty body", ``, http.StatusBadRequest,
+			`{"error": {"code": "bad_request", "message": "Malformed JSON body"}}`},
+		{"unknown field", `{"title": "New Task", "colour": "red"}`, http.StatusUnprocessableEntity,
+			`{"error": {"code": "validation_failed", "message": "validation failed", "details": {"colour": "is not a known field"}}}`},
+		{"wrong type", `{"title": 42}`, http.StatusUnprocessableEntity,
+			`{"error": {"code": "validation_failed", "message": "validation failed", "details": {"title": "must be a string"}}}`},
+		{"pointer of the wrong type", `{"title": "New Task", "project_id": "inbox"}`, http.StatusUnprocessableEntity,
+			`{"error": {"code": "validation_failed", "message": "validation failed", "details": {"project_id": "must be a number"}}}`},
+		{"not an object", `[]`, http.StatusBadRequest,
+			`{"error": {"code": "bad_request", "message": "Malformed JSON body"}}`},
+		{"a string", `"New Task"`, http.StatusBadRequest,
+			`{"error": {"code": "bad_request", "message": "Malformed JSON body"}}`},
+		{"blank title", `{"title": "   "}`, http.StatusUnprocessableEntity,
+			`{"error": {"code": "validation_failed", "message": "validation failed", "details": {"title": "must not be empty"}}}`},
+		{"server fields", `{"title": "New Task", "id": 7, "user_id": 3, "completed_at": null}`, http.StatusUnprocessableEntity,
+			`{"error": {"code": "validation_failed", "message": "validation failed", "details": {"id": "is read-only", "user_id": "is read-only", "completed_at": "is read-only"}}}`},
+		{"unknown priority", `{"title": "New Task", "priority": "critical"}`, http.StatusUnprocessableEntity,
+			`{"error": {"code": "validation_failed", "message": "validation failed", "details": {"priority": "must be among none, low, medium, high, urgent"}}}`},
+		{"position", `{"title": "New Task", "position": "a"}`, http.StatusUnprocessableEntity,
+			`{"error": {"code": "validation_failed", "message": "validation failed", "details": {"position": "is read-only"}}}`},
+		{"due date without offset", `{"title": "New Task", "due_at": "2030-01-31T18:00:00"}`, http.StatusUnprocessableEntity,
+			`{"error": {"code": "validation_failed", "message": "validation failed", "details": {"due_at": "must be a time in RFC 3339 format, with its offset, e.g. 2030-01-31T18:00:00+01:00"}}}`},
+		{"too large", `{"title": "` + strings.Repeat("a", 70<<10) + `"}`, http.StatusRequestEntityTooLarge,
+			`{"error": {"code": "payload_too_large", "message": "Request body too large"}}`},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			/// Arrange
+			///
+			todos := model.NewMemoryTodoStore()
+			c := controller.NewController(todos, model.NewMemoryUserStore())
+
+			w := httptest.NewRecorder()
+			r := newAuthenticatedRequest("POST", "/todo", tc.body, 2)
+
+			/// Act
+			///
+			c.CreateTodo(w, r)
+
+			/// Assert
+			///
+			assert.Equal(t, tc.expectedCode, w.Code)
+			assert.JSONEq(t, tc.expectedBody, w.Body.String())
+
+			stored, _ := todos.GetAllTodoItems(2)
+			assert.Empty(t, stored, "Expected no todo item to be created")
+		})
+	}
+}
+
+// TestCreateTodo_TrimsTitle tests that CreateTodo stores the title without surrounding spaces.
+func TestCreateTodo_TrimsTitle(t *testing.T) {
+	todos := model.NewMemoryTodoStore()
+	c := controller.NewController(todos, model.NewMemoryUserStore())
+
+	w := httptest.NewRecorder()
+	c.CreateTodo(w, newAuthenticatedRequest("POST", "/todo", `{"title": "  New Task \n"}`, 2))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	stored, _ := todos.GetAllTodoItems(2)
+	assert.Equal(t, "New Task", stored[0].Title)
+}
+
+// TestCreateTodo_StoresDueAtInUTC tests that a due date given with an offset is stored as the same instant in UTC.
+func TestCreateTodo_StoresDueAtInUTC(t *testing.T) {
+	todos := model.NewMemoryTodoStore()
+	c := controller.NewController(todos, model.NewMemoryUserStore())
+
+	w := httptest.NewRecorder()
+	c.CreateTodo(w, newAuthenticatedRequest("POST", "/todo", `{"title": "Call mum", "due_at": "2030-01-31T18:00:00+01:00"}`, 2))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"due_at":"2030-01-31T17:00:00Z"`)
+	assert.Contains(t, w.Body.String(), `"completed_at":null`)
+}
+
+// TestUpdateTodoById_ClearsDueAtWithNull tests that due_at set to null removes the due date,
+// while leaving it out does not touch it.
+func TestUpdateTodoById_ClearsDueAtWithNull(t *testing.T) {
+	/// Arrange
+	///
+	todos := model.NewMemoryTodoStore()
+	c := controller.NewController(todos, model.NewMemoryUserStore())
+
+	due := time.Date(2030, 1, 31, 17, 0, 0, 0, time.UTC)
+	todo := model.TodoItem{Title: "Call mum", DueAt: &due}
+	todos.CreateTodoItem(2, &todo)
+
+	update := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := newAuthenticatedRequest("PATCH", "/todo/"+strconv.Itoa(todo.ID), body, 2)
+		c.UpdateTodoById(w, mux.SetURLVars(r, map[string]string{"id": strconv.Itoa(todo.ID)}))
+		return w
+	}
+
+	/// Act
+	///
+	renamed := update(`{"title": "Call dad"}`)
+	stillDue, _ := todos.GetTodoItem(2, todo.ID)
+	cleared := update(`{"due_at": null}`)
+	notDue, _ := todos.GetTodoItem(2, todo.ID)
+
+	/// Assert
+	///
+	assert.Equal(t, http.StatusOK, renamed.Code)
+	assert.Equal(t, &due, stillDue.DueAt, "Expected the due date to be left untouched")
+	assert.Equal(t, http.StatusOK, cleared.Code)
+	assert.Nil(t, notDue.DueAt, "Expected the due date to be removed")
+}
+
+// TestGetTodos_FiltersByDueDate tests that GetTodos lists the items selected by the due date filters,
+// today being the day in the time zone given.
+func TestGetTodos_FiltersByDueDate(t *testing.T) {
+	// 1am in UTC is still the evening before in New York
+	now := time.Date(2030, 1, 16, 1, 0, 0, 0, time.UTC)
+	model.Now = func() time.Time { return now }
+	defer func() { model.Now = time.Now }()
+
+	todos := model.NewMemoryTodoStore()
+	titles := map[string]time.Duration{"Last week": -7 * 24 * time.Hour, "Last night": -3 * time.Hour, "Tonight": 2 * time.Hour, "Tomorrow": 30 * time.Hour}
+	for title, in := range titles {
+		due := now.Add(in)
+		todos.CreateTodoItem(2, &model.TodoItem{Title: title, DueAt: &due})
+	}
+	todos.CreateTodoItem(2, &model.TodoItem{Title: "Someday"})
+
+	cases := []struct {
+		name     string
+		query    string
+		expected []string
+	}{
+		{"no filter", "", []string{"Last night", "Last week", "Someday", "Tomorrow", "Tonight"}},
+		{"overdue", "?overdue=true", []string{"Last week", "Last night"}},
+		{"due today in UTC", "?due_today=true", []string{"Tonight"}},
+		{"due today in New York", "?due_today=true&tz=America/New_York", []string{"Last night", "Tonight"}},
+		{"overdue today", "?due_today=true&overdue=true&tz=America/New_York", []string{"Last night"}},
+		{"due before", "?due_before=2030-01-16T00:00:00Z", []string{"Last week", "Last night"}},
+		{"due after with offset", "?due_after=2030-01-16T03:00:00%2B01:00", []string{"Tonight", "Tomorrow"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := controller.NewController(todos, model.NewMemoryUserStore())
+
+			w := httptest.NewRecorder()
+			c.GetTodos(w, newAuthenticatedRequest("GET", "/todo"+tc.query, "", 2))
+
+			assert.Equal(t, http.StatusOK, w.Code)
+
+			var listed []model.TodoItem
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &listed))
+			titles := []string{}
+			for _, item := range listed {
+				titles = append(titles, item.Title)
+			}
+			if tc.query == "" {
+				sort.Strings(titles) // in the order of creation, from a map
+			}
+			assert.Equal(t, tc.expected, titles)
+		})
+	}
+}
+
+// TestGetTodos_RejectsInvalidFilters tests that GetTodos refuses invalid filters with field-level details.
+func TestGetTodos_RejectsInvalidFilters(t *testing.T) {
+	c := controller.NewController(model.NewMemoryTodoStore(), model.NewMemoryUserStore())
+
+	w := httptest.NewRecorder()
+	c.GetTodos(w, newAuthenticatedRequest("GET", "/todo?due_before=tomorrow&overdue=yes&tz=Mars/Olympus&priority=critical&tag_match=some", "", 2))
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.JSONEq(t, `{"error": {"code": "validation_failed", "message": "validation failed", "details": {
+		"due_before": "must be a time in RFC 3339 format, with its offset, e.g. 2030-01-31T18:00:00+01:00",
+		"overdue": "must be true or false",
+		"tz": "must be a time zone of the IANA database, e.g. Europe/Paris",
+		"priority": "must be among none, low, medium, high, urgent",
+		"tag_match": "must be any or all"}}}`, w.Body.String())
+}
+
+// TestMoveTodoById_ReordersList tests that a moved todo item is listed at its new place,
+// the others keeping their order.
+func TestMoveTodoById_ReordersList(t *testing.T) {
+	/// Arrange
+	///
+	todos := model.NewMemoryTodoStore()
+	c := controller.NewController(todos, model.NewMemoryUserStore())
+
+	items := []*model.TodoItem{{Title: "First"}, {Title: "Second"}, {Title: "Third"}}
+	for _, item := range items {
+		todos.CreateTodoItem(2, item)
+	}
+
+	w := httptest.NewRecorder()
+	r := newAuthenticatedRequest("PUT", "/todo/"+strconv.Itoa(items[2].ID)+"/move", `{"before_id": `+strconv.Itoa(items[0].ID)+`}`, 2)
+	r = mux.SetURLVars(r, map[string]string{"id": strconv.Itoa(items[2].ID)})
+
+	/// Act
+	///
+	c.MoveTodoById(w, r)
+
+	/// Assert
+	///
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	listed := httptest.NewRecorder()
+	c.GetTodos(listed, newAuthenticatedRequest("GET", "/todo", "", 2))
+	var list []model.TodoItem
+	assert.NoError(t, json.Unmarshal(listed.Body.Bytes(), &list))
+	titles := []string{}
+	for _, item := range list {
+		titles = append(titles, item.Title)
+	}
+	assert.Equal(t, []string{"Third", "First", "Second"}, titles)
+}
+
+// TestMoveTodoById_RejectsInvalidMoves tests that MoveTodoById needs exactly one other todo item of the user.
+func TestMoveTodoById_RejectsInvalidMoves(t *testing.T) {
+	todos := model.NewMemoryTodoStore()
+	mine, other, theirs := model.TodoItem{Title: "Mine"}, model.TodoItem{Title: "Other"}, model.TodoItem{Title: "Theirs"}
+	todos.CreateTodoItem(2, &mine)
+	todos.CreateTodoItem(2, &other)
+	todos.CreateTodoItem(3, &theirs)
+
+	cases := []struct {
+		name         string
+		body         string
+		expectedCode int
+		expectedBody string
+	}{
+		{"no anchor", `{}`, http.StatusUnprocessableEntity,
+			`{"error": {"code": "validation_failed", "message": "validation failed", "details": {"after_id": "exactly one of after_id and before_id must be given"}}}`},
+		{"both anchors", `{"after_id": ` + strconv.Itoa(other.ID) + `, "before_id": ` + strconv.Itoa(other.ID) + `}`, http.StatusUnprocessableEntity,
+			`{"error": {"code": "validation_failed", "message": "validation failed", "details": {"after_id": "exactly one of after_id and before_id must be given"}}}`},
+		{"itself", `{"after_id": ` + strconv.Itoa(mine.ID) + `}`, http.StatusUnprocessableEntity,
+			`{"error": {"code": "validation_failed", "message": "validation failed", "details": {"after_id": "must be another todo item"}}}`},
+		{"someone else's item", `{"before_id": ` + strconv.Itoa(theirs.ID) + `}`, http.StatusUnprocessableEntity,
+			`{"error": {"code": "validation_failed", "message": "validation failed", "details": {"before_id": "must be one of your todo items"}}}`},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := controller.NewController(todos, model.NewMemoryUserStore())
+
+			w := httptest.NewRecorder()
+			r := newAuthenticatedRequest("PUT", "/todo/"+strconv.Itoa(mine.ID)+"/move", tc.body, 2)
+			c.MoveTodoById(w, mux.SetURLVars(r, map[string]string{"id": strconv.Itoa(mine.ID)}))
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			assert.JSONEq(t, tc.expectedBody, w.Body.String())
+		})
+	}
+}
+
+// TestSubtasks_TreeChildrenCompleteAndDelete tests that the subtasks are nested on demand, listed under their parent,
+// give its progress, are completed with it on demand and deleted with it.
+func TestSubtasks_TreeChildrenCompleteAndDelete(t *testing.T) {
+	/// Arrange
+	///
+	todos := model.NewMemoryTodoStore()
+	c := controller.NewController(todos, model.NewMemoryUserStore())
+
+	create := func(body string) model.TodoItem {
+		w := httptest.NewRecorder()
+		c.CreateTodo(w, newAuthenticatedRequest("POST", "/todo", body, 2))
+		var item model.TodoItem
+		json.Unmarshal(w.Body.Bytes(), &item)
+		return item
+	}
+	trip := create(`{"title": "Trip"}`)
+	id := strconv.Itoa(trip.ID)
+	tickets := create(`{"title": "Tickets", "parent_id": ` + id + `}`)
+	create(`{"title": "Train", "parent_id": ` + strconv.Itoa(tickets.ID) + `, "completed": true}`)
+	create(`{"title": "Hotel", "parent_id": ` + id + `}`)
+
+	/// Act & Assert
+	///
+	w := httptest.NewRecorder()
+	c.GetTodos(w, newAuthenticatedRequest("GET", "/todo?tree=true", "", 2))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var tree []model.TodoItem
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &tree))
+	if assert.Equal(t, 1, len(tree), "Expected the subtasks nested under their parent") {
+		assert.Equal(t, 2, len(tree[0].Children))
+		assert.Equal(t, 1, len(tree[0].Children[0].Children))
+	}
+
+	w = httptest.NewRecorder()
+	c.GetTodos(w, newAuthenticatedRequest("GET", "/todo?tree=maybe", "", 2))
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.JSONEq(t, `{"error": {"code": "validation_failed", "message": "validation failed", "details": {"tree": "must be true or false"}}}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	r := newAuthenticatedRequest("GET", "/todo/"+id+"/children", "", 2)
+	c.GetTodoChildrenById(w, mux.SetURLVars(r, map[string]string{"id": id}))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var children []model.TodoItem
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &children))
+	if assert.Equal(t, 2, len(children)) {
+		assert.Equal(t, "Tickets", children[0].Title)
+		assert.Equal(t, "Train", children[0].Children[0].Title)
+		assert.Equal(t, "Hotel", children[1].Title)
+	}
+
+	w = httptest.NewRecorder()
+	r = newAuthenticatedRequest("GET", "/todo/"+id+"/children", "", 3)
+	c.GetTodoChildrenById(w, mux.SetURLVars(r, map[string]string{"id": id}))
+	assert.Equal(t, http.StatusNotFound, w.Code, "Expected the subtasks of another user not to be listed")
+
+	w = httptest.NewRecorder()
+	r = newAuthenticatedRequest("PUT", "/todo/"+id+"/complete?subtasks=true", "", 2)
+	c.MarkTodoCompleteById(w, mux.SetURLVars(r, map[string]string{"id": id}))
+	assert.Equal(t, http.StatusOK, w.Code)
+	completed, _ := todos.GetTodoItem(2, trip.ID)
+	assert.True(t, completed.Completed)
+	if assert.NotNil(t, completed.Progress) {
+		assert.Equal(t, 100, *completed.Progress, "Expected the subtasks completed with their parent")
+	}
+
+	w = httptest.NewRecorder()
+	r = newAuthenticatedRequest("DELETE", "/todo/"+id, "", 2)
+	c.DeleteTodoById(w, mux.SetURLVars(r, map[string]string{"id": id}))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	left, _ := todos.GetAllTodoItems(2)
+	assert.Empty(t, left, "Expected the subtasks deleted with their parent")
+}
+
+// TestUpdateTodoById_RejectsInvalidParents tests that a todo item cannot become a subtask of itself,
+// of one of its subtasks or of another user's item, and that null makes it a top-level item.
+func TestUpdateTodoById_RejectsInvalidParents(t *testing.T) {
+	todos := model.NewMemoryTodoStore()
+	parent, theirs := model.TodoItem{Title: "Parent"}, model.TodoItem{Title: "Theirs"}
+	todos.CreateTodoItem(2, &parent)
+	todos.CreateTodoItem(3, &theirs)
+	child := model.TodoItem{Title: "Child", ParentID: &parent.ID}
+	todos.CreateTodoItem(2, &child)
+
+	cases := []struct {
+		name         string
+		body         string
+		expectedCode int
+		expectedBody string
+	}{
+		{"itself", `{"parent_id": ` + strconv.Itoa(parent.ID) + `}`, http.StatusUnprocessableEntity,
+			`{"error": {"code": "validation_failed", "message": "validation failed", "details": {"parent_id": "must be another todo item"}}}`},
+		{"one of its subtasks", `{"parent_id": ` + strconv.Itoa(child.ID) + `}`, http.StatusUnprocessableEntity,
+			`{"error": {"code": "validation_failed", "message": "validation failed", "details": {"parent_id": "must not be one of its subtasks"}}}`},
+		{"someone else's item", `{"parent_id": ` + strconv.Itoa(theirs.ID) + `}`, http.StatusUnprocessableEntity,
+			`{"error": {"code": "validation_failed", "message": "validation failed", "details": {"parent_id": "must be one of your todo items"}}}`},
+		{"not an ID", `{"parent_id": "first"}`, http.StatusUnprocessableEntity,
+			`{"error": {"code": "validation_failed", "message": "validation failed", "details": {"parent_id": "must be an ID or null"}}}`},
+		{"progress", `{"progress": 100}`, http.StatusUnprocessableEntity,
+			`{"error": {"code": "validation_failed", "message": "validation failed", "details": {"progress": "is read-only"}}}`},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := controller.NewController(todos, model.NewMemoryUserStore())
+
+			w := httptest.NewRecorder()
+			r := newAuthenticatedRequest("PATCH", "/todo/"+strconv.Itoa(parent.ID), tc.body, 2)
+			c.UpdateTodoById(w, mux.SetURLVars(r, map[string]string{"id": strconv.Itoa(parent.ID)}))
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			assert.JSONEq(t, tc.expectedBody, w.Body.String())
+		})
+	}
+
+	c := controller.NewController(todos, model.NewMemoryUserStore())
+	w := httptest.NewRecorder()
+	r := newAuthenticatedRequest("PATCH", "/todo/"+strconv.Itoa(child.ID), `{"parent_id": null}`, 2)
+	c.UpdateTodoById(w, mux.SetURLVars(r, map[string]string{"id": strconv.Itoa(child.ID)}))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `null`, string(mustField(t, w, "parent_id")), "Expected null to make the item a top-level one")
+}
@@ -0,0 +1,104 @@
+package controller
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mystardustcaptain/mattodo/pkg/auth"
+	"github.com/mystardustcaptain/mattodo/pkg/model"
+)
+
+// RegisterTokenRoutes registers the routes managing the personal access tokens of the authenticated user.
+// They require an access token of a login, a personal access token cannot mint other ones.
+func (c *Controller) RegisterTokenRoutes(router *mux.Router) {
+	router.Handle("/me/tokens", c.Auth.ValidateTokenMiddleware(http.HandlerFunc(c.GetAccessTokens))).Methods("GET")
+	router.Handle("/me/tokens", c.Auth.ValidateTokenMiddleware(http.HandlerFunc(c.CreateAccessToken))).Methods("POST")
+	router.Handle("/me/tokens/{id}", c.Auth.ValidateTokenMiddleware(http.HandlerFunc(c.RevokeAccessToken))).Methods("DELETE")
+}
+
+// accessTokenRequest is the body of POST /me/tokens
+type accessTokenRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"` // optional, never expires if omitted
+}
+
+// accessTokenResponse is the personal access token created, with the token shown this time only
+type accessTokenResponse struct {
+	*model.PersonalAccessToken
+	Token string `json:"token"`
+}
+
+// GetAccessTokens lists the personal access tokens of the authenticated user, without their value
+func (c *Controller) GetAccessTokens(w http.ResponseWriter, r *http.Request) {
+	iam, ok := r.Context().Value(auth.ContextUserIDKey).(int)
+	if !ok {
+		log.Printf("Failed to read context")
+		respondWithError(w, http.StatusInternalServerError, "Failed to read context")
+		return
+	}
+
+	tokens, err := c.Auth.AccessTokens.Store.GetPersonalAccessTokens(iam)
+	if err != nil {
+		log.Printf("Failed to get personal access tokens: %s", err.Error())
+		respondWithModelError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, tokens)
+}
+
+// CreateAccessToken mints a personal access token for the authenticated user.
+// The token is in the response only, it cannot be retrieved again.
+func (c *Controller) CreateAccessToken(w http.ResponseWriter, r *http.Request) {
+	iam, ok := r.Context().Value(auth.ContextUserIDKey).(int)
+	if !ok {
+		log.Printf("Failed to read context")
+		respondWithError(w, http.StatusInternalServerError, "Failed to read context")
+		return
+	}
+
+	var req accessTokenRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		log.Printf("Invalid request body: %s", err.Error())
+		respondWithDecodeError(w, err)
+		return
+	}
+
+	token, record, err := c.Auth.AccessTokens.Issue(iam, req.Name, req.Scopes, req.ExpiresAt)
+	if err != nil {
+		log.Printf("Failed to create personal access token: %s", err.Error())
+		respondWithModelError(w, err)
+		return
+	}
+
+	log.Printf("Personal access token %d created for user %d", record.ID, iam)
+	respondWithJSON(w, http.StatusCreated, accessTokenResponse{PersonalAccessToken: record, Token: token})
+}
+
+// RevokeAccessToken revokes a personal access token of the authenticated user, it is refused right away
+func (c *Controller) RevokeAccessToken(w http.ResponseWriter, r *http.Request) {
+	iam, ok := r.Context().Value(auth.ContextUserIDKey).(int)
+	if !ok {
+		log.Printf("Failed to read context")
+		respondWithError(w, http.StatusInternalServerError, "Failed to read context")
+		return
+	}
+
+	tokenID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid token ID")
+		return
+	}
+
+	if err := c.Auth.AccessTokens.Store.DeletePersonalAccessToken(iam, tokenID); err != nil {
+		log.Printf("Failed to revoke personal access token: %s", err.Error())
+		respondWithModelError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
@@ -0,0 +1,80 @@
+package controller_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/mystardustcaptain/mattodo/pkg/controller"
+	"github.com/mystardustcaptain/mattodo/pkg/model"
+	"github.com/mystardustcaptain/mattodo/pkg/route"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestAccessTokens_CreateListAndRevoke tests that a personal access token created through /me/tokens
+// is shown once, can be used on the todo routes of its scopes and is refused once revoked.
+func TestAccessTokens_CreateListAndRevoke(t *testing.T) {
+	/// Arrange
+	///
+	c, alice := newControllerWithAlice()
+	router := route.InitializeRoutes(c)
+	session, _ := c.Auth.CreateToken(alice.Email, alice.ID, 1)
+
+	serve := func(method string, target string, body string, token string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+token)
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	/// Act
+	///
+	created := serve("POST", "/me/tokens", `{"name": "CI", "scopes": ["todo:read"]}`, session)
+	var token struct {
+		ID    int    `json:"id"`
+		Token string `json:"token"`
+	}
+	json.Unmarshal(created.Body.Bytes(), &token)
+
+	listed := serve("GET", "/me/tokens", "", session)
+	read := serve("GET", "/todo", "", token.Token)
+	write := serve("POST", "/todo", `{"title": "From CI"}`, token.Token)
+	mint := serve("POST", "/me/tokens", `{"name": "Other", "scopes": ["todo:write"]}`, token.Token)
+	revoked := serve("DELETE", "/me/tokens/"+strconv.Itoa(token.ID), "", session)
+	readAfter := serve("GET", "/todo", "", token.Token)
+
+	/// Assert
+	///
+	assert.Equal(t, http.StatusCreated, created.Code)
+	assert.NotEmpty(t, token.Token)
+
+	assert.Equal(t, http.StatusOK, listed.Code)
+	assert.NotContains(t, listed.Body.String(), token.Token, "Expected the token not to be listed")
+	assert.Contains(t, listed.Body.String(), `"scopes":["todo:read"]`)
+
+	assert.Equal(t, http.StatusOK, read.Code)
+	assert.Equal(t, http.StatusForbidden, write.Code, "Expected the todo:write scope to be required")
+	assert.Equal(t, http.StatusForbidden, mint.Code, "Expected a personal access token not to mint others")
+	assert.Equal(t, http.StatusNoContent, revoked.Code)
+	assert.Equal(t, http.StatusUnauthorized, readAfter.Code)
+}
+
+// TestCreateAccessToken_RejectsInvalidScopes tests that CreateAccessToken refuses unknown scopes.
+func TestCreateAccessToken_RejectsInvalidScopes(t *testing.T) {
+	/// Arrange
+	///
+	c := controller.NewController(model.NewMemoryTodoStore(), model.NewMemoryUserStore())
+
+	/// Act
+	///
+	w := httptest.NewRecorder()
+	c.CreateAccessToken(w, newAuthenticatedRequest("POST", "/me/tokens", `{"name": "CI", "scopes": ["admin"]}`, 2))
+
+	/// Assert
+	///
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+}
@@ -0,0 +1,73 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/mystardustcaptain/mattodo/pkg/model"
+)
+
+// errorEnvelope is the JSON body of every error response
+// {"error": {"code": "not_found", "message": "...", "details": {...}}}
+type errorEnvelope struct {
+	Error errorBody `json:"error"`
+}
+
+// errorBody describes an error.
+// Code is stable and meant for programs, Message is meant for humans.
+type errorBody struct {
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// errorCodes maps HTTP status codes to the stable error codes of the envelope
+var errorCodes = map[int]string{
+	http.StatusBadRequest:            "bad_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusConflict:              "conflict",
+	http.StatusRequestEntityTooLarge: "payload_too_large",
+	http.StatusUnprocessableEntity:   "validation_failed",
+	http.StatusInternalServerError:   "internal",
+}
+
+// respondWithError is a helper function to respond with an error and a status code
+func respondWithError(w http.ResponseWriter, code int, message string) {
+	respondWithErrorDetails(w, code, message, nil)
+}
+
+// respondWithErrorDetails responds with an error, a status code and details about the error, e.g. invalid fields
+func respondWithErrorDetails(w http.ResponseWriter, code int, message string, details interface{}) {
+	errorCode, ok := errorCodes[code]
+	if !ok {
+		errorCode = "error"
+	}
+
+	respondWithJSON(w, code, errorEnvelope{Error: errorBody{Code: errorCode, Message: message, Details: details}})
+}
+
+// respondWithModelError maps an error returned by the stores to a status code and responds with it.
+// Domain errors are described to the user,
+// any other error is logged and reported as an internal error to not leak database details.
+func respondWithModelError(w http.ResponseWriter, err error) {
+	var validationErr *model.ValidationError
+
+	switch {
+	case errors.As(err, &validationErr):
+		respondWithErrorDetails(w, http.StatusUnprocessableEntity, model.ErrValidation.Error(), validationErr.Fields)
+	case errors.Is(err, model.ErrValidation):
+		respondWithError(w, http.StatusUnprocessableEntity, err.Error())
+	case errors.Is(err, model.ErrNotFound):
+		respondWithError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, model.ErrConflict):
+		respondWithError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, model.ErrForbidden):
+		respondWithError(w, http.StatusForbidden, err.Error())
+	default:
+		log.Printf("Internal error: %s", err.Error())
+		respondWithError(w, http.StatusInternalServerError, "Internal server error")
+	}
+}
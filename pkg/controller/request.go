@@ -0,0 +1,301 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/mystardustcaptain/mattodo/pkg/model"
+)
+
+// maxRequestBodyBytes caps the size of the JSON bodies accepted by the API
+const maxRequestBodyBytes = 64 << 10
+
+// errMalformedBody is returned by decodeJSON when the body is not a single JSON object
+var errMalformedBody = errors.New("malformed JSON body")
+
+// errBodyTooLarge is returned by decodeJSON when the body exceeds maxRequestBodyBytes
+var errBodyTooLarge = errors.New("request body too large")
+
+// readOnlyFieldsError returns a ValidationError listing the fields present in a payload, if any,
+// given the raw values of the fields the client cannot set, nil when absent.
+func readOnlyFieldsError(fields map[string]json.RawMessage) *model.ValidationError {
+	verr := &model.ValidationError{}
+	for field, value := range fields {
+		if value != nil {
+			verr.Add(field, "is read-only")
+		}
+	}
+
+	if len(verr.Fields) == 0 {
+		return nil
+	}
+	return verr
+}
+
+// serverFields are the TodoItem fields set by the server.
+// They are decoded only to reject payloads trying to set them, rather than silently ignoring them.
+type serverFields struct {
+	ID          json.RawMessage `json:"id"`
+	UserID      json.RawMessage `json:"user_id"`
+	CompletedAt json.RawMessage `json:"completed_at"`
+	Position    json.RawMessage `json:"position"`
+	Progress    json.RawMessage `json:"progress"`
+	Children    json.RawMessage `json:"children"`
+	Tags        json.RawMessage `json:"tags"`
+	CreatedAt   json.RawMessage `json:"created_at"`
+	UpdatedAt   json.RawMessage `json:"updated_at"`
+}
+
+// readOnlyError refuses the TodoItem fields set by the server, see readOnlyFieldsError
+func (f *serverFields) readOnlyError() *model.ValidationError {
+	return readOnlyFieldsError(map[string]json.RawMessage{
+		"id":           f.ID,
+		"user_id":      f.UserID,
+		"completed_at": f.CompletedAt,
+		"position":     f.Position,
+		"progress":     f.Progress,
+		"children":     f.Children,
+		"tags":         f.Tags,
+		"created_at":   f.CreatedAt,
+		"updated_at":   f.UpdatedAt,
+	})
+}
+
+// todoCreatePayload is the body accepted to create a todo item
+type todoCreatePayload struct {
+	Title     string          `json:"title"`
+	Completed bool            `json:"completed"`
+	Priority  string          `json:"priority"`
+	DueAt     json.RawMessage `json:"due_at"`
+	ProjectID *int            `json:"project_id"`
+	ParentID  *int            `json:"parent_id"`
+	serverFields
+}
+
+// todoUpdatePayload is the body accepted to partially update a todo item,
+// due_at set to null removes the due date, project_id set to null takes the item out of its project,
+// parent_id set to null makes it a top-level item
+type todoUpdatePayload struct {
+	model.TodoItemUpdate
+	DueAt     json.RawMessage `json:"due_at"`
+	ProjectID json.RawMessage `json:"project_id"`
+	ParentID  json.RawMessage `json:"parent_id"`
+	serverFields
+}
+
+// dueAtFormat is shown to the clients sending a due date in another format
+const dueAtFormat = "must be a time in RFC 3339 format, with its offset, e.g. 2030-01-31T18:00:00+01:00"
+
+// parseDueAt decodes the due_at field of a payload.
+// set reports whether the field is present, dueAt is nil if it is null.
+func parseDueAt(raw json.RawMessage) (dueAt *time.Time, set bool, err error) {
+	if raw == nil {
+		return nil, false, nil
+	}
+	if string(raw) == "null" {
+		return nil, true, nil
+	}
+
+	var t time.Time
+	if err := json.Unmarshal(raw, &t); err != nil {
+		return nil, true, model.NewValidationError("due_at", dueAtFormat)
+	}
+
+	return &t, true, nil
+}
+
+// parseNullableID decodes a field of a payload referencing another record by its ID, e.g. project_id.
+// set reports whether the field is present, id is nil if it is null.
+func parseNullableID(raw json.RawMessage, field string) (id *int, set bool, err error) {
+	if raw == nil {
+		return nil, false, nil
+	}
+	if string(raw) == "null" {
+		return nil, true, nil
+	}
+
+	var value int
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, true, model.NewValidationError(field, "must be an ID or null")
+	}
+
+	return &value, true, nil
+}
+
+// tagServerFields are the Tag fields set by the server.
+// They are decoded only to reject payloads trying to set them.
+type tagServerFields struct {
+	ID        json.RawMessage `json:"id"`
+	UserID    json.RawMessage `json:"user_id"`
+	CreatedAt json.RawMessage `json:"created_at"`
+}
+
+// readOnlyError refuses the Tag fields set by the server, see readOnlyFieldsError
+func (f *tagServerFields) readOnlyError() *model.ValidationError {
+	return readOnlyFieldsError(map[string]json.RawMessage{
+		"id":         f.ID,
+		"user_id":    f.UserID,
+		"created_at": f.CreatedAt,
+	})
+}
+
+// tagCreatePayload is the body accepted to create a tag
+type tagCreatePayload struct {
+	Name  string `json:"name"`
+	Color string `json:"color"`
+	tagServerFields
+}
+
+// tagUpdatePayload is the body accepted to rename or color a tag
+type tagUpdatePayload struct {
+	model.TagUpdate
+	tagServerFields
+}
+
+// projectServerFields are the Project fields set by the server.
+// They are decoded only to reject payloads trying to set them.
+type projectServerFields struct {
+	ID        json.RawMessage `json:"id"`
+	UserID    json.RawMessage `json:"user_id"`
+	Inbox     json.RawMessage `json:"inbox"`
+	CreatedAt json.RawMessage `json:"created_at"`
+	UpdatedAt json.RawMessage `json:"updated_at"`
+}
+
+// readOnlyError refuses the Project fields set by the server, see readOnlyFieldsError
+func (f *projectServerFields) readOnlyError() *model.ValidationError {
+	return readOnlyFieldsError(map[string]json.RawMessage{
+		"id":         f.ID,
+		"user_id":    f.UserID,
+		"inbox":      f.Inbox,
+		"created_at": f.CreatedAt,
+		"updated_at": f.UpdatedAt,
+	})
+}
+
+// projectCreatePayload is the body accepted to create a project
+type projectCreatePayload struct {
+	Name string `json:"name"`
+	projectServerFields
+}
+
+// projectUpdatePayload is the body accepted to rename a project
+type projectUpdatePayload struct {
+	model.ProjectUpdate
+	projectServerFields
+}
+
+// userReadOnlyFields are the User fields the user cannot change on their profile.
+// They are decoded only to reject payloads trying to set them.
+type userReadOnlyFields struct {
+	ID                  json.RawMessage `json:"id"`
+	OAuthProvider       json.RawMessage `json:"oauth_provider"`
+	OAuthID             json.RawMessage `json:"oauth_id"`
+	Email               json.RawMessage `json:"email"`
+	DeletionScheduledAt json.RawMessage `json:"deletion_scheduled_at"`
+	Role                json.RawMessage `json:"role"`
+	SuspendedAt         json.RawMessage `json:"suspended_at"`
+}
+
+// readOnlyError refuses the profile fields the user cannot change, see readOnlyFieldsError
+func (f *userReadOnlyFields) readOnlyError() *model.ValidationError {
+	return readOnlyFieldsError(map[string]json.RawMessage{
+		"id":                    f.ID,
+		"oauth_provider":        f.OAuthProvider,
+		"oauth_id":              f.OAuthID,
+		"email":                 f.Email,
+		"deletion_scheduled_at": f.DeletionScheduledAt,
+		"role":                  f.Role,
+		"suspended_at":          f.SuspendedAt,
+	})
+}
+
+// userUpdatePayload is the body accepted to update the profile of the user
+type userUpdatePayload struct {
+	model.UserUpdate
+	userReadOnlyFields
+}
+
+// decodeJSON decodes the request body into dst.
+// The body must be a single JSON object of at most maxRequestBodyBytes,
+// unknown fields and values of the wrong type are reported as a ValidationError.
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst interface{}) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodyBytes)
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err == nil && dec.More() {
+		// Trailing data after the object
+		err = errMalformedBody
+	}
+	if err == nil {
+		return nil
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case errors.As(err, &maxBytesErr):
+		return errBodyTooLarge
+	case errors.As(err, &typeErr) && typeErr.Field == "":
+		// The body itself is not an object, e.g. [] or "x"
+		return errMalformedBody
+	case errors.As(err, &typeErr):
+		return model.NewValidationError(typeErr.Field, "must be "+jsonTypeName(typeErr.Type))
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return model.NewValidationError(field, "is not a known field")
+	case errors.Is(err, io.EOF):
+		return errMalformedBody
+	default:
+		log.Printf("Failed to decode request body: %s", err.Error())
+		return errMalformedBody
+	}
+}
+
+// jsonTypeName names the JSON type a value of the Go type t is decoded from, e.g. "a string" for *string,
+// so that the clients are not shown the Go types.
+func jsonTypeName(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return "a string"
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	default:
+		return "an object"
+	}
+}
+
+// respondWithDecodeError responds with the error returned by decodeJSON.
+func respondWithDecodeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errBodyTooLarge):
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Request body too large")
+	case errors.Is(err, errMalformedBody):
+		respondWithError(w, http.StatusBadRequest, "Malformed JSON body")
+	default:
+		respondWithModelError(w, err)
+	}
+}
@@ -3,19 +3,75 @@ package database
 import (
 	"database/sql"
 	"log"
+	"strings"
 
 	_ "modernc.org/sqlite"
 )
 
 // InitDB initializes the database
+// and brings its schema up to date by applying the pending migrations.
 // dbType: sqlite, mysql, postgres
-// dbPath: path to the database file
+// dbPath: path to the database file, or the connection string (DSN) for mysql and postgres
 func InitDB(dbType string, dbPath string) *sql.DB {
+	dialect, err := DialectFor(dbType)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
 	// Initialize database
-	db, err := sql.Open(dbType, dbPath)
+	db, err := OpenDB(dbType, dbPath)
 	if err != nil {
 		log.Fatal(err.Error())
 	}
 
+	// Apply the pending schema migrations
+	migrator, err := NewMigrator(db, dialect)
+	if err != nil {
+		log.Fatalf("Failed to load migrations: %s", err.Error())
+	}
+
+	if _, err := migrator.Up(); err != nil {
+		log.Fatalf("Failed to migrate database: %s", err.Error())
+	}
+
 	return db
 }
+
+// OpenDB opens the database without touching its schema.
+// dbType: sqlite, mysql, postgres
+// dbPath: path to the database file, or the connection string (DSN) for mysql and postgres
+func OpenDB(dbType string, dbPath string) (*sql.DB, error) {
+	if dbType == string(SQLite) {
+		dbPath = sqliteDSN(dbPath)
+	}
+
+	db, err := sql.Open(dbType, dbPath)
+	if err != nil {
+		log.Printf("Failed to open database: %s", err.Error())
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// sqlitePragmas are set on every SQLite connection: a writer waits up to 5s for the lock of another one
+// rather than failing at once with SQLITE_BUSY, and the foreign keys are enforced as on the other databases.
+var sqlitePragmas = []string{"busy_timeout(5000)", "foreign_keys(1)"}
+
+// sqliteDSN adds the sqlitePragmas to the DSN of a SQLite database, except those it sets already.
+func sqliteDSN(dsn string) string {
+	for _, pragma := range sqlitePragmas {
+		name := pragma[:strings.Index(pragma, "(")]
+		if strings.Contains(dsn, "_pragma="+name) {
+			continue
+		}
+
+		separator := "?"
+		if strings.Contains(dsn, "?") {
+			separator = "&"
+		}
+		dsn += separator + "_pragma=" + pragma
+	}
+
+	return dsn
+}
@@ -0,0 +1,48 @@
+package database_test
+
+import (
+	"testing"
+
+	"github.com/mystardustcaptain/mattodo/pkg/database"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestOpenDB_SQLiteWaitsForLocksAndEnforcesForeignKeys tests that OpenDB sets the busy timeout
+// and turns the foreign keys on for SQLite, unless the DSN sets them already.
+func TestOpenDB_SQLiteWaitsForLocksAndEnforcesForeignKeys(t *testing.T) {
+	tests := []struct {
+		name        string
+		dsn         string
+		busyTimeout int
+		foreignKeys int
+	}{
+		{"path", ":memory:", 5000, 1},
+		{"with a query", "file::memory:?cache=private", 5000, 1},
+		{"set in the DSN", "file::memory:?_pragma=foreign_keys(0)&_pragma=busy_timeout(100)", 100, 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			/// Arrange
+			///
+			db, err := database.OpenDB("sqlite", tc.dsn)
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening the database", err)
+			}
+			defer db.Close()
+
+			/// Act
+			///
+			var busyTimeout, foreignKeys int
+			errBusy := db.QueryRow("PRAGMA busy_timeout").Scan(&busyTimeout)
+			errForeign := db.QueryRow("PRAGMA foreign_keys").Scan(&foreignKeys)
+
+			/// Assert
+			///
+			assert.NoError(t, errBusy, "Expected no error but got one")
+			assert.NoError(t, errForeign, "Expected no error but got one")
+			assert.Equal(t, tc.busyTimeout, busyTimeout)
+			assert.Equal(t, tc.foreignKeys, foreignKeys)
+		})
+	}
+}
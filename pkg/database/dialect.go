@@ -0,0 +1,131 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// Dialect identifies the SQL flavour of a database, named after its driver.
+// Queries are written with ? placeholders and adapted to the dialect with Rebind.
+// The zero value behaves like SQLite.
+type Dialect string
+
+const (
+	SQLite   Dialect = "sqlite"
+	Postgres Dialect = "postgres"
+	MySQL    Dialect = "mysql"
+)
+
+// DialectFor returns the Dialect for a DB_TYPE value.
+// Returns error if the database type is not supported.
+func DialectFor(dbType string) (Dialect, error) {
+	switch d := Dialect(dbType); d {
+	case SQLite, Postgres, MySQL:
+		return d, nil
+	default:
+		return "", fmt.Errorf("unsupported database type: %q", dbType)
+	}
+}
+
+// Querier is implemented by both *sql.DB and *sql.Tx.
+type Querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// ForUpdate returns the clause to append to a SELECT to lock the rows it reads until the end of the transaction.
+// SQLite has none, it has a single writer and refuses to commit a write over data changed since the transaction read it.
+func (d Dialect) ForUpdate() string {
+	if d == Postgres || d == MySQL {
+		return " FOR UPDATE"
+	}
+
+	return ""
+}
+
+// IsUniqueViolation reports whether err is the database refusing a row which breaks a unique index,
+// e.g. when two requests insert the same name at once, past the checks made beforehand.
+func IsUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505" // unique_violation
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == 1062 // ER_DUP_ENTRY
+	}
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+	}
+
+	return false
+}
+
+// Rebind rewrites the ? placeholders of query into the dialect's own placeholders.
+// Postgres uses $1, $2, ... while SQLite and MySQL keep ?.
+// Question marks inside quoted literals are left alone.
+func (d Dialect) Rebind(query string) string {
+	if d != Postgres {
+		return query
+	}
+
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+
+	n := 0
+	var quote rune
+	for _, c := range query {
+		switch {
+		case quote != 0:
+			// inside a literal, wait for it to close
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '?':
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+
+	return b.String()
+}
+
+// InsertReturningID runs an INSERT query written with ? placeholders and returns the id of the new row.
+// Postgres does not support LastInsertId, so the query gets a RETURNING id clause instead.
+func (d Dialect) InsertReturningID(q Querier, query string, args ...interface{}) (int64, error) {
+	if d == Postgres {
+		var id int64
+		if err := q.QueryRow(d.Rebind(query)+" RETURNING id", args...).Scan(&id); err != nil {
+			return 0, err
+		}
+		return id, nil
+	}
+
+	result, err := q.Exec(d.Rebind(query), args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+// migrationDir returns the directory of the embedded migrations written for the dialect.
+func (d Dialect) migrationDir() string {
+	if d == "" {
+		return "migrations/" + string(SQLite)
+	}
+	return "migrations/" + string(d)
+}
@@ -0,0 +1,114 @@
+package database_test
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/mystardustcaptain/mattodo/pkg/database"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRebind_RewritesPlaceholdersForPostgres tests that Rebind numbers the placeholders for Postgres,
+// leaves question marks inside literals alone,
+// and keeps the query as is for the other dialects.
+func TestRebind_RewritesPlaceholdersForPostgres(t *testing.T) {
+	query := "SELECT id FROM todos WHERE title = '?' AND id = ? AND user_id = ?"
+
+	assert.Equal(t, "SELECT id FROM todos WHERE title = '?' AND id = $1 AND user_id = $2", database.Postgres.Rebind(query))
+	assert.Equal(t, query, database.SQLite.Rebind(query))
+	assert.Equal(t, query, database.MySQL.Rebind(query))
+	assert.Equal(t, query, database.Dialect("").Rebind(query), "Expected the zero value to behave like SQLite")
+}
+
+// TestForUpdate_LocksRowsOnPostgresAndMySQL tests that ForUpdate locks the rows read on Postgres and MySQL,
+// and adds nothing for SQLite, which has no such clause.
+func TestForUpdate_LocksRowsOnPostgresAndMySQL(t *testing.T) {
+	assert.Equal(t, " FOR UPDATE", database.Postgres.ForUpdate())
+	assert.Equal(t, " FOR UPDATE", database.MySQL.ForUpdate())
+	assert.Equal(t, "", database.SQLite.ForUpdate())
+}
+
+// TestInsertReturningID_UsesReturningForPostgres tests that InsertReturningID
+// reads the new id from a RETURNING clause on Postgres.
+func TestInsertReturningID_UsesReturningForPostgres(t *testing.T) {
+	/// Arrange
+	///
+	db, mock, errdb := sqlmock.New()
+	if errdb != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", errdb)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("INSERT INTO users \\(name\\) VALUES \\(\\$1\\) RETURNING id").
+		WithArgs("Alice").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+
+	/// Act
+	///
+	id, err := database.Postgres.InsertReturningID(db, "INSERT INTO users (name) VALUES (?)", "Alice")
+
+	/// Assert
+	///
+	assert.NoError(t, err, "Expected no error but got one")
+	assert.Equal(t, int64(7), id)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestInsertReturningID_UsesLastInsertIdForSQLite tests that InsertReturningID
+// reads the new id from LastInsertId on SQLite and MySQL.
+func TestInsertReturningID_UsesLastInsertIdForSQLite(t *testing.T) {
+	/// Arrange
+	///
+	db, mock, errdb := sqlmock.New()
+	if errdb != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", errdb)
+	}
+	defer db.Close()
+
+	mock.ExpectExec("INSERT INTO users \\(name\\) VALUES \\(\\?\\)").
+		WithArgs("Alice").
+		WillReturnResult(sqlmock.NewResult(7, 1))
+
+	/// Act
+	///
+	id, err := database.SQLite.InsertReturningID(db, "INSERT INTO users (name) VALUES (?)", "Alice")
+
+	/// Assert
+	///
+	assert.NoError(t, err, "Expected no error but got one")
+	assert.Equal(t, int64(7), id)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestIsUniqueViolation_DetectsDuplicateRows tests that a row breaking a unique index is reported as such,
+// and other errors are not.
+func TestIsUniqueViolation_DetectsDuplicateRows(t *testing.T) {
+	/// Arrange
+	///
+	db, err := database.OpenDB("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening the database", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	db.Exec("CREATE TABLE tags (user_id INTEGER NOT NULL, name TEXT NOT NULL)")
+	db.Exec("CREATE UNIQUE INDEX idx_tags_user_name ON tags(user_id, LOWER(name))")
+	db.Exec("INSERT INTO tags (user_id, name) VALUES (1, 'Work')")
+
+	/// Act
+	///
+	_, errDuplicate := db.Exec("INSERT INTO tags (user_id, name) VALUES (1, 'work')")
+	_, errOther := db.Exec("INSERT INTO tags (user_id, name) VALUES (1, NULL)")
+
+	/// Assert
+	///
+	assert.True(t, database.IsUniqueViolation(errDuplicate), "Expected a unique violation but got %v", errDuplicate)
+	assert.False(t, database.IsUniqueViolation(errOther), "Expected a NOT NULL violation not to be a unique violation")
+	assert.False(t, database.IsUniqueViolation(nil))
+}
@@ -0,0 +1,353 @@
+package database
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrationFiles holds the schema migrations shipped with the binary, one directory per dialect.
+// File names follow the pattern {version}_{name}.up.sql / {version}_{name}.down.sql
+//
+//go:embed migrations/*/*.sql
+var migrationFiles embed.FS
+
+// migrationFileRegex matches a migration file name, e.g. 0001_init.up.sql
+var migrationFileRegex = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// ErrChecksumMismatch is returned when an applied migration differs from the one shipped.
+var ErrChecksumMismatch = errors.New("migration checksum mismatch")
+
+// Migration is a single versioned schema change.
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string // sha256 of the Up script
+}
+
+// MigrationStatus tells whether a Migration has been applied to the database.
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// appliedMigration is a row of the schema_migrations bookkeeping table.
+type appliedMigration struct {
+	Version   int
+	Name      string
+	Checksum  string
+	AppliedAt time.Time
+}
+
+// Migrator applies migrations in version order and records them in the schema_migrations table.
+// With DryRun set, the statements are only logged and the database is left untouched.
+type Migrator struct {
+	DB         *sql.DB
+	Dialect    Dialect
+	Migrations []Migration
+	DryRun     bool
+}
+
+// NewMigrator returns a Migrator loaded with the embedded migrations written for the dialect.
+func NewMigrator(db *sql.DB, dialect Dialect) (*Migrator, error) {
+	sub, err := fs.Sub(migrationFiles, dialect.migrationDir())
+	if err != nil {
+		return nil, err
+	}
+
+	migrations, err := LoadMigrations(sub)
+	if err != nil {
+		return nil, err
+	}
+	if len(migrations) == 0 {
+		return nil, fmt.Errorf("no migrations found for dialect %q", dialect)
+	}
+
+	return &Migrator{DB: db, Dialect: dialect, Migrations: migrations}, nil
+}
+
+// LoadMigrations reads the *.up.sql and *.down.sql files at the root of fsys.
+// Returns the migrations sorted by version,
+// or error if a version has conflicting names or no up step.
+func LoadMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		match := migrationFileRegex.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, _ := strconv.Atoi(match[1])
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has conflicting names %q and %q", version, m.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			m.Up = string(content)
+			sum := sha256.Sum256(content)
+			m.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up step", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// Up applies every migration that has not been applied yet, in version order.
+// Each migration runs in its own transaction together with its bookkeeping row.
+// Returns the migrations applied (or that would be applied in dry-run mode).
+func (m *Migrator) Up() ([]Migration, error) {
+	applied, err := m.prepare()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, migration := range m.Migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+
+		log.Printf("Applying migration %d_%s", migration.Version, migration.Name)
+		err := m.run(migration.Up, func(tx *sql.Tx) error {
+			_, err := tx.Exec(m.Dialect.Rebind("INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)"),
+				migration.Version, migration.Name, migration.Checksum, time.Now())
+			return err
+		})
+		if err != nil {
+			log.Printf("Failed to apply migration %d_%s: %s", migration.Version, migration.Name, err.Error())
+			return done, err
+		}
+
+		done = append(done, migration)
+	}
+
+	return done, nil
+}
+
+// Down rolls back the last steps applied migrations, newest first.
+// Returns the migrations rolled back (or that would be rolled back in dry-run mode).
+func (m *Migrator) Down(steps int) ([]Migration, error) {
+	applied, err := m.prepare()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for i := len(m.Migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		migration := m.Migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+
+		if migration.Down == "" {
+			return done, fmt.Errorf("migration %d_%s has no down step", migration.Version, migration.Name)
+		}
+
+		log.Printf("Rolling back migration %d_%s", migration.Version, migration.Name)
+		err := m.run(migration.Down, func(tx *sql.Tx) error {
+			_, err := tx.Exec(m.Dialect.Rebind("DELETE FROM schema_migrations WHERE version = ?"), migration.Version)
+			return err
+		})
+		if err != nil {
+			log.Printf("Failed to roll back migration %d_%s: %s", migration.Version, migration.Name, err.Error())
+			return done, err
+		}
+
+		done = append(done, migration)
+	}
+
+	return done, nil
+}
+
+// Status lists every known migration and whether it has been applied.
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	applied, err := m.prepare()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(m.Migrations))
+	for _, migration := range m.Migrations {
+		a, ok := applied[migration.Version]
+		statuses = append(statuses, MigrationStatus{Migration: migration, Applied: ok, AppliedAt: a.AppliedAt})
+	}
+
+	return statuses, nil
+}
+
+// prepare makes sure the bookkeeping table exists,
+// then reads the applied migrations and verifies them against the known ones.
+func (m *Migrator) prepare() (map[int]appliedMigration, error) {
+	if !m.DryRun {
+		query := "CREATE TABLE IF NOT EXISTS schema_migrations (" +
+			"version INTEGER NOT NULL PRIMARY KEY, " +
+			"name TEXT NOT NULL, " +
+			"checksum TEXT NOT NULL, " +
+			"applied_at TIMESTAMP NOT NULL)"
+		if _, err := m.DB.Exec(query); err != nil {
+			log.Printf("Failed to create schema_migrations table: %s", err.Error())
+			return nil, err
+		}
+	}
+
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.verify(applied); err != nil {
+		return nil, err
+	}
+
+	return applied, nil
+}
+
+// applied reads the schema_migrations table.
+// In dry-run mode a missing table is treated as a database with no migration applied.
+func (m *Migrator) applied() (map[int]appliedMigration, error) {
+	applied := map[int]appliedMigration{}
+
+	rows, err := m.DB.Query("SELECT version, name, checksum, applied_at FROM schema_migrations")
+	if err != nil {
+		if m.DryRun {
+			log.Printf("Dry run: schema_migrations not readable, assuming empty database: %s", err.Error())
+			return applied, nil
+		}
+		log.Printf("Failed to read schema_migrations: %s", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a appliedMigration
+		if err := rows.Scan(&a.Version, &a.Name, &a.Checksum, &a.AppliedAt); err != nil {
+			log.Printf("Failed to scan row: %s", err.Error())
+			return nil, err
+		}
+		applied[a.Version] = a
+	}
+
+	return applied, rows.Err()
+}
+
+// verify checks that every applied migration is still known
+// and that its up step has not been modified since it was applied.
+func (m *Migrator) verify(applied map[int]appliedMigration) error {
+	known := map[int]Migration{}
+	for _, migration := range m.Migrations {
+		known[migration.Version] = migration
+	}
+
+	for version, a := range applied {
+		migration, ok := known[version]
+		if !ok {
+			return fmt.Errorf("migration %d_%s is applied but unknown to this build", version, a.Name)
+		}
+		if migration.Checksum != a.Checksum {
+			return fmt.Errorf("%w: migration %d_%s was modified after being applied", ErrChecksumMismatch, version, migration.Name)
+		}
+	}
+
+	return nil
+}
+
+// run executes the statements of script followed by record in a single transaction.
+// In dry-run mode the statements are only logged.
+// Note MySQL commits DDL statements implicitly, so a failing MySQL migration may be partially applied.
+func (m *Migrator) run(script string, record func(tx *sql.Tx) error) error {
+	statements := splitStatements(script)
+
+	if m.DryRun {
+		for _, statement := range statements {
+			log.Printf("Dry run: %s", statement)
+		}
+		return nil
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if err := record(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// splitStatements splits a script into statements on semicolons ending a line,
+// dropping comment lines and empty statements.
+// Not all drivers accept several statements in a single Exec.
+func splitStatements(script string) []string {
+	var statements []string
+	var current strings.Builder
+
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+
+		current.WriteString(line)
+		current.WriteString("\n")
+
+		if strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, strings.TrimSuffix(strings.TrimSpace(current.String()), ";"))
+			current.Reset()
+		}
+	}
+
+	if rest := strings.TrimSpace(current.String()); rest != "" {
+		statements = append(statements, rest)
+	}
+
+	return statements
+}
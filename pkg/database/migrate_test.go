@@ -0,0 +1,158 @@
+package database_test
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/mystardustcaptain/mattodo/pkg/database"
+	"github.com/stretchr/testify/assert"
+)
+
+// openTestDB opens a fresh in-memory sqlite database.
+// The pool is limited to one connection as every connection to :memory: is a separate database.
+func openTestDB(t *testing.T) *sql.DB {
+	db, err := database.OpenDB("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening an in-memory database", err)
+	}
+	db.SetMaxOpenConns(1)
+
+	return db
+}
+
+// tableExists reports whether a table of the given name exists in the sqlite database.
+func tableExists(t *testing.T, db *sql.DB, name string) bool {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", name).Scan(&count)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when reading sqlite_master", err)
+	}
+
+	return count == 1
+}
+
+// TestMigratorUp_AppliesEmbeddedMigrationsOnce tests that Up creates the application tables,
+// records the applied migrations,
+// and does nothing when run again.
+func TestMigratorUp_AppliesEmbeddedMigrationsOnce(t *testing.T) {
+	/// Arrange
+	///
+	db := openTestDB(t)
+	defer db.Close()
+
+	migrator, err := database.NewMigrator(db, database.SQLite)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when loading migrations", err)
+	}
+
+	/// Act
+	///
+	applied, err := migrator.Up()
+	appliedAgain, errAgain := migrator.Up()
+
+	/// Assert
+	///
+	assert.NoError(t, err, "Expected no error but got one")
+	assert.Equal(t, len(migrator.Migrations), len(applied), "Expected every migration to be applied")
+	assert.NoError(t, errAgain, "Expected no error but got one")
+	assert.Empty(t, appliedAgain, "Expected no migration to be applied twice")
+	assert.True(t, tableExists(t, db, "users"))
+	assert.True(t, tableExists(t, db, "todos"))
+	assert.True(t, tableExists(t, db, "schema_migrations"))
+}
+
+// TestMigratorDown_RollsBackLastMigration tests that Down runs the down step
+// and removes the bookkeeping row so the migration is pending again.
+func TestMigratorDown_RollsBackLastMigration(t *testing.T) {
+	/// Arrange
+	///
+	db := openTestDB(t)
+	defer db.Close()
+
+	migrations, _ := database.LoadMigrations(fstest.MapFS{
+		"0001_create_a.up.sql":   {Data: []byte("CREATE TABLE a (id INTEGER);")},
+		"0001_create_a.down.sql": {Data: []byte("DROP TABLE a;")},
+		"0002_create_b.up.sql":   {Data: []byte("CREATE TABLE b (id INTEGER);")},
+		"0002_create_b.down.sql": {Data: []byte("DROP TABLE b;")},
+	})
+	migrator := &database.Migrator{DB: db, Migrations: migrations}
+	if _, err := migrator.Up(); err != nil {
+		t.Fatalf("an error '%s' was not expected when applying migrations", err)
+	}
+
+	/// Act
+	///
+	rolledBack, err := migrator.Down(1)
+
+	/// Assert
+	///
+	assert.NoError(t, err, "Expected no error but got one")
+	assert.Equal(t, 1, len(rolledBack))
+	assert.Equal(t, 2, rolledBack[0].Version, "Expected the newest migration to be rolled back")
+	assert.True(t, tableExists(t, db, "a"))
+	assert.False(t, tableExists(t, db, "b"))
+
+	statuses, _ := migrator.Status()
+	assert.True(t, statuses[0].Applied)
+	assert.False(t, statuses[1].Applied)
+}
+
+// TestMigratorUp_ReturnErrorWhenChecksumMismatch tests that Up refuses to run
+// when an applied migration was modified afterwards.
+func TestMigratorUp_ReturnErrorWhenChecksumMismatch(t *testing.T) {
+	/// Arrange
+	///
+	db := openTestDB(t)
+	defer db.Close()
+
+	original, _ := database.LoadMigrations(fstest.MapFS{
+		"0001_create_a.up.sql": {Data: []byte("CREATE TABLE a (id INTEGER);")},
+	})
+	if _, err := (&database.Migrator{DB: db, Migrations: original}).Up(); err != nil {
+		t.Fatalf("an error '%s' was not expected when applying migrations", err)
+	}
+
+	modified, _ := database.LoadMigrations(fstest.MapFS{
+		"0001_create_a.up.sql": {Data: []byte("CREATE TABLE a (id INTEGER, name TEXT);")},
+		"0002_create_b.up.sql": {Data: []byte("CREATE TABLE b (id INTEGER);")},
+	})
+
+	/// Act
+	///
+	applied, err := (&database.Migrator{DB: db, Migrations: modified}).Up()
+
+	/// Assert
+	///
+	assert.Error(t, err, "Expected an error but got none")
+	assert.True(t, errors.Is(err, database.ErrChecksumMismatch), "Expected a checksum mismatch error")
+	assert.Empty(t, applied)
+	assert.False(t, tableExists(t, db, "b"), "Expected no further migration to be applied")
+}
+
+// TestMigratorUp_DryRunLeavesDatabaseUntouched tests that Up in dry-run mode
+// reports the pending migrations without creating any table.
+func TestMigratorUp_DryRunLeavesDatabaseUntouched(t *testing.T) {
+	/// Arrange
+	///
+	db := openTestDB(t)
+	defer db.Close()
+
+	migrator, err := database.NewMigrator(db, database.SQLite)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when loading migrations", err)
+	}
+	migrator.DryRun = true
+
+	/// Act
+	///
+	applied, err := migrator.Up()
+
+	/// Assert
+	///
+	assert.NoError(t, err, "Expected no error but got one")
+	assert.Equal(t, len(migrator.Migrations), len(applied), "Expected every migration to be reported")
+	assert.False(t, tableExists(t, db, "users"))
+	assert.False(t, tableExists(t, db, "schema_migrations"))
+}
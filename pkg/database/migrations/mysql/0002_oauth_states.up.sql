@@ -0,0 +1,9 @@
+-- pending OAuth logins, each state is single-use and short-lived
+CREATE TABLE IF NOT EXISTS oauth_states (
+    state VARCHAR(64) NOT NULL PRIMARY KEY,
+    provider VARCHAR(64) NOT NULL,
+    binding_hash VARCHAR(64) NOT NULL,
+    expires_at DATETIME(6) NOT NULL,
+    created_at DATETIME(6) NOT NULL,
+    INDEX idx_oauth_states_expires_at (expires_at)
+) ENGINE=InnoDB;
@@ -0,0 +1,2 @@
+-- PKCE verifier of the login attempt, sent with the code exchange
+ALTER TABLE oauth_states ADD COLUMN code_verifier VARCHAR(128) NOT NULL DEFAULT '';
@@ -0,0 +1,14 @@
+-- long-lived refresh tokens, rotated on every use
+-- tokens issued from the same login share a family_id, revoked together when a rotated token is replayed
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    family_id VARCHAR(64) NOT NULL,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    expires_at DATETIME(6) NOT NULL,
+    created_at DATETIME(6) NOT NULL,
+    rotated_at DATETIME(6) NULL,
+    revoked_at DATETIME(6) NULL,
+    INDEX idx_refresh_tokens_family_id (family_id),
+    FOREIGN KEY (user_id) REFERENCES users(id)
+) ENGINE=InnoDB;
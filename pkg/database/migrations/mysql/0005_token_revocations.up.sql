@@ -0,0 +1,14 @@
+-- access tokens revoked before their expiry, kept until they would have expired anyway
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    jti VARCHAR(64) NOT NULL PRIMARY KEY,
+    user_id INT NOT NULL,
+    expires_at DATETIME(6) NOT NULL,
+    revoked_at DATETIME(6) NOT NULL,
+    INDEX idx_revoked_tokens_expires_at (expires_at)
+) ENGINE=InnoDB;
+
+-- every access token of the user issued before revoked_before is revoked
+CREATE TABLE IF NOT EXISTS session_revocations (
+    user_id INT NOT NULL PRIMARY KEY,
+    revoked_before DATETIME(6) NOT NULL
+) ENGINE=InnoDB;
@@ -0,0 +1,2 @@
+ALTER TABLE oauth_states DROP COLUMN link_user_id;
+DROP TABLE IF EXISTS user_identities;
@@ -0,0 +1,20 @@
+-- accounts of the OAuth providers a user can sign in with, several per user
+CREATE TABLE IF NOT EXISTS user_identities (
+    id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    provider VARCHAR(64) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    created_at DATETIME(6) NOT NULL,
+    UNIQUE INDEX idx_user_identities_provider_subject (provider, subject),
+    INDEX idx_user_identities_user_id (user_id),
+    FOREIGN KEY (user_id) REFERENCES users(id)
+) ENGINE=InnoDB;
+
+-- every existing user keeps signing in with the provider they registered with,
+-- a provider account registered twice (after an email change) stays with the oldest user
+INSERT INTO user_identities (user_id, provider, subject, email, created_at)
+SELECT MIN(id), oauth_provider, oauth_id, MIN(email), CURRENT_TIMESTAMP(6) FROM users GROUP BY oauth_provider, oauth_id;
+
+-- an account linking a provider, instead of a login
+ALTER TABLE oauth_states ADD COLUMN link_user_id INT NOT NULL DEFAULT 0;
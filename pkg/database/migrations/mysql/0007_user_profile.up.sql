@@ -0,0 +1,4 @@
+-- settings of the user as a JSON object
+ALTER TABLE users ADD COLUMN preferences VARCHAR(4096) NOT NULL DEFAULT '{}';
+-- the user asked for their account to be deleted, it is purged after this time unless cancelled
+ALTER TABLE users ADD COLUMN deletion_scheduled_at DATETIME(6) NULL;
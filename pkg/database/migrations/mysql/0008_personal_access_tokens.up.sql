@@ -0,0 +1,14 @@
+-- long-lived tokens minted by the users for scripts and CI, limited to their scopes
+-- scopes are separated by spaces, e.g. "todo:read todo:write"
+CREATE TABLE IF NOT EXISTS personal_access_tokens (
+    id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    scopes VARCHAR(255) NOT NULL,
+    expires_at DATETIME(6) NULL,
+    last_used_at DATETIME(6) NULL,
+    created_at DATETIME(6) NOT NULL,
+    INDEX idx_personal_access_tokens_user_id (user_id),
+    FOREIGN KEY (user_id) REFERENCES users(id)
+) ENGINE=InnoDB;
@@ -0,0 +1,9 @@
+-- keys signing the access tokens, identified by the kid header of the tokens
+-- a key signs until it is rotated, then only verifies until the tokens it signed have expired
+CREATE TABLE IF NOT EXISTS signing_keys (
+    kid VARCHAR(64) NOT NULL PRIMARY KEY,
+    algorithm VARCHAR(16) NOT NULL,
+    private_key TEXT NOT NULL,
+    created_at DATETIME(6) NOT NULL,
+    expires_at DATETIME(6) NOT NULL
+) ENGINE=InnoDB;
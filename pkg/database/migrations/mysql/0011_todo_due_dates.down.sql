@@ -0,0 +1,3 @@
+DROP INDEX idx_todos_user_due_at ON todos;
+ALTER TABLE todos DROP COLUMN completed_at;
+ALTER TABLE todos DROP COLUMN due_at;
@@ -0,0 +1,3 @@
+DROP INDEX idx_todos_user_position ON todos;
+ALTER TABLE todos DROP COLUMN position;
+ALTER TABLE todos DROP COLUMN priority;
@@ -0,0 +1,20 @@
+-- labels of the todo items, each user has their own, names are unique per user whatever their case
+CREATE TABLE IF NOT EXISTS tags (
+    id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    name VARCHAR(50) NOT NULL,
+    color VARCHAR(7) NOT NULL DEFAULT '',
+    created_at DATETIME(6) NOT NULL,
+    UNIQUE INDEX idx_tags_user_name (user_id, name),
+    FOREIGN KEY (user_id) REFERENCES users(id)
+) ENGINE=InnoDB;
+
+-- the tags of each todo item
+CREATE TABLE IF NOT EXISTS todo_tags (
+    todo_id INT NOT NULL,
+    tag_id INT NOT NULL,
+    PRIMARY KEY (todo_id, tag_id),
+    INDEX idx_todo_tags_tag_id (tag_id),
+    FOREIGN KEY (todo_id) REFERENCES todos(id),
+    FOREIGN KEY (tag_id) REFERENCES tags(id)
+) ENGINE=InnoDB;
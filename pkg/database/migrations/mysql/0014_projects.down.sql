@@ -0,0 +1,4 @@
+ALTER TABLE todos DROP FOREIGN KEY fk_todos_project_id;
+DROP INDEX idx_todos_project_id ON todos;
+ALTER TABLE todos DROP COLUMN project_id;
+DROP TABLE IF EXISTS projects;
@@ -0,0 +1,25 @@
+-- named lists grouping the todo items, names are unique per user whatever their case.
+-- inbox is the default list of the user, created with their account
+CREATE TABLE IF NOT EXISTS projects (
+    id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    inbox BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at DATETIME(6) NOT NULL,
+    updated_at DATETIME(6) NOT NULL,
+    UNIQUE INDEX idx_projects_user_name (user_id, name),
+    FOREIGN KEY (user_id) REFERENCES users(id)
+) ENGINE=InnoDB;
+
+-- the list of the item, NULL if it is in none
+ALTER TABLE todos ADD COLUMN project_id INT NULL;
+
+CREATE INDEX idx_todos_project_id ON todos(project_id);
+
+ALTER TABLE todos ADD CONSTRAINT fk_todos_project_id FOREIGN KEY (project_id) REFERENCES projects(id);
+
+-- the users registered before get their inbox, with all their items in it
+INSERT INTO projects (user_id, name, inbox, created_at, updated_at)
+SELECT id, 'Inbox', TRUE, CURRENT_TIMESTAMP(6), CURRENT_TIMESTAMP(6) FROM users;
+
+UPDATE todos SET project_id = (SELECT p.id FROM projects p WHERE p.user_id = todos.user_id AND p.inbox = TRUE);
@@ -0,0 +1,3 @@
+ALTER TABLE todos DROP FOREIGN KEY fk_todos_parent_id;
+DROP INDEX idx_todos_parent_id ON todos;
+ALTER TABLE todos DROP COLUMN parent_id;
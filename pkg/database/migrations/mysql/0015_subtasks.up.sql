@@ -0,0 +1,6 @@
+-- the item this one is a subtask of, NULL for a top-level item
+ALTER TABLE todos ADD COLUMN parent_id INT NULL;
+
+CREATE INDEX idx_todos_parent_id ON todos(parent_id);
+
+ALTER TABLE todos ADD CONSTRAINT fk_todos_parent_id FOREIGN KEY (parent_id) REFERENCES todos(id);
@@ -0,0 +1 @@
+-- nothing to undo, see 0016_tags_name_lower.up.sql
@@ -0,0 +1,2 @@
+-- tag names are unique per user whatever their case,
+-- idx_tags_user_name already compares them with the case-insensitive collation of the column
@@ -0,0 +1 @@
+-- nothing to undo, see 0017_projects_name_lower.up.sql
@@ -0,0 +1,2 @@
+-- project names are unique per user whatever their case,
+-- idx_projects_user_name already compares them with the case-insensitive collation of the column
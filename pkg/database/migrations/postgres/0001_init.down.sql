@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_todos_user_id;
+DROP TABLE IF EXISTS todos;
+DROP TABLE IF EXISTS users;
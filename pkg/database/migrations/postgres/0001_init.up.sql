@@ -0,0 +1,19 @@
+-- users are identified by an external OAuth provider
+CREATE TABLE IF NOT EXISTS users (
+    id SERIAL PRIMARY KEY,
+    oauth_provider TEXT NOT NULL,
+    oauth_id TEXT NOT NULL,
+    name TEXT NOT NULL,
+    email TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS todos (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    title TEXT NOT NULL,
+    completed BOOLEAN NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_todos_user_id ON todos(user_id);
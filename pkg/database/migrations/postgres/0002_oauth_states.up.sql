@@ -0,0 +1,10 @@
+-- pending OAuth logins, each state is single-use and short-lived
+CREATE TABLE IF NOT EXISTS oauth_states (
+    state TEXT NOT NULL PRIMARY KEY,
+    provider TEXT NOT NULL,
+    binding_hash TEXT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_oauth_states_expires_at ON oauth_states(expires_at);
@@ -0,0 +1,15 @@
+-- access tokens revoked before their expiry, kept until they would have expired anyway
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    jti TEXT NOT NULL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_expires_at ON revoked_tokens(expires_at);
+
+-- every access token of the user issued before revoked_before is revoked
+CREATE TABLE IF NOT EXISTS session_revocations (
+    user_id INTEGER NOT NULL PRIMARY KEY,
+    revoked_before TIMESTAMPTZ NOT NULL
+);
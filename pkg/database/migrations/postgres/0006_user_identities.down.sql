@@ -0,0 +1,4 @@
+ALTER TABLE oauth_states DROP COLUMN link_user_id;
+DROP INDEX IF EXISTS idx_user_identities_user_id;
+DROP INDEX IF EXISTS idx_user_identities_provider_subject;
+DROP TABLE IF EXISTS user_identities;
@@ -0,0 +1,20 @@
+-- accounts of the OAuth providers a user can sign in with, several per user
+CREATE TABLE IF NOT EXISTS user_identities (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    provider TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    email TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_identities_provider_subject ON user_identities(provider, subject);
+CREATE INDEX IF NOT EXISTS idx_user_identities_user_id ON user_identities(user_id);
+
+-- every existing user keeps signing in with the provider they registered with,
+-- a provider account registered twice (after an email change) stays with the oldest user
+INSERT INTO user_identities (user_id, provider, subject, email, created_at)
+SELECT MIN(id), oauth_provider, oauth_id, MIN(email), CURRENT_TIMESTAMP FROM users GROUP BY oauth_provider, oauth_id;
+
+-- an account linking a provider, instead of a login
+ALTER TABLE oauth_states ADD COLUMN link_user_id INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,4 @@
+-- role of the user, admins manage the other users
+ALTER TABLE users ADD COLUMN role VARCHAR(20) NOT NULL DEFAULT 'user';
+-- an admin suspended the account, every sign in and token of the user is refused until it is reactivated
+ALTER TABLE users ADD COLUMN suspended_at TIMESTAMPTZ NULL;
@@ -0,0 +1,23 @@
+-- named lists grouping the todo items, names are unique per user whatever their case.
+-- inbox is the default list of the user, created with their account
+CREATE TABLE IF NOT EXISTS projects (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    name VARCHAR(100) NOT NULL,
+    inbox BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_projects_user_name ON projects(user_id, name);
+
+-- the list of the item, NULL if it is in none
+ALTER TABLE todos ADD COLUMN project_id INTEGER REFERENCES projects(id);
+
+CREATE INDEX IF NOT EXISTS idx_todos_project_id ON todos(project_id);
+
+-- the users registered before get their inbox, with all their items in it
+INSERT INTO projects (user_id, name, inbox, created_at, updated_at)
+SELECT id, 'Inbox', TRUE, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP FROM users;
+
+UPDATE todos SET project_id = (SELECT p.id FROM projects p WHERE p.user_id = todos.user_id AND p.inbox = TRUE);
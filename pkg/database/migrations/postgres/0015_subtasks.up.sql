@@ -0,0 +1,4 @@
+-- the item this one is a subtask of, NULL for a top-level item
+ALTER TABLE todos ADD COLUMN parent_id INTEGER REFERENCES todos(id);
+
+CREATE INDEX IF NOT EXISTS idx_todos_parent_id ON todos(parent_id);
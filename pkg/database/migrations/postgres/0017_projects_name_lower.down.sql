@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_projects_user_name;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_projects_user_name ON projects(user_id, name);
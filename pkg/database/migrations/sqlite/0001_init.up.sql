@@ -1,6 +1,13 @@
---- tables used in the database
+-- users are identified by an external OAuth provider
+CREATE TABLE IF NOT EXISTS users (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    oauth_provider TEXT NOT NULL,
+    oauth_id TEXT NOT NULL,
+    name TEXT NOT NULL,
+    email TEXT NOT NULL
+);
 
-CREATE TABLE todos (
+CREATE TABLE IF NOT EXISTS todos (
     id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
     user_id INTEGER NOT NULL,
     title TEXT NOT NULL,
@@ -8,13 +15,6 @@ CREATE TABLE todos (
     created_at TIMESTAMP NOT NULL,
     updated_at TIMESTAMP NOT NULL,
     FOREIGN KEY (user_id) REFERENCES users(id)
-)
-CREATE INDEX idx_todos_user_id ON todos(user_id);
+);
 
-CREATE TABLE users (
-    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
-    oauth_provider TEXT NOT NULL,
-    oauth_id TEXT NOT NULL,
-    name TEXT NOT NULL,
-    email TEXT NOT NULL
-)
\ No newline at end of file
+CREATE INDEX IF NOT EXISTS idx_todos_user_id ON todos(user_id);
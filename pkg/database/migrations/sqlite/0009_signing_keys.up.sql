@@ -0,0 +1,9 @@
+-- keys signing the access tokens, identified by the kid header of the tokens
+-- a key signs until it is rotated, then only verifies until the tokens it signed have expired
+CREATE TABLE IF NOT EXISTS signing_keys (
+    kid TEXT NOT NULL PRIMARY KEY,
+    algorithm TEXT NOT NULL,
+    private_key TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
@@ -0,0 +1,10 @@
+-- when the item is due, in UTC, NULL if it has no due date
+ALTER TABLE todos ADD COLUMN due_at TIMESTAMP NULL;
+-- when the item was completed, NULL while it is not
+ALTER TABLE todos ADD COLUMN completed_at TIMESTAMP NULL;
+
+-- the items completed before are best known to be completed at their last update
+UPDATE todos SET completed_at = updated_at WHERE completed = TRUE;
+
+-- lists the overdue items and the items due on a day without scanning every item of the user
+CREATE INDEX IF NOT EXISTS idx_todos_user_due_at ON todos(user_id, due_at);
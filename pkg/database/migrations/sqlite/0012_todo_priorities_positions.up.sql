@@ -0,0 +1,9 @@
+-- priority of the item: none, low, medium, high or urgent
+ALTER TABLE todos ADD COLUMN priority VARCHAR(10) NOT NULL DEFAULT 'none';
+-- rank of the item in the list of the user, compared byte by byte
+ALTER TABLE todos ADD COLUMN position TEXT NOT NULL DEFAULT '';
+
+-- the items created before keep the order of their IDs, "i" keeps the ranks from ending with the lowest digit
+UPDATE todos SET position = printf('%010d', id) || 'i';
+
+CREATE INDEX IF NOT EXISTS idx_todos_user_position ON todos(user_id, position);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_todos_project_id;
+ALTER TABLE todos DROP COLUMN project_id;
+DROP TABLE IF EXISTS projects;
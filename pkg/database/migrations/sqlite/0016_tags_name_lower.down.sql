@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_tags_user_name;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_tags_user_name ON tags(user_id, name);
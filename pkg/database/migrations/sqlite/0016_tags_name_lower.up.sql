@@ -0,0 +1,3 @@
+-- tag names are unique per user whatever their case, the index enforces it for concurrent requests too
+DROP INDEX IF EXISTS idx_tags_user_name;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_tags_user_name ON tags(user_id, LOWER(name));
@@ -0,0 +1,3 @@
+-- project names are unique per user whatever their case, the index enforces it for concurrent requests too
+DROP INDEX IF EXISTS idx_projects_user_name;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_projects_user_name ON projects(user_id, LOWER(name));
@@ -0,0 +1,178 @@
+// Package export packs everything mattodo stores about a user into a ZIP archive.
+package export
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mystardustcaptain/mattodo/pkg/model"
+)
+
+// Archive is the personal data of a user.
+type Archive struct {
+	User        *model.User
+	Todos       []*model.TodoItem
+	Tags        []*model.Tag
+	Projects    []*model.Project
+	Identities  []*model.UserIdentity
+	GeneratedAt time.Time
+}
+
+// Load gathers the personal data of a User of a given userID from the stores.
+func Load(users model.UserStore, todos model.TodoStore, userID int) (*Archive, error) {
+	user, err := users.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	todoItems, err := todos.GetAllTodoItems(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	tags, err := todos.GetTags(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	projects, err := todos.GetProjects(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	identities, err := users.GetUserIdentities(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Archive{User: user, Todos: todoItems, Tags: tags, Projects: projects, Identities: identities, GeneratedAt: model.Now()}, nil
+}
+
+// WriteZip writes the archive as a ZIP file to w:
+//
+//	user.json        the profile of the user
+//	todos.json       the todo items
+//	todos.csv        the todo items, for spreadsheets
+//	tags.json        the tags, including those on no todo item
+//	projects.json    the projects, including the empty ones
+//	identities.json  the provider accounts linked to the user
+func (a *Archive) WriteZip(w io.Writer) error {
+	zw := zip.NewWriter(w)
+
+	todos := a.Todos
+	if todos == nil {
+		todos = []*model.TodoItem{}
+	}
+	tags := a.Tags
+	if tags == nil {
+		tags = []*model.Tag{}
+	}
+	projects := a.Projects
+	if projects == nil {
+		projects = []*model.Project{}
+	}
+
+	files := []struct {
+		name  string
+		write func(w io.Writer) error
+	}{
+		{"user.json", jsonFile(a.User)},
+		{"todos.json", jsonFile(todos)},
+		{"todos.csv", a.writeTodosCSV},
+		{"tags.json", jsonFile(tags)},
+		{"projects.json", jsonFile(projects)},
+		{"identities.json", jsonFile(a.Identities)},
+	}
+
+	for _, file := range files {
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: file.name, Method: zip.Deflate, Modified: a.GeneratedAt})
+		if err != nil {
+			log.Printf("Failed to create %s in archive: %s\n", file.name, err.Error())
+			return err
+		}
+		if err := file.write(fw); err != nil {
+			log.Printf("Failed to write %s in archive: %s\n", file.name, err.Error())
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// jsonFile returns a writer of v as indented JSON
+func jsonFile(v interface{}) func(w io.Writer) error {
+	return func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+}
+
+// writeTodosCSV writes the todo items as CSV, with a header row.
+// The project of an item is given by its name, empty if it is in none, and its parent by its ID, empty for a top-level item.
+func (a *Archive) writeTodosCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+
+	projectNames := map[int]string{}
+	for _, p := range a.Projects {
+		projectNames[p.ID] = p.Name
+	}
+
+	cw.Write([]string{"id", "parent_id", "title", "completed", "priority", "project", "tags", "due_at", "completed_at", "created_at", "updated_at"})
+	for _, t := range a.Todos {
+		projectName := ""
+		if t.ProjectID != nil {
+			projectName = projectNames[*t.ProjectID]
+		}
+		parentID := ""
+		if t.ParentID != nil {
+			parentID = strconv.Itoa(*t.ParentID)
+		}
+
+		tagNames := make([]string, len(t.Tags))
+		for i, tag := range t.Tags {
+			tagNames[i] = tag.Name
+		}
+
+		cw.Write([]string{
+			strconv.Itoa(t.ID),
+			parentID,
+			escapeFormula(t.Title),
+			strconv.FormatBool(t.Completed),
+			t.Priority,
+			escapeFormula(projectName),
+			escapeFormula(strings.Join(tagNames, ", ")),
+			formatOptionalTime(t.DueAt),
+			formatOptionalTime(t.CompletedAt),
+			t.CreatedAt.Format(time.RFC3339),
+			t.UpdatedAt.Format(time.RFC3339),
+		})
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// formatOptionalTime formats a time of the CSV, an empty cell if it is not set
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+
+	return t.Format(time.RFC3339)
+}
+
+// escapeFormula keeps spreadsheets from evaluating a cell starting like a formula
+func escapeFormula(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+
+	return s
+}
@@ -0,0 +1,168 @@
+package export_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/mystardustcaptain/mattodo/pkg/export"
+	"github.com/mystardustcaptain/mattodo/pkg/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// readZip returns the files of a ZIP archive by name
+func readZip(t *testing.T, data []byte) map[string][]byte {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when reading the archive", err)
+	}
+
+	files := map[string][]byte{}
+	for _, f := range zr.File {
+		rc, _ := f.Open()
+		files[f.Name], _ = io.ReadAll(rc)
+		rc.Close()
+	}
+
+	return files
+}
+
+// TestArchiveWriteZip_ContainsEveryRecord tests that the archive holds the user, the todo items
+// as JSON and CSV, the tags, the projects and the identities.
+func TestArchiveWriteZip_ContainsEveryRecord(t *testing.T) {
+	/// Arrange
+	///
+	created := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	due := time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC)
+	inboxID := 4
+	parentID := 1
+	archive := &export.Archive{
+		User: &model.User{ID: 2, Name: "Alice", Email: "alice@example.com"},
+		Todos: []*model.TodoItem{
+			{ID: 1, UserID: 2, Title: "Buy milk", Priority: model.PriorityHigh, Tags: []*model.Tag{{ID: 1, Name: "Errands"}, {ID: 2, Name: "Home"}}, ProjectID: &inboxID, DueAt: &due, CreatedAt: created, UpdatedAt: created},
+			{ID: 2, UserID: 2, Title: "=HYPERLINK(\"http://evil\")", Completed: true, CompletedAt: &created, ParentID: &parentID, CreatedAt: created, UpdatedAt: created},
+		},
+		Tags:        []*model.Tag{{ID: 1, UserID: 2, Name: "Errands"}, {ID: 2, UserID: 2, Name: "Home"}, {ID: 3, UserID: 2, Name: "Unused"}},
+		Projects:    []*model.Project{{ID: inboxID, UserID: 2, Name: "Inbox", Inbox: true}, {ID: 5, UserID: 2, Name: "Empty"}},
+		Identities:  []*model.UserIdentity{{ID: 1, UserID: 2, Provider: "github", Subject: "42"}},
+		GeneratedAt: created,
+	}
+
+	/// Act
+	///
+	var buf bytes.Buffer
+	err := archive.WriteZip(&buf)
+
+	/// Assert
+	///
+	assert.NoError(t, err, "Expected no error but got one")
+
+	files := readZip(t, buf.Bytes())
+	assert.Equal(t, 6, len(files))
+
+	var user model.User
+	assert.NoError(t, json.Unmarshal(files["user.json"], &user))
+	assert.Equal(t, "alice@example.com", user.Email)
+
+	var todos []model.TodoItem
+	assert.NoError(t, json.Unmarshal(files["todos.json"], &todos))
+	assert.Equal(t, 2, len(todos))
+
+	var tags []model.Tag
+	assert.NoError(t, json.Unmarshal(files["tags.json"], &tags))
+	assert.Equal(t, 3, len(tags), "Expected the tags on no todo item too")
+
+	var projects []model.Project
+	assert.NoError(t, json.Unmarshal(files["projects.json"], &projects))
+	assert.Equal(t, 2, len(projects), "Expected the empty projects too")
+
+	var identities []model.UserIdentity
+	assert.NoError(t, json.Unmarshal(files["identities.json"], &identities))
+	assert.Equal(t, "github", identities[0].Provider)
+
+	rows, err := csv.NewReader(bytes.NewReader(files["todos.csv"])).ReadAll()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(rows), "Expected a header row and a row per todo item")
+	assert.Equal(t, []string{"1", "", "Buy milk", "false", "high", "Inbox", "Errands, Home", "2024-01-02T09:00:00Z", "", "2024-01-01T12:00:00Z", "2024-01-01T12:00:00Z"}, rows[1])
+	assert.Equal(t, "2024-01-01T12:00:00Z", rows[2][8], "Expected the completion time of a completed item")
+	assert.Equal(t, `'=HYPERLINK("http://evil")`, rows[2][2], "Expected formulas not to be evaluated by spreadsheets")
+	assert.Equal(t, "", rows[2][5], "Expected an empty project for an item in none")
+	assert.Equal(t, "1", rows[2][1], "Expected the ID of the parent of a subtask")
+}
+
+// TestJobs_DownloadOnceWhenReady tests that a background export is pending until generated,
+// can then be downloaded, and only once.
+func TestJobs_DownloadOnceWhenReady(t *testing.T) {
+	/// Arrange
+	///
+	jobs := export.NewJobs()
+	jobs.Dir = t.TempDir()
+
+	release := make(chan struct{})
+	token, _, err := jobs.Start(2, func(w io.Writer) error {
+		<-release
+		_, err := w.Write([]byte("archive"))
+		return err
+	})
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when starting the export", err)
+	}
+
+	/// Act
+	///
+	_, errPending := jobs.Open(token)
+	close(release)
+
+	var file io.ReadCloser
+	assert.Eventually(t, func() bool {
+		file, err = jobs.Open(token)
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	content, _ := io.ReadAll(file)
+	file.Close()
+	_, errAgain := jobs.Open(token)
+
+	/// Assert
+	///
+	assert.ErrorIs(t, errPending, export.ErrJobPending)
+	assert.Equal(t, "archive", string(content))
+	assert.ErrorIs(t, errAgain, export.ErrJobNotFound, "Expected the link to work only once")
+}
+
+// TestJobs_OnePendingExportPerUser tests that a user cannot start an export while another one of theirs is generated,
+// and that an export never generated is given up once its link expired.
+func TestJobs_OnePendingExportPerUser(t *testing.T) {
+	/// Arrange
+	///
+	jobs := export.NewJobs()
+	jobs.Dir = t.TempDir()
+
+	stuck := make(chan struct{})
+	defer close(stuck)
+	generate := func(w io.Writer) error {
+		<-stuck
+		return nil
+	}
+	_, expiresAt, err := jobs.Start(2, generate)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when starting the export", err)
+	}
+
+	/// Act
+	///
+	_, _, errAgain := jobs.Start(2, generate)
+	_, _, errOther := jobs.Start(3, generate)
+	jobs.Prune(expiresAt.Add(time.Second))
+	_, _, errAfterExpiry := jobs.Start(2, generate)
+
+	/// Assert
+	///
+	assert.ErrorIs(t, errAgain, export.ErrJobRunning, "Expected a second export of the user to be refused")
+	assert.NoError(t, errOther, "Expected another user to start their export")
+	assert.NoError(t, errAfterExpiry, "Expected the expired export to be given up")
+}
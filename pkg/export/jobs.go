@@ -0,0 +1,182 @@
+package export
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultJobTTL is how long a generated export can be downloaded
+const DefaultJobTTL = time.Hour
+
+// DefaultPruneInterval is how often the expired exports are removed
+const DefaultPruneInterval = 5 * time.Minute
+
+// ErrJobNotFound is returned for a download link that is unknown, expired or already used.
+var ErrJobNotFound = errors.New("export not found")
+
+// ErrJobPending is returned for a download link of an export still being generated.
+var ErrJobPending = errors.New("export not ready yet")
+
+// ErrJobRunning is returned when a user asks for an export while another one of theirs is still being generated.
+var ErrJobRunning = errors.New("export already being generated")
+
+// Jobs generates the large exports in the background, into temporary files.
+// Each export is downloadable once through a random link, until the TTL.
+// Jobs are kept in memory, the link only works on the instance that generated the export.
+type Jobs struct {
+	Dir string // for the temporary files, the default temporary directory if empty
+	TTL time.Duration
+
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+// job is an export generated in the background
+type job struct {
+	userID    int
+	path      string
+	done      bool
+	err       error
+	expiresAt time.Time
+}
+
+// NewJobs returns Jobs using the default temporary directory.
+func NewJobs() *Jobs {
+	return &Jobs{TTL: DefaultJobTTL, jobs: map[string]*job{}}
+}
+
+// Start generates an export of a User of a given userID in the background with generate.
+// Returns the token of the download link and when it expires,
+// or ErrJobRunning while another export of the User is being generated.
+func (j *Jobs) Start(userID int, generate func(w io.Writer) error) (string, time.Time, error) {
+	token, err := randomToken()
+	if err != nil {
+		log.Printf("Failed to generate export token: %s\n", err.Error())
+		return "", time.Time{}, err
+	}
+
+	now := time.Now()
+	j.Prune(now)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for _, jb := range j.jobs {
+		if jb.userID == userID && !jb.done {
+			return "", time.Time{}, ErrJobRunning
+		}
+	}
+
+	file, err := os.CreateTemp(j.Dir, "mattodo-export-*.zip")
+	if err != nil {
+		log.Printf("Failed to create export file: %s\n", err.Error())
+		return "", time.Time{}, err
+	}
+
+	jb := &job{userID: userID, path: file.Name(), expiresAt: now.Add(j.TTL)}
+	j.jobs[token] = jb
+
+	go func() {
+		err := generate(file)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			log.Printf("Failed to generate export of user %d: %s\n", userID, err.Error())
+		}
+
+		j.mu.Lock()
+		jb.done, jb.err = true, err
+		j.mu.Unlock()
+	}()
+
+	return token, jb.expiresAt, nil
+}
+
+// Open consumes the download link of an export and returns the archive.
+// The file is removed once closed, the link cannot be used again.
+// Returns ErrJobPending while the export is generated, or ErrJobNotFound.
+func (j *Jobs) Open(token string) (io.ReadCloser, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	jb, ok := j.jobs[token]
+	if !ok || time.Now().After(jb.expiresAt) {
+		return nil, ErrJobNotFound
+	}
+	if !jb.done {
+		return nil, ErrJobPending
+	}
+
+	delete(j.jobs, token)
+	if jb.err != nil {
+		os.Remove(jb.path)
+		return nil, jb.err
+	}
+
+	file, err := os.Open(jb.path)
+	if err != nil {
+		log.Printf("Failed to open export file: %s\n", err.Error())
+		return nil, err
+	}
+
+	return &removeOnClose{File: file}, nil
+}
+
+// Prune removes the exports whose link expired before now.
+// An export still generated by then is given up, its user can ask for another one.
+func (j *Jobs) Prune(now time.Time) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for token, jb := range j.jobs {
+		if now.After(jb.expiresAt) {
+			os.Remove(jb.path)
+			delete(j.jobs, token)
+		}
+	}
+}
+
+// PruneEvery prunes the expired exports every interval until ctx is done, so that the files nobody downloaded are removed.
+// Meant to run in its own goroutine.
+func (j *Jobs) PruneEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			j.Prune(time.Now())
+		}
+	}
+}
+
+// removeOnClose removes the file once it is closed
+type removeOnClose struct {
+	*os.File
+}
+
+func (f *removeOnClose) Close() error {
+	err := f.File.Close()
+	os.Remove(f.File.Name())
+	return err
+}
+
+// randomToken returns 32 bytes from the cryptographic random source, base64url encoded
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,262 @@
+package model
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mystardustcaptain/mattodo/pkg/database"
+)
+
+// PersonalAccessToken is a long-lived token a user mints for scripts and CI,
+// limited to its scopes, e.g. todo:read.
+// Only the hash of the token is stored, the token itself is shown once when it is created.
+type PersonalAccessToken struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"user_id"`
+	Name       string     `json:"name"`
+	TokenHash  string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"` // nil never expires
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// HasScope reports whether the token grants the scope.
+func (t *PersonalAccessToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+type PersonalAccessTokenCollection struct {
+	DB      *sql.DB
+	Dialect database.Dialect
+}
+
+// personalAccessTokenColumns are the columns scanned by scanPersonalAccessToken
+const personalAccessTokenColumns = "id, user_id, name, token_hash, scopes, expires_at, last_used_at, created_at"
+
+// scanPersonalAccessToken scans a row of personalAccessTokenColumns into a PersonalAccessToken
+func scanPersonalAccessToken(row rowScanner) (*PersonalAccessToken, error) {
+	t := PersonalAccessToken{}
+	var scopes string
+	var expiresAt, lastUsedAt sql.NullTime
+
+	if err := row.Scan(&t.ID, &t.UserID, &t.Name, &t.TokenHash, &scopes, &expiresAt, &lastUsedAt, &t.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	t.Scopes = strings.Fields(scopes)
+	if expiresAt.Valid {
+		t.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		t.LastUsedAt = &lastUsedAt.Time
+	}
+
+	return &t, nil
+}
+
+// CreatePersonalAccessToken stores a new personal access token, t is modified with its ID.
+func (pc *PersonalAccessTokenCollection) CreatePersonalAccessToken(t *PersonalAccessToken) error {
+	query := "INSERT INTO personal_access_tokens (user_id, name, token_hash, scopes, expires_at, created_at) VALUES (?, ?, ?, ?, ?, ?)"
+
+	var expiresAt sql.NullTime
+	if t.ExpiresAt != nil {
+		expiresAt = sql.NullTime{Time: *t.ExpiresAt, Valid: true}
+	}
+
+	id, err := pc.Dialect.InsertReturningID(pc.DB, query, t.UserID, t.Name, t.TokenHash, strings.Join(t.Scopes, " "), expiresAt, t.CreatedAt)
+	if err != nil {
+		log.Printf("Failed to create personal access token: %s", err.Error())
+		return err
+	}
+	t.ID = int(id)
+
+	return nil
+}
+
+// GetPersonalAccessTokenByHash gets a personal access token by the hash of its value.
+// Returns ErrNotFound if there is no such token.
+func (pc *PersonalAccessTokenCollection) GetPersonalAccessTokenByHash(tokenHash string) (*PersonalAccessToken, error) {
+	query := "SELECT " + personalAccessTokenColumns + " FROM personal_access_tokens WHERE token_hash = ?"
+
+	t, err := scanPersonalAccessToken(pc.DB.QueryRow(pc.Dialect.Rebind(query), tokenHash))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("personal access token: %w", ErrNotFound)
+	}
+	if err != nil {
+		log.Printf("Failed to get personal access token: %s", err.Error())
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// GetPersonalAccessTokens gets the personal access tokens of a User of a given userID, ordered by ID.
+func (pc *PersonalAccessTokenCollection) GetPersonalAccessTokens(userID int) ([]*PersonalAccessToken, error) {
+	query := "SELECT " + personalAccessTokenColumns + " FROM personal_access_tokens WHERE user_id = ? ORDER BY id"
+
+	rows, err := pc.DB.Query(pc.Dialect.Rebind(query), userID)
+	if err != nil {
+		log.Printf("Failed to get personal access tokens: %s", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*PersonalAccessToken{}
+	for rows.Next() {
+		t, err := scanPersonalAccessToken(rows)
+		if err != nil {
+			log.Printf("Failed to scan personal access token: %s", err.Error())
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+
+	return tokens, rows.Err()
+}
+
+// TouchPersonalAccessToken records when a personal access token was last used.
+func (pc *PersonalAccessTokenCollection) TouchPersonalAccessToken(id int, at time.Time) error {
+	query := "UPDATE personal_access_tokens SET last_used_at = ? WHERE id = ?"
+
+	if _, err := pc.DB.Exec(pc.Dialect.Rebind(query), at, id); err != nil {
+		log.Printf("Failed to touch personal access token: %s", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// DeletePersonalAccessToken deletes a personal access token of a User of a given userID, revoking it.
+// Returns ErrNotFound if the user has no such token.
+func (pc *PersonalAccessTokenCollection) DeletePersonalAccessToken(userID int, id int) error {
+	query := "DELETE FROM personal_access_tokens WHERE id = ? AND user_id = ?"
+
+	result, err := pc.DB.Exec(pc.Dialect.Rebind(query), id, userID)
+	if err != nil {
+		log.Printf("Failed to delete personal access token: %s", err.Error())
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("Failed to get rows affected: %s", err.Error())
+		return err
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("personal access token %d: %w", id, ErrNotFound)
+	}
+
+	return nil
+}
+
+// MemoryPersonalAccessTokenStore is a PersonalAccessTokenStore kept in memory.
+// It is safe for concurrent use, meant for tests and ephemeral demo instances.
+type MemoryPersonalAccessTokenStore struct {
+	mu     sync.Mutex
+	tokens map[int]PersonalAccessToken
+	lastID int
+}
+
+// NewMemoryPersonalAccessTokenStore returns an empty MemoryPersonalAccessTokenStore.
+func NewMemoryPersonalAccessTokenStore() *MemoryPersonalAccessTokenStore {
+	return &MemoryPersonalAccessTokenStore{tokens: map[int]PersonalAccessToken{}}
+}
+
+// copyPersonalAccessToken returns a copy of t not sharing its scopes
+func copyPersonalAccessToken(t PersonalAccessToken) *PersonalAccessToken {
+	t.Scopes = append([]string(nil), t.Scopes...)
+	return &t
+}
+
+// CreatePersonalAccessToken stores a new personal access token, t is modified with its ID.
+func (ms *MemoryPersonalAccessTokenStore) CreatePersonalAccessToken(t *PersonalAccessToken) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.lastID++
+	t.ID = ms.lastID
+	ms.tokens[t.ID] = *copyPersonalAccessToken(*t)
+
+	return nil
+}
+
+// GetPersonalAccessTokenByHash gets a personal access token by the hash of its value.
+func (ms *MemoryPersonalAccessTokenStore) GetPersonalAccessTokenByHash(tokenHash string) (*PersonalAccessToken, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for _, t := range ms.tokens {
+		if t.TokenHash == tokenHash {
+			return copyPersonalAccessToken(t), nil
+		}
+	}
+
+	return nil, fmt.Errorf("personal access token: %w", ErrNotFound)
+}
+
+// GetPersonalAccessTokens gets the personal access tokens of a User of a given userID, ordered by ID.
+func (ms *MemoryPersonalAccessTokenStore) GetPersonalAccessTokens(userID int) ([]*PersonalAccessToken, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	tokens := []*PersonalAccessToken{}
+	for _, t := range ms.tokens {
+		if t.UserID == userID {
+			tokens = append(tokens, copyPersonalAccessToken(t))
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].ID < tokens[j].ID })
+
+	return tokens, nil
+}
+
+// TouchPersonalAccessToken records when a personal access token was last used.
+func (ms *MemoryPersonalAccessTokenStore) TouchPersonalAccessToken(id int, at time.Time) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if t, ok := ms.tokens[id]; ok {
+		t.LastUsedAt = &at
+		ms.tokens[id] = t
+	}
+
+	return nil
+}
+
+// DeletePersonalAccessToken deletes a personal access token of a User of a given userID, revoking it.
+func (ms *MemoryPersonalAccessTokenStore) DeletePersonalAccessToken(userID int, id int) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	t, ok := ms.tokens[id]
+	if !ok || t.UserID != userID {
+		return fmt.Errorf("personal access token %d: %w", id, ErrNotFound)
+	}
+	delete(ms.tokens, id)
+
+	return nil
+}
+
+// DeleteUserData deletes every personal access token of a User of a given userID, when the user is deleted.
+func (ms *MemoryPersonalAccessTokenStore) DeleteUserData(userID int) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for id, t := range ms.tokens {
+		if t.UserID == userID {
+			delete(ms.tokens, id)
+		}
+	}
+}
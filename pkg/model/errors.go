@@ -0,0 +1,55 @@
+package model
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+// Domain errors returned by the stores.
+// Callers should test for them with errors.Is, as they are usually wrapped with more context.
+var (
+	// ErrNotFound is returned when an entity does not exist or does not belong to the user.
+	ErrNotFound = errors.New("not found")
+	// ErrValidation is returned when an input is not acceptable, see ValidationError.
+	ErrValidation = errors.New("validation failed")
+	// ErrConflict is returned when an operation clashes with the current state of an entity.
+	ErrConflict = errors.New("conflict")
+	// ErrForbidden is returned when the user is not allowed to perform an operation.
+	ErrForbidden = errors.New("forbidden")
+)
+
+// ValidationError lists the invalid fields of an input with the reason for each.
+// It matches ErrValidation with errors.Is.
+type ValidationError struct {
+	Fields map[string]string `json:"fields"`
+}
+
+// NewValidationError returns a ValidationError for a single field.
+func NewValidationError(field string, reason string) *ValidationError {
+	return &ValidationError{Fields: map[string]string{field: reason}}
+}
+
+// Add records another invalid field.
+func (e *ValidationError) Add(field string, reason string) {
+	if e.Fields == nil {
+		e.Fields = map[string]string{}
+	}
+	e.Fields[field] = reason
+}
+
+// Error lists the invalid fields in a stable order.
+func (e *ValidationError) Error() string {
+	fields := make([]string, 0, len(e.Fields))
+	for field, reason := range e.Fields {
+		fields = append(fields, field+": "+reason)
+	}
+	sort.Strings(fields)
+
+	return ErrValidation.Error() + ": " + strings.Join(fields, ", ")
+}
+
+// Is makes errors.Is(err, ErrValidation) true for a ValidationError.
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidation
+}
@@ -0,0 +1,231 @@
+package model
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// UserIdentity is an account at an OAuth provider a User can sign in with.
+// It is identified by the provider and the subject, the provider's own id of the account,
+// which unlike the email never changes.
+type UserIdentity struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	Email     string    `json:"email"` // as last reported by the provider
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// GetUserByIdentity gets the user an identity is linked to.
+// Returns nil if the identity is not linked to any user with ErrNotFound.
+func (uc *UserCollection) GetUserByIdentity(provider string, subject string) (*User, error) {
+	query := "SELECT " + userColumns("u.") + " FROM users u JOIN user_identities i ON i.user_id = u.id WHERE i.provider = ? AND i.subject = ?"
+
+	u, err := scanUser(uc.DB.QueryRow(uc.Dialect.Rebind(query), provider, subject))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("identity %s/%s: %w", provider, subject, ErrNotFound)
+	}
+	if err != nil {
+		log.Printf("Failed to get user by identity: %s", err.Error())
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// GetUserIdentities gets the identities linked to a User of a given userID, ordered by ID.
+func (uc *UserCollection) GetUserIdentities(userID int) ([]*UserIdentity, error) {
+	query := "SELECT id, user_id, provider, subject, email, created_at FROM user_identities WHERE user_id = ? ORDER BY id"
+
+	rows, err := uc.DB.Query(uc.Dialect.Rebind(query), userID)
+	if err != nil {
+		log.Printf("Failed to get user identities: %s", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []*UserIdentity{}
+	for rows.Next() {
+		var i UserIdentity
+		if err := rows.Scan(&i.ID, &i.UserID, &i.Provider, &i.Subject, &i.Email, &i.CreatedAt); err != nil {
+			log.Printf("Failed to scan user identity: %s", err.Error())
+			return nil, err
+		}
+		identities = append(identities, &i)
+	}
+
+	return identities, rows.Err()
+}
+
+// LinkIdentity links an identity to a User of a given userID, i is modified with its ID and UserID.
+// Returns ErrConflict if the identity is already linked, to this user or another one.
+func (uc *UserCollection) LinkIdentity(userID int, i *UserIdentity) error {
+	tx, err := uc.DB.Begin()
+	if err != nil {
+		log.Printf("Failed to begin transaction: %s", err.Error())
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := uc.linkIdentity(tx, userID, i); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UnlinkIdentity unlinks an identity from a User of a given userID.
+// Returns ErrNotFound if the identity is not linked to the user,
+// or ErrConflict if it is the last one, the user would not be able to sign in anymore.
+func (uc *UserCollection) UnlinkIdentity(userID int, identityID int) error {
+	tx, err := uc.DB.Begin()
+	if err != nil {
+		log.Printf("Failed to begin transaction: %s", err.Error())
+		return err
+	}
+	defer tx.Rollback()
+
+	var count int
+	query := "SELECT COUNT(*) FROM user_identities WHERE user_id = ?"
+	if err := tx.QueryRow(uc.Dialect.Rebind(query), userID).Scan(&count); err != nil {
+		log.Printf("Failed to count user identities: %s", err.Error())
+		return err
+	}
+
+	query = "DELETE FROM user_identities WHERE id = ? AND user_id = ?"
+	result, err := tx.Exec(uc.Dialect.Rebind(query), identityID, userID)
+	if err != nil {
+		log.Printf("Failed to unlink user identity: %s", err.Error())
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("Failed to get rows affected: %s", err.Error())
+		return err
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("identity %d: %w", identityID, ErrNotFound)
+	}
+	if count <= 1 {
+		return fmt.Errorf("identity %d is the last one of user %d: %w", identityID, userID, ErrConflict)
+	}
+
+	return tx.Commit()
+}
+
+// linkIdentity links an identity within the transaction of the caller
+func (uc *UserCollection) linkIdentity(tx *sql.Tx, userID int, i *UserIdentity) error {
+	var count int
+	query := "SELECT COUNT(*) FROM user_identities WHERE provider = ? AND subject = ?"
+	if err := tx.QueryRow(uc.Dialect.Rebind(query), i.Provider, i.Subject).Scan(&count); err != nil {
+		log.Printf("Failed to look up user identity: %s", err.Error())
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("identity %s/%s already linked: %w", i.Provider, i.Subject, ErrConflict)
+	}
+
+	if i.CreatedAt.IsZero() {
+		i.CreatedAt = Now()
+	}
+
+	query = "INSERT INTO user_identities (user_id, provider, subject, email, created_at) VALUES (?, ?, ?, ?, ?)"
+	id, err := uc.Dialect.InsertReturningID(tx, query, userID, i.Provider, i.Subject, i.Email, i.CreatedAt)
+	if err != nil {
+		log.Printf("Failed to link user identity: %s", err.Error())
+		return err
+	}
+	i.ID = int(id)
+	i.UserID = userID
+
+	return nil
+}
+
+// GetUserByIdentity gets the user an identity is linked to.
+func (s *MemoryUserStore) GetUserByIdentity(provider string, subject string) (*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, i := range s.identities {
+		if i.Provider == provider && i.Subject == subject {
+			return copyUser(s.users[i.UserID]), nil
+		}
+	}
+
+	return nil, fmt.Errorf("identity %s/%s: %w", provider, subject, ErrNotFound)
+}
+
+// GetUserIdentities gets the identities linked to a User of a given userID, ordered by ID.
+func (s *MemoryUserStore) GetUserIdentities(userID int) ([]*UserIdentity, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	identities := []*UserIdentity{}
+	for _, i := range s.identities {
+		if i.UserID == userID {
+			i := i
+			identities = append(identities, &i)
+		}
+	}
+
+	return identities, nil
+}
+
+// LinkIdentity links an identity to a User of a given userID, i is modified with its ID and UserID.
+func (s *MemoryUserStore) LinkIdentity(userID int, i *UserIdentity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.linkIdentity(userID, i)
+}
+
+// UnlinkIdentity unlinks an identity from a User of a given userID.
+func (s *MemoryUserStore) UnlinkIdentity(userID int, identityID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index, count := -1, 0
+	for n, i := range s.identities {
+		if i.UserID == userID {
+			count++
+			if i.ID == identityID {
+				index = n
+			}
+		}
+	}
+
+	if index < 0 {
+		return fmt.Errorf("identity %d: %w", identityID, ErrNotFound)
+	}
+	if count <= 1 {
+		return fmt.Errorf("identity %d is the last one of user %d: %w", identityID, userID, ErrConflict)
+	}
+
+	s.identities = append(s.identities[:index], s.identities[index+1:]...)
+
+	return nil
+}
+
+// linkIdentity links an identity, the caller holds the lock
+func (s *MemoryUserStore) linkIdentity(userID int, i *UserIdentity) error {
+	for _, linked := range s.identities {
+		if linked.Provider == i.Provider && linked.Subject == i.Subject {
+			return fmt.Errorf("identity %s/%s already linked: %w", i.Provider, i.Subject, ErrConflict)
+		}
+	}
+
+	if i.CreatedAt.IsZero() {
+		i.CreatedAt = Now()
+	}
+
+	s.lastIdentityID++
+	i.ID = s.lastIdentityID
+	i.UserID = userID
+	s.identities = append(s.identities, *i)
+
+	return nil
+}
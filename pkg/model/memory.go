@@ -0,0 +1,636 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryTodoStore is a TodoStore kept in memory.
+// It is safe for concurrent use, meant for tests and ephemeral demo instances.
+// TodoItems are copied in and out so callers never share state with the store.
+type MemoryTodoStore struct {
+	mu     sync.RWMutex
+	items  map[int]TodoItem
+	lastID int
+
+	tags      map[int]Tag
+	lastTagID int
+	// todoTags holds the tag IDs of each TodoItem ID
+	todoTags map[int]map[int]bool
+
+	projects      map[int]Project
+	lastProjectID int
+}
+
+// NewMemoryTodoStore returns an empty MemoryTodoStore.
+func NewMemoryTodoStore() *MemoryTodoStore {
+	return &MemoryTodoStore{items: map[int]TodoItem{}, tags: map[int]Tag{}, todoTags: map[int]map[int]bool{}, projects: map[int]Project{}}
+}
+
+// GetAllTodoItems returns all TodoItems for a User of a given userID, ordered by position.
+func (s *MemoryTodoStore) GetAllTodoItems(userID int) ([]*TodoItem, error) {
+	return s.FindTodoItems(userID, &TodoFilter{})
+}
+
+// FindTodoItems returns the TodoItems selected by a filter for a User of a given userID,
+// ordered by position, after their due date when the filter bounds it, then by ID.
+func (s *MemoryTodoStore) FindTodoItems(userID int, f *TodoFilter) ([]*TodoItem, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var todoItems []*TodoItem
+	for _, t := range s.items {
+		if t.UserID == userID {
+			if t := s.copyOut(t); f.matches(t) {
+				todoItems = append(todoItems, t)
+			}
+		}
+	}
+
+	sort.Slice(todoItems, func(i, j int) bool {
+		if f.HasDueBound() && !todoItems[i].DueAt.Equal(*todoItems[j].DueAt) {
+			return todoItems[i].DueAt.Before(*todoItems[j].DueAt)
+		}
+		return positionLess(todoItems[i], todoItems[j])
+	})
+
+	return todoItems, nil
+}
+
+// copyOut returns a copy of a TodoItem with copies of its Tags and the progress of its subtasks, the lock being held
+func (s *MemoryTodoStore) copyOut(t TodoItem) *TodoItem {
+	tagIDs := s.todoTags[t.ID]
+	t.Tags = s.tagsOf(t.UserID, func(tag *Tag) bool { return tagIDs[tag.ID] })
+	t.Progress = s.progressOf(t.ID)
+
+	return &t
+}
+
+// positionLess reports whether a comes before b in the list of their user, like ORDER BY position, id
+func positionLess(a *TodoItem, b *TodoItem) bool {
+	if a.Position != b.Position {
+		return a.Position < b.Position
+	}
+
+	return a.ID < b.ID
+}
+
+// listOf returns the TodoItems of a User of a given userID in the order of their list, the lock being held
+func (s *MemoryTodoStore) listOf(userID int) []*TodoItem {
+	var todoItems []*TodoItem
+	for _, t := range s.items {
+		if t.UserID == userID {
+			t := t
+			todoItems = append(todoItems, &t)
+		}
+	}
+	sort.Slice(todoItems, func(i, j int) bool { return positionLess(todoItems[i], todoItems[j]) })
+
+	return todoItems
+}
+
+// spreadPositions gives evenly spread positions to the TodoItems of a User of a given userID, the lock being held
+func (s *MemoryTodoStore) spreadPositions(userID int) {
+	todoItems := s.listOf(userID)
+	for i, position := range SpreadRanks(len(todoItems)) {
+		t := s.items[todoItems[i].ID]
+		t.Position = position
+		s.items[t.ID] = t
+	}
+}
+
+// appendedPosition returns the position after the last TodoItem of a User of a given userID, the lock being held
+func (s *MemoryTodoStore) appendedPosition(userID int) string {
+	last := ""
+	if todoItems := s.listOf(userID); len(todoItems) > 0 {
+		last = todoItems[len(todoItems)-1].Position
+	}
+
+	position, _ := RankBetween(last, "")
+	if len(position) > MaxRankLength {
+		s.spreadPositions(userID)
+		return s.appendedPosition(userID)
+	}
+
+	return position
+}
+
+// GetTodoItem returns a TodoItem by its ID for a User of a given userID.
+func (s *MemoryTodoStore) GetTodoItem(userID int, todoItemID int) (*TodoItem, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	t, ok := s.items[todoItemID]
+	if !ok || t.UserID != userID {
+		return nil, fmt.Errorf("todo item %d: %w", todoItemID, ErrNotFound)
+	}
+
+	return s.copyOut(t), nil
+}
+
+// CreateTodoItem stores a new TodoItem for a User of a given userID.
+// Same as the SQL implementation, ID, UserID and the timestamps are set by the store.
+func (s *MemoryTodoStore) CreateTodoItem(userID int, t *TodoItem) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var parent TodoItem
+	if t.ParentID != nil {
+		var ok bool
+		if parent, ok = s.items[*t.ParentID]; !ok || parent.UserID != userID {
+			return errParentNotFound()
+		}
+		parentID := *t.ParentID
+		t.ParentID = &parentID
+	}
+
+	switch {
+	case t.ProjectID != nil:
+		if !s.hasProject(userID, *t.ProjectID) {
+			return errProjectNotFound()
+		}
+		projectID := *t.ProjectID
+		t.ProjectID = &projectID
+	case t.ParentID != nil:
+		t.ProjectID = parent.ProjectID
+	default:
+		if inbox, ok := s.inboxOf(userID); ok {
+			t.ProjectID = &inbox.ID
+		}
+	}
+
+	s.lastID++
+	now := Now()
+	t.ID = s.lastID
+	t.UserID = userID
+	t.CreatedAt = now
+	t.UpdatedAt = now
+	t.CompletedAt = nil
+	if t.Completed {
+		t.CompletedAt = &now
+	}
+	if t.DueAt != nil {
+		dueAt := t.DueAt.UTC()
+		t.DueAt = &dueAt
+	}
+	if t.Priority == "" {
+		t.Priority = PriorityNone
+	}
+	t.Position = s.appendedPosition(userID)
+	t.Tags = []*Tag{}
+	t.Progress = nil
+	t.Children = nil
+	s.items[t.ID] = *t
+
+	return nil
+}
+
+// UpdateTodoItem applies a partial update to a TodoItem for a User of a given userID.
+func (s *MemoryTodoStore) UpdateTodoItem(userID int, todoItemID int, u *TodoItemUpdate) (*TodoItem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.items[todoItemID]
+	if !ok || t.UserID != userID {
+		return nil, fmt.Errorf("todo item %d: %w", todoItemID, ErrNotFound)
+	}
+	if u.ProjectID != nil && !s.hasProject(userID, *u.ProjectID) {
+		return nil, errProjectNotFound()
+	}
+	if u.ParentID != nil {
+		if err := s.checkParent(userID, todoItemID, *u.ParentID); err != nil {
+			return nil, err
+		}
+	}
+
+	if u.Title != nil {
+		t.Title = *u.Title
+	}
+	now := Now()
+	if u.Priority != nil {
+		t.Priority = *u.Priority
+	}
+	if u.Completed != nil {
+		t.Completed = *u.Completed
+		if !t.Completed {
+			t.CompletedAt = nil
+		} else if t.CompletedAt == nil {
+			t.CompletedAt = &now
+		}
+	}
+	if u.DueAt != nil {
+		dueAt := u.DueAt.UTC()
+		t.DueAt = &dueAt
+	} else if u.ClearDueAt {
+		t.DueAt = nil
+	}
+	if u.ProjectID != nil {
+		projectID := *u.ProjectID
+		t.ProjectID = &projectID
+	} else if u.ClearProjectID {
+		t.ProjectID = nil
+	}
+	if u.ParentID != nil {
+		parentID := *u.ParentID
+		t.ParentID = &parentID
+	} else if u.ClearParentID {
+		t.ParentID = nil
+	}
+	t.UpdatedAt = now
+	s.items[todoItemID] = t
+
+	return s.copyOut(t), nil
+}
+
+// MarkComplete marks a TodoItem as completed for a User of a given userID,
+// with all its subtasks whatever their depth if withSubtasks.
+func (s *MemoryTodoStore) MarkComplete(userID int, todoItemID int, withSubtasks bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.items[todoItemID]
+	if !ok || t.UserID != userID {
+		return fmt.Errorf("todo item %d: %w", todoItemID, ErrNotFound)
+	}
+
+	now := Now()
+	t.Completed = true
+	if t.CompletedAt == nil {
+		t.CompletedAt = &now
+	}
+	t.UpdatedAt = now
+	s.items[todoItemID] = t
+
+	if withSubtasks {
+		s.completeSubtasks(todoItemID, now)
+	}
+
+	return nil
+}
+
+// MoveTodoItem moves a TodoItem for a User of a given userID, right after or right before another of their TodoItems.
+func (s *MemoryTodoStore) MoveTodoItem(userID int, todoItemID int, m *TodoItemMove) (*TodoItem, error) {
+	if err := m.Validate(todoItemID); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.items[todoItemID]
+	if !ok || t.UserID != userID {
+		return nil, fmt.Errorf("todo item %d: %w", todoItemID, ErrNotFound)
+	}
+	_, anchorID := m.anchor()
+	if anchor, ok := s.items[anchorID]; !ok || anchor.UserID != userID {
+		return nil, m.errAnchorNotFound()
+	}
+
+	position := s.movedPosition(userID, todoItemID, m)
+	if position == "" {
+		s.spreadPositions(userID)
+		position = s.movedPosition(userID, todoItemID, m)
+	}
+	if position == "" {
+		return nil, errNoRoom
+	}
+
+	t = s.items[todoItemID]
+	t.Position = position
+	t.UpdatedAt = Now()
+	s.items[todoItemID] = t
+
+	return s.copyOut(t), nil
+}
+
+// movedPosition returns the position between the neighbours of a TodoItem of a given todoItemID once moved,
+// or an empty string if there is no room between them, the lock being held
+func (s *MemoryTodoStore) movedPosition(userID int, todoItemID int, m *TodoItemMove) string {
+	_, anchorID := m.anchor()
+
+	// The list without the moved item
+	var todoItems []*TodoItem
+	for _, t := range s.listOf(userID) {
+		if t.ID != todoItemID {
+			todoItems = append(todoItems, t)
+		}
+	}
+
+	prev, next := "", ""
+	for i, t := range todoItems {
+		if t.ID != anchorID {
+			continue
+		}
+		if m.AfterID != nil {
+			prev = t.Position
+			if i+1 < len(todoItems) {
+				next = todoItems[i+1].Position
+			}
+		} else {
+			next = t.Position
+			if i > 0 {
+				prev = todoItems[i-1].Position
+			}
+		}
+	}
+
+	position, ok := RankBetween(prev, next)
+	if !ok || len(position) > MaxRankLength {
+		return ""
+	}
+
+	return position
+}
+
+// DeleteTodoItem deletes a TodoItem by its ID for a User of a given userID, with all its subtasks.
+func (s *MemoryTodoStore) DeleteTodoItem(userID int, todoItemID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.items[todoItemID]
+	if !ok || t.UserID != userID {
+		return fmt.Errorf("todo item %d: %w", todoItemID, ErrNotFound)
+	}
+
+	for _, id := range append(s.subtasksOf(todoItemID), todoItemID) {
+		delete(s.items, id)
+		delete(s.todoTags, id)
+	}
+
+	return nil
+}
+
+// CountTodoItems counts the TodoItems of a User of a given userID, and those completed among them.
+func (s *MemoryTodoStore) CountTodoItems(userID int) (int, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	total, completed := 0, 0
+	for _, t := range s.items {
+		if t.UserID == userID {
+			total++
+			if t.Completed {
+				completed++
+			}
+		}
+	}
+
+	return total, completed, nil
+}
+
+// DeleteUserData deletes every TodoItem, Tag and Project of a User of a given userID, when the user is deleted.
+func (s *MemoryTodoStore) DeleteUserData(userID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, t := range s.items {
+		if t.UserID == userID {
+			delete(s.items, id)
+			delete(s.todoTags, id)
+		}
+	}
+	for id, t := range s.tags {
+		if t.UserID == userID {
+			delete(s.tags, id)
+		}
+	}
+	for id, p := range s.projects {
+		if p.UserID == userID {
+			delete(s.projects, id)
+		}
+	}
+}
+
+// MemoryUserStore is a UserStore kept in memory.
+// It is safe for concurrent use, meant for tests and ephemeral demo instances.
+type MemoryUserStore struct {
+	mu     sync.RWMutex
+	users  map[int]User
+	lastID int
+
+	identities     []UserIdentity
+	lastIdentityID int
+
+	// Cascade are the other in-memory stores keeping data of the users,
+	// deleted along with them like the SQL implementation does
+	Cascade []UserDataDeleter
+}
+
+// UserDataDeleter is implemented by the in-memory stores keeping data of the users.
+type UserDataDeleter interface {
+	DeleteUserData(userID int)
+}
+
+// NewMemoryUserStore returns an empty MemoryUserStore.
+func NewMemoryUserStore() *MemoryUserStore {
+	return &MemoryUserStore{users: map[int]User{}}
+}
+
+// copyUser returns a copy of u not sharing its preferences
+func copyUser(u User) *User {
+	preferences := make(map[string]interface{}, len(u.Preferences))
+	for key, value := range u.Preferences {
+		preferences[key] = value
+	}
+	u.Preferences = preferences
+
+	return &u
+}
+
+// GetUserByID gets a user by ID.
+// Returns nil if no user is found with ErrNotFound.
+func (s *MemoryUserStore) GetUserByID(userID int) (*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	u, ok := s.users[userID]
+	if !ok {
+		return nil, fmt.Errorf("user %d: %w", userID, ErrNotFound)
+	}
+
+	return copyUser(u), nil
+}
+
+// GetUserByEmail gets a user by Email.
+// Returns nil if no user is found with ErrNotFound.
+func (s *MemoryUserStore) GetUserByEmail(email string) (*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, u := range s.users {
+		if u.Email == email {
+			return copyUser(u), nil
+		}
+	}
+
+	return nil, fmt.Errorf("user %s: %w", email, ErrNotFound)
+}
+
+// CreateUser stores a new user along with its identity, u is modified with the new user's ID.
+func (s *MemoryUserStore) CreateUser(u *User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	identity := &UserIdentity{Provider: u.OAuthProvider, Subject: u.OAuthID, Email: u.Email}
+	if err := s.linkIdentity(s.lastID+1, identity); err != nil {
+		return err
+	}
+
+	if u.Role == "" {
+		u.Role = RoleUser
+	}
+
+	s.lastID++
+	u.ID = s.lastID
+	s.users[u.ID] = *copyUser(*u)
+
+	return nil
+}
+
+// UpdateUser applies a profile update to a User of a given userID and returns the updated user.
+func (s *MemoryUserStore) UpdateUser(userID int, update *UserUpdate) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[userID]
+	if !ok {
+		return nil, fmt.Errorf("user %d: %w", userID, ErrNotFound)
+	}
+
+	updated := copyUser(u)
+	if err := update.apply(updated); err != nil {
+		return nil, err
+	}
+	s.users[userID] = *updated
+
+	return copyUser(*updated), nil
+}
+
+// ScheduleUserDeletion schedules the purge of a User of a given userID at the given time,
+// or cancels it with a nil time.
+func (s *MemoryUserStore) ScheduleUserDeletion(userID int, at *time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[userID]
+	if !ok {
+		return fmt.Errorf("user %d: %w", userID, ErrNotFound)
+	}
+
+	if at != nil {
+		scheduledAt := *at
+		at = &scheduledAt
+	}
+	u.DeletionScheduledAt = at
+	s.users[userID] = u
+
+	return nil
+}
+
+// SetUserRole gives a role to a User of a given userID.
+func (s *MemoryUserStore) SetUserRole(userID int, role string) error {
+	if err := ValidateRole(role); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[userID]
+	if !ok {
+		return fmt.Errorf("user %d: %w", userID, ErrNotFound)
+	}
+	u.Role = role
+	s.users[userID] = u
+
+	return nil
+}
+
+// SuspendUser suspends a User of a given userID from the given time, or reactivates them with a nil time.
+func (s *MemoryUserStore) SuspendUser(userID int, at *time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[userID]
+	if !ok {
+		return fmt.Errorf("user %d: %w", userID, ErrNotFound)
+	}
+
+	if at != nil {
+		suspendedAt := *at
+		at = &suspendedAt
+	}
+	u.SuspendedAt = at
+	s.users[userID] = u
+
+	return nil
+}
+
+// SearchUsers gets the users selected by the filter, ordered by ID.
+func (s *MemoryUserStore) SearchUsers(f *UserFilter) ([]*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	users := []*User{}
+	for _, u := range s.users {
+		u := u
+		if f.matches(&u) {
+			users = append(users, copyUser(u))
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+
+	if f.Offset >= len(users) {
+		return []*User{}, nil
+	}
+	users = users[f.Offset:]
+	if len(users) > f.Limit {
+		users = users[:f.Limit]
+	}
+
+	return users, nil
+}
+
+// GetUsersDueForDeletion gets the IDs of the users whose deletion was scheduled before now.
+func (s *MemoryUserStore) GetUsersDueForDeletion(now time.Time) ([]int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	userIDs := []int{}
+	for id, u := range s.users {
+		if u.DeletionScheduledAt != nil && !u.DeletionScheduledAt.After(now) {
+			userIDs = append(userIDs, id)
+		}
+	}
+	sort.Ints(userIDs)
+
+	return userIDs, nil
+}
+
+// DeleteUser deletes a User of a given userID with their identities,
+// and their data in the Cascade stores.
+func (s *MemoryUserStore) DeleteUser(userID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[userID]; !ok {
+		return fmt.Errorf("user %d: %w", userID, ErrNotFound)
+	}
+
+	for _, store := range s.Cascade {
+		store.DeleteUserData(userID)
+	}
+
+	identities := s.identities[:0]
+	for _, i := range s.identities {
+		if i.UserID != userID {
+			identities = append(identities, i)
+		}
+	}
+	s.identities = identities
+	delete(s.users, userID)
+
+	return nil
+}
@@ -0,0 +1,341 @@
+package model_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mystardustcaptain/mattodo/pkg/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMemoryTodoStore_ScopesItemsToOwner tests that MemoryTodoStore behaves like the SQL implementation,
+// only returning, updating and deleting the TodoItems of the given user.
+func TestMemoryTodoStore_ScopesItemsToOwner(t *testing.T) {
+	/// Arrange
+	///
+	store := model.NewMemoryTodoStore()
+
+	mine := model.TodoItem{UserID: 21, Title: "Mine"} // UserID will be overwritten
+	theirs := model.TodoItem{Title: "Theirs"}
+	store.CreateTodoItem(2, &mine)
+	store.CreateTodoItem(3, &theirs)
+
+	/// Act
+	///
+	todos, err := store.GetAllTodoItems(2)
+	errComplete := store.MarkComplete(2, theirs.ID, false)
+	errDelete := store.DeleteTodoItem(2, theirs.ID)
+
+	/// Assert
+	///
+	assert.NoError(t, err, "Expected no error but got one")
+	assert.Equal(t, 1, len(todos))
+	assert.Equal(t, mine.ID, todos[0].ID)
+	assert.Equal(t, 2, todos[0].UserID, "Expected user ID to be what is system specified instead of what user given")
+	assert.Error(t, errComplete, "Expected an error when completing someone else's todo item")
+	assert.Error(t, errDelete, "Expected an error when deleting someone else's todo item")
+
+	stillThere, err := store.GetTodoItem(3, theirs.ID)
+	assert.NoError(t, err)
+	assert.False(t, stillThere.Completed)
+}
+
+// TestMemoryTodoStore_ReturnsCopies tests that changing a returned TodoItem
+// does not change the stored one.
+func TestMemoryTodoStore_ReturnsCopies(t *testing.T) {
+	store := model.NewMemoryTodoStore()
+	todo := model.TodoItem{Title: "Original"}
+	store.CreateTodoItem(2, &todo)
+
+	got, _ := store.GetTodoItem(2, todo.ID)
+	got.Title = "Changed"
+	todo.Title = "Changed too"
+
+	again, _ := store.GetTodoItem(2, todo.ID)
+	assert.Equal(t, "Original", again.Title)
+}
+
+// TestMemoryTodoStore_FindsDueItems tests that MemoryTodoStore filters and orders by due date like the SQL implementation,
+// and keeps completed_at in line with completed.
+func TestMemoryTodoStore_FindsDueItems(t *testing.T) {
+	/// Arrange
+	///
+	store := model.NewMemoryTodoStore()
+
+	now := time.Date(2030, 1, 15, 12, 0, 0, 0, time.UTC)
+	lastWeek, yesterday := now.AddDate(0, 0, -7), now.AddDate(0, 0, -1)
+	late := model.TodoItem{Title: "Late", DueAt: &yesterday}
+	later := model.TodoItem{Title: "Later", DueAt: &lastWeek}
+	done := model.TodoItem{Title: "Done", DueAt: &lastWeek, Completed: true}
+	undated := model.TodoItem{Title: "Undated"}
+	for _, item := range []*model.TodoItem{&late, &later, &done, &undated} {
+		store.CreateTodoItem(2, item)
+	}
+
+	/// Act
+	///
+	filter := &model.TodoFilter{}
+	filter.Overdue(now)
+	overdue, err := store.FindTodoItems(2, filter)
+
+	reopened := false
+	updated, errUpdate := store.UpdateTodoItem(2, done.ID, &model.TodoItemUpdate{Completed: &reopened})
+
+	/// Assert
+	///
+	assert.NoError(t, err, "Expected no error but got one")
+	if assert.Equal(t, 2, len(overdue), "Expected the completed and undated items to be left out") {
+		assert.Equal(t, later.ID, overdue[0].ID, "Expected the items ordered by due date")
+		assert.Equal(t, late.ID, overdue[1].ID)
+	}
+
+	assert.NotNil(t, done.CompletedAt, "Expected an item created completed to have a completion time")
+	assert.NoError(t, errUpdate)
+	assert.Nil(t, updated.CompletedAt, "Expected the completion time to be cleared when reopened")
+}
+
+// TestMemoryTodoStore_MovesItems tests that MemoryTodoStore appends the new items and moves them
+// among the items of their user only, like the SQL implementation.
+func TestMemoryTodoStore_MovesItems(t *testing.T) {
+	/// Arrange
+	///
+	store := model.NewMemoryTodoStore()
+
+	first, second, third := model.TodoItem{Title: "First"}, model.TodoItem{Title: "Second"}, model.TodoItem{Title: "Third"}
+	theirs := model.TodoItem{Title: "Theirs"}
+	for _, item := range []*model.TodoItem{&first, &second, &third} {
+		store.CreateTodoItem(2, item)
+	}
+	store.CreateTodoItem(3, &theirs)
+
+	titlesOf := func() []string {
+		todos, _ := store.GetAllTodoItems(2)
+		titles := []string{}
+		for _, todo := range todos {
+			titles = append(titles, todo.Title)
+		}
+		return titles
+	}
+
+	/// Act
+	///
+	created := titlesOf()
+	_, errAfter := store.MoveTodoItem(2, third.ID, &model.TodoItemMove{AfterID: &first.ID})
+	afterFirst := titlesOf()
+	_, errBefore := store.MoveTodoItem(2, first.ID, &model.TodoItemMove{BeforeID: &second.ID})
+	beforeSecond := titlesOf()
+	_, errTheirs := store.MoveTodoItem(2, first.ID, &model.TodoItemMove{AfterID: &theirs.ID})
+	_, errItself := store.MoveTodoItem(2, first.ID, &model.TodoItemMove{AfterID: &first.ID})
+
+	/// Assert
+	///
+	assert.Equal(t, []string{"First", "Second", "Third"}, created, "Expected the items to be appended")
+	assert.NoError(t, errAfter)
+	assert.Equal(t, []string{"First", "Third", "Second"}, afterFirst)
+	assert.NoError(t, errBefore)
+	assert.Equal(t, []string{"Third", "First", "Second"}, beforeSecond)
+	assert.ErrorIs(t, errTheirs, model.ErrValidation, "Expected an item of another user to be refused")
+	assert.ErrorIs(t, errItself, model.ErrValidation, "Expected an item not to be moved next to itself")
+}
+
+// TestMemoryTodoStore_TagsItems tests that MemoryTodoStore behaves like the SQL implementation with the tags,
+// scoped to their owner, unique whatever their case, and selecting the items with any or all of them.
+func TestMemoryTodoStore_TagsItems(t *testing.T) {
+	/// Arrange
+	///
+	store := model.NewMemoryTodoStore()
+
+	first := model.TodoItem{Title: "First"}
+	second := model.TodoItem{Title: "Second"}
+	theirs := model.TodoItem{Title: "Theirs"}
+	store.CreateTodoItem(2, &first)
+	store.CreateTodoItem(2, &second)
+	store.CreateTodoItem(3, &theirs)
+
+	work := model.Tag{Name: "Work"}
+	home := model.Tag{Name: "Home"}
+	store.CreateTag(2, &work)
+	store.CreateTag(2, &home)
+
+	/// Act
+	///
+	errConflict := store.CreateTag(2, &model.Tag{Name: "WORK"})
+	errTheirs := store.AttachTag(3, theirs.ID, work.ID)
+	store.AttachTag(2, first.ID, work.ID)
+	store.AttachTag(2, second.ID, work.ID)
+	store.AttachTag(2, second.ID, home.ID)
+	anyTag, _ := store.FindTodoItems(2, &model.TodoFilter{Tags: []string{"home", "work"}})
+	allTags, _ := store.FindTodoItems(2, &model.TodoFilter{Tags: []string{"home", "work"}, AllTags: true})
+	store.DeleteTag(2, home.ID)
+	afterDelete, _ := store.GetTodoItem(2, second.ID)
+
+	/// Assert
+	///
+	assert.ErrorIs(t, errConflict, model.ErrConflict, "Expected the names to be unique whatever their case")
+	assert.ErrorIs(t, errTheirs, model.ErrNotFound, "Expected the tag of another user to be refused")
+	assert.Equal(t, 2, len(anyTag))
+	if assert.Equal(t, 1, len(allTags)) {
+		assert.Equal(t, second.ID, allTags[0].ID)
+		assert.Equal(t, []string{"Home", "Work"}, []string{allTags[0].Tags[0].Name, allTags[0].Tags[1].Name}, "Expected the tags ordered by name")
+	}
+	assert.Equal(t, 1, len(afterDelete.Tags), "Expected a deleted tag to be detached")
+}
+
+// TestMemoryTodoStore_ConcurrentCreate tests that concurrent creations get distinct IDs.
+// Run with -race to check the locking.
+func TestMemoryTodoStore_ConcurrentCreate(t *testing.T) {
+	store := model.NewMemoryTodoStore()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			store.CreateTodoItem(2, &model.TodoItem{Title: "Concurrent"})
+			store.GetAllTodoItems(2)
+		}()
+	}
+	wg.Wait()
+
+	todos, _ := store.GetAllTodoItems(2)
+	ids := map[int]bool{}
+	for _, todo := range todos {
+		ids[todo.ID] = true
+	}
+	assert.Equal(t, 50, len(ids), "Expected every todo item to get its own ID")
+}
+
+// TestMemoryUserStore_LinksIdentities tests that MemoryUserStore behaves like the SQL implementation,
+// an identity is linked to a single user and the last identity of a user cannot be unlinked.
+func TestMemoryUserStore_LinksIdentities(t *testing.T) {
+	/// Arrange
+	///
+	store := model.NewMemoryUserStore()
+	alice := model.User{OAuthProvider: "github", OAuthID: "42", Name: "Alice", Email: "alice@example.com"}
+	bob := model.User{OAuthProvider: "google", OAuthID: "abc", Name: "Bob", Email: "bob@example.com"}
+	store.CreateUser(&alice)
+	store.CreateUser(&bob)
+
+	/// Act
+	///
+	google := model.UserIdentity{Provider: "google", Subject: "def", Email: "alice@example.com"}
+	errLink := store.LinkIdentity(alice.ID, &google)
+	errTaken := store.LinkIdentity(alice.ID, &model.UserIdentity{Provider: "google", Subject: "abc"})
+	found, errFound := store.GetUserByIdentity("google", "def")
+	errOthers := store.UnlinkIdentity(alice.ID, 2) // bob's
+	errUnlink := store.UnlinkIdentity(alice.ID, google.ID)
+	identities, _ := store.GetUserIdentities(alice.ID)
+	errLast := store.UnlinkIdentity(alice.ID, identities[0].ID)
+
+	/// Assert
+	///
+	assert.NoError(t, errLink, "Expected no error but got one")
+	assert.ErrorIs(t, errTaken, model.ErrConflict, "Expected the identity of another user to be refused")
+	assert.NoError(t, errFound)
+	assert.Equal(t, alice.ID, found.ID)
+	assert.ErrorIs(t, errOthers, model.ErrNotFound, "Expected the identity of another user not to be found")
+	assert.NoError(t, errUnlink)
+	assert.ErrorIs(t, errLast, model.ErrConflict, "Expected the last identity to be kept")
+}
+
+// TestMemoryUserStore_DeleteUserCascades tests that MemoryUserStore behaves like the SQL implementation,
+// deleting the data of the user in the other stores along with them.
+func TestMemoryUserStore_DeleteUserCascades(t *testing.T) {
+	/// Arrange
+	///
+	todos := model.NewMemoryTodoStore()
+	store := model.NewMemoryUserStore()
+	store.Cascade = []model.UserDataDeleter{todos}
+
+	alice := model.User{OAuthProvider: "github", OAuthID: "42", Name: "Alice", Email: "alice@example.com"}
+	bob := model.User{OAuthProvider: "github", OAuthID: "43", Name: "Bob", Email: "bob@example.com"}
+	store.CreateUser(&alice)
+	store.CreateUser(&bob)
+	todos.CreateTodoItem(alice.ID, &model.TodoItem{Title: "Alice's"})
+	todos.CreateTodoItem(bob.ID, &model.TodoItem{Title: "Bob's"})
+
+	/// Act
+	///
+	err := store.DeleteUser(alice.ID)
+
+	/// Assert
+	///
+	assert.NoError(t, err, "Expected no error but got one")
+
+	_, err = store.GetUserByIdentity("github", "42")
+	assert.ErrorIs(t, err, model.ErrNotFound)
+	aliceTodos, _ := todos.GetAllTodoItems(alice.ID)
+	assert.Empty(t, aliceTodos)
+	bobTodos, _ := todos.GetAllTodoItems(bob.ID)
+	assert.Equal(t, 1, len(bobTodos), "Expected the data of other users to be kept")
+}
+
+// TestMemoryPersonalAccessTokenStore_ScopesTokensToOwner tests that MemoryPersonalAccessTokenStore
+// lists and revokes the tokens of their owner only.
+func TestMemoryPersonalAccessTokenStore_ScopesTokensToOwner(t *testing.T) {
+	/// Arrange
+	///
+	store := model.NewMemoryPersonalAccessTokenStore()
+	alice := model.PersonalAccessToken{UserID: 1, Name: "CI", TokenHash: "alice", Scopes: []string{"todo:read"}}
+	bob := model.PersonalAccessToken{UserID: 2, Name: "CI", TokenHash: "bob", Scopes: []string{"todo:read"}}
+	store.CreatePersonalAccessToken(&alice)
+	store.CreatePersonalAccessToken(&bob)
+
+	/// Act
+	///
+	errOthers := store.DeletePersonalAccessToken(1, bob.ID)
+	errOwn := store.DeletePersonalAccessToken(1, alice.ID)
+
+	/// Assert
+	///
+	assert.ErrorIs(t, errOthers, model.ErrNotFound, "Expected the token of another user not to be found")
+	assert.NoError(t, errOwn)
+	aliceTokens, _ := store.GetPersonalAccessTokens(1)
+	assert.Empty(t, aliceTokens)
+	_, err := store.GetPersonalAccessTokenByHash("bob")
+	assert.NoError(t, err, "Expected the token of the other user to be kept")
+}
+
+// TestMemoryUserStore_SearchUsers tests that MemoryUserStore searches like the SQL implementation,
+// case-insensitively on the name or the email, by role, and paged in ID order.
+func TestMemoryUserStore_SearchUsers(t *testing.T) {
+	/// Arrange
+	///
+	store := model.NewMemoryUserStore()
+	alice := model.User{OAuthProvider: "github", OAuthID: "1", Name: "Alice", Email: "alice@example.com", Role: model.RoleAdmin}
+	bob := model.User{OAuthProvider: "github", OAuthID: "2", Name: "Bob", Email: "bob@example.org"}
+	store.CreateUser(&alice)
+	store.CreateUser(&bob)
+
+	tests := []struct {
+		name   string
+		filter model.UserFilter
+		ids    []int
+	}{
+		{"everyone", model.UserFilter{Limit: 10}, []int{alice.ID, bob.ID}},
+		{"by name", model.UserFilter{Query: "ALI", Limit: 10}, []int{alice.ID}},
+		{"by email", model.UserFilter{Query: "example.org", Limit: 10}, []int{bob.ID}},
+		{"by role", model.UserFilter{Role: model.RoleUser, Limit: 10}, []int{bob.ID}},
+		{"limited", model.UserFilter{Limit: 1}, []int{alice.ID}},
+		{"offset", model.UserFilter{Limit: 10, Offset: 1}, []int{bob.ID}},
+		{"offset past the end", model.UserFilter{Limit: 10, Offset: 5}, []int{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			/// Act
+			///
+			users, err := store.SearchUsers(&tt.filter)
+
+			/// Assert
+			///
+			assert.NoError(t, err)
+			ids := []int{}
+			for _, u := range users {
+				ids = append(ids, u.ID)
+			}
+			assert.Equal(t, tt.ids, ids)
+		})
+	}
+}
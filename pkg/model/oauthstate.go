@@ -0,0 +1,142 @@
+package model
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mystardustcaptain/mattodo/pkg/database"
+)
+
+// OAuthState is a pending OAuth login.
+// It is issued when the login starts and consumed once by the callback.
+type OAuthState struct {
+	State        string    `json:"state"`         // random value round-tripped through the provider
+	Provider     string    `json:"provider"`      // provider the login was started for
+	BindingHash  string    `json:"binding_hash"`  // hash of the value kept in the browser cookie
+	CodeVerifier string    `json:"code_verifier"` // PKCE verifier, only sent to the provider with the code exchange
+	LinkUserID   int       `json:"link_user_id"`  // user linking the provider to their account, 0 for a login
+	ExpiresAt    time.Time `json:"expires_at"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type OAuthStateCollection struct {
+	DB      *sql.DB
+	Dialect database.Dialect
+}
+
+// SaveOAuthState stores a new pending login.
+func (sc *OAuthStateCollection) SaveOAuthState(s *OAuthState) error {
+	query := "INSERT INTO oauth_states (state, provider, binding_hash, code_verifier, link_user_id, expires_at, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)"
+
+	_, err := sc.DB.Exec(sc.Dialect.Rebind(query), s.State, s.Provider, s.BindingHash, s.CodeVerifier, s.LinkUserID, s.ExpiresAt, s.CreatedAt)
+	if err != nil {
+		log.Printf("Failed to save oauth state: %s", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// ConsumeOAuthState removes a pending login and returns it.
+// A state can only be consumed once, concurrent callers racing on the same state get ErrNotFound.
+// Returns ErrNotFound if the state does not exist, expired states are returned as is for the caller to check.
+func (sc *OAuthStateCollection) ConsumeOAuthState(state string) (*OAuthState, error) {
+	query := "SELECT state, provider, binding_hash, code_verifier, link_user_id, expires_at, created_at FROM oauth_states WHERE state = ?"
+
+	s := OAuthState{}
+	err := sc.DB.QueryRow(sc.Dialect.Rebind(query), state).Scan(&s.State, &s.Provider, &s.BindingHash, &s.CodeVerifier, &s.LinkUserID, &s.ExpiresAt, &s.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("oauth state: %w", ErrNotFound)
+	}
+	if err != nil {
+		log.Printf("Failed to get oauth state: %s", err.Error())
+		return nil, err
+	}
+
+	// Only the caller that actually deletes the row gets to use it
+	result, err := sc.DB.Exec(sc.Dialect.Rebind("DELETE FROM oauth_states WHERE state = ?"), state)
+	if err != nil {
+		log.Printf("Failed to delete oauth state: %s", err.Error())
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("Failed to get rows affected: %s", err.Error())
+		return nil, err
+	}
+	if rowsAffected == 0 {
+		return nil, fmt.Errorf("oauth state: %w", ErrNotFound)
+	}
+
+	return &s, nil
+}
+
+// DeleteExpiredOAuthStates removes the logins that were never completed.
+func (sc *OAuthStateCollection) DeleteExpiredOAuthStates(now time.Time) error {
+	query := "DELETE FROM oauth_states WHERE expires_at < ?"
+
+	if _, err := sc.DB.Exec(sc.Dialect.Rebind(query), now); err != nil {
+		log.Printf("Failed to delete expired oauth states: %s", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// MemoryOAuthStateStore is an OAuthStateStore kept in memory.
+// It is safe for concurrent use, but only suits a single instance of the service.
+type MemoryOAuthStateStore struct {
+	mu     sync.Mutex
+	states map[string]OAuthState
+}
+
+// NewMemoryOAuthStateStore returns an empty MemoryOAuthStateStore.
+func NewMemoryOAuthStateStore() *MemoryOAuthStateStore {
+	return &MemoryOAuthStateStore{states: map[string]OAuthState{}}
+}
+
+// SaveOAuthState stores a new pending login.
+func (ms *MemoryOAuthStateStore) SaveOAuthState(s *OAuthState) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.states[s.State]; ok {
+		return fmt.Errorf("oauth state: %w", ErrConflict)
+	}
+	ms.states[s.State] = *s
+
+	return nil
+}
+
+// ConsumeOAuthState removes a pending login and returns it.
+// Returns ErrNotFound if the state does not exist.
+func (ms *MemoryOAuthStateStore) ConsumeOAuthState(state string) (*OAuthState, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	s, ok := ms.states[state]
+	if !ok {
+		return nil, fmt.Errorf("oauth state: %w", ErrNotFound)
+	}
+	delete(ms.states, state)
+
+	return &s, nil
+}
+
+// DeleteExpiredOAuthStates removes the logins that were never completed.
+func (ms *MemoryOAuthStateStore) DeleteExpiredOAuthStates(now time.Time) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for state, s := range ms.states {
+		if s.ExpiresAt.Before(now) {
+			delete(ms.states, state)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,503 @@
+package model
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mystardustcaptain/mattodo/pkg/database"
+)
+
+// Project is a named list grouping TodoItems of a User, a TodoItem belongs to at most one project.
+// Every user has an inbox, created with their account, where the TodoItems go unless given another project.
+type Project struct {
+	ID     int    `json:"id"`
+	UserID int    `json:"user_id"`
+	Name   string `json:"name"`
+	// Inbox is true for the default project of the user, which cannot be deleted
+	Inbox     bool      `json:"inbox"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// InboxName is the name the inbox of a User is created with, it can be renamed.
+const InboxName = "Inbox"
+
+// MaxProjectNameLength is the maximum number of characters of a Project name.
+const MaxProjectNameLength = 100
+
+// ValidateProjectName trims a Project name and checks it is neither empty nor too long.
+// Returns the trimmed name, or a ValidationError for the name field.
+func ValidateProjectName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+
+	if name == "" {
+		return "", NewValidationError("name", "must not be empty")
+	}
+	if utf8.RuneCountInString(name) > MaxProjectNameLength {
+		return "", NewValidationError("name", fmt.Sprintf("must be at most %d characters", MaxProjectNameLength))
+	}
+
+	return name, nil
+}
+
+// Validate checks the user provided fields of a Project before it is created.
+// The name is trimmed in place.
+func (p *Project) Validate() error {
+	name, err := ValidateProjectName(p.Name)
+	if err != nil {
+		return err
+	}
+	p.Name = name
+
+	return nil
+}
+
+// ProjectUpdate holds the mutable fields of a Project for a partial update.
+// Fields left nil are not touched.
+type ProjectUpdate struct {
+	Name *string `json:"name"`
+}
+
+// IsEmpty reports whether the update does not change any field.
+func (u *ProjectUpdate) IsEmpty() bool {
+	return u.Name == nil
+}
+
+// Validate checks the fields given in a partial update.
+// The name, if given, is trimmed in place.
+func (u *ProjectUpdate) Validate() error {
+	if u.Name != nil {
+		name, err := ValidateProjectName(*u.Name)
+		if err != nil {
+			return err
+		}
+		u.Name = &name
+	}
+
+	return nil
+}
+
+// errProjectNameTaken is returned when a User already has a Project of that name
+func errProjectNameTaken(name string) error {
+	return fmt.Errorf("project %q already exists: %w", name, ErrConflict)
+}
+
+// errProjectNotFound is returned when a TodoItem is given a Project which is not one of the User
+func errProjectNotFound() error {
+	return NewValidationError("project_id", "must be one of your projects")
+}
+
+// projectColumns are the columns of the projects table scanned by scanProject
+const projectColumns = "id, user_id, name, inbox, created_at, updated_at"
+
+// scanProject scans a row of projectColumns into a Project
+func scanProject(row rowScanner) (*Project, error) {
+	p := Project{}
+	if err := row.Scan(&p.ID, &p.UserID, &p.Name, &p.Inbox, &p.CreatedAt, &p.UpdatedAt); err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// GetProjects gets the Projects of a User of a given userID, the inbox first and then by name.
+func (tc *TodoItemCollection) GetProjects(userID int) ([]*Project, error) {
+	query := "SELECT " + projectColumns + " FROM projects WHERE user_id = ? ORDER BY inbox DESC, name, id"
+
+	rows, err := tc.DB.Query(tc.Dialect.Rebind(query), userID)
+	if err != nil {
+		log.Printf("Failed to get projects: %s", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	projects := []*Project{}
+	for rows.Next() {
+		p, err := scanProject(rows)
+		if err != nil {
+			log.Printf("Failed to scan project: %s", err.Error())
+			return nil, err
+		}
+		projects = append(projects, p)
+	}
+
+	return projects, rows.Err()
+}
+
+// GetProject gets a Project by its ID for a User of a given userID.
+// Returns ErrNotFound if the Project does not exist or does not belong to the user.
+func (tc *TodoItemCollection) GetProject(userID int, projectID int) (*Project, error) {
+	query := "SELECT " + projectColumns + " FROM projects WHERE id = ? AND user_id = ?"
+
+	p, err := scanProject(tc.DB.QueryRow(tc.Dialect.Rebind(query), projectID, userID))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("project %d: %w", projectID, ErrNotFound)
+	}
+	if err != nil {
+		log.Printf("Failed to get project: %s", err.Error())
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// CreateProject creates a Project for a User of a given userID, p is modified with its ID, UserID and timestamps.
+// Project Fields taken: Name
+// Fields ignored: ID, UserID, Inbox, CreatedAt, UpdatedAt
+// Returns ErrConflict if the user already has a Project of that name, whatever its case.
+func (tc *TodoItemCollection) CreateProject(userID int, p *Project) error {
+	p.Inbox = false
+	return tc.insertProject(userID, p)
+}
+
+// CreateInbox creates the inbox of a User of a given userID, when they register.
+// Returns the inbox, the existing one if the user already has one.
+func (tc *TodoItemCollection) CreateInbox(userID int) (*Project, error) {
+	inboxID, err := tc.inboxID(tc.DB, userID)
+	if err != nil {
+		return nil, err
+	}
+	if inboxID.Valid {
+		return tc.GetProject(userID, int(inboxID.Int64))
+	}
+
+	p := &Project{Name: InboxName, Inbox: true}
+	if err := tc.insertProject(userID, p); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// insertProject inserts a Project after checking its name is not taken
+func (tc *TodoItemCollection) insertProject(userID int, p *Project) error {
+	tx, err := tc.DB.Begin()
+	if err != nil {
+		log.Printf("Failed to begin transaction: %s", err.Error())
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := tc.checkProjectName(tx, userID, 0, p.Name); err != nil {
+		return err
+	}
+
+	now := Now()
+	p.UserID = userID
+	p.CreatedAt = now
+	p.UpdatedAt = now
+
+	query := "INSERT INTO projects (user_id, name, inbox, created_at, updated_at) VALUES (?, ?, ?, ?, ?)"
+	id, err := tc.Dialect.InsertReturningID(tx, query, p.UserID, p.Name, p.Inbox, p.CreatedAt, p.UpdatedAt)
+	if database.IsUniqueViolation(err) {
+		return errProjectNameTaken(p.Name)
+	}
+	if err != nil {
+		log.Printf("Failed to create project: %s", err.Error())
+		return err
+	}
+	p.ID = int(id)
+
+	return tx.Commit()
+}
+
+// UpdateProject renames a Project for a User of a given userID.
+// Returns the updated Project, ErrNotFound if the Project could not be found,
+// or ErrConflict if the user already has another Project of the new name.
+func (tc *TodoItemCollection) UpdateProject(userID int, projectID int, u *ProjectUpdate) (*Project, error) {
+	if u.IsEmpty() {
+		return tc.GetProject(userID, projectID)
+	}
+
+	tx, err := tc.DB.Begin()
+	if err != nil {
+		log.Printf("Failed to begin transaction: %s", err.Error())
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := tc.checkProjectName(tx, userID, projectID, *u.Name); err != nil {
+		return nil, err
+	}
+
+	query := "UPDATE projects SET name = ?, updated_at = ? WHERE id = ? AND user_id = ?"
+	result, err := tx.Exec(tc.Dialect.Rebind(query), *u.Name, Now(), projectID, userID)
+	if database.IsUniqueViolation(err) {
+		return nil, errProjectNameTaken(*u.Name)
+	}
+	if err != nil {
+		log.Printf("Failed to update project: %s", err.Error())
+		return nil, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("Failed to get rows affected: %s", err.Error())
+		return nil, err
+	}
+	if rowsAffected == 0 {
+		return nil, fmt.Errorf("project %d: %w", projectID, ErrNotFound)
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Failed to commit project update: %s", err.Error())
+		return nil, err
+	}
+
+	return tc.GetProject(userID, projectID)
+}
+
+// DeleteProject deletes a Project for a User of a given userID, its TodoItems are moved to the inbox.
+// Returns ErrNotFound if the Project could not be found, or ErrConflict if it is the inbox.
+func (tc *TodoItemCollection) DeleteProject(userID int, projectID int) error {
+	tx, err := tc.DB.Begin()
+	if err != nil {
+		log.Printf("Failed to begin transaction: %s", err.Error())
+		return err
+	}
+	defer tx.Rollback()
+
+	var inbox bool
+	query := "SELECT inbox FROM projects WHERE id = ? AND user_id = ?"
+	err = tx.QueryRow(tc.Dialect.Rebind(query), projectID, userID).Scan(&inbox)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("project %d: %w", projectID, ErrNotFound)
+	}
+	if err != nil {
+		log.Printf("Failed to get project: %s", err.Error())
+		return err
+	}
+	if inbox {
+		return fmt.Errorf("project %d is the inbox: %w", projectID, ErrConflict)
+	}
+
+	// Users registered without an inbox, e.g. through the API, get their items out of any project
+	inboxID, err := tc.inboxID(tx, userID)
+	if err != nil {
+		return err
+	}
+	query = "UPDATE todos SET project_id = ?, updated_at = ? WHERE project_id = ? AND user_id = ?"
+	if _, err := tx.Exec(tc.Dialect.Rebind(query), inboxID, Now(), projectID, userID); err != nil {
+		log.Printf("Failed to move todo items to the inbox: %s", err.Error())
+		return err
+	}
+
+	if _, err := tx.Exec(tc.Dialect.Rebind("DELETE FROM projects WHERE id = ? AND user_id = ?"), projectID, userID); err != nil {
+		log.Printf("Failed to delete project: %s", err.Error())
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// inboxID returns the ID of the inbox of a User of a given userID, not valid if they have none
+func (tc *TodoItemCollection) inboxID(q database.Querier, userID int) (sql.NullInt64, error) {
+	var id sql.NullInt64
+	query := "SELECT id FROM projects WHERE user_id = ? AND inbox = ?"
+	err := q.QueryRow(tc.Dialect.Rebind(query), userID, true).Scan(&id)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Failed to get inbox: %s", err.Error())
+		return id, err
+	}
+
+	return id, nil
+}
+
+// checkProjectID returns a ValidationError unless a User of a given userID has the Project of projectID.
+// The Project is locked until the end of tx, so that it cannot be deleted before a TodoItem is put in it.
+func (tc *TodoItemCollection) checkProjectID(tx *sql.Tx, userID int, projectID int) error {
+	var id int
+	query := "SELECT id FROM projects WHERE id = ? AND user_id = ?" + tc.Dialect.ForUpdate()
+	err := tx.QueryRow(tc.Dialect.Rebind(query), projectID, userID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return errProjectNotFound()
+	}
+	if err != nil {
+		log.Printf("Failed to look up project: %s", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// checkProjectName returns ErrConflict if a User of a given userID has a Project of the name, other than the one of projectID.
+// Two requests may both pass it, the unique index on the lower case names then refuses the second one.
+func (tc *TodoItemCollection) checkProjectName(tx *sql.Tx, userID int, projectID int, name string) error {
+	var count int
+	query := "SELECT COUNT(*) FROM projects WHERE user_id = ? AND id <> ? AND LOWER(name) = ?"
+	if err := tx.QueryRow(tc.Dialect.Rebind(query), userID, projectID, strings.ToLower(name)).Scan(&count); err != nil {
+		log.Printf("Failed to look up project: %s", err.Error())
+		return err
+	}
+	if count > 0 {
+		return errProjectNameTaken(name)
+	}
+
+	return nil
+}
+
+// GetProjects gets the Projects of a User of a given userID, the inbox first and then by name.
+func (s *MemoryTodoStore) GetProjects(userID int) ([]*Project, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	projects := []*Project{}
+	for _, p := range s.projects {
+		if p.UserID == userID {
+			p := p
+			projects = append(projects, &p)
+		}
+	}
+	sort.Slice(projects, func(i, j int) bool {
+		a, b := projects[i], projects[j]
+		if a.Inbox != b.Inbox {
+			return a.Inbox
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.ID < b.ID
+	})
+
+	return projects, nil
+}
+
+// GetProject gets a Project by its ID for a User of a given userID.
+func (s *MemoryTodoStore) GetProject(userID int, projectID int) (*Project, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	p, ok := s.projects[projectID]
+	if !ok || p.UserID != userID {
+		return nil, fmt.Errorf("project %d: %w", projectID, ErrNotFound)
+	}
+
+	return &p, nil
+}
+
+// CreateProject creates a Project for a User of a given userID, p is modified with its ID, UserID and timestamps.
+func (s *MemoryTodoStore) CreateProject(userID int, p *Project) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p.Inbox = false
+	return s.insertProject(userID, p)
+}
+
+// CreateInbox creates the inbox of a User of a given userID, or returns the existing one.
+func (s *MemoryTodoStore) CreateInbox(userID int) (*Project, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if inbox, ok := s.inboxOf(userID); ok {
+		return &inbox, nil
+	}
+
+	p := &Project{Name: InboxName, Inbox: true}
+	if err := s.insertProject(userID, p); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// insertProject stores a Project after checking its name is not taken, the lock being held
+func (s *MemoryTodoStore) insertProject(userID int, p *Project) error {
+	if s.projectNameTaken(userID, 0, p.Name) {
+		return errProjectNameTaken(p.Name)
+	}
+
+	now := Now()
+	s.lastProjectID++
+	p.ID = s.lastProjectID
+	p.UserID = userID
+	p.CreatedAt = now
+	p.UpdatedAt = now
+	s.projects[p.ID] = *p
+
+	return nil
+}
+
+// UpdateProject renames a Project for a User of a given userID.
+func (s *MemoryTodoStore) UpdateProject(userID int, projectID int, u *ProjectUpdate) (*Project, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.projects[projectID]
+	if !ok || p.UserID != userID {
+		return nil, fmt.Errorf("project %d: %w", projectID, ErrNotFound)
+	}
+
+	if u.Name != nil {
+		if s.projectNameTaken(userID, projectID, *u.Name) {
+			return nil, errProjectNameTaken(*u.Name)
+		}
+		p.Name = *u.Name
+		p.UpdatedAt = Now()
+	}
+	s.projects[projectID] = p
+
+	return &p, nil
+}
+
+// DeleteProject deletes a Project for a User of a given userID, its TodoItems are moved to the inbox.
+func (s *MemoryTodoStore) DeleteProject(userID int, projectID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.projects[projectID]
+	if !ok || p.UserID != userID {
+		return fmt.Errorf("project %d: %w", projectID, ErrNotFound)
+	}
+	if p.Inbox {
+		return fmt.Errorf("project %d is the inbox: %w", projectID, ErrConflict)
+	}
+
+	var inboxID *int
+	if inbox, ok := s.inboxOf(userID); ok {
+		inboxID = &inbox.ID
+	}
+	now := Now()
+	for id, t := range s.items {
+		if t.ProjectID != nil && *t.ProjectID == projectID {
+			t.ProjectID = inboxID
+			t.UpdatedAt = now
+			s.items[id] = t
+		}
+	}
+	delete(s.projects, projectID)
+
+	return nil
+}
+
+// inboxOf returns the inbox of a User of a given userID, if they have one, the lock being held
+func (s *MemoryTodoStore) inboxOf(userID int) (Project, bool) {
+	for _, p := range s.projects {
+		if p.UserID == userID && p.Inbox {
+			return p, true
+		}
+	}
+
+	return Project{}, false
+}
+
+// hasProject reports whether a User of a given userID has the Project of projectID, the lock being held
+func (s *MemoryTodoStore) hasProject(userID int, projectID int) bool {
+	p, ok := s.projects[projectID]
+	return ok && p.UserID == userID
+}
+
+// projectNameTaken reports whether a User of a given userID has a Project of the name, other than the one of projectID, the lock being held
+func (s *MemoryTodoStore) projectNameTaken(userID int, projectID int, name string) bool {
+	for _, p := range s.projects {
+		if p.UserID == userID && p.ID != projectID && strings.EqualFold(p.Name, name) {
+			return true
+		}
+	}
+
+	return false
+}
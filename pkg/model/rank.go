@@ -0,0 +1,112 @@
+package model
+
+import (
+	"strings"
+)
+
+// Ranks order the TodoItems of a user by their position.
+// They are strings of rankDigits compared byte by byte, read as fractions: "i" is 0.5, "ir" 0.5 + 0.75/36.
+// A rank can always be found between two others, so moving an item only rewrites its own position.
+// Ranks never end with the lowest digit, so that there is always room before them.
+const rankDigits = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+// MaxRankLength is the length above which the ranks of a user are spread again, see SpreadRanks.
+// Ranks only grow when items keep being inserted at the same place, e.g. always appended.
+const MaxRankLength = 64
+
+// RankBetween returns a rank sorting after prev and before next, an empty string standing for the start or the end.
+// Returns false if prev does not sort before next, e.g. two items were given the same rank.
+func RankBetween(prev string, next string) (string, bool) {
+	if next != "" && prev >= next {
+		return "", false
+	}
+
+	return midRank(prev, next), true
+}
+
+// midRank returns a rank between a and b, a < b, b empty standing for the end.
+// Appended and prepended ranks take the smallest step, so that there is room for the next ones.
+func midRank(a string, b string) string {
+	if b != "" {
+		// Keep the common prefix, a being padded with the lowest digit
+		n := 0
+		for n < len(b) && rankDigitAt(a, n) == b[n] {
+			n++
+		}
+		if n > 0 {
+			rest := ""
+			if n < len(a) {
+				rest = a[n:]
+			}
+			return b[:n] + midRank(rest, b[n:])
+		}
+	}
+
+	digitA := 0
+	if a != "" {
+		digitA = strings.IndexByte(rankDigits, a[0])
+	}
+	digitB := len(rankDigits)
+	if b != "" {
+		digitB = strings.IndexByte(rankDigits, b[0])
+	}
+
+	if digitB-digitA > 1 {
+		switch {
+		case a != "" && b == "":
+			return rankDigits[digitA+1 : digitA+2]
+		case a == "" && b != "":
+			return rankDigits[digitB-1 : digitB]
+		default:
+			middle := (digitA + digitB + 1) / 2
+			return rankDigits[middle : middle+1]
+		}
+	}
+
+	// The first digits are adjacent, b cut after its first digit still sorts after a
+	if len(b) > 1 {
+		return b[:1]
+	}
+
+	rest := ""
+	if len(a) > 1 {
+		rest = a[1:]
+	}
+	return rankDigits[digitA:digitA+1] + midRank(rest, "")
+}
+
+// rankDigitAt returns the digit of a rank at i, the lowest digit past its end
+func rankDigitAt(rank string, i int) byte {
+	if i < len(rank) {
+		return rank[i]
+	}
+
+	return rankDigits[0]
+}
+
+// SpreadRanks returns n ranks evenly spread and as short as possible, in order.
+// They replace the ranks of a user grown too long, or given twice.
+func SpreadRanks(n int) []string {
+	base := len(rankDigits)
+
+	// Leave at least one free rank between two items
+	width, capacity := 1, base
+	for capacity < 2*(n+1) {
+		width++
+		capacity *= base
+	}
+
+	ranks := make([]string, n)
+	for i := range ranks {
+		value := (i + 1) * capacity / (n + 1)
+
+		digits := make([]byte, width)
+		for d := width - 1; d >= 0; d-- {
+			digits[d] = rankDigits[value%base]
+			value /= base
+		}
+		ranks[i] = strings.TrimRight(string(digits), rankDigits[:1])
+	}
+
+	return ranks
+}
@@ -0,0 +1,94 @@
+package model_test
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/mystardustcaptain/mattodo/pkg/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRankBetween_SortsBetweenNeighbours tests that RankBetween returns a rank strictly between its neighbours,
+// the empty string standing for the start or the end of the list.
+func TestRankBetween_SortsBetweenNeighbours(t *testing.T) {
+	cases := []struct {
+		prev     string
+		next     string
+		expected string
+	}{
+		{"", "", "i"},
+		{"i", "", "j"},
+		{"z", "", "zi"},
+		{"", "i", "h"},
+		{"", "1", "0i"},
+		{"a", "c", "b"},
+		{"a", "b", "ai"},
+		{"a", "b5", "b"},
+		{"az", "b", "azi"},
+		{"ai", "aj", "aii"},
+	}
+
+	for _, tc := range cases {
+		rank, ok := model.RankBetween(tc.prev, tc.next)
+		assert.True(t, ok)
+		assert.Equal(t, tc.expected, rank, "Expected the rank between %q and %q", tc.prev, tc.next)
+	}
+
+	_, ok := model.RankBetween("b", "b")
+	assert.False(t, ok, "Expected no rank between equal ranks")
+	_, ok = model.RankBetween("c", "b")
+	assert.False(t, ok, "Expected no rank between ranks out of order")
+}
+
+// TestRankBetween_KeepsOrderAfterManyMoves tests that ranks inserted at random places keep sorting in the list order,
+// and that appending only grows the ranks slowly.
+func TestRankBetween_KeepsOrderAfterManyMoves(t *testing.T) {
+	random := rand.New(rand.NewSource(42))
+
+	ranks := []string{}
+	for i := 0; i < 1000; i++ {
+		at := random.Intn(len(ranks) + 1)
+		prev, next := "", ""
+		if at > 0 {
+			prev = ranks[at-1]
+		}
+		if at < len(ranks) {
+			next = ranks[at]
+		}
+
+		rank, ok := model.RankBetween(prev, next)
+		if !assert.True(t, ok) {
+			return
+		}
+		assert.False(t, strings.HasSuffix(rank, "0"), "Expected no rank to end with the lowest digit")
+		ranks = append(ranks[:at], append([]string{rank}, ranks[at:]...)...)
+	}
+
+	for i := 1; i < len(ranks); i++ {
+		assert.Less(t, ranks[i-1], ranks[i])
+	}
+
+	appended := ""
+	for i := 0; i < 500; i++ {
+		appended, _ = model.RankBetween(appended, "")
+	}
+	assert.LessOrEqual(t, len(appended), model.MaxRankLength, "Expected hundreds of appends before the ranks are spread")
+}
+
+// TestSpreadRanks_OrderedAndShort tests that SpreadRanks returns distinct ranks in order, leaving room between them.
+func TestSpreadRanks_OrderedAndShort(t *testing.T) {
+	assert.Equal(t, []string{"9", "i", "r"}, model.SpreadRanks(3))
+	assert.Empty(t, model.SpreadRanks(0))
+
+	ranks := model.SpreadRanks(5000)
+	assert.Equal(t, 5000, len(ranks))
+	for i, rank := range ranks {
+		assert.LessOrEqual(t, len(rank), 4)
+		assert.False(t, strings.HasSuffix(rank, "0"), "Expected no rank to end with the lowest digit")
+		if i > 0 {
+			_, ok := model.RankBetween(ranks[i-1], rank)
+			assert.True(t, ok, "Expected room between %q and %q", ranks[i-1], rank)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package model
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mystardustcaptain/mattodo/pkg/database"
+)
+
+// RefreshToken is a long-lived token exchanged for a new access token.
+// Only the hash of the token is stored.
+// Every use rotates it: the token is marked rotated and a new one of the same family is issued.
+type RefreshToken struct {
+	ID        int        `json:"id"`
+	UserID    int        `json:"user_id"`
+	FamilyID  string     `json:"family_id"` // shared by all the tokens rotated from the same login
+	TokenHash string     `json:"-"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	CreatedAt time.Time  `json:"created_at"`
+	RotatedAt *time.Time `json:"rotated_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+}
+
+type RefreshTokenCollection struct {
+	DB      *sql.DB
+	Dialect database.Dialect
+}
+
+// CreateRefreshToken stores a new refresh token, rt is modified with its ID.
+func (rc *RefreshTokenCollection) CreateRefreshToken(rt *RefreshToken) error {
+	query := "INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at, created_at) VALUES (?, ?, ?, ?, ?)"
+
+	id, err := rc.Dialect.InsertReturningID(rc.DB, query, rt.UserID, rt.FamilyID, rt.TokenHash, rt.ExpiresAt, rt.CreatedAt)
+	if err != nil {
+		log.Printf("Failed to create refresh token: %s", err.Error())
+		return err
+	}
+	rt.ID = int(id)
+
+	return nil
+}
+
+// GetRefreshTokenByHash gets a refresh token by the hash of its value.
+// Returns ErrNotFound if there is no such token.
+func (rc *RefreshTokenCollection) GetRefreshTokenByHash(tokenHash string) (*RefreshToken, error) {
+	query := "SELECT id, user_id, family_id, token_hash, expires_at, created_at, rotated_at, revoked_at FROM refresh_tokens WHERE token_hash = ?"
+
+	rt := RefreshToken{}
+	var rotatedAt, revokedAt sql.NullTime
+	err := rc.DB.QueryRow(rc.Dialect.Rebind(query), tokenHash).
+		Scan(&rt.ID, &rt.UserID, &rt.FamilyID, &rt.TokenHash, &rt.ExpiresAt, &rt.CreatedAt, &rotatedAt, &revokedAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("refresh token: %w", ErrNotFound)
+	}
+	if err != nil {
+		log.Printf("Failed to get refresh token: %s", err.Error())
+		return nil, err
+	}
+
+	if rotatedAt.Valid {
+		rt.RotatedAt = &rotatedAt.Time
+	}
+	if revokedAt.Valid {
+		rt.RevokedAt = &revokedAt.Time
+	}
+
+	return &rt, nil
+}
+
+// RotateRefreshToken records that a refresh token has been used and stores the next one of its family,
+// in one transaction, next is modified with its ID.
+// Returns ErrConflict if it had already been rotated, e.g. by a concurrent request replaying it.
+func (rc *RefreshTokenCollection) RotateRefreshToken(id int, at time.Time, next *RefreshToken) error {
+	query := "UPDATE refresh_tokens SET rotated_at = ? WHERE id = ? AND rotated_at IS NULL"
+
+	tx, err := rc.DB.Begin()
+	if err != nil {
+		log.Printf("Failed to begin transaction: %s", err.Error())
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(rc.Dialect.Rebind(query), at, id)
+	if err != nil {
+		log.Printf("Failed to mark refresh token rotated: %s", err.Error())
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("Failed to get rows affected: %s", err.Error())
+		return err
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("refresh token %d already rotated: %w", id, ErrConflict)
+	}
+
+	query = "INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at, created_at) VALUES (?, ?, ?, ?, ?)"
+	nextID, err := rc.Dialect.InsertReturningID(tx, query, next.UserID, next.FamilyID, next.TokenHash, next.ExpiresAt, next.CreatedAt)
+	if err != nil {
+		log.Printf("Failed to create refresh token: %s", err.Error())
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Failed to commit refresh token rotation: %s", err.Error())
+		return err
+	}
+	next.ID = int(nextID)
+
+	return nil
+}
+
+// IsRefreshTokenFamilyRevoked reports whether a token of the family has been revoked, the family is then revoked as a whole.
+// A token rotated while its family was revoked may have been missed by the revocation, this tells it apart.
+func (rc *RefreshTokenCollection) IsRefreshTokenFamilyRevoked(familyID string) (bool, error) {
+	query := "SELECT COUNT(*) FROM refresh_tokens WHERE family_id = ? AND revoked_at IS NOT NULL"
+
+	var count int
+	if err := rc.DB.QueryRow(rc.Dialect.Rebind(query), familyID).Scan(&count); err != nil {
+		log.Printf("Failed to look up refresh token family: %s", err.Error())
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// RevokeRefreshTokenFamily revokes every refresh token rotated from the same login.
+func (rc *RefreshTokenCollection) RevokeRefreshTokenFamily(familyID string, at time.Time) error {
+	query := "UPDATE refresh_tokens SET revoked_at = ? WHERE family_id = ? AND revoked_at IS NULL"
+
+	if _, err := rc.DB.Exec(rc.Dialect.Rebind(query), at, familyID); err != nil {
+		log.Printf("Failed to revoke refresh token family: %s", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// RevokeUserRefreshTokens revokes every refresh token of a User of a given userID.
+func (rc *RefreshTokenCollection) RevokeUserRefreshTokens(userID int, at time.Time) error {
+	query := "UPDATE refresh_tokens SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL"
+
+	if _, err := rc.DB.Exec(rc.Dialect.Rebind(query), at, userID); err != nil {
+		log.Printf("Failed to revoke user refresh tokens: %s", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// MemoryRefreshTokenStore is a RefreshTokenStore kept in memory.
+// It is safe for concurrent use, meant for tests and ephemeral demo instances.
+type MemoryRefreshTokenStore struct {
+	mu     sync.Mutex
+	tokens map[int]RefreshToken
+	lastID int
+}
+
+// NewMemoryRefreshTokenStore returns an empty MemoryRefreshTokenStore.
+func NewMemoryRefreshTokenStore() *MemoryRefreshTokenStore {
+	return &MemoryRefreshTokenStore{tokens: map[int]RefreshToken{}}
+}
+
+// CreateRefreshToken stores a new refresh token, rt is modified with its ID.
+func (ms *MemoryRefreshTokenStore) CreateRefreshToken(rt *RefreshToken) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.lastID++
+	rt.ID = ms.lastID
+	ms.tokens[rt.ID] = *rt
+
+	return nil
+}
+
+// GetRefreshTokenByHash gets a refresh token by the hash of its value.
+func (ms *MemoryRefreshTokenStore) GetRefreshTokenByHash(tokenHash string) (*RefreshToken, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for _, rt := range ms.tokens {
+		if rt.TokenHash == tokenHash {
+			return &rt, nil
+		}
+	}
+
+	return nil, fmt.Errorf("refresh token: %w", ErrNotFound)
+}
+
+// RotateRefreshToken records that a refresh token has been used and stores the next one of its family.
+func (ms *MemoryRefreshTokenStore) RotateRefreshToken(id int, at time.Time, next *RefreshToken) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	rt, ok := ms.tokens[id]
+	if !ok {
+		return fmt.Errorf("refresh token %d: %w", id, ErrNotFound)
+	}
+	if rt.RotatedAt != nil {
+		return fmt.Errorf("refresh token %d already rotated: %w", id, ErrConflict)
+	}
+
+	rt.RotatedAt = &at
+	ms.tokens[id] = rt
+
+	ms.lastID++
+	next.ID = ms.lastID
+	ms.tokens[next.ID] = *next
+
+	return nil
+}
+
+// IsRefreshTokenFamilyRevoked reports whether a token of the family has been revoked.
+func (ms *MemoryRefreshTokenStore) IsRefreshTokenFamilyRevoked(familyID string) (bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for _, rt := range ms.tokens {
+		if rt.FamilyID == familyID && rt.RevokedAt != nil {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// RevokeRefreshTokenFamily revokes every refresh token rotated from the same login.
+func (ms *MemoryRefreshTokenStore) RevokeRefreshTokenFamily(familyID string, at time.Time) error {
+	ms.revokeWhere(func(rt RefreshToken) bool { return rt.FamilyID == familyID }, at)
+	return nil
+}
+
+// RevokeUserRefreshTokens revokes every refresh token of a User of a given userID.
+func (ms *MemoryRefreshTokenStore) RevokeUserRefreshTokens(userID int, at time.Time) error {
+	ms.revokeWhere(func(rt RefreshToken) bool { return rt.UserID == userID }, at)
+	return nil
+}
+
+// DeleteUserData deletes every refresh token of a User of a given userID, when the user is deleted.
+func (ms *MemoryRefreshTokenStore) DeleteUserData(userID int) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for id, rt := range ms.tokens {
+		if rt.UserID == userID {
+			delete(ms.tokens, id)
+		}
+	}
+}
+
+// revokeWhere revokes the tokens not revoked yet matching the condition
+func (ms *MemoryRefreshTokenStore) revokeWhere(match func(rt RefreshToken) bool, at time.Time) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for id, rt := range ms.tokens {
+		if rt.RevokedAt == nil && match(rt) {
+			rt.RevokedAt = &at
+			ms.tokens[id] = rt
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package model
+
+import (
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mystardustcaptain/mattodo/pkg/database"
+)
+
+// RevokedToken is an access token revoked before its expiry, identified by its jti claim.
+// It only needs to be kept until it would have expired anyway.
+type RevokedToken struct {
+	JTI       string    `json:"jti"`
+	UserID    int       `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+	RevokedAt time.Time `json:"revoked_at"`
+}
+
+// SessionRevocation revokes every access token of a User issued before RevokedBefore,
+// e.g. when the user logs out of all their sessions.
+type SessionRevocation struct {
+	UserID        int       `json:"user_id"`
+	RevokedBefore time.Time `json:"revoked_before"`
+}
+
+type TokenRevocationCollection struct {
+	DB      *sql.DB
+	Dialect database.Dialect
+}
+
+// RevokeToken records a revoked access token.
+// Revoking a token already revoked is not an error.
+func (tc *TokenRevocationCollection) RevokeToken(rt *RevokedToken) error {
+	query := "SELECT COUNT(*) FROM revoked_tokens WHERE jti = ?"
+
+	var count int
+	if err := tc.DB.QueryRow(tc.Dialect.Rebind(query), rt.JTI).Scan(&count); err != nil {
+		log.Printf("Failed to look up revoked token: %s", err.Error())
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	query = "INSERT INTO revoked_tokens (jti, user_id, expires_at, revoked_at) VALUES (?, ?, ?, ?)"
+
+	if _, err := tc.DB.Exec(tc.Dialect.Rebind(query), rt.JTI, rt.UserID, rt.ExpiresAt, rt.RevokedAt); err != nil {
+		log.Printf("Failed to revoke token: %s", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// GetRevokedTokens gets the revoked access tokens not expired at now.
+func (tc *TokenRevocationCollection) GetRevokedTokens(now time.Time) ([]*RevokedToken, error) {
+	query := "SELECT jti, user_id, expires_at, revoked_at FROM revoked_tokens WHERE expires_at >= ?"
+
+	rows, err := tc.DB.Query(tc.Dialect.Rebind(query), now)
+	if err != nil {
+		log.Printf("Failed to get revoked tokens: %s", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*RevokedToken{}
+	for rows.Next() {
+		var rt RevokedToken
+		if err := rows.Scan(&rt.JTI, &rt.UserID, &rt.ExpiresAt, &rt.RevokedAt); err != nil {
+			log.Printf("Failed to scan revoked token: %s", err.Error())
+			return nil, err
+		}
+		tokens = append(tokens, &rt)
+	}
+
+	return tokens, rows.Err()
+}
+
+// DeleteExpiredRevokedTokens deletes the revoked access tokens expired before now,
+// they are refused for their expiry anyway.
+func (tc *TokenRevocationCollection) DeleteExpiredRevokedTokens(now time.Time) error {
+	query := "DELETE FROM revoked_tokens WHERE expires_at < ?"
+
+	if _, err := tc.DB.Exec(tc.Dialect.Rebind(query), now); err != nil {
+		log.Printf("Failed to delete expired revoked tokens: %s", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// RevokeSessions revokes every access token of a User issued before sr.RevokedBefore,
+// replacing any previous revocation of the user.
+func (tc *TokenRevocationCollection) RevokeSessions(sr *SessionRevocation) error {
+	query := "UPDATE session_revocations SET revoked_before = ? WHERE user_id = ?"
+
+	result, err := tc.DB.Exec(tc.Dialect.Rebind(query), sr.RevokedBefore, sr.UserID)
+	if err != nil {
+		log.Printf("Failed to revoke sessions: %s", err.Error())
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("Failed to get rows affected: %s", err.Error())
+		return err
+	}
+	if rowsAffected > 0 {
+		return nil
+	}
+
+	query = "INSERT INTO session_revocations (user_id, revoked_before) VALUES (?, ?)"
+
+	if _, err := tc.DB.Exec(tc.Dialect.Rebind(query), sr.UserID, sr.RevokedBefore); err != nil {
+		log.Printf("Failed to revoke sessions: %s", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// GetSessionRevocations gets the session revocations of every user.
+func (tc *TokenRevocationCollection) GetSessionRevocations() ([]*SessionRevocation, error) {
+	query := "SELECT user_id, revoked_before FROM session_revocations"
+
+	rows, err := tc.DB.Query(query)
+	if err != nil {
+		log.Printf("Failed to get session revocations: %s", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	revocations := []*SessionRevocation{}
+	for rows.Next() {
+		var sr SessionRevocation
+		if err := rows.Scan(&sr.UserID, &sr.RevokedBefore); err != nil {
+			log.Printf("Failed to scan session revocation: %s", err.Error())
+			return nil, err
+		}
+		revocations = append(revocations, &sr)
+	}
+
+	return revocations, rows.Err()
+}
+
+// MemoryTokenRevocationStore is a TokenRevocationStore kept in memory.
+// It is safe for concurrent use, but only suits a single instance of the service.
+type MemoryTokenRevocationStore struct {
+	mu       sync.Mutex
+	tokens   map[string]RevokedToken
+	sessions map[int]SessionRevocation
+}
+
+// NewMemoryTokenRevocationStore returns an empty MemoryTokenRevocationStore.
+func NewMemoryTokenRevocationStore() *MemoryTokenRevocationStore {
+	return &MemoryTokenRevocationStore{
+		tokens:   map[string]RevokedToken{},
+		sessions: map[int]SessionRevocation{},
+	}
+}
+
+// RevokeToken records a revoked access token.
+func (ms *MemoryTokenRevocationStore) RevokeToken(rt *RevokedToken) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.tokens[rt.JTI]; !ok {
+		ms.tokens[rt.JTI] = *rt
+	}
+
+	return nil
+}
+
+// GetRevokedTokens gets the revoked access tokens not expired at now.
+func (ms *MemoryTokenRevocationStore) GetRevokedTokens(now time.Time) ([]*RevokedToken, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	tokens := []*RevokedToken{}
+	for _, rt := range ms.tokens {
+		if !rt.ExpiresAt.Before(now) {
+			rt := rt
+			tokens = append(tokens, &rt)
+		}
+	}
+
+	return tokens, nil
+}
+
+// DeleteExpiredRevokedTokens deletes the revoked access tokens expired before now.
+func (ms *MemoryTokenRevocationStore) DeleteExpiredRevokedTokens(now time.Time) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for jti, rt := range ms.tokens {
+		if rt.ExpiresAt.Before(now) {
+			delete(ms.tokens, jti)
+		}
+	}
+
+	return nil
+}
+
+// RevokeSessions revokes every access token of a User issued before sr.RevokedBefore.
+func (ms *MemoryTokenRevocationStore) RevokeSessions(sr *SessionRevocation) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.sessions[sr.UserID] = *sr
+
+	return nil
+}
+
+// GetSessionRevocations gets the session revocations of every user.
+func (ms *MemoryTokenRevocationStore) GetSessionRevocations() ([]*SessionRevocation, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	revocations := []*SessionRevocation{}
+	for _, sr := range ms.sessions {
+		sr := sr
+		revocations = append(revocations, &sr)
+	}
+
+	return revocations, nil
+}
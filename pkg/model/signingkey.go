@@ -0,0 +1,133 @@
+package model
+
+import (
+	"database/sql"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mystardustcaptain/mattodo/pkg/database"
+)
+
+// SigningKey is a key signing the access tokens, identified by the kid header of the tokens it signed.
+// It is kept until ExpiresAt, when the last token it could have signed has expired.
+type SigningKey struct {
+	ID         string    `json:"kid"`
+	Algorithm  string    `json:"alg"`
+	PrivateKey string    `json:"-"` // PEM encoded PKCS #8
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+type SigningKeyCollection struct {
+	DB      *sql.DB
+	Dialect database.Dialect
+}
+
+// CreateSigningKey stores a new signing key.
+func (sc *SigningKeyCollection) CreateSigningKey(k *SigningKey) error {
+	query := "INSERT INTO signing_keys (kid, algorithm, private_key, created_at, expires_at) VALUES (?, ?, ?, ?, ?)"
+
+	if _, err := sc.DB.Exec(sc.Dialect.Rebind(query), k.ID, k.Algorithm, k.PrivateKey, k.CreatedAt, k.ExpiresAt); err != nil {
+		log.Printf("Failed to create signing key: %s", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// GetSigningKeys gets the signing keys not expired at now, ordered from the oldest to the newest.
+func (sc *SigningKeyCollection) GetSigningKeys(now time.Time) ([]*SigningKey, error) {
+	query := "SELECT kid, algorithm, private_key, created_at, expires_at FROM signing_keys WHERE expires_at >= ? ORDER BY created_at, kid"
+
+	rows, err := sc.DB.Query(sc.Dialect.Rebind(query), now)
+	if err != nil {
+		log.Printf("Failed to get signing keys: %s", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*SigningKey{}
+	for rows.Next() {
+		var k SigningKey
+		if err := rows.Scan(&k.ID, &k.Algorithm, &k.PrivateKey, &k.CreatedAt, &k.ExpiresAt); err != nil {
+			log.Printf("Failed to scan signing key: %s", err.Error())
+			return nil, err
+		}
+		keys = append(keys, &k)
+	}
+
+	return keys, rows.Err()
+}
+
+// DeleteExpiredSigningKeys deletes the signing keys expired before now.
+func (sc *SigningKeyCollection) DeleteExpiredSigningKeys(now time.Time) error {
+	query := "DELETE FROM signing_keys WHERE expires_at < ?"
+
+	if _, err := sc.DB.Exec(sc.Dialect.Rebind(query), now); err != nil {
+		log.Printf("Failed to delete expired signing keys: %s", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// MemorySigningKeyStore is a SigningKeyStore kept in memory.
+// It is safe for concurrent use, but only suits a single instance of the service,
+// the tokens signed are refused after a restart.
+type MemorySigningKeyStore struct {
+	mu   sync.Mutex
+	keys map[string]SigningKey
+}
+
+// NewMemorySigningKeyStore returns an empty MemorySigningKeyStore.
+func NewMemorySigningKeyStore() *MemorySigningKeyStore {
+	return &MemorySigningKeyStore{keys: map[string]SigningKey{}}
+}
+
+// CreateSigningKey stores a new signing key.
+func (ms *MemorySigningKeyStore) CreateSigningKey(k *SigningKey) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.keys[k.ID] = *k
+
+	return nil
+}
+
+// GetSigningKeys gets the signing keys not expired at now, ordered from the oldest to the newest.
+func (ms *MemorySigningKeyStore) GetSigningKeys(now time.Time) ([]*SigningKey, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	keys := []*SigningKey{}
+	for _, k := range ms.keys {
+		if !k.ExpiresAt.Before(now) {
+			k := k
+			keys = append(keys, &k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].ID < keys[j].ID
+		}
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+
+	return keys, nil
+}
+
+// DeleteExpiredSigningKeys deletes the signing keys expired before now.
+func (ms *MemorySigningKeyStore) DeleteExpiredSigningKeys(now time.Time) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for kid, k := range ms.keys {
+		if k.ExpiresAt.Before(now) {
+			delete(ms.keys, kid)
+		}
+	}
+
+	return nil
+}
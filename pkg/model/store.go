@@ -0,0 +1,122 @@
+package model
+
+import "time"
+
+// TodoStore persists the TodoItems of the users, the Tags labelling them and the Projects grouping them.
+// Every method is scoped to the User of the given userID.
+type TodoStore interface {
+	GetAllTodoItems(userID int) ([]*TodoItem, error)
+	FindTodoItems(userID int, f *TodoFilter) ([]*TodoItem, error)
+	GetTodoItem(userID int, todoItemID int) (*TodoItem, error)
+	CreateTodoItem(userID int, t *TodoItem) error
+	UpdateTodoItem(userID int, todoItemID int, u *TodoItemUpdate) (*TodoItem, error)
+	MoveTodoItem(userID int, todoItemID int, m *TodoItemMove) (*TodoItem, error)
+	MarkComplete(userID int, todoItemID int, withSubtasks bool) error
+	DeleteTodoItem(userID int, todoItemID int) error
+	CountTodoItems(userID int) (total int, completed int, err error)
+
+	GetTags(userID int) ([]*Tag, error)
+	GetTag(userID int, tagID int) (*Tag, error)
+	CreateTag(userID int, t *Tag) error
+	UpdateTag(userID int, tagID int, u *TagUpdate) (*Tag, error)
+	DeleteTag(userID int, tagID int) error
+	AttachTag(userID int, todoItemID int, tagID int) error
+	DetachTag(userID int, todoItemID int, tagID int) error
+
+	GetProjects(userID int) ([]*Project, error)
+	GetProject(userID int, projectID int) (*Project, error)
+	CreateProject(userID int, p *Project) error
+	CreateInbox(userID int) (*Project, error)
+	UpdateProject(userID int, projectID int, u *ProjectUpdate) (*Project, error)
+	DeleteProject(userID int, projectID int) error
+}
+
+// UserStore persists the users of the application and the identities they sign in with.
+type UserStore interface {
+	GetUserByID(userID int) (*User, error)
+	GetUserByEmail(email string) (*User, error)
+	GetUserByIdentity(provider string, subject string) (*User, error)
+	CreateUser(u *User) error
+	UpdateUser(userID int, u *UserUpdate) (*User, error)
+
+	SetUserRole(userID int, role string) error
+	SuspendUser(userID int, at *time.Time) error
+	SearchUsers(f *UserFilter) ([]*User, error)
+
+	ScheduleUserDeletion(userID int, at *time.Time) error
+	GetUsersDueForDeletion(now time.Time) ([]int, error)
+	DeleteUser(userID int) error
+
+	GetUserIdentities(userID int) ([]*UserIdentity, error)
+	LinkIdentity(userID int, i *UserIdentity) error
+	UnlinkIdentity(userID int, identityID int) error
+}
+
+// OAuthStateStore persists the pending OAuth logins.
+type OAuthStateStore interface {
+	SaveOAuthState(s *OAuthState) error
+	ConsumeOAuthState(state string) (*OAuthState, error)
+	DeleteExpiredOAuthStates(now time.Time) error
+}
+
+// RefreshTokenStore persists the refresh tokens of the users.
+type RefreshTokenStore interface {
+	CreateRefreshToken(rt *RefreshToken) error
+	GetRefreshTokenByHash(tokenHash string) (*RefreshToken, error)
+	RotateRefreshToken(id int, at time.Time, next *RefreshToken) error
+	IsRefreshTokenFamilyRevoked(familyID string) (bool, error)
+	RevokeRefreshTokenFamily(familyID string, at time.Time) error
+	RevokeUserRefreshTokens(userID int, at time.Time) error
+}
+
+// TokenRevocationStore persists the access tokens revoked before their expiry.
+type TokenRevocationStore interface {
+	RevokeToken(rt *RevokedToken) error
+	GetRevokedTokens(now time.Time) ([]*RevokedToken, error)
+	DeleteExpiredRevokedTokens(now time.Time) error
+	RevokeSessions(sr *SessionRevocation) error
+	GetSessionRevocations() ([]*SessionRevocation, error)
+}
+
+// PersonalAccessTokenStore persists the personal access tokens of the users.
+type PersonalAccessTokenStore interface {
+	CreatePersonalAccessToken(t *PersonalAccessToken) error
+	GetPersonalAccessTokenByHash(tokenHash string) (*PersonalAccessToken, error)
+	GetPersonalAccessTokens(userID int) ([]*PersonalAccessToken, error)
+	TouchPersonalAccessToken(id int, at time.Time) error
+	DeletePersonalAccessToken(userID int, id int) error
+}
+
+// SigningKeyStore persists the keys signing the access tokens, shared by every instance.
+type SigningKeyStore interface {
+	CreateSigningKey(k *SigningKey) error
+	GetSigningKeys(now time.Time) ([]*SigningKey, error)
+	DeleteExpiredSigningKeys(now time.Time) error
+}
+
+// Make sure the implementations satisfy the interfaces
+var (
+	_ TodoStore = (*TodoItemCollection)(nil)
+	_ TodoStore = (*MemoryTodoStore)(nil)
+	_ UserStore = (*UserCollection)(nil)
+	_ UserStore = (*MemoryUserStore)(nil)
+
+	_ UserDataDeleter = (*MemoryTodoStore)(nil)
+	_ UserDataDeleter = (*MemoryRefreshTokenStore)(nil)
+	_ UserDataDeleter = (*MemoryPersonalAccessTokenStore)(nil)
+
+	_ OAuthStateStore = (*OAuthStateCollection)(nil)
+	_ OAuthStateStore = (*MemoryOAuthStateStore)(nil)
+
+	_ RefreshTokenStore = (*RefreshTokenCollection)(nil)
+	_ RefreshTokenStore = (*MemoryRefreshTokenStore)(nil)
+
+	_ TokenRevocationStore = (*TokenRevocationCollection)(nil)
+	_ TokenRevocationStore = (*MemoryTokenRevocationStore)(nil)
+
+	_ PersonalAccessTokenStore = (*PersonalAccessTokenCollection)(nil)
+	_ PersonalAccessTokenStore = (*MemoryPersonalAccessTokenStore)(nil)
+
+	_ SigningKeyStore = (*SigningKeyCollection)(nil)
+	_ SigningKeyStore = (*MemorySigningKeyStore)(nil)
+)
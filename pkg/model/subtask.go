@@ -0,0 +1,329 @@
+package model
+
+import (
+	"database/sql"
+	"log"
+	"strings"
+	"time"
+)
+
+// BuildTree nests the TodoItems under their parent, when their parent is among them, keeping their order.
+// Returns the items left at the top, those without a parent or whose parent was left out, e.g. by a filter.
+func BuildTree(todoItems []*TodoItem) []*TodoItem {
+	byID := make(map[int]*TodoItem, len(todoItems))
+	for _, t := range todoItems {
+		t.Children = []*TodoItem{}
+		byID[t.ID] = t
+	}
+
+	roots := []*TodoItem{}
+	for _, t := range todoItems {
+		if t.ParentID != nil {
+			if parent, ok := byID[*t.ParentID]; ok {
+				parent.Children = append(parent.Children, t)
+				continue
+			}
+		}
+		roots = append(roots, t)
+	}
+
+	return roots
+}
+
+// progress returns the percentage of completed subtasks, rounded down, nil without subtasks
+func progress(total int, completed int) *int {
+	if total == 0 {
+		return nil
+	}
+
+	percent := completed * 100 / total
+	return &percent
+}
+
+// errParentNotFound is returned when a TodoItem is given a parent which is not one of the User
+func errParentNotFound() error {
+	return NewValidationError("parent_id", "must be one of your todo items")
+}
+
+// errParentCycle is returned when a TodoItem is given itself or one of its subtasks as parent
+func errParentCycle(todoItemID int, parentID int) error {
+	if todoItemID == parentID {
+		return NewValidationError("parent_id", "must be another todo item")
+	}
+
+	return NewValidationError("parent_id", "must not be one of its subtasks")
+}
+
+// loadProgress sets the Progress of the TodoItems of a User of a given userID from their subtasks, with a single query
+func (tc *TodoItemCollection) loadProgress(userID int, todoItems []*TodoItem) error {
+	byID := make(map[int]*TodoItem, len(todoItems))
+	for _, t := range todoItems {
+		t.Progress = nil
+		byID[t.ID] = t
+	}
+	if len(todoItems) == 0 {
+		return nil
+	}
+
+	query := "SELECT parent_id, COUNT(*), COUNT(CASE WHEN completed THEN 1 END) FROM todos WHERE user_id = ?"
+	args := []interface{}{userID}
+	if len(todoItems) == 1 {
+		query += " AND parent_id = ?"
+		args = append(args, todoItems[0].ID)
+	} else {
+		query += " AND parent_id IS NOT NULL"
+	}
+	query += " GROUP BY parent_id"
+
+	rows, err := tc.DB.Query(tc.Dialect.Rebind(query), args...)
+	if err != nil {
+		log.Printf("Failed to get subtask progress: %s", err.Error())
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var parentID, total, completed int
+		if err := rows.Scan(&parentID, &total, &completed); err != nil {
+			log.Printf("Failed to scan subtask progress: %s", err.Error())
+			return err
+		}
+		if t, ok := byID[parentID]; ok {
+			t.Progress = progress(total, completed)
+		}
+	}
+
+	return rows.Err()
+}
+
+// parentProjectID returns the project of the parent of a new TodoItem, a ValidationError if it is not one of the User.
+// The parent is locked until the end of tx, so that it cannot be deleted before the new TodoItem is inserted.
+func (tc *TodoItemCollection) parentProjectID(tx *sql.Tx, userID int, parentID int) (sql.NullInt64, error) {
+	var projectID sql.NullInt64
+	query := "SELECT project_id FROM todos WHERE id = ? AND user_id = ?" + tc.Dialect.ForUpdate()
+	err := tx.QueryRow(tc.Dialect.Rebind(query), parentID, userID).Scan(&projectID)
+	if err == sql.ErrNoRows {
+		return projectID, errParentNotFound()
+	}
+	if err != nil {
+		log.Printf("Failed to get parent todo item: %s", err.Error())
+		return projectID, err
+	}
+
+	return projectID, nil
+}
+
+// checkParent returns a ValidationError unless the TodoItem of parentID can become the parent of the one of todoItemID:
+// it must be another TodoItem of the User, and not one of its subtasks, walking up from it.
+// The TodoItem and the ancestors walked are locked until the end of tx, so that two moves cannot form a cycle
+// by crossing each other, e.g. A under B and B under A at once: the second one waits and sees the first.
+func (tc *TodoItemCollection) checkParent(tx *sql.Tx, userID int, todoItemID int, parentID int) error {
+	if parentID == todoItemID {
+		return errParentCycle(todoItemID, parentID)
+	}
+
+	// A missing TodoItem is reported by the update itself
+	var id int
+	query := tc.Dialect.Rebind("SELECT id FROM todos WHERE id = ? AND user_id = ?" + tc.Dialect.ForUpdate())
+	if err := tx.QueryRow(query, todoItemID, userID).Scan(&id); err != nil && err != sql.ErrNoRows {
+		log.Printf("Failed to lock todo item: %s", err.Error())
+		return err
+	}
+
+	query = tc.Dialect.Rebind("SELECT parent_id FROM todos WHERE id = ? AND user_id = ?" + tc.Dialect.ForUpdate())
+	seen := map[int]bool{}
+	for id := parentID; !seen[id]; {
+		seen[id] = true
+
+		var next sql.NullInt64
+		err := tx.QueryRow(query, id, userID).Scan(&next)
+		if err == sql.ErrNoRows {
+			return errParentNotFound()
+		}
+		if err != nil {
+			log.Printf("Failed to get parent todo item: %s", err.Error())
+			return err
+		}
+		if !next.Valid {
+			return nil
+		}
+		if int(next.Int64) == todoItemID {
+			return errParentCycle(todoItemID, parentID)
+		}
+		id = int(next.Int64)
+	}
+
+	return nil
+}
+
+// subtaskLevels returns the IDs of the subtasks of a TodoItem of a given todoItemID, level by level from its children
+func (tc *TodoItemCollection) subtaskLevels(tx *sql.Tx, userID int, todoItemID int) ([][]int, error) {
+	var levels [][]int
+	seen := map[int]bool{todoItemID: true}
+
+	for parents := []int{todoItemID}; len(parents) > 0; {
+		in, args := inClause(parents)
+		query := "SELECT id FROM todos WHERE user_id = ? AND parent_id" + in
+		rows, err := tx.Query(tc.Dialect.Rebind(query), append([]interface{}{userID}, args...)...)
+		if err != nil {
+			log.Printf("Failed to get subtasks: %s", err.Error())
+			return nil, err
+		}
+		var children []int
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				log.Printf("Failed to scan row: %s", err.Error())
+				return nil, err
+			}
+			if !seen[id] {
+				seen[id] = true
+				children = append(children, id)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			log.Printf("Failed to iterate over rows: %s", err.Error())
+			return nil, err
+		}
+
+		if len(children) > 0 {
+			levels = append(levels, children)
+		}
+		parents = children
+	}
+
+	return levels, nil
+}
+
+// inClause returns " IN (?, ...)" for the IDs, with them as arguments
+func inClause(ids []int) (string, []interface{}) {
+	placeholders := make([]string, len(ids))
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		placeholders[i] = "?"
+		args[i] = id
+	}
+
+	return " IN (" + strings.Join(placeholders, ", ") + ")", args
+}
+
+// completeSubtasks marks all the subtasks of a TodoItem of a given todoItemID as completed at now, whatever their depth.
+// The subtasks already completed keep their completed_at.
+func (tc *TodoItemCollection) completeSubtasks(tx *sql.Tx, userID int, todoItemID int, now time.Time) error {
+	levels, err := tc.subtaskLevels(tx, userID, todoItemID)
+	if err != nil {
+		return err
+	}
+
+	for _, ids := range levels {
+		in, args := inClause(ids)
+		query := "UPDATE todos SET completed = ?, completed_at = COALESCE(completed_at, ?), updated_at = ? WHERE completed = ? AND id" + in
+		if _, err := tx.Exec(tc.Dialect.Rebind(query), append([]interface{}{true, now, now, false}, args...)...); err != nil {
+			log.Printf("Failed to complete subtasks: %s", err.Error())
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteSubtasks deletes all the subtasks of a TodoItem of a given todoItemID with their tag links,
+// the deepest first so that no row references a deleted one.
+func (tc *TodoItemCollection) deleteSubtasks(tx *sql.Tx, userID int, todoItemID int) error {
+	levels, err := tc.subtaskLevels(tx, userID, todoItemID)
+	if err != nil {
+		return err
+	}
+
+	for i := len(levels) - 1; i >= 0; i-- {
+		in, args := inClause(levels[i])
+		if _, err := tx.Exec(tc.Dialect.Rebind("DELETE FROM todo_tags WHERE todo_id"+in), args...); err != nil {
+			log.Printf("Failed to detach tags: %s", err.Error())
+			return err
+		}
+		if _, err := tx.Exec(tc.Dialect.Rebind("DELETE FROM todos WHERE id"+in), args...); err != nil {
+			log.Printf("Failed to delete subtasks: %s", err.Error())
+			return err
+		}
+	}
+
+	return nil
+}
+
+// progressOf returns the Progress of a TodoItem of a given todoItemID from its subtasks, the lock being held
+func (s *MemoryTodoStore) progressOf(todoItemID int) *int {
+	total, completed := 0, 0
+	for _, t := range s.items {
+		if t.ParentID != nil && *t.ParentID == todoItemID {
+			total++
+			if t.Completed {
+				completed++
+			}
+		}
+	}
+
+	return progress(total, completed)
+}
+
+// checkParent returns a ValidationError unless the TodoItem of parentID can become the parent of the one of todoItemID,
+// the lock being held
+func (s *MemoryTodoStore) checkParent(userID int, todoItemID int, parentID int) error {
+	if parentID == todoItemID {
+		return errParentCycle(todoItemID, parentID)
+	}
+
+	parent, ok := s.items[parentID]
+	if !ok || parent.UserID != userID {
+		return errParentNotFound()
+	}
+	for seen := map[int]bool{}; parent.ParentID != nil && !seen[parent.ID]; parent = s.items[*parent.ParentID] {
+		seen[parent.ID] = true
+		if *parent.ParentID == todoItemID {
+			return errParentCycle(todoItemID, parentID)
+		}
+	}
+
+	return nil
+}
+
+// subtasksOf returns the IDs of all the subtasks of a TodoItem of a given todoItemID, whatever their depth, the lock being held
+func (s *MemoryTodoStore) subtasksOf(todoItemID int) []int {
+	var ids []int
+	seen := map[int]bool{todoItemID: true}
+	for parents := []int{todoItemID}; len(parents) > 0; {
+		var children []int
+		for _, t := range s.items {
+			if t.ParentID == nil || seen[t.ID] {
+				continue
+			}
+			for _, parentID := range parents {
+				if *t.ParentID == parentID {
+					seen[t.ID] = true
+					children = append(children, t.ID)
+					break
+				}
+			}
+		}
+		ids = append(ids, children...)
+		parents = children
+	}
+
+	return ids
+}
+
+// completeSubtasks marks all the subtasks of a TodoItem of a given todoItemID as completed at now, whatever their depth,
+// the lock being held.
+func (s *MemoryTodoStore) completeSubtasks(todoItemID int, now time.Time) {
+	for _, id := range s.subtasksOf(todoItemID) {
+		subtask := s.items[id]
+		if subtask.Completed {
+			continue
+		}
+		subtask.Completed = true
+		subtask.CompletedAt = &now
+		subtask.UpdatedAt = now
+		s.items[id] = subtask
+	}
+}
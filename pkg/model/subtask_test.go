@@ -0,0 +1,87 @@
+package model_test
+
+import (
+	"testing"
+
+	"github.com/mystardustcaptain/mattodo/pkg/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestBuildTree_NestsSubtasks tests that BuildTree nests the items under their parent in their order,
+// and keeps at the top the items whose parent is not listed.
+func TestBuildTree_NestsSubtasks(t *testing.T) {
+	/// Arrange
+	///
+	id := func(i int) *int { return &i }
+	items := []*model.TodoItem{
+		{ID: 1, Title: "Trip"},
+		{ID: 2, Title: "Tickets", ParentID: id(1)},
+		{ID: 3, Title: "Train", ParentID: id(2)},
+		{ID: 4, Title: "Hotel", ParentID: id(1)},
+		{ID: 5, Title: "Filtered out parent", ParentID: id(9)},
+	}
+
+	/// Act
+	///
+	roots := model.BuildTree(items)
+
+	/// Assert
+	///
+	if assert.Equal(t, 2, len(roots)) {
+		assert.Equal(t, "Trip", roots[0].Title)
+		assert.Equal(t, "Filtered out parent", roots[1].Title)
+	}
+	if assert.Equal(t, 2, len(roots[0].Children)) {
+		assert.Equal(t, "Tickets", roots[0].Children[0].Title)
+		assert.Equal(t, "Hotel", roots[0].Children[1].Title)
+		assert.Equal(t, "Train", roots[0].Children[0].Children[0].Title)
+	}
+	assert.NotNil(t, items[3].Children, "Expected the leaves to have no children rather than nil")
+}
+
+// TestMemoryTodoStore_Subtasks tests that subtasks cannot form cycles, give the progress of their parent,
+// are completed with it on demand and deleted with it.
+func TestMemoryTodoStore_Subtasks(t *testing.T) {
+	/// Arrange
+	///
+	store := model.NewMemoryTodoStore()
+	trip := model.TodoItem{Title: "Trip"}
+	store.CreateTodoItem(2, &trip)
+	tickets := model.TodoItem{Title: "Tickets", ParentID: &trip.ID}
+	store.CreateTodoItem(2, &tickets)
+	train := model.TodoItem{Title: "Train", ParentID: &tickets.ID, Completed: true}
+	store.CreateTodoItem(2, &train)
+	hotel := model.TodoItem{Title: "Hotel", ParentID: &trip.ID}
+	store.CreateTodoItem(2, &hotel)
+	theirs := model.TodoItem{Title: "Theirs"}
+	store.CreateTodoItem(3, &theirs)
+
+	/// Act
+	///
+	errTheirs := store.CreateTodoItem(3, &model.TodoItem{Title: "Sneaky", ParentID: &trip.ID})
+	_, errSelf := store.UpdateTodoItem(2, trip.ID, &model.TodoItemUpdate{ParentID: &trip.ID})
+	_, errCycle := store.UpdateTodoItem(2, trip.ID, &model.TodoItemUpdate{ParentID: &train.ID})
+	before, _ := store.GetTodoItem(2, trip.ID)
+	errComplete := store.MarkComplete(2, trip.ID, true)
+	after, _ := store.GetTodoItem(2, trip.ID)
+	completedTrain, _ := store.GetTodoItem(2, train.ID)
+	errDelete := store.DeleteTodoItem(2, trip.ID)
+	left, _ := store.GetAllTodoItems(2)
+
+	/// Assert
+	///
+	assert.Equal(t, `validation failed: parent_id: must be one of your todo items`, errTheirs.Error())
+	assert.Equal(t, `validation failed: parent_id: must be another todo item`, errSelf.Error())
+	assert.Equal(t, `validation failed: parent_id: must not be one of its subtasks`, errCycle.Error())
+	if assert.NotNil(t, before.Progress) {
+		assert.Equal(t, 0, *before.Progress, "Expected the progress of the direct subtasks only")
+	}
+	assert.NoError(t, errComplete)
+	if assert.NotNil(t, after.Progress) {
+		assert.Equal(t, 100, *after.Progress)
+	}
+	assert.True(t, after.Completed, "Expected the item to be completed with its subtasks")
+	assert.Equal(t, train.CompletedAt, completedTrain.CompletedAt, "Expected a completed subtask to keep its completion time")
+	assert.NoError(t, errDelete)
+	assert.Empty(t, left, "Expected the subtasks to be deleted with their parent, whatever their depth")
+}
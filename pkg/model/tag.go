@@ -0,0 +1,598 @@
+package model
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mystardustcaptain/mattodo/pkg/database"
+)
+
+// Tag labels the TodoItems of a User, an item can have several tags and a tag several items.
+// Names are unique per user, whatever their case.
+type Tag struct {
+	ID     int    `json:"id"`
+	UserID int    `json:"user_id"`
+	Name   string `json:"name"`
+	// Color is a hex color like #1e90ff, empty if the tag has none
+	Color     string    `json:"color"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// MaxTagNameLength is the maximum number of characters of a Tag name.
+const MaxTagNameLength = 50
+
+// tagColorPattern matches the colors of the tags, #rrggbb
+var tagColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// ValidateTagName trims a Tag name and checks it is neither empty nor too long.
+// Returns the trimmed name, or a ValidationError for the name field.
+func ValidateTagName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+
+	if name == "" {
+		return "", NewValidationError("name", "must not be empty")
+	}
+	if utf8.RuneCountInString(name) > MaxTagNameLength {
+		return "", NewValidationError("name", fmt.Sprintf("must be at most %d characters", MaxTagNameLength))
+	}
+
+	return name, nil
+}
+
+// ValidateTagColor checks a Tag color is empty or a hex color like #1e90ff.
+// Returns the color in lower case, or a ValidationError for the color field.
+func ValidateTagColor(color string) (string, error) {
+	if color != "" && !tagColorPattern.MatchString(color) {
+		return "", NewValidationError("color", "must be a hex color like #1e90ff, or empty")
+	}
+
+	return strings.ToLower(color), nil
+}
+
+// Validate checks the user provided fields of a Tag before it is created.
+// The name is trimmed and the color lowered in place.
+func (t *Tag) Validate() error {
+	name, err := ValidateTagName(t.Name)
+	if err != nil {
+		return err
+	}
+	t.Name = name
+
+	color, err := ValidateTagColor(t.Color)
+	if err != nil {
+		return err
+	}
+	t.Color = color
+
+	return nil
+}
+
+// TagUpdate holds the mutable fields of a Tag for a partial update.
+// Fields left nil are not touched, an empty color removes it.
+type TagUpdate struct {
+	Name  *string `json:"name"`
+	Color *string `json:"color"`
+}
+
+// IsEmpty reports whether the update does not change any field.
+func (u *TagUpdate) IsEmpty() bool {
+	return u.Name == nil && u.Color == nil
+}
+
+// Validate checks the fields given in a partial update.
+// The name, if given, is trimmed and the color lowered in place.
+func (u *TagUpdate) Validate() error {
+	if u.Name != nil {
+		name, err := ValidateTagName(*u.Name)
+		if err != nil {
+			return err
+		}
+		u.Name = &name
+	}
+	if u.Color != nil {
+		color, err := ValidateTagColor(*u.Color)
+		if err != nil {
+			return err
+		}
+		u.Color = &color
+	}
+
+	return nil
+}
+
+// errTagNameTaken is returned when a User already has a Tag of that name
+func errTagNameTaken(name string) error {
+	return fmt.Errorf("tag %q already exists: %w", name, ErrConflict)
+}
+
+// tagColumns returns the columns of the tags table scanned by scanTag, prefixed by the table alias if any
+func tagColumns(alias string) string {
+	columns := []string{"id", "user_id", "name", "color", "created_at"}
+	for i, column := range columns {
+		columns[i] = alias + column
+	}
+
+	return strings.Join(columns, ", ")
+}
+
+// scanTag scans a row of tagColumns into a Tag, after the given leading columns
+func scanTag(row rowScanner, leading ...interface{}) (*Tag, error) {
+	t := Tag{}
+	if err := row.Scan(append(leading, &t.ID, &t.UserID, &t.Name, &t.Color, &t.CreatedAt)...); err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+// GetTags gets the Tags of a User of a given userID, ordered by name.
+func (tc *TodoItemCollection) GetTags(userID int) ([]*Tag, error) {
+	query := "SELECT " + tagColumns("") + " FROM tags WHERE user_id = ? ORDER BY name, id"
+
+	rows, err := tc.DB.Query(tc.Dialect.Rebind(query), userID)
+	if err != nil {
+		log.Printf("Failed to get tags: %s", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []*Tag{}
+	for rows.Next() {
+		t, err := scanTag(rows)
+		if err != nil {
+			log.Printf("Failed to scan tag: %s", err.Error())
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+
+	return tags, rows.Err()
+}
+
+// GetTag gets a Tag by its ID for a User of a given userID.
+// Returns ErrNotFound if the Tag does not exist or does not belong to the user.
+func (tc *TodoItemCollection) GetTag(userID int, tagID int) (*Tag, error) {
+	query := "SELECT " + tagColumns("") + " FROM tags WHERE id = ? AND user_id = ?"
+
+	t, err := scanTag(tc.DB.QueryRow(tc.Dialect.Rebind(query), tagID, userID))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("tag %d: %w", tagID, ErrNotFound)
+	}
+	if err != nil {
+		log.Printf("Failed to get tag: %s", err.Error())
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// CreateTag creates a Tag for a User of a given userID, t is modified with its ID, UserID and CreatedAt.
+// Tag Fields taken: Name, Color
+// Returns ErrConflict if the user already has a Tag of that name.
+func (tc *TodoItemCollection) CreateTag(userID int, t *Tag) error {
+	tx, err := tc.DB.Begin()
+	if err != nil {
+		log.Printf("Failed to begin transaction: %s", err.Error())
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := tc.checkTagName(tx, userID, 0, t.Name); err != nil {
+		return err
+	}
+
+	t.UserID = userID
+	t.CreatedAt = Now()
+
+	query := "INSERT INTO tags (user_id, name, color, created_at) VALUES (?, ?, ?, ?)"
+	id, err := tc.Dialect.InsertReturningID(tx, query, t.UserID, t.Name, t.Color, t.CreatedAt)
+	if database.IsUniqueViolation(err) {
+		return errTagNameTaken(t.Name)
+	}
+	if err != nil {
+		log.Printf("Failed to create tag: %s", err.Error())
+		return err
+	}
+	t.ID = int(id)
+
+	return tx.Commit()
+}
+
+// UpdateTag renames or colors a Tag for a User of a given userID.
+// Returns the updated Tag, ErrNotFound if the Tag could not be found,
+// or ErrConflict if the user already has another Tag of the new name.
+func (tc *TodoItemCollection) UpdateTag(userID int, tagID int, u *TagUpdate) (*Tag, error) {
+	if u.IsEmpty() {
+		return tc.GetTag(userID, tagID)
+	}
+
+	tx, err := tc.DB.Begin()
+	if err != nil {
+		log.Printf("Failed to begin transaction: %s", err.Error())
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Looked up first, MySQL reports no affected rows when the values are unchanged
+	var count int
+	query := "SELECT COUNT(*) FROM tags WHERE id = ? AND user_id = ?"
+	if err := tx.QueryRow(tc.Dialect.Rebind(query), tagID, userID).Scan(&count); err != nil {
+		log.Printf("Failed to look up tag: %s", err.Error())
+		return nil, err
+	}
+	if count == 0 {
+		return nil, fmt.Errorf("tag %d: %w", tagID, ErrNotFound)
+	}
+
+	sets := []string{}
+	args := []interface{}{}
+	if u.Name != nil {
+		if err := tc.checkTagName(tx, userID, tagID, *u.Name); err != nil {
+			return nil, err
+		}
+		sets = append(sets, "name = ?")
+		args = append(args, *u.Name)
+	}
+	if u.Color != nil {
+		sets = append(sets, "color = ?")
+		args = append(args, *u.Color)
+	}
+	query = "UPDATE tags SET " + strings.Join(sets, ", ") + " WHERE id = ? AND user_id = ?"
+	args = append(args, tagID, userID)
+	_, err = tx.Exec(tc.Dialect.Rebind(query), args...)
+	if database.IsUniqueViolation(err) {
+		return nil, errTagNameTaken(*u.Name)
+	}
+	if err != nil {
+		log.Printf("Failed to update tag: %s", err.Error())
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Failed to commit tag update: %s", err.Error())
+		return nil, err
+	}
+
+	return tc.GetTag(userID, tagID)
+}
+
+// DeleteTag deletes a Tag for a User of a given userID, detaching it from their TodoItems.
+// Returns ErrNotFound if the Tag could not be found.
+func (tc *TodoItemCollection) DeleteTag(userID int, tagID int) error {
+	tx, err := tc.DB.Begin()
+	if err != nil {
+		log.Printf("Failed to begin transaction: %s", err.Error())
+		return err
+	}
+	defer tx.Rollback()
+
+	// The links go first, they reference the tag
+	query := "DELETE FROM todo_tags WHERE tag_id IN (SELECT id FROM tags WHERE id = ? AND user_id = ?)"
+	if _, err := tx.Exec(tc.Dialect.Rebind(query), tagID, userID); err != nil {
+		log.Printf("Failed to detach tag: %s", err.Error())
+		return err
+	}
+
+	result, err := tx.Exec(tc.Dialect.Rebind("DELETE FROM tags WHERE id = ? AND user_id = ?"), tagID, userID)
+	if err != nil {
+		log.Printf("Failed to delete tag: %s", err.Error())
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("Failed to get rows affected: %s", err.Error())
+		return err
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("tag %d: %w", tagID, ErrNotFound)
+	}
+
+	return tx.Commit()
+}
+
+// AttachTag tags a TodoItem with a Tag, both of a User of a given userID.
+// Attaching a Tag twice is a no-op, otherwise the updated_at of the TodoItem is bumped.
+// Returns ErrNotFound if the TodoItem or the Tag could not be found.
+func (tc *TodoItemCollection) AttachTag(userID int, todoItemID int, tagID int) error {
+	return tc.tagTodoItem(userID, todoItemID, tagID, true)
+}
+
+// DetachTag removes a Tag from a TodoItem, both of a User of a given userID.
+// Detaching a Tag the item does not have is a no-op, otherwise the updated_at of the TodoItem is bumped.
+// Returns ErrNotFound if the TodoItem or the Tag could not be found.
+func (tc *TodoItemCollection) DetachTag(userID int, todoItemID int, tagID int) error {
+	return tc.tagTodoItem(userID, todoItemID, tagID, false)
+}
+
+// tagTodoItem attaches or detaches a Tag from a TodoItem
+func (tc *TodoItemCollection) tagTodoItem(userID int, todoItemID int, tagID int, attach bool) error {
+	tx, err := tc.DB.Begin()
+	if err != nil {
+		log.Printf("Failed to begin transaction: %s", err.Error())
+		return err
+	}
+	defer tx.Rollback()
+
+	// Both must belong to the user
+	var count int
+	query := "SELECT COUNT(*) FROM todos WHERE id = ? AND user_id = ?"
+	if err := tx.QueryRow(tc.Dialect.Rebind(query), todoItemID, userID).Scan(&count); err != nil {
+		log.Printf("Failed to look up todo item: %s", err.Error())
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("todo item %d: %w", todoItemID, ErrNotFound)
+	}
+	query = "SELECT COUNT(*) FROM tags WHERE id = ? AND user_id = ?"
+	if err := tx.QueryRow(tc.Dialect.Rebind(query), tagID, userID).Scan(&count); err != nil {
+		log.Printf("Failed to look up tag: %s", err.Error())
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("tag %d: %w", tagID, ErrNotFound)
+	}
+
+	query = "SELECT COUNT(*) FROM todo_tags WHERE todo_id = ? AND tag_id = ?"
+	if err := tx.QueryRow(tc.Dialect.Rebind(query), todoItemID, tagID).Scan(&count); err != nil {
+		log.Printf("Failed to look up todo item tag: %s", err.Error())
+		return err
+	}
+	if (count > 0) == attach {
+		return nil
+	}
+
+	query = "DELETE FROM todo_tags WHERE todo_id = ? AND tag_id = ?"
+	if attach {
+		query = "INSERT INTO todo_tags (todo_id, tag_id) VALUES (?, ?)"
+	}
+	if _, err := tx.Exec(tc.Dialect.Rebind(query), todoItemID, tagID); err != nil {
+		log.Printf("Failed to tag todo item: %s", err.Error())
+		return err
+	}
+
+	query = "UPDATE todos SET updated_at = ? WHERE id = ?"
+	if _, err := tx.Exec(tc.Dialect.Rebind(query), Now(), todoItemID); err != nil {
+		log.Printf("Failed to update todo item: %s", err.Error())
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// checkTagName returns ErrConflict if a User of a given userID has a Tag of the name, other than the one of tagID.
+// It gives the usual answer early, idx_tags_user_name has the last word when tags are created at once.
+func (tc *TodoItemCollection) checkTagName(tx *sql.Tx, userID int, tagID int, name string) error {
+	var count int
+	query := "SELECT COUNT(*) FROM tags WHERE user_id = ? AND id <> ? AND LOWER(name) = ?"
+	if err := tx.QueryRow(tc.Dialect.Rebind(query), userID, tagID, strings.ToLower(name)).Scan(&count); err != nil {
+		log.Printf("Failed to look up tag: %s", err.Error())
+		return err
+	}
+	if count > 0 {
+		return errTagNameTaken(name)
+	}
+
+	return nil
+}
+
+// loadTags sets the Tags of TodoItems of a User of a given userID, in a single query whatever the number of items.
+// Every item gets a non-nil list of tags, ordered by name.
+func (tc *TodoItemCollection) loadTags(userID int, todoItems []*TodoItem) error {
+	byID := make(map[int]*TodoItem, len(todoItems))
+	for _, t := range todoItems {
+		t.Tags = []*Tag{}
+		byID[t.ID] = t
+	}
+	if len(todoItems) == 0 {
+		return nil
+	}
+
+	query := "SELECT tt.todo_id, " + tagColumns("t.") + " FROM todo_tags tt JOIN tags t ON t.id = tt.tag_id WHERE t.user_id = ?"
+	args := []interface{}{userID}
+	if len(todoItems) == 1 {
+		query += " AND tt.todo_id = ?"
+		args = append(args, todoItems[0].ID)
+	}
+	query += " ORDER BY t.name, t.id"
+
+	rows, err := tc.DB.Query(tc.Dialect.Rebind(query), args...)
+	if err != nil {
+		log.Printf("Failed to get todo item tags: %s", err.Error())
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var todoItemID int
+		tag, err := scanTag(rows, &todoItemID)
+		if err != nil {
+			log.Printf("Failed to scan tag: %s", err.Error())
+			return err
+		}
+		// The tags of the items left out by a filter are read too, they are cheaper to skip than to select
+		if t, ok := byID[todoItemID]; ok {
+			t.Tags = append(t.Tags, tag)
+		}
+	}
+
+	return rows.Err()
+}
+
+// tagFilterClause returns the condition selecting the TodoItems with any of the tags of the filter,
+// or all of them if f.AllTags, with its arguments.
+func tagFilterClause(userID int, f *TodoFilter) (string, []interface{}) {
+	names := f.tagNames()
+
+	placeholders := make([]string, len(names))
+	args := []interface{}{userID}
+	for i, name := range names {
+		placeholders[i] = "?"
+		args = append(args, name)
+	}
+
+	clause := " AND id IN (SELECT tt.todo_id FROM todo_tags tt JOIN tags t ON t.id = tt.tag_id WHERE t.user_id = ? AND LOWER(t.name) IN (" + strings.Join(placeholders, ", ") + ")"
+	if f.AllTags {
+		clause += " GROUP BY tt.todo_id HAVING COUNT(DISTINCT t.id) = ?"
+		args = append(args, len(names))
+	}
+
+	return clause + ")", args
+}
+
+// GetTags gets the Tags of a User of a given userID, ordered by name.
+func (s *MemoryTodoStore) GetTags(userID int) ([]*Tag, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.tagsOf(userID, func(*Tag) bool { return true }), nil
+}
+
+// GetTag gets a Tag by its ID for a User of a given userID.
+func (s *MemoryTodoStore) GetTag(userID int, tagID int) (*Tag, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	t, ok := s.tags[tagID]
+	if !ok || t.UserID != userID {
+		return nil, fmt.Errorf("tag %d: %w", tagID, ErrNotFound)
+	}
+
+	return &t, nil
+}
+
+// CreateTag creates a Tag for a User of a given userID, t is modified with its ID, UserID and CreatedAt.
+func (s *MemoryTodoStore) CreateTag(userID int, t *Tag) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.tagNameTaken(userID, 0, t.Name) {
+		return errTagNameTaken(t.Name)
+	}
+
+	s.lastTagID++
+	t.ID = s.lastTagID
+	t.UserID = userID
+	t.CreatedAt = Now()
+	s.tags[t.ID] = *t
+
+	return nil
+}
+
+// UpdateTag renames or colors a Tag for a User of a given userID.
+func (s *MemoryTodoStore) UpdateTag(userID int, tagID int, u *TagUpdate) (*Tag, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tags[tagID]
+	if !ok || t.UserID != userID {
+		return nil, fmt.Errorf("tag %d: %w", tagID, ErrNotFound)
+	}
+
+	if u.Name != nil {
+		if s.tagNameTaken(userID, tagID, *u.Name) {
+			return nil, errTagNameTaken(*u.Name)
+		}
+		t.Name = *u.Name
+	}
+	if u.Color != nil {
+		t.Color = *u.Color
+	}
+	s.tags[tagID] = t
+
+	return &t, nil
+}
+
+// DeleteTag deletes a Tag for a User of a given userID, detaching it from their TodoItems.
+func (s *MemoryTodoStore) DeleteTag(userID int, tagID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tags[tagID]
+	if !ok || t.UserID != userID {
+		return fmt.Errorf("tag %d: %w", tagID, ErrNotFound)
+	}
+
+	delete(s.tags, tagID)
+	for _, tagIDs := range s.todoTags {
+		delete(tagIDs, tagID)
+	}
+
+	return nil
+}
+
+// AttachTag tags a TodoItem with a Tag, both of a User of a given userID.
+func (s *MemoryTodoStore) AttachTag(userID int, todoItemID int, tagID int) error {
+	return s.tagTodoItem(userID, todoItemID, tagID, true)
+}
+
+// DetachTag removes a Tag from a TodoItem, both of a User of a given userID.
+func (s *MemoryTodoStore) DetachTag(userID int, todoItemID int, tagID int) error {
+	return s.tagTodoItem(userID, todoItemID, tagID, false)
+}
+
+// tagTodoItem attaches or detaches a Tag from a TodoItem
+func (s *MemoryTodoStore) tagTodoItem(userID int, todoItemID int, tagID int, attach bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.items[todoItemID]
+	if !ok || t.UserID != userID {
+		return fmt.Errorf("todo item %d: %w", todoItemID, ErrNotFound)
+	}
+	if tag, ok := s.tags[tagID]; !ok || tag.UserID != userID {
+		return fmt.Errorf("tag %d: %w", tagID, ErrNotFound)
+	}
+
+	if s.todoTags[todoItemID][tagID] == attach {
+		return nil
+	}
+	if attach {
+		if s.todoTags[todoItemID] == nil {
+			s.todoTags[todoItemID] = map[int]bool{}
+		}
+		s.todoTags[todoItemID][tagID] = true
+	} else {
+		delete(s.todoTags[todoItemID], tagID)
+	}
+
+	t.UpdatedAt = Now()
+	s.items[todoItemID] = t
+
+	return nil
+}
+
+// tagNameTaken reports whether a User of a given userID has a Tag of the name, other than the one of tagID, the lock being held
+func (s *MemoryTodoStore) tagNameTaken(userID int, tagID int, name string) bool {
+	for _, t := range s.tags {
+		if t.UserID == userID && t.ID != tagID && strings.EqualFold(t.Name, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// tagsOf returns copies of the Tags of a User of a given userID kept by keep, ordered by name, the lock being held
+func (s *MemoryTodoStore) tagsOf(userID int, keep func(*Tag) bool) []*Tag {
+	tags := []*Tag{}
+	for _, t := range s.tags {
+		t := t
+		if t.UserID == userID && keep(&t) {
+			tags = append(tags, &t)
+		}
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Name != tags[j].Name {
+			return tags[i].Name < tags[j].Name
+		}
+		return tags[i].ID < tags[j].ID
+	})
+
+	return tags
+}
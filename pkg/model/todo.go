@@ -2,50 +2,431 @@ package model
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
 	"log"
+	"strings"
 	"time"
+	"unicode/utf8"
+
+	"github.com/mystardustcaptain/mattodo/pkg/database"
 )
 
 // TodoItem with ID, title, completed status, and timestamps.
 type TodoItem struct {
-	ID        int       `json:"id"`
-	UserID    int       `json:"user_id"` // Foreign key to User
-	Title     string    `json:"title"`
-	Completed bool      `json:"completed"`
+	ID        int    `json:"id"`
+	UserID    int    `json:"user_id"` // Foreign key to User
+	Title     string `json:"title"`
+	Completed bool   `json:"completed"`
+	// DueAt is when the item is due, in UTC, nil if it has no due date
+	DueAt *time.Time `json:"due_at"`
+	// CompletedAt is when the item was completed, nil while it is not
+	CompletedAt *time.Time `json:"completed_at"`
+	// Priority is among Priorities, PriorityNone unless given
+	Priority string `json:"priority"`
+	// Position is the rank of the item in the list of the user, see RankBetween.
+	// New items are appended, MoveTodoItem moves them.
+	Position string `json:"position"`
+	// ProjectID is the ID of the Project the item is listed in, nil if it is in none.
+	// New items go to the inbox of the user unless given another of their projects.
+	ProjectID *int `json:"project_id"`
+	// ParentID is the ID of the TodoItem this one is a subtask of, nil for a top-level item.
+	// New subtasks go to the project of their parent unless given another.
+	ParentID *int `json:"parent_id"`
+	// Progress is the percentage of the subtasks of the item completed, rounded down, nil if it has none
+	Progress *int `json:"progress"`
+	// Children are the subtasks of the item, only set in the tree-shaped lists, see BuildTree
+	Children []*TodoItem `json:"children,omitempty"`
+	// Tags are the tags of the item ordered by name, never nil, see AttachTag
+	Tags      []*Tag    `json:"tags"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
+// Priorities of the TodoItems, from the lowest.
+const (
+	PriorityNone   = "none"
+	PriorityLow    = "low"
+	PriorityMedium = "medium"
+	PriorityHigh   = "high"
+	PriorityUrgent = "urgent"
+)
+
+// Priorities are all the priorities a TodoItem can be given, from the lowest.
+var Priorities = []string{PriorityNone, PriorityLow, PriorityMedium, PriorityHigh, PriorityUrgent}
+
+// ValidatePriority checks that the priority exists.
+func ValidatePriority(priority string) error {
+	for _, p := range Priorities {
+		if priority == p {
+			return nil
+		}
+	}
+
+	return NewValidationError("priority", fmt.Sprintf("must be among %s", strings.Join(Priorities, ", ")))
+}
+
+// MaxTitleLength is the maximum number of characters of a TodoItem title.
+const MaxTitleLength = 200
+
+// ValidateTitle trims a TodoItem title and checks it is neither empty nor too long.
+// Returns the trimmed title, or a ValidationError for the title field.
+func ValidateTitle(title string) (string, error) {
+	title = strings.TrimSpace(title)
+
+	if title == "" {
+		return "", NewValidationError("title", "must not be empty")
+	}
+	if utf8.RuneCountInString(title) > MaxTitleLength {
+		return "", NewValidationError("title", fmt.Sprintf("must be at most %d characters", MaxTitleLength))
+	}
+
+	return title, nil
+}
+
+// ValidateDueAt checks a TodoItem due date can be stored by every database.
+// Returns the due date in UTC, so that they compare the same whatever the time zone they were given in,
+// or a ValidationError for the due_at field.
+func ValidateDueAt(dueAt time.Time) (time.Time, error) {
+	dueAt = dueAt.UTC()
+
+	if dueAt.Year() < 1000 || dueAt.Year() > 9999 {
+		return time.Time{}, NewValidationError("due_at", "must be between the years 1000 and 9999")
+	}
+
+	return dueAt, nil
+}
+
+// Validate checks the user provided fields of a TodoItem before it is created.
+// The title is trimmed, the due date converted to UTC and the priority defaulted in place.
+func (t *TodoItem) Validate() error {
+	title, err := ValidateTitle(t.Title)
+	if err != nil {
+		return err
+	}
+	t.Title = title
+
+	if t.Priority == "" {
+		t.Priority = PriorityNone
+	}
+	if err := ValidatePriority(t.Priority); err != nil {
+		return err
+	}
+
+	if t.DueAt != nil {
+		dueAt, err := ValidateDueAt(*t.DueAt)
+		if err != nil {
+			return err
+		}
+		t.DueAt = &dueAt
+	}
+
+	return nil
+}
+
+// TodoItemUpdate holds the mutable fields of a TodoItem for a partial update.
+// Fields left nil are not touched.
+type TodoItemUpdate struct {
+	Title     *string `json:"title"`
+	Completed *bool   `json:"completed"`
+	Priority  *string `json:"priority"`
+	// DueAt sets the due date, ClearDueAt removes it.
+	// They are not decoded as is, a due_at set to null has to be told apart from a missing one.
+	DueAt      *time.Time `json:"-"`
+	ClearDueAt bool       `json:"-"`
+	// ProjectID moves the item to another project of the user, ClearProjectID takes it out of any.
+	// They are decoded like DueAt and ClearDueAt.
+	ProjectID      *int `json:"-"`
+	ClearProjectID bool `json:"-"`
+	// ParentID makes the item a subtask of another of the user, ClearParentID a top-level item again.
+	// They are decoded like DueAt and ClearDueAt.
+	ParentID      *int `json:"-"`
+	ClearParentID bool `json:"-"`
+}
+
+// IsEmpty reports whether the update does not change any field.
+func (u *TodoItemUpdate) IsEmpty() bool {
+	return u.Title == nil && u.Completed == nil && u.Priority == nil && u.DueAt == nil && !u.ClearDueAt &&
+		u.ProjectID == nil && !u.ClearProjectID && u.ParentID == nil && !u.ClearParentID
+}
+
+// Validate checks the fields given in a partial update.
+// The title, if given, is trimmed in place.
+func (u *TodoItemUpdate) Validate() error {
+	if u.Title != nil {
+		title, err := ValidateTitle(*u.Title)
+		if err != nil {
+			return err
+		}
+		u.Title = &title
+	}
+	if u.Priority != nil {
+		if err := ValidatePriority(*u.Priority); err != nil {
+			return err
+		}
+	}
+	if u.DueAt != nil {
+		dueAt, err := ValidateDueAt(*u.DueAt)
+		if err != nil {
+			return err
+		}
+		u.DueAt = &dueAt
+	}
+
+	return nil
+}
+
+// TodoItemMove places a TodoItem right after or right before another TodoItem of the user.
+// Exactly one of them is given.
+type TodoItemMove struct {
+	AfterID  *int `json:"after_id"`
+	BeforeID *int `json:"before_id"`
+}
+
+// Validate checks that the move of the TodoItem of a given todoItemID gives exactly one other TodoItem.
+func (m *TodoItemMove) Validate(todoItemID int) error {
+	if (m.AfterID == nil) == (m.BeforeID == nil) {
+		return NewValidationError("after_id", "exactly one of after_id and before_id must be given")
+	}
+
+	field, anchorID := m.anchor()
+	if anchorID == todoItemID {
+		return NewValidationError(field, "must be another todo item")
+	}
+
+	return nil
+}
+
+// anchor returns the field and the ID of the TodoItem the move is relative to
+func (m *TodoItemMove) anchor() (string, int) {
+	if m.AfterID != nil {
+		return "after_id", *m.AfterID
+	}
+
+	return "before_id", *m.BeforeID
+}
+
+// errAnchorNotFound is returned when the TodoItem the move is relative to is not one of the user
+func (m *TodoItemMove) errAnchorNotFound() error {
+	field, _ := m.anchor()
+	return NewValidationError(field, "must be one of your todo items")
+}
+
+// TodoFilter selects the TodoItems listed by FindTodoItems, the zero value selects them all.
+type TodoFilter struct {
+	// DueAfter and DueBefore only select the items due in [DueAfter, DueBefore),
+	// the items without a due date are left out as soon as one is set
+	DueAfter  *time.Time
+	DueBefore *time.Time
+	// Completed only selects the completed items if true, the pending ones if false
+	Completed *bool
+	// Priority only selects the items of the priority if not empty
+	Priority string
+	// Tags only selects the items with any of these tag names, or all of them if AllTags.
+	// Names are compared whatever their case.
+	Tags    []string
+	AllTags bool
+	// ProjectID only selects the items listed in the project if not nil
+	ProjectID *int
+}
+
+// HasDueBound reports whether the filter bounds the due date, the items are then ordered by due date before their position.
+func (f *TodoFilter) HasDueBound() bool {
+	return f.DueAfter != nil || f.DueBefore != nil
+}
+
+// Overdue narrows the filter to the pending items due before now.
+func (f *TodoFilter) Overdue(now time.Time) {
+	completed := false
+	f.Completed = &completed
+	f.narrowDue(nil, &now)
+}
+
+// DueOn narrows the filter to the items due on the day of day, in its location:
+// from midnight to the next midnight, whatever the offset of the time zone in UTC.
+func (f *TodoFilter) DueOn(day time.Time) {
+	start := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, day.Location())
+	end := start.AddDate(0, 0, 1)
+	f.narrowDue(&start, &end)
+}
+
+// narrowDue keeps the latest lower bound and the earliest upper bound of the due date
+func (f *TodoFilter) narrowDue(after, before *time.Time) {
+	if after != nil && (f.DueAfter == nil || after.After(*f.DueAfter)) {
+		f.DueAfter = after
+	}
+	if before != nil && (f.DueBefore == nil || before.Before(*f.DueBefore)) {
+		f.DueBefore = before
+	}
+}
+
+// tagNames returns the tag names of the filter in lower case, without duplicates
+func (f *TodoFilter) tagNames() []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, name := range f.Tags {
+		name = strings.ToLower(name)
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// matchesTags reports whether the tags of the TodoItem are selected by the filter
+func (f *TodoFilter) matchesTags(t *TodoItem) bool {
+	names := f.tagNames()
+	if len(names) == 0 {
+		return true
+	}
+
+	found := 0
+	for _, name := range names {
+		for _, tag := range t.Tags {
+			if strings.ToLower(tag.Name) == name {
+				found++
+				break
+			}
+		}
+	}
+
+	if f.AllTags {
+		return found == len(names)
+	}
+	return found > 0
+}
+
+// matches reports whether the TodoItem, with its tags, is selected by the filter
+func (f *TodoFilter) matches(t *TodoItem) bool {
+	if f.Completed != nil && t.Completed != *f.Completed {
+		return false
+	}
+	if f.Priority != "" && t.Priority != f.Priority {
+		return false
+	}
+	if f.ProjectID != nil && (t.ProjectID == nil || *t.ProjectID != *f.ProjectID) {
+		return false
+	}
+	if !f.matchesTags(t) {
+		return false
+	}
+	if !f.HasDueBound() {
+		return true
+	}
+	if t.DueAt == nil {
+		return false
+	}
+
+	return (f.DueAfter == nil || !t.DueAt.Before(*f.DueAfter)) && (f.DueBefore == nil || t.DueAt.Before(*f.DueBefore))
+}
+
+// Now returns the current time used for the timestamps written by the collections.
+// It is a variable so that tests can pin it to a known value.
+var Now = time.Now
+
 type TodoItemCollection struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Dialect database.Dialect
+}
+
+// todoColumns are the columns of the todos table scanned by scanTodoItem
+const todoColumns = "id, user_id, title, completed, due_at, completed_at, priority, position, project_id, parent_id, created_at, updated_at"
+
+// scanTodoItem scans a row of todoColumns into a TodoItem
+func scanTodoItem(row rowScanner) (*TodoItem, error) {
+	t := TodoItem{}
+	var dueAt, completedAt sql.NullTime
+	var projectID, parentID sql.NullInt64
+
+	if err := row.Scan(&t.ID, &t.UserID, &t.Title, &t.Completed, &dueAt, &completedAt, &t.Priority, &t.Position, &projectID, &parentID, &t.CreatedAt, &t.UpdatedAt); err != nil {
+		return nil, err
+	}
+
+	if dueAt.Valid {
+		// Some drivers return the times in the time zone of the connection
+		due := dueAt.Time.UTC()
+		t.DueAt = &due
+	}
+	if completedAt.Valid {
+		t.CompletedAt = &completedAt.Time
+	}
+	if projectID.Valid {
+		id := int(projectID.Int64)
+		t.ProjectID = &id
+	}
+	if parentID.Valid {
+		id := int(parentID.Int64)
+		t.ParentID = &id
+	}
+
+	return &t, nil
 }
 
-// GetAllTodoItems function to get all TodoItems for a User of a given userID.
+// GetAllTodoItems function to get all TodoItems for a User of a given userID, ordered by position.
 func (tc *TodoItemCollection) GetAllTodoItems(userID int) ([]*TodoItem, error) {
+	return tc.FindTodoItems(userID, &TodoFilter{})
+}
+
+// FindTodoItems function to get the TodoItems selected by a filter for a User of a given userID.
+// They are ordered by position, after their due date when the filter bounds it.
+// The ID breaks the ties, e.g. items created at the same time, so that the order is stable.
+// The index on (user_id, due_at) serves the overdue and due today queries, the one on (user_id, position) the default order.
+// Their tags and the progress of their subtasks are loaded with a query each, whatever the number of items.
+func (tc *TodoItemCollection) FindTodoItems(userID int, f *TodoFilter) ([]*TodoItem, error) {
 	var todoItems []*TodoItem
 
-	query := "SELECT id, user_id, title, completed, created_at, updated_at FROM todos WHERE user_id = ?"
+	query := "SELECT " + todoColumns + " FROM todos WHERE user_id = ?"
+	args := []interface{}{userID}
+	if f.DueAfter != nil {
+		query += " AND due_at >= ?"
+		args = append(args, f.DueAfter.UTC())
+	}
+	if f.DueBefore != nil {
+		query += " AND due_at < ?"
+		args = append(args, f.DueBefore.UTC())
+	}
+	if f.Completed != nil {
+		query += " AND completed = ?"
+		args = append(args, *f.Completed)
+	}
+	if f.Priority != "" {
+		query += " AND priority = ?"
+		args = append(args, f.Priority)
+	}
+	if f.ProjectID != nil {
+		query += " AND project_id = ?"
+		args = append(args, *f.ProjectID)
+	}
+	if len(f.Tags) > 0 {
+		clause, tagArgs := tagFilterClause(userID, f)
+		query += clause
+		args = append(args, tagArgs...)
+	}
+	if f.HasDueBound() {
+		query += " ORDER BY due_at, position, id"
+	} else {
+		query += " ORDER BY position, id"
+	}
 
-	rows, err := tc.DB.Query(query, userID)
+	rows, err := tc.DB.Query(tc.Dialect.Rebind(query), args...)
 	if err != nil {
-		log.Printf("Failed to get all todo items: %s", err.Error())
+		log.Printf("Failed to get todo items: %s", err.Error())
 		return nil, err
 	}
 	defer rows.Close()
 
 	// Iterate over the rows
 	for rows.Next() {
-		var todoItem TodoItem
-
-		// Scan the rows into the TodoItem struct
-		err := rows.Scan(&todoItem.ID, &todoItem.UserID, &todoItem.Title, &todoItem.Completed, &todoItem.CreatedAt, &todoItem.UpdatedAt)
+		todoItem, err := scanTodoItem(rows)
 		if err != nil {
 			log.Printf("Failed to scan row: %s", err.Error())
 			return nil, err
 		}
 
 		// Append the TodoItem to the slice of TodoItems
-		todoItems = append(todoItems, &todoItem)
+		todoItems = append(todoItems, todoItem)
 	}
 
 	// Check for errors after we are done iterating over the rows
@@ -53,16 +434,27 @@ func (tc *TodoItemCollection) GetAllTodoItems(userID int) ([]*TodoItem, error) {
 		log.Printf("Failed to iterate over rows: %s", err.Error())
 		return nil, err
 	}
+	rows.Close()
+
+	if err := tc.loadTags(userID, todoItems); err != nil {
+		return nil, err
+	}
+	if err := tc.loadProgress(userID, todoItems); err != nil {
+		return nil, err
+	}
 
 	return todoItems, nil
 }
 
 // CreateTodoItem function to create a new TodoItem in the database.
 // Takes in a userID to ensure that the TodoItem created goes to the User.
-// TodoItem Fields taken: Title, Completed
-// Fields ignored: ID, UserID, CreatedAt, UpdatedAt
+// The TodoItem is appended to the list of the User.
+// It goes to the inbox of the User unless given another of their projects, or the project of its parent for a subtask.
+// TodoItem Fields taken: Title, Completed, DueAt, Priority, ProjectID, ParentID
+// Fields ignored: ID, UserID, CompletedAt, Position, Progress, Children, Tags, CreatedAt, UpdatedAt
+// Returns a ValidationError if the project or the parent is not one of the User.
 func (tc *TodoItemCollection) CreateTodoItem(userID int, t *TodoItem) error {
-	query := "INSERT INTO todos (user_id, title, completed, created_at, updated_at) VALUES (?, ?, ?, ?, ?)"
+	query := "INSERT INTO todos (user_id, title, completed, due_at, completed_at, priority, position, project_id, parent_id, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
 
 	// You can only create a todo item for yourself
 	// ? Should we return an error if the user tries to create a todo item for someone else?
@@ -70,20 +462,94 @@ func (tc *TodoItemCollection) CreateTodoItem(userID int, t *TodoItem) error {
 	// ? Or should we just return an error if the userID in the request body is not the same as the userID in the request context?
 	// Simple approach for now
 	t.UserID = userID
+	// A new item has no tags nor subtasks yet, see AttachTag
+	t.Tags = []*Tag{}
+	t.Progress = nil
+	t.Children = nil
 	// Set the timestamps for CreatedAt and UpdatedAt as the current time
-	t.CreatedAt = time.Now()
-	t.UpdatedAt = time.Now()
+	now := Now()
+	t.CreatedAt = now
+	t.UpdatedAt = now
+	// An item created completed was completed right away
+	t.CompletedAt = nil
+	if t.Completed {
+		t.CompletedAt = &now
+	}
+
+	var dueAt, completedAt sql.NullTime
+	if t.DueAt != nil {
+		dueAt = sql.NullTime{Time: t.DueAt.UTC(), Valid: true}
+	}
+	if t.CompletedAt != nil {
+		completedAt = sql.NullTime{Time: *t.CompletedAt, Valid: true}
+	}
+	if t.Priority == "" {
+		t.Priority = PriorityNone
+	}
 
-	result, err := tc.DB.Exec(query, t.UserID, t.Title, t.Completed, t.CreatedAt, t.UpdatedAt)
+	// The project and the parent are checked and the position read in the same transaction as the insert,
+	// they are locked meanwhile so that they cannot be deleted before the item references them
+	tx, err := tc.DB.Begin()
+	if err != nil {
+		log.Printf("Failed to begin transaction: %s", err.Error())
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := tc.lockTodoList(tx, userID); err != nil {
+		return err
+	}
+
+	// The project is locked before the parent, in the order UpdateTodoItem locks them
+	if t.ProjectID != nil {
+		if err := tc.checkProjectID(tx, userID, *t.ProjectID); err != nil {
+			return err
+		}
+	}
+
+	var parentID, parentProjectID sql.NullInt64
+	if t.ParentID != nil {
+		projectID, err := tc.parentProjectID(tx, userID, *t.ParentID)
+		if err != nil {
+			return err
+		}
+		parentID = sql.NullInt64{Int64: int64(*t.ParentID), Valid: true}
+		parentProjectID = projectID
+	}
+
+	var projectID sql.NullInt64
+	switch {
+	case t.ProjectID != nil:
+		projectID = sql.NullInt64{Int64: int64(*t.ProjectID), Valid: true}
+	case t.ParentID != nil:
+		projectID = parentProjectID
+	default:
+		inboxID, err := tc.inboxID(tx, userID)
+		if err != nil {
+			return err
+		}
+		projectID = inboxID
+	}
+	if projectID.Valid {
+		id := int(projectID.Int64)
+		t.ProjectID = &id
+	}
+
+	position, err := tc.appendedPosition(tx, userID)
 	if err != nil {
-		log.Printf("Failed to create todo item: %s", err.Error())
 		return err
 	}
+	t.Position = position
 
-	// Get the ID of the newly created TodoItem
-	todoItemID, err := result.LastInsertId()
+	// Insert and get the ID of the newly created TodoItem
+	todoItemID, err := tc.Dialect.InsertReturningID(tx, query, t.UserID, t.Title, t.Completed, dueAt, completedAt, t.Priority, t.Position, projectID, parentID, t.CreatedAt, t.UpdatedAt)
 	if err != nil {
-		log.Printf("Failed to get last insert id: %s", err.Error())
+		log.Printf("Failed to create todo item: %s", err.Error())
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Failed to commit todo item creation: %s", err.Error())
 		return err
 	}
 
@@ -93,14 +559,210 @@ func (tc *TodoItemCollection) CreateTodoItem(userID int, t *TodoItem) error {
 	return nil
 }
 
-// MarkComplete function marks a TodoItem as completed for a User of a given userID.
+// lockTodoList locks the row of a User of a given userID until the end of tx,
+// so that the positions of their TodoItems are read and written by one transaction at a time:
+// two items created or moved at once cannot take the same position, nor a spread reorder the list under a move.
+func (tc *TodoItemCollection) lockTodoList(tx *sql.Tx, userID int) error {
+	var id int
+	query := tc.Dialect.Rebind("SELECT id FROM users WHERE id = ?" + tc.Dialect.ForUpdate())
+	if err := tx.QueryRow(query, userID).Scan(&id); err != nil && err != sql.ErrNoRows {
+		log.Printf("Failed to lock todo list: %s", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// appendedPosition returns the position after the last TodoItem of a User of a given userID,
+// spreading the positions first if it would grow too long.
+func (tc *TodoItemCollection) appendedPosition(tx *sql.Tx, userID int) (string, error) {
+	query := tc.Dialect.Rebind("SELECT MAX(position) FROM todos WHERE user_id = ?")
+
+	var last sql.NullString
+	if err := tx.QueryRow(query, userID).Scan(&last); err != nil {
+		log.Printf("Failed to get last position: %s", err.Error())
+		return "", err
+	}
+
+	position, _ := RankBetween(last.String, "")
+	if len(position) <= MaxRankLength {
+		return position, nil
+	}
+
+	// Appending again and again grows the positions, start afresh
+	if err := tc.spreadPositions(tx, userID); err != nil {
+		return "", err
+	}
+	if err := tx.QueryRow(query, userID).Scan(&last); err != nil {
+		log.Printf("Failed to get last position: %s", err.Error())
+		return "", err
+	}
+
+	position, _ = RankBetween(last.String, "")
+	if len(position) > MaxRankLength {
+		return "", errNoRoom
+	}
+
+	return position, nil
+}
+
+// MoveTodoItem function moves a TodoItem for a User of a given userID, right after or right before another of their TodoItems.
+// Only the position of the moved item is written, unless the positions around have to be spread first.
+// Returns the moved TodoItem, ErrNotFound if the TodoItem could not be found,
+// or a ValidationError if the other TodoItem is not one of the User.
+func (tc *TodoItemCollection) MoveTodoItem(userID int, todoItemID int, m *TodoItemMove) (*TodoItem, error) {
+	if err := m.Validate(todoItemID); err != nil {
+		return nil, err
+	}
+
+	// The neighbours are read and the position written in the same transaction, see lockTodoList
+	tx, err := tc.DB.Begin()
+	if err != nil {
+		log.Printf("Failed to begin transaction: %s", err.Error())
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := tc.lockTodoList(tx, userID); err != nil {
+		return nil, err
+	}
+
+	var id int
+	err = tx.QueryRow(tc.Dialect.Rebind("SELECT id FROM todos WHERE id = ? AND user_id = ?"), todoItemID, userID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("todo item %d: %w", todoItemID, ErrNotFound)
+	}
+	if err != nil {
+		log.Printf("Failed to get todo item: %s", err.Error())
+		return nil, err
+	}
+
+	position, err := tc.movedPosition(tx, userID, todoItemID, m)
+	if err != nil {
+		return nil, err
+	}
+	if position == "" {
+		// The neighbours have the same position or the position grew too long, spread them and try again
+		if err := tc.spreadPositions(tx, userID); err != nil {
+			return nil, err
+		}
+		if position, err = tc.movedPosition(tx, userID, todoItemID, m); err != nil {
+			return nil, err
+		}
+		if position == "" {
+			return nil, errNoRoom
+		}
+	}
+
+	query := "UPDATE todos SET position = ?, updated_at = ? WHERE id = ? AND user_id = ?"
+	if _, err := tx.Exec(tc.Dialect.Rebind(query), position, Now(), todoItemID, userID); err != nil {
+		log.Printf("Failed to move todo item: %s", err.Error())
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Failed to commit todo item move: %s", err.Error())
+		return nil, err
+	}
+
+	return tc.GetTodoItem(userID, todoItemID)
+}
+
+// errNoRoom is returned when no position is left for a TodoItem, even once the positions are spread
+var errNoRoom = errors.New("no position left in the todo list")
+
+// movedPosition returns the position between the neighbours of a TodoItem of a given todoItemID once moved,
+// or an empty string if there is no room between them.
+func (tc *TodoItemCollection) movedPosition(tx *sql.Tx, userID int, todoItemID int, m *TodoItemMove) (string, error) {
+	_, anchorID := m.anchor()
+
+	var anchor string
+	err := tx.QueryRow(tc.Dialect.Rebind("SELECT position FROM todos WHERE id = ? AND user_id = ?"), anchorID, userID).Scan(&anchor)
+	if err == sql.ErrNoRows {
+		return "", m.errAnchorNotFound()
+	}
+	if err != nil {
+		log.Printf("Failed to get position: %s", err.Error())
+		return "", err
+	}
+
+	// The other neighbour is the item next to the anchor, in the order of the list
+	query := "SELECT position FROM todos WHERE user_id = ? AND id <> ? AND (position > ? OR (position = ? AND id > ?)) ORDER BY position, id LIMIT 1"
+	if m.BeforeID != nil {
+		query = "SELECT position FROM todos WHERE user_id = ? AND id <> ? AND (position < ? OR (position = ? AND id < ?)) ORDER BY position DESC, id DESC LIMIT 1"
+	}
+
+	var neighbour string
+	err = tx.QueryRow(tc.Dialect.Rebind(query), userID, todoItemID, anchor, anchor, anchorID).Scan(&neighbour)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Failed to get neighbour position: %s", err.Error())
+		return "", err
+	}
+
+	prev, next := anchor, neighbour
+	if m.BeforeID != nil {
+		prev, next = neighbour, anchor
+	}
+
+	position, ok := RankBetween(prev, next)
+	if !ok || len(position) > MaxRankLength {
+		return "", nil
+	}
+
+	return position, nil
+}
+
+// spreadPositions gives evenly spread positions to the TodoItems of a User of a given userID, keeping their order.
+func (tc *TodoItemCollection) spreadPositions(tx *sql.Tx, userID int) error {
+	rows, err := tx.Query(tc.Dialect.Rebind("SELECT id FROM todos WHERE user_id = ? ORDER BY position, id"), userID)
+	if err != nil {
+		log.Printf("Failed to get todo items: %s", err.Error())
+		return err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			log.Printf("Failed to scan row: %s", err.Error())
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		log.Printf("Failed to iterate over rows: %s", err.Error())
+		return err
+	}
+
+	for i, position := range SpreadRanks(len(ids)) {
+		if _, err := tx.Exec(tc.Dialect.Rebind("UPDATE todos SET position = ? WHERE id = ?"), position, ids[i]); err != nil {
+			log.Printf("Failed to spread positions: %s", err.Error())
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MarkComplete function marks a TodoItem as completed for a User of a given userID,
+// with all its subtasks whatever their depth if withSubtasks, in one transaction.
+// An item already completed keeps its completed_at.
 // Returns error if the TodoItem could not be marked as completed.
-func (tc *TodoItemCollection) MarkComplete(userID int, todoItemID int) error {
-	query := "UPDATE todos SET completed = ?, updated_at = ? WHERE id = ? AND user_id = ?"
+func (tc *TodoItemCollection) MarkComplete(userID int, todoItemID int, withSubtasks bool) error {
+	query := "UPDATE todos SET completed = ?, completed_at = COALESCE(completed_at, ?), updated_at = ? WHERE id = ? AND user_id = ?"
+
+	tx, err := tc.DB.Begin()
+	if err != nil {
+		log.Printf("Failed to begin transaction: %s", err.Error())
+		return err
+	}
+	defer tx.Rollback()
 
 	// Update the TodoItem
 	// Mark it as completed and update the timestamp to the current time
-	result, err := tc.DB.Exec(query, true, time.Now(), todoItemID, userID)
+	now := Now()
+	result, err := tx.Exec(tc.Dialect.Rebind(query), true, now, now, todoItemID, userID)
 	if err != nil {
 		log.Printf("Failed to mark todo item as complete: %s", err.Error())
 		return err
@@ -118,37 +780,183 @@ func (tc *TodoItemCollection) MarkComplete(userID int, todoItemID int) error {
 	// or it was already deleted
 	if rowsAffected == 0 {
 		log.Printf("Todo item not found or does not belong to user")
-		return sql.ErrNoRows
+		return fmt.Errorf("todo item %d: %w", todoItemID, ErrNotFound)
 	}
 
-	return nil
+	if withSubtasks {
+		if err := tc.completeSubtasks(tx, userID, todoItemID, now); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// UpdateTodoItem function applies a partial update to a TodoItem for a User of a given userID.
+// Only the non-nil fields of u are written, updated_at is always bumped to the current time.
+// completed_at follows completed: set when the item gets completed, cleared when it is reopened.
+// Returns the updated TodoItem, a ValidationError if the project or the parent is not one of the User,
+// or the parent is the item itself or one of its subtasks, or error if the TodoItem could not be updated.
+func (tc *TodoItemCollection) UpdateTodoItem(userID int, todoItemID int, u *TodoItemUpdate) (*TodoItem, error) {
+	now := Now()
+
+	// The parent is checked and set in the same transaction, see checkParent
+	tx, err := tc.DB.Begin()
+	if err != nil {
+		log.Printf("Failed to begin transaction: %s", err.Error())
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if u.ProjectID != nil {
+		if err := tc.checkProjectID(tx, userID, *u.ProjectID); err != nil {
+			return nil, err
+		}
+	}
+	if u.ParentID != nil {
+		if err := tc.checkParent(tx, userID, todoItemID, *u.ParentID); err != nil {
+			return nil, err
+		}
+	}
+
+	// Build the SET clause from the fields given
+	sets := []string{}
+	args := []interface{}{}
+	if u.Title != nil {
+		sets = append(sets, "title = ?")
+		args = append(args, *u.Title)
+	}
+	if u.Priority != nil {
+		sets = append(sets, "priority = ?")
+		args = append(args, *u.Priority)
+	}
+	if u.Completed != nil {
+		sets = append(sets, "completed = ?")
+		args = append(args, *u.Completed)
+		if *u.Completed {
+			sets = append(sets, "completed_at = COALESCE(completed_at, ?)")
+			args = append(args, now)
+		} else {
+			sets = append(sets, "completed_at = NULL")
+		}
+	}
+	if u.DueAt != nil {
+		sets = append(sets, "due_at = ?")
+		args = append(args, u.DueAt.UTC())
+	} else if u.ClearDueAt {
+		sets = append(sets, "due_at = NULL")
+	}
+	if u.ProjectID != nil {
+		sets = append(sets, "project_id = ?")
+		args = append(args, *u.ProjectID)
+	} else if u.ClearProjectID {
+		sets = append(sets, "project_id = NULL")
+	}
+	if u.ParentID != nil {
+		sets = append(sets, "parent_id = ?")
+		args = append(args, *u.ParentID)
+	} else if u.ClearParentID {
+		sets = append(sets, "parent_id = NULL")
+	}
+	sets = append(sets, "updated_at = ?")
+	args = append(args, now)
+
+	query := "UPDATE todos SET " + strings.Join(sets, ", ") + " WHERE id = ? AND user_id = ?"
+	args = append(args, todoItemID, userID)
+
+	result, err := tx.Exec(tc.Dialect.Rebind(query), args...)
+	if err != nil {
+		log.Printf("Failed to update todo item: %s", err.Error())
+		return nil, err
+	}
+
+	// Check if the TodoItem was actually updated
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("Failed to get rows affected: %s", err.Error())
+		return nil, err
+	}
+
+	// If no rows were affected, then the TodoItem was not found
+	// or it does not belong to the user
+	if rowsAffected == 0 {
+		log.Printf("Todo item not found or does not belong to user")
+		return nil, fmt.Errorf("todo item %d: %w", todoItemID, ErrNotFound)
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Failed to commit todo item update: %s", err.Error())
+		return nil, err
+	}
+
+	return tc.GetTodoItem(userID, todoItemID)
 }
 
 // GetTodoItem function to get a TodoItem by its ID for a User of a given userID.
 // Returns error if the TodoItem could not be retrieved.
 func (tc *TodoItemCollection) GetTodoItem(userID int, todoItemID int) (*TodoItem, error) {
-	query := "SELECT id, user_id, title, completed, created_at, updated_at FROM todos WHERE id = ? AND user_id = ?"
+	query := "SELECT " + todoColumns + " FROM todos WHERE id = ? AND user_id = ?"
 
-	t := TodoItem{}
-	err := tc.DB.QueryRow(query, todoItemID, userID).Scan(&t.ID, &t.UserID, &t.Title, &t.Completed, &t.CreatedAt, &t.UpdatedAt)
+	t, err := scanTodoItem(tc.DB.QueryRow(tc.Dialect.Rebind(query), todoItemID, userID))
+	if err == sql.ErrNoRows {
+		// The TodoItem does not exist or does not belong to the user,
+		// both are reported the same to not leak the existence of other users' items
+		log.Printf("Todo item not found or does not belong to user")
+		return nil, fmt.Errorf("todo item %d: %w", todoItemID, ErrNotFound)
+	}
 	if err != nil {
-		// Error returned might not be clear enough to the user
-		// whether the TodoItem was not found (does not exist or does not belong to the user)
-		// or if there was some other error
-
 		log.Printf("Failed to get todo item: %s", err.Error())
 		return nil, err
 	}
 
-	return &t, nil
+	if err := tc.loadTags(userID, []*TodoItem{t}); err != nil {
+		return nil, err
+	}
+	if err := tc.loadProgress(userID, []*TodoItem{t}); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// CountTodoItems counts the TodoItems of a User of a given userID, and those completed among them.
+func (tc *TodoItemCollection) CountTodoItems(userID int) (int, int, error) {
+	query := "SELECT COUNT(*), COUNT(CASE WHEN completed THEN 1 END) FROM todos WHERE user_id = ?"
+
+	var total, completed int
+	if err := tc.DB.QueryRow(tc.Dialect.Rebind(query), userID).Scan(&total, &completed); err != nil {
+		log.Printf("Failed to count todo items: %s", err.Error())
+		return 0, 0, err
+	}
+
+	return total, completed, nil
 }
 
-// DeleteTodoItem function delete a TodoItem by its ID for a User of a given userID.
+// DeleteTodoItem function delete a TodoItem by its ID for a User of a given userID, with its tags
+// and all its subtasks, whatever their depth.
 // Returns error if the TodoItem could not be deleted.
 func (tc *TodoItemCollection) DeleteTodoItem(userID int, todoItemID int) error {
 	query := "DELETE FROM todos WHERE id = ? AND user_id = ?"
 
-	result, err := tc.DB.Exec(query, todoItemID, userID)
+	tx, err := tc.DB.Begin()
+	if err != nil {
+		log.Printf("Failed to begin transaction: %s", err.Error())
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := tc.deleteSubtasks(tx, userID, todoItemID); err != nil {
+		return err
+	}
+
+	// The tags themselves stay, only detached from the item before it goes as the links reference it
+	detach := "DELETE FROM todo_tags WHERE todo_id IN (SELECT id FROM todos WHERE id = ? AND user_id = ?)"
+	if _, err := tx.Exec(tc.Dialect.Rebind(detach), todoItemID, userID); err != nil {
+		log.Printf("Failed to detach tags: %s", err.Error())
+		return err
+	}
+
+	result, err := tx.Exec(tc.Dialect.Rebind(query), todoItemID, userID)
 	if err != nil {
 		log.Printf("Failed to delete todo item: %s", err.Error())
 		return err
@@ -166,8 +974,8 @@ func (tc *TodoItemCollection) DeleteTodoItem(userID int, todoItemID int) error {
 	// or it was already deleted
 	if rowsAffected == 0 {
 		log.Printf("Todo item not found or does not belong to user")
-		return sql.ErrNoRows
+		return fmt.Errorf("todo item %d: %w", todoItemID, ErrNotFound)
 	}
 
-	return nil
+	return tx.Commit()
 }
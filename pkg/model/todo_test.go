@@ -2,10 +2,12 @@ package model_test
 
 import (
 	"errors"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/mystardustcaptain/mattodo/pkg/database"
 	"github.com/mystardustcaptain/mattodo/pkg/model"
 	"github.com/stretchr/testify/assert"
 )
@@ -24,12 +26,22 @@ func TestGetAllTodoItems_ExecuteCorrectQuery(t *testing.T) {
 	}
 	defer db.Close()
 
-	columns := []string{"id", "user_id", "title", "completed", "created_at", "updated_at"}
-	mock.ExpectQuery("SELECT id, user_id, title, completed, created_at, updated_at FROM todos WHERE user_id = ?").
+	columns := []string{"id", "user_id", "title", "completed", "due_at", "completed_at", "priority", "position", "project_id", "parent_id", "created_at", "updated_at"}
+	mock.ExpectQuery("SELECT id, user_id, title, completed, due_at, completed_at, priority, position, project_id, parent_id, created_at, updated_at FROM todos WHERE user_id = ?").
 		WithArgs(2).
 		WillReturnRows(sqlmock.NewRows(columns).
-			AddRow(2, 2, "Todo 2", false, time.Now(), time.Now()).
-			AddRow(3, 2, "Todo 3", false, time.Now(), time.Now()))
+			AddRow(2, 2, "Todo 2", false, nil, nil, "none", "i", nil, nil, time.Now(), time.Now()).
+			AddRow(3, 2, "Todo 3", false, nil, nil, "none", "j", nil, nil, time.Now(), time.Now()))
+	// the tags of all the items are loaded at once
+	tagColumns := []string{"todo_id", "id", "user_id", "name", "color", "created_at"}
+	mock.ExpectQuery("SELECT tt.todo_id, t.id, t.user_id, t.name, t.color, t.created_at FROM todo_tags tt JOIN tags t ON t.id = tt.tag_id WHERE t.user_id = \\? ORDER BY t.name, t.id").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows(tagColumns).
+			AddRow(3, 7, 2, "work", "#1e90ff", time.Now()))
+	// the progress of their subtasks
+	mock.ExpectQuery("SELECT parent_id, COUNT\\(\\*\\), .+ FROM todos WHERE user_id = \\? AND parent_id IS NOT NULL GROUP BY parent_id").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"parent_id", "total", "completed"}))
 
 	tc := model.TodoItemCollection{DB: db}
 
@@ -53,6 +65,10 @@ func TestGetAllTodoItems_ExecuteCorrectQuery(t *testing.T) {
 	assert.Equal(t, 2, todos[1].UserID)
 	assert.Equal(t, "Todo 3", todos[1].Title)
 	assert.Equal(t, false, todos[1].Completed)
+	assert.Empty(t, todos[0].Tags)
+	assert.NotNil(t, todos[0].Tags, "Expected an empty list of tags rather than none")
+	assert.Equal(t, 1, len(todos[1].Tags))
+	assert.Equal(t, "work", todos[1].Tags[0].Name)
 
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("there were unfulfilled expectations: %s", err)
@@ -76,7 +92,7 @@ func TestGetAllTodoItems_ReturnNothingWhenQueryError(t *testing.T) {
 	// Define a custom error
 	customErr := errors.New("mock database connection error")
 
-	mock.ExpectQuery("SELECT id, user_id, title, completed, created_at, updated_at FROM todos WHERE user_id = ?").
+	mock.ExpectQuery("SELECT id, user_id, title, completed, due_at, completed_at, priority, position, project_id, parent_id, created_at, updated_at FROM todos WHERE user_id = ?").
 		WithArgs(2).
 		WillReturnError(customErr)
 
@@ -112,14 +128,14 @@ func TestGetAllTodoItems_ReturnNothingWhenScanError(t *testing.T) {
 	defer db.Close()
 
 	// Define a custom error
-	customErr := errors.New("sql: Scan error on column index 5, name \"updated_at\": unsupported Scan, storing driver.Value type string into type *time.Time")
+	customErr := errors.New("sql: Scan error on column index 11, name \"updated_at\": unsupported Scan, storing driver.Value type string into type *time.Time")
 
-	columns := []string{"id", "user_id", "title", "completed", "created_at", "updated_at"}
-	mock.ExpectQuery("SELECT id, user_id, title, completed, created_at, updated_at FROM todos WHERE user_id = ?").
+	columns := []string{"id", "user_id", "title", "completed", "due_at", "completed_at", "priority", "position", "project_id", "parent_id", "created_at", "updated_at"}
+	mock.ExpectQuery("SELECT id, user_id, title, completed, due_at, completed_at, priority, position, project_id, parent_id, created_at, updated_at FROM todos WHERE user_id = ?").
 		WithArgs(2).
 		WillReturnRows(sqlmock.NewRows(columns).
-			AddRow(2, 2, "Todo 2", false, time.Now(), time.Now()).
-			AddRow(3, 2, "Todo 3", false, time.Now(), "hi")) // This will cause an error due to the wrong type
+			AddRow(2, 2, "Todo 2", false, nil, nil, "none", "i", nil, nil, time.Now(), time.Now()).
+			AddRow(3, 2, "Todo 3", false, nil, nil, "none", "i", nil, nil, time.Now(), "hi")) // This will cause an error due to the wrong type
 
 	tc := model.TodoItemCollection{DB: db}
 
@@ -153,12 +169,28 @@ func TestCreateTodoItem_ExecuteCorrectQuery(t *testing.T) {
 	defer db.Close()
 
 	expectedTimeNow := time.Now()
+	model.Now = func() time.Time { return expectedTimeNow }
+	defer func() { model.Now = time.Now }()
 
-	// columns := []string{"id", "user_id", "title", "completed", "created_at", "updated_at"}
+	// columns := []string{"id", "user_id", "title", "completed", "due_at", "completed_at", "priority", "position", "project_id", "parent_id", "created_at", "updated_at"}
 	//mock.ExpectExec("INSERT INTO todos (user_id, title, completed, created_at, updated_at) VALUES (?, ?, ?, ?, ?)").
-	mock.ExpectExec("INSERT INTO todos \\(user_id, title, completed, created_at, updated_at\\) VALUES \\(.+\\)").
-		WithArgs(2, "Todo 2", true, expectedTimeNow, expectedTimeNow).
+	// the list of the user is locked while the todo item is added to it
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM users WHERE id = \\?").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	// the todo item goes to the inbox of the user
+	mock.ExpectQuery("SELECT id FROM projects WHERE user_id = \\? AND inbox = \\?").
+		WithArgs(2, true).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+	// the todo item is appended after the last one of the user
+	mock.ExpectQuery("SELECT MAX\\(position\\) FROM todos WHERE user_id = \\?").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow("i"))
+	mock.ExpectExec("INSERT INTO todos \\(user_id, title, completed, due_at, completed_at, priority, position, project_id, parent_id, created_at, updated_at\\) VALUES \\(.+\\)").
+		WithArgs(2, "Todo 2", true, nil, expectedTimeNow, "none", "j", 5, nil, expectedTimeNow, expectedTimeNow).
 		WillReturnResult(sqlmock.NewResult(3, 1)) // expect id 3 to be returned
+	mock.ExpectCommit()
 
 	tc := model.TodoItemCollection{DB: db}
 
@@ -186,6 +218,119 @@ func TestCreateTodoItem_ExecuteCorrectQuery(t *testing.T) {
 	assert.Equal(t, true, todo.Completed, "Expected completed to follow what user given")
 	assert.Equal(t, expectedTimeNow, todo.CreatedAt, "Expected created_at to be time of creation instead of what user given")
 	assert.Equal(t, expectedTimeNow, todo.UpdatedAt, "Expected updated_at to be time of creation instead of what user given")
+	assert.Equal(t, &expectedTimeNow, todo.CompletedAt, "Expected an item created completed to be completed at creation")
+	assert.Equal(t, "j", todo.Position, "Expected the todo item to be appended")
+	if assert.NotNil(t, todo.ProjectID, "Expected the todo item to go to the inbox") {
+		assert.Equal(t, 5, *todo.ProjectID)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestCreateTodoItem_LocksParentUntilInserted tests that CreateTodoItem locks the parent of a subtask
+// while it is checked, and inserts the subtask in the same transaction, in the project of its parent.
+func TestCreateTodoItem_LocksParentUntilInserted(t *testing.T) {
+	/// Arrange
+	///
+	// Create a new instance of sqlmock
+	db, mock, errdb := sqlmock.New()
+	if errdb != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", errdb)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM users WHERE id = \\$1 FOR UPDATE").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	mock.ExpectQuery("SELECT project_id FROM todos WHERE id = \\$1 AND user_id = \\$2 FOR UPDATE").
+		WithArgs(7, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"project_id"}).AddRow(5))
+	mock.ExpectQuery("SELECT MAX\\(position\\) FROM todos WHERE user_id = \\$1").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow("i"))
+	mock.ExpectQuery("INSERT INTO todos \\(.+\\) VALUES \\(.+\\) RETURNING id").
+		WithArgs(2, "Tickets", false, nil, nil, "none", "j", 5, 7, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(8))
+	mock.ExpectCommit()
+
+	tc := model.TodoItemCollection{DB: db, Dialect: database.Postgres}
+
+	/// Act
+	///
+	parentID := 7
+	todo := model.TodoItem{Title: "Tickets", ParentID: &parentID}
+	err := tc.CreateTodoItem(2, &todo)
+
+	/// Assert
+	///
+	assert.NoError(t, err, "Expected no error but got one")
+	assert.Equal(t, 8, todo.ID)
+	if assert.NotNil(t, todo.ProjectID, "Expected the subtask in the project of its parent") {
+		assert.Equal(t, 5, *todo.ProjectID)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestMoveTodoItem_RefusesWhenNoRoomLeft tests that MoveTodoItem reads the neighbours with the list of the user locked,
+// spreads the positions when there is no room between them,
+// and writes nothing if there is still none, rather than an empty position.
+func TestMoveTodoItem_RefusesWhenNoRoomLeft(t *testing.T) {
+	/// Arrange
+	///
+	// Create a new instance of sqlmock
+	db, mock, errdb := sqlmock.New()
+	if errdb != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", errdb)
+	}
+	defer db.Close()
+
+	// the neighbours of todo item 2 once after todo item 5, both at the same position
+	expectNeighbours := func() {
+		mock.ExpectQuery("SELECT position FROM todos WHERE id = \\$1 AND user_id = \\$2").
+			WithArgs(5, 3).
+			WillReturnRows(sqlmock.NewRows([]string{"position"}).AddRow("i"))
+		mock.ExpectQuery("SELECT position FROM todos WHERE user_id = \\$1 AND id <> \\$2 .+ LIMIT 1").
+			WithArgs(3, 2, "i", "i", 5).
+			WillReturnRows(sqlmock.NewRows([]string{"position"}).AddRow("i"))
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM users WHERE id = \\$1 FOR UPDATE").
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	mock.ExpectQuery("SELECT id FROM todos WHERE id = \\$1 AND user_id = \\$2").
+		WithArgs(2, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	expectNeighbours()
+	mock.ExpectQuery("SELECT id FROM todos WHERE user_id = \\$1 ORDER BY position, id").
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5).AddRow(2))
+	mock.ExpectExec("UPDATE todos SET position = \\$1 WHERE id = \\$2").
+		WithArgs(sqlmock.AnyArg(), 5).
+		WillReturnResult(sqlmock.NewResult(-1, 1))
+	mock.ExpectExec("UPDATE todos SET position = \\$1 WHERE id = \\$2").
+		WithArgs(sqlmock.AnyArg(), 2).
+		WillReturnResult(sqlmock.NewResult(-1, 1))
+	expectNeighbours()
+	mock.ExpectRollback()
+
+	tc := model.TodoItemCollection{DB: db, Dialect: database.Postgres}
+
+	/// Act
+	///
+	anchorID := 5
+	todo, err := tc.MoveTodoItem(3, 2, &model.TodoItemMove{AfterID: &anchorID})
+
+	/// Assert
+	///
+	assert.EqualError(t, err, "no position left in the todo list")
+	assert.Nil(t, todo, "Expected todo to be nil on error")
 
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("there were unfulfilled expectations: %s", err)
@@ -206,17 +351,22 @@ func TestMarkComplete_ExecuteCorrectQuery(t *testing.T) {
 	defer db.Close()
 
 	expectedTimeNow := time.Now() // expected MarkComplete() to update updated_at to now
+	model.Now = func() time.Time { return expectedTimeNow }
+	defer func() { model.Now = time.Now }()
 
-	mock.ExpectExec("UPDATE todos SET completed = \\?, updated_at = \\? WHERE id = \\? AND user_id = \\?").
-		WithArgs(true, expectedTimeNow, 2, 3).     //aiming for todo id 2, user id 3
-		WillReturnResult(sqlmock.NewResult(-1, 1)) // expect impacted rows to be 1
+	// completed_at is kept if the item was already completed
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE todos SET completed = \\?, completed_at = COALESCE\\(completed_at, \\?\\), updated_at = \\? WHERE id = \\? AND user_id = \\?").
+		WithArgs(true, expectedTimeNow, expectedTimeNow, 2, 3). //aiming for todo id 2, user id 3
+		WillReturnResult(sqlmock.NewResult(-1, 1))              // expect impacted rows to be 1
+	mock.ExpectCommit()
 
 	tc := model.TodoItemCollection{DB: db}
 
 	/// Act
 	///
 	// call MarkComplete on todo item id 2, user id 3
-	err := tc.MarkComplete(3, 2)
+	err := tc.MarkComplete(3, 2, false)
 	if err != nil {
 		t.Errorf("error was not expected while creating todo item: %s", err)
 	}
@@ -240,9 +390,19 @@ func TestDeleteTodoItem_ExecuteCorrectQuery(t *testing.T) {
 	}
 	defer db.Close()
 
+	mock.ExpectBegin()
+	// the item has no subtasks to delete
+	mock.ExpectQuery("SELECT id FROM todos WHERE user_id = \\? AND parent_id IN \\(\\?\\)").
+		WithArgs(3, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	// the item is detached from its tags first
+	mock.ExpectExec("DELETE FROM todo_tags WHERE todo_id IN \\(SELECT id FROM todos WHERE id = \\? AND user_id = \\?\\)").
+		WithArgs(2, 3).
+		WillReturnResult(sqlmock.NewResult(-1, 2))
 	mock.ExpectExec("DELETE FROM todos WHERE id = \\? AND user_id = \\?").
 		WithArgs(2, 3).                            //aiming for todo id 2, user id 3
 		WillReturnResult(sqlmock.NewResult(-1, 1)) // expect impacted rows to be 1
+	mock.ExpectCommit()
 
 	/// Act
 	///
@@ -262,3 +422,383 @@ func TestDeleteTodoItem_ExecuteCorrectQuery(t *testing.T) {
 		t.Errorf("there were unfulfilled expectations: %s", err)
 	}
 }
+
+// TestUpdateTodoItem_ExecuteCorrectQuery tests that UpdateTodoItem only sets the fields given,
+// bumps updated_at to now,
+// and returns the updated TodoItem.
+func TestUpdateTodoItem_ExecuteCorrectQuery(t *testing.T) {
+	/// Arrange
+	///
+	// Create a new instance of sqlmock
+	db, mock, errdb := sqlmock.New()
+	if errdb != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", errdb)
+	}
+	defer db.Close()
+
+	expectedTimeNow := time.Now() // expected UpdateTodoItem() to update updated_at to now
+	model.Now = func() time.Time { return expectedTimeNow }
+	defer func() { model.Now = time.Now }()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE todos SET title = \\?, updated_at = \\? WHERE id = \\? AND user_id = \\?").
+		WithArgs("Renamed", expectedTimeNow, 2, 3). //aiming for todo id 2, user id 3
+		WillReturnResult(sqlmock.NewResult(-1, 1))  // expect impacted rows to be 1
+	mock.ExpectCommit()
+
+	columns := []string{"id", "user_id", "title", "completed", "due_at", "completed_at", "priority", "position", "project_id", "parent_id", "created_at", "updated_at"}
+	mock.ExpectQuery("SELECT id, user_id, title, completed, due_at, completed_at, priority, position, project_id, parent_id, created_at, updated_at FROM todos WHERE id = \\? AND user_id = \\?").
+		WithArgs(2, 3).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(2, 3, "Renamed", true, nil, expectedTimeNow, "none", "i", nil, nil, expectedTimeNow, expectedTimeNow))
+	mock.ExpectQuery("SELECT tt.todo_id, .+ FROM todo_tags tt JOIN tags t ON t.id = tt.tag_id WHERE t.user_id = \\? AND tt.todo_id = \\?").
+		WithArgs(3, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"todo_id", "id", "user_id", "name", "color", "created_at"}))
+	// the progress of its subtasks
+	mock.ExpectQuery("SELECT parent_id, COUNT\\(\\*\\), .+ FROM todos WHERE user_id = \\? AND parent_id = \\? GROUP BY parent_id").
+		WithArgs(3, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"parent_id", "total", "completed"}))
+
+	tc := model.TodoItemCollection{DB: db}
+
+	/// Act
+	///
+	// call UpdateTodoItem on todo item id 2, user id 3, only changing the title
+	title := "Renamed"
+	todo, err := tc.UpdateTodoItem(3, 2, &model.TodoItemUpdate{Title: &title})
+
+	/// Assert
+	///
+	assert.NoError(t, err, "Expected no error but got one")
+	assert.Equal(t, 2, todo.ID)
+	assert.Equal(t, "Renamed", todo.Title)
+	assert.Equal(t, true, todo.Completed, "Expected completed to be left untouched")
+	assert.Equal(t, expectedTimeNow, todo.UpdatedAt)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestUpdateTodoItem_ReparentsInOneTransaction tests that UpdateTodoItem locks the todo item
+// and the ancestors of its new parent while checking them, and moves it in the same transaction.
+func TestUpdateTodoItem_ReparentsInOneTransaction(t *testing.T) {
+	/// Arrange
+	///
+	// Create a new instance of sqlmock
+	db, mock, errdb := sqlmock.New()
+	if errdb != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", errdb)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	// the todo item moved
+	mock.ExpectQuery("SELECT id FROM todos WHERE id = \\$1 AND user_id = \\$2 FOR UPDATE").
+		WithArgs(2, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	// its new parent 5, then the parent of that one, 7, a top level todo item
+	mock.ExpectQuery("SELECT parent_id FROM todos WHERE id = \\$1 AND user_id = \\$2 FOR UPDATE").
+		WithArgs(5, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"parent_id"}).AddRow(7))
+	mock.ExpectQuery("SELECT parent_id FROM todos WHERE id = \\$1 AND user_id = \\$2 FOR UPDATE").
+		WithArgs(7, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"parent_id"}).AddRow(nil))
+	mock.ExpectExec("UPDATE todos SET parent_id = \\$1, updated_at = \\$2 WHERE id = \\$3 AND user_id = \\$4").
+		WithArgs(5, sqlmock.AnyArg(), 2, 3).
+		WillReturnResult(sqlmock.NewResult(-1, 1))
+	mock.ExpectCommit()
+
+	columns := []string{"id", "user_id", "title", "completed", "due_at", "completed_at", "priority", "position", "project_id", "parent_id", "created_at", "updated_at"}
+	mock.ExpectQuery("SELECT .+ FROM todos WHERE id = \\$1 AND user_id = \\$2").
+		WithArgs(2, 3).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(2, 3, "Todo 2", false, nil, nil, "none", "i", nil, 5, time.Now(), time.Now()))
+	mock.ExpectQuery("SELECT tt.todo_id, .+ FROM todo_tags tt JOIN tags t ON t.id = tt.tag_id WHERE t.user_id = \\$1 AND tt.todo_id = \\$2").
+		WithArgs(3, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"todo_id", "id", "user_id", "name", "color", "created_at"}))
+	// the progress of its subtasks
+	mock.ExpectQuery("SELECT parent_id, COUNT\\(\\*\\), .+ FROM todos WHERE user_id = \\$1 AND parent_id = \\$2 GROUP BY parent_id").
+		WithArgs(3, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"parent_id", "total", "completed"}))
+
+	tc := model.TodoItemCollection{DB: db, Dialect: database.Postgres}
+
+	/// Act
+	///
+	parentID := 5
+	todo, err := tc.UpdateTodoItem(3, 2, &model.TodoItemUpdate{ParentID: &parentID})
+
+	/// Assert
+	///
+	assert.NoError(t, err, "Expected no error but got one")
+	assert.Equal(t, &parentID, todo.ParentID)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestUpdateTodoItem_ReturnErrorWhenNotOwned tests that UpdateTodoItem returns an error
+// when no row matches the todo item id and user id,
+// so a user cannot update someone else's todo item.
+func TestUpdateTodoItem_ReturnErrorWhenNotOwned(t *testing.T) {
+	/// Arrange
+	///
+	// Create a new instance of sqlmock
+	db, mock, errdb := sqlmock.New()
+	if errdb != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", errdb)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE todos SET completed = \\?, completed_at = NULL, updated_at = \\? WHERE id = \\? AND user_id = \\?").
+		WithArgs(false, sqlmock.AnyArg(), 2, 4).   //aiming for todo id 2, user id 4
+		WillReturnResult(sqlmock.NewResult(-1, 0)) // expect no rows impacted
+	mock.ExpectRollback()
+
+	tc := model.TodoItemCollection{DB: db}
+
+	/// Act
+	///
+	completed := false
+	todo, err := tc.UpdateTodoItem(4, 2, &model.TodoItemUpdate{Completed: &completed})
+
+	/// Assert
+	///
+	assert.Error(t, err, "Expected an error but got none")
+	assert.ErrorIs(t, err, model.ErrNotFound, "Expected a not found error")
+	assert.Nil(t, todo, "Expected todo to be nil on error")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestGetTodoItem_ReturnNotFoundWhenNoRows tests that GetTodoItem translates sql.ErrNoRows
+// into the ErrNotFound domain error.
+func TestGetTodoItem_ReturnNotFoundWhenNoRows(t *testing.T) {
+	/// Arrange
+	///
+	// Create a new instance of sqlmock
+	db, mock, errdb := sqlmock.New()
+	if errdb != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", errdb)
+	}
+	defer db.Close()
+
+	columns := []string{"id", "user_id", "title", "completed", "due_at", "completed_at", "priority", "position", "project_id", "parent_id", "created_at", "updated_at"}
+	mock.ExpectQuery("SELECT id, user_id, title, completed, due_at, completed_at, priority, position, project_id, parent_id, created_at, updated_at FROM todos WHERE id = \\? AND user_id = \\?").
+		WithArgs(2, 3).
+		WillReturnRows(sqlmock.NewRows(columns)) // no rows
+
+	tc := model.TodoItemCollection{DB: db}
+
+	/// Act
+	///
+	todo, err := tc.GetTodoItem(3, 2)
+
+	/// Assert
+	///
+	assert.ErrorIs(t, err, model.ErrNotFound, "Expected a not found error")
+	assert.Nil(t, todo, "Expected todo to be nil on error")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestValidateTitle_TrimsAndChecksLength tests that ValidateTitle trims the title,
+// and rejects empty or too long titles with a ValidationError on the title field.
+func TestValidateTitle_TrimsAndChecksLength(t *testing.T) {
+	title, err := model.ValidateTitle("  Buy milk  ")
+	assert.NoError(t, err)
+	assert.Equal(t, "Buy milk", title)
+
+	_, err = model.ValidateTitle(" \t ")
+	assert.ErrorIs(t, err, model.ErrValidation)
+
+	// the limit counts characters, not bytes
+	_, err = model.ValidateTitle(strings.Repeat("é", model.MaxTitleLength))
+	assert.NoError(t, err)
+
+	_, err = model.ValidateTitle(strings.Repeat("a", model.MaxTitleLength+1))
+	var verr *model.ValidationError
+	assert.ErrorAs(t, err, &verr)
+	assert.Contains(t, verr.Fields, "title")
+}
+
+// TestFindTodoItems_ExecuteCorrectQuery tests that FindTodoItems only adds the conditions of the filter,
+// with the times in UTC, and orders the items by due date when it is bounded.
+func TestFindTodoItems_ExecuteCorrectQuery(t *testing.T) {
+	/// Arrange
+	///
+	// Create a new instance of sqlmock
+	db, mock, errdb := sqlmock.New()
+	if errdb != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", errdb)
+	}
+	defer db.Close()
+
+	now := time.Date(2030, 1, 15, 12, 0, 0, 0, time.FixedZone("CET", 60*60))
+	due := time.Date(2030, 1, 14, 9, 0, 0, 0, time.UTC)
+
+	columns := []string{"id", "user_id", "title", "completed", "due_at", "completed_at", "priority", "position", "project_id", "parent_id", "created_at", "updated_at"}
+	mock.ExpectQuery("SELECT .+ FROM todos WHERE user_id = \\? AND due_at < \\? AND completed = \\? ORDER BY due_at, position, id").
+		WithArgs(2, now.UTC(), false).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(3, 2, "Todo 3", false, due, nil, "none", "i", nil, nil, due, due))
+	mock.ExpectQuery("SELECT tt.todo_id, .+ FROM todo_tags tt JOIN tags t ON t.id = tt.tag_id WHERE t.user_id = \\? AND tt.todo_id = \\?").
+		WithArgs(2, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"todo_id", "id", "user_id", "name", "color", "created_at"}))
+	// the progress of its subtasks
+	mock.ExpectQuery("SELECT parent_id, COUNT\\(\\*\\), .+ FROM todos WHERE user_id = \\? AND parent_id = \\? GROUP BY parent_id").
+		WithArgs(2, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"parent_id", "total", "completed"}))
+
+	tc := model.TodoItemCollection{DB: db}
+
+	/// Act
+	///
+	filter := &model.TodoFilter{}
+	filter.Overdue(now)
+	todos, err := tc.FindTodoItems(2, filter)
+
+	/// Assert
+	///
+	assert.NoError(t, err, "Expected no error but got one")
+	assert.Equal(t, 1, len(todos))
+	assert.Equal(t, &due, todos[0].DueAt)
+	assert.Nil(t, todos[0].CompletedAt)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestUpdateTodoItem_ClearsDueAt tests that UpdateTodoItem removes the due date when asked to.
+func TestUpdateTodoItem_ClearsDueAt(t *testing.T) {
+	/// Arrange
+	///
+	// Create a new instance of sqlmock
+	db, mock, errdb := sqlmock.New()
+	if errdb != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", errdb)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE todos SET due_at = NULL, updated_at = \\? WHERE id = \\? AND user_id = \\?").
+		WithArgs(sqlmock.AnyArg(), 2, 3).
+		WillReturnResult(sqlmock.NewResult(-1, 1))
+	mock.ExpectCommit()
+
+	columns := []string{"id", "user_id", "title", "completed", "due_at", "completed_at", "priority", "position", "project_id", "parent_id", "created_at", "updated_at"}
+	mock.ExpectQuery("SELECT .+ FROM todos WHERE id = \\? AND user_id = \\?").
+		WithArgs(2, 3).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(2, 3, "Todo 2", false, nil, nil, "none", "i", nil, nil, time.Now(), time.Now()))
+	mock.ExpectQuery("SELECT tt.todo_id, .+ FROM todo_tags tt JOIN tags t ON t.id = tt.tag_id WHERE t.user_id = \\? AND tt.todo_id = \\?").
+		WithArgs(3, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"todo_id", "id", "user_id", "name", "color", "created_at"}))
+	// the progress of its subtasks
+	mock.ExpectQuery("SELECT parent_id, COUNT\\(\\*\\), .+ FROM todos WHERE user_id = \\? AND parent_id = \\? GROUP BY parent_id").
+		WithArgs(3, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"parent_id", "total", "completed"}))
+
+	tc := model.TodoItemCollection{DB: db}
+
+	/// Act
+	///
+	todo, err := tc.UpdateTodoItem(3, 2, &model.TodoItemUpdate{ClearDueAt: true})
+
+	/// Assert
+	///
+	assert.NoError(t, err, "Expected no error but got one")
+	assert.Nil(t, todo.DueAt)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestTodoFilter_DueOnFollowsTimeZone tests that DueOn bounds the due date from midnight to midnight
+// in the time zone of the day given, and only ever narrows the filter.
+func TestTodoFilter_DueOnFollowsTimeZone(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when loading the time zone", err)
+	}
+
+	// 1am in UTC is still the evening before in New York
+	now := time.Date(2030, 1, 16, 1, 0, 0, 0, time.UTC)
+
+	filter := &model.TodoFilter{}
+	filter.DueOn(now.In(newYork))
+	assert.True(t, time.Date(2030, 1, 15, 5, 0, 0, 0, time.UTC).Equal(*filter.DueAfter))
+	assert.True(t, time.Date(2030, 1, 16, 5, 0, 0, 0, time.UTC).Equal(*filter.DueBefore))
+
+	// the overdue items of today are due before now
+	filter.Overdue(now)
+	assert.True(t, time.Date(2030, 1, 15, 5, 0, 0, 0, time.UTC).Equal(*filter.DueAfter))
+	assert.True(t, now.Equal(*filter.DueBefore))
+	assert.False(t, *filter.Completed)
+}
+
+// TestValidateDueAt_ConvertsToUTC tests that ValidateDueAt keeps the instant given in any time zone, in UTC,
+// and rejects the years the databases cannot store.
+func TestValidateDueAt_ConvertsToUTC(t *testing.T) {
+	due, err := model.ValidateDueAt(time.Date(2030, 1, 15, 18, 0, 0, 0, time.FixedZone("CET", 60*60)))
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2030, 1, 15, 17, 0, 0, 0, time.UTC), due)
+
+	_, err = model.ValidateDueAt(time.Date(1, 1, 1, 0, 0, 0, 0, time.UTC))
+	var verr *model.ValidationError
+	assert.ErrorAs(t, err, &verr)
+	assert.Contains(t, verr.Fields, "due_at")
+}
+
+// TestFindTodoItems_FiltersByAllTags tests that FindTodoItems selects the items having all the tags asked,
+// counting the names once whatever their case.
+func TestFindTodoItems_FiltersByAllTags(t *testing.T) {
+	/// Arrange
+	///
+	// Create a new instance of sqlmock
+	db, mock, errdb := sqlmock.New()
+	if errdb != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", errdb)
+	}
+	defer db.Close()
+
+	columns := []string{"id", "user_id", "title", "completed", "due_at", "completed_at", "priority", "position", "project_id", "parent_id", "created_at", "updated_at"}
+	mock.ExpectQuery("SELECT .+ FROM todos WHERE user_id = \\? AND id IN \\(SELECT tt.todo_id FROM todo_tags tt JOIN tags t ON t.id = tt.tag_id WHERE t.user_id = \\? AND LOWER\\(t.name\\) IN \\(\\?, \\?\\) GROUP BY tt.todo_id HAVING COUNT\\(DISTINCT t.id\\) = \\?\\) ORDER BY position, id").
+		WithArgs(2, 2, "work", "urgent", 2).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(3, 2, "Todo 3", false, nil, nil, "none", "i", nil, nil, time.Now(), time.Now()))
+	mock.ExpectQuery("SELECT tt.todo_id, .+ FROM todo_tags tt JOIN tags t ON t.id = tt.tag_id WHERE t.user_id = \\? AND tt.todo_id = \\?").
+		WithArgs(2, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"todo_id", "id", "user_id", "name", "color", "created_at"}).
+			AddRow(3, 8, 2, "urgent", "", time.Now()).
+			AddRow(3, 7, 2, "Work", "", time.Now()))
+	// the progress of its subtasks
+	mock.ExpectQuery("SELECT parent_id, COUNT\\(\\*\\), .+ FROM todos WHERE user_id = \\? AND parent_id = \\? GROUP BY parent_id").
+		WithArgs(2, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"parent_id", "total", "completed"}))
+
+	tc := model.TodoItemCollection{DB: db}
+
+	/// Act
+	///
+	todos, err := tc.FindTodoItems(2, &model.TodoFilter{Tags: []string{"Work", "urgent", "work"}, AllTags: true})
+
+	/// Assert
+	///
+	assert.NoError(t, err, "Expected no error but got one")
+	assert.Equal(t, 1, len(todos))
+	assert.Equal(t, 2, len(todos[0].Tags))
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -2,58 +2,493 @@ package model
 
 import (
 	"database/sql"
+	"encoding/json"
+	"fmt"
 	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mystardustcaptain/mattodo/pkg/database"
 )
 
 // User represents a user of the TODO application, identified by an external OAuth provider.
 type User struct {
-	ID            int    `json:"id"`
-	OAuthProvider string `json:"oauth_provider"`
-	OAuthID       string `json:"oauth_id"`
-	Name          string `json:"name"`
-	Email         string `json:"email"`
+	ID            int                    `json:"id"`
+	OAuthProvider string                 `json:"oauth_provider"`
+	OAuthID       string                 `json:"oauth_id"`
+	Name          string                 `json:"name"`
+	Email         string                 `json:"email"`
+	Preferences   map[string]interface{} `json:"preferences"`
+	// DeletionScheduledAt is when the account is purged, nil unless the user asked for its deletion
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at"`
+	// Role is RoleUser or RoleAdmin, given by an admin
+	Role string `json:"role"`
+	// SuspendedAt is when an admin suspended the account, nil unless it is suspended
+	SuspendedAt *time.Time `json:"suspended_at"`
+}
+
+// Roles of the users. Admins can manage the other users through the admin API.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// Roles are all the roles a User can be given.
+var Roles = []string{RoleUser, RoleAdmin}
+
+// ValidateRole checks that the role exists.
+func ValidateRole(role string) error {
+	for _, r := range Roles {
+		if role == r {
+			return nil
+		}
+	}
+
+	return NewValidationError("role", fmt.Sprintf("must be among %s", strings.Join(Roles, ", ")))
+}
+
+// IsAdmin reports whether the user has the admin role.
+func (u *User) IsAdmin() bool {
+	return u.Role == RoleAdmin
+}
+
+// IsSuspended reports whether the account is suspended, refusing every sign in and token of the user.
+func (u *User) IsSuspended() bool {
+	return u.SuspendedAt != nil
+}
+
+// UserFilter selects the users listed by SearchUsers.
+type UserFilter struct {
+	// Query matches the name or the email, case-insensitively, every user if empty
+	Query string
+	// Role only lists the users of the role if not empty
+	Role string
+	// Limit and Offset page through the users ordered by ID
+	Limit  int
+	Offset int
+}
+
+// matches reports whether the user is selected by the filter, paging aside
+func (f *UserFilter) matches(u *User) bool {
+	if f.Role != "" && u.Role != f.Role {
+		return false
+	}
+
+	query := strings.ToLower(f.Query)
+	return strings.Contains(strings.ToLower(u.Name), query) || strings.Contains(strings.ToLower(u.Email), query)
+}
+
+// likePattern returns a LIKE pattern matching the values containing s, escaped with '!'
+func likePattern(s string) string {
+	s = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(strings.ToLower(s))
+	return "%" + s + "%"
+}
+
+// MaxNameLength is the maximum number of characters of a User name.
+const MaxNameLength = 100
+
+// MaxPreferencesBytes is the maximum size of the preferences of a User, as JSON.
+const MaxPreferencesBytes = 4096
+
+// UserUpdate holds the fields a User can change on their profile.
+// Fields left nil are not touched.
+// Preferences are merged into the current ones, a key set to null is removed.
+type UserUpdate struct {
+	Name        *string                `json:"name"`
+	Preferences map[string]interface{} `json:"preferences"`
+}
+
+// IsEmpty reports whether the update does not change any field.
+func (u *UserUpdate) IsEmpty() bool {
+	return u.Name == nil && u.Preferences == nil
+}
+
+// Validate checks the fields given in a profile update.
+// The name, if given, is trimmed in place.
+func (u *UserUpdate) Validate() error {
+	if u.Name != nil {
+		name := strings.TrimSpace(*u.Name)
+		if name == "" {
+			return NewValidationError("name", "must not be empty")
+		}
+		if utf8.RuneCountInString(name) > MaxNameLength {
+			return NewValidationError("name", fmt.Sprintf("must be at most %d characters", MaxNameLength))
+		}
+		u.Name = &name
+	}
+
+	return nil
+}
+
+// apply applies the update to the profile of a User,
+// the merged preferences are checked against MaxPreferencesBytes
+func (u *UserUpdate) apply(user *User) error {
+	if u.Name != nil {
+		user.Name = *u.Name
+	}
+
+	if u.Preferences != nil {
+		merged := map[string]interface{}{}
+		for key, value := range user.Preferences {
+			merged[key] = value
+		}
+		for key, value := range u.Preferences {
+			if value == nil {
+				delete(merged, key)
+			} else {
+				merged[key] = value
+			}
+		}
+
+		encoded, err := json.Marshal(merged)
+		if err != nil {
+			return NewValidationError("preferences", "must be a JSON object")
+		}
+		if len(encoded) > MaxPreferencesBytes {
+			return NewValidationError("preferences", fmt.Sprintf("must be at most %d bytes", MaxPreferencesBytes))
+		}
+		user.Preferences = merged
+	}
+
+	return nil
 }
 
 type UserCollection struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Dialect database.Dialect
 }
 
-// GetUserById gets a user by Email from the database.
-// Returns nil if no user is found with error.
-// Returns a pointer to the user if found with no error.
-func (uc *UserCollection) GetUserByEmail(email string) (*User, error) {
-	query := "SELECT id, oauth_provider, oauth_id, name, email FROM users WHERE email = ?"
+// userColumns are the columns scanned by scanUser, prefixed with the alias of the users table
+func userColumns(alias string) string {
+	columns := []string{"id", "oauth_provider", "oauth_id", "name", "email", "preferences", "deletion_scheduled_at", "role", "suspended_at"}
+	for i, column := range columns {
+		columns[i] = alias + column
+	}
+
+	return strings.Join(columns, ", ")
+}
 
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanUser scans a row of userColumns into a User
+func scanUser(row rowScanner) (*User, error) {
 	u := User{}
-	err := uc.DB.QueryRow(query, email).Scan(&u.ID, &u.OAuthProvider, &u.OAuthID, &u.Name, &u.Email)
+	var preferences string
+	var deletionScheduledAt, suspendedAt sql.NullTime
+
+	if err := row.Scan(&u.ID, &u.OAuthProvider, &u.OAuthID, &u.Name, &u.Email, &preferences, &deletionScheduledAt, &u.Role, &suspendedAt); err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(preferences), &u.Preferences); err != nil {
+		log.Printf("Failed to decode preferences of user %d: %s", u.ID, err.Error())
+		return nil, err
+	}
+	if u.Preferences == nil {
+		u.Preferences = map[string]interface{}{}
+	}
+	if deletionScheduledAt.Valid {
+		u.DeletionScheduledAt = &deletionScheduledAt.Time
+	}
+	if suspendedAt.Valid {
+		u.SuspendedAt = &suspendedAt.Time
+	}
 
+	return &u, nil
+}
+
+// GetUserByID gets a user by ID from the database.
+// Returns nil if no user is found with ErrNotFound.
+func (uc *UserCollection) GetUserByID(userID int) (*User, error) {
+	query := "SELECT " + userColumns("") + " FROM users WHERE id = ?"
+
+	u, err := scanUser(uc.DB.QueryRow(uc.Dialect.Rebind(query), userID))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("user %d: %w", userID, ErrNotFound)
+	}
+	if err != nil {
+		log.Printf("Failed to get user by id: %s", err.Error())
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// GetUserByEmail gets a user by Email from the database.
+// Returns nil if no user is found with ErrNotFound.
+// Returns a pointer to the user if found with no error.
+func (uc *UserCollection) GetUserByEmail(email string) (*User, error) {
+	query := "SELECT " + userColumns("") + " FROM users WHERE email = ?"
+
+	u, err := scanUser(uc.DB.QueryRow(uc.Dialect.Rebind(query), email))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("user %s: %w", email, ErrNotFound)
+	}
 	if err != nil {
 		log.Printf("Failed to get user by email: %s", err.Error())
 		return nil, err
 	}
 
-	return &u, nil
+	return u, nil
 }
 
-// CreateUser creates a new user in the database.
+// CreateUser creates a new user in the database,
+// along with its identity at the provider it registered with (OAuthProvider + OAuthID) and its inbox project.
 // expect u to be modified with the new user's ID.
-// Returns error if the user could not be created, or if the ID could not be retrieved.
+// Returns ErrConflict if the identity is already linked to another user,
+// or error if the user could not be created, or if the ID could not be retrieved.
 func (uc *UserCollection) CreateUser(u *User) error {
-	query := "INSERT INTO users (oauth_provider, oauth_id, name, email) VALUES (?, ?, ?, ?)"
-	res, err := uc.DB.Exec(query, u.OAuthProvider, u.OAuthID, u.Name, u.Email)
+	tx, err := uc.DB.Begin()
 	if err != nil {
-		log.Printf("Failed to create user: %s", err.Error())
+		log.Printf("Failed to begin transaction: %s", err.Error())
 		return err
 	}
+	defer tx.Rollback()
+
+	if u.Preferences == nil {
+		u.Preferences = map[string]interface{}{}
+	}
+	preferences, err := json.Marshal(u.Preferences)
+	if err != nil {
+		return NewValidationError("preferences", "must be a JSON object")
+	}
+	if u.Role == "" {
+		u.Role = RoleUser
+	}
 
-	id, err := res.LastInsertId()
+	query := "INSERT INTO users (oauth_provider, oauth_id, name, email, preferences, role) VALUES (?, ?, ?, ?, ?, ?)"
+	id, err := uc.Dialect.InsertReturningID(tx, query, u.OAuthProvider, u.OAuthID, u.Name, u.Email, string(preferences), u.Role)
 	if err != nil {
-		log.Printf("Failed to get last insert id: %s", err.Error())
+		log.Printf("Failed to create user: %s", err.Error())
+		return err
+	}
+
+	identity := &UserIdentity{Provider: u.OAuthProvider, Subject: u.OAuthID, Email: u.Email}
+	if err := uc.linkIdentity(tx, int(id), identity); err != nil {
+		return err
+	}
+
+	// The todo items of the user go to their inbox, it exists as long as the user
+	now := Now()
+	query = "INSERT INTO projects (user_id, name, inbox, created_at, updated_at) VALUES (?, ?, ?, ?, ?)"
+	if _, err := tx.Exec(uc.Dialect.Rebind(query), id, InboxName, true, now, now); err != nil {
+		log.Printf("Failed to create inbox: %s", err.Error())
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Failed to commit user creation: %s", err.Error())
 		return err
 	}
 	u.ID = int(id)
 
-	return err
+	return nil
+}
+
+// UpdateUser applies a profile update to a User of a given userID and returns the updated user.
+// Returns ErrNotFound if there is no such user.
+func (uc *UserCollection) UpdateUser(userID int, update *UserUpdate) (*User, error) {
+	tx, err := uc.DB.Begin()
+	if err != nil {
+		log.Printf("Failed to begin transaction: %s", err.Error())
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Preferences are merged, the current ones are read in the same transaction
+	query := "SELECT " + userColumns("") + " FROM users WHERE id = ?"
+	u, err := scanUser(tx.QueryRow(uc.Dialect.Rebind(query), userID))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("user %d: %w", userID, ErrNotFound)
+	}
+	if err != nil {
+		log.Printf("Failed to get user by id: %s", err.Error())
+		return nil, err
+	}
+
+	if err := update.apply(u); err != nil {
+		return nil, err
+	}
+
+	preferences, _ := json.Marshal(u.Preferences)
+	query = "UPDATE users SET name = ?, preferences = ? WHERE id = ?"
+	if _, err := tx.Exec(uc.Dialect.Rebind(query), u.Name, string(preferences), userID); err != nil {
+		log.Printf("Failed to update user: %s", err.Error())
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Failed to commit user update: %s", err.Error())
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// ScheduleUserDeletion schedules the purge of a User of a given userID at the given time,
+// or cancels it with a nil time.
+// Returns ErrNotFound if there is no such user.
+func (uc *UserCollection) ScheduleUserDeletion(userID int, at *time.Time) error {
+	var scheduledAt sql.NullTime
+	if at != nil {
+		scheduledAt = sql.NullTime{Time: *at, Valid: true}
+	}
+
+	return uc.updateUserColumn("deletion_scheduled_at", scheduledAt, userID)
 }
 
-// TODO: There is currently no mechanism to DeleteUser and UpdateUser
+// SetUserRole gives a role to a User of a given userID.
+// Returns ErrNotFound if there is no such user, or a ValidationError if the role does not exist.
+func (uc *UserCollection) SetUserRole(userID int, role string) error {
+	if err := ValidateRole(role); err != nil {
+		return err
+	}
+
+	return uc.updateUserColumn("role", role, userID)
+}
+
+// SuspendUser suspends a User of a given userID from the given time, or reactivates them with a nil time.
+// Returns ErrNotFound if there is no such user.
+func (uc *UserCollection) SuspendUser(userID int, at *time.Time) error {
+	var suspendedAt sql.NullTime
+	if at != nil {
+		suspendedAt = sql.NullTime{Time: *at, Valid: true}
+	}
+
+	return uc.updateUserColumn("suspended_at", suspendedAt, userID)
+}
+
+// updateUserColumn sets a column of a User of a given userID.
+// Returns ErrNotFound if there is no such user.
+func (uc *UserCollection) updateUserColumn(column string, value interface{}, userID int) error {
+	query := "UPDATE users SET " + column + " = ? WHERE id = ?"
+
+	result, err := uc.DB.Exec(uc.Dialect.Rebind(query), value, userID)
+	if err != nil {
+		log.Printf("Failed to update user %s: %s", column, err.Error())
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("Failed to get rows affected: %s", err.Error())
+		return err
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("user %d: %w", userID, ErrNotFound)
+	}
+
+	return nil
+}
+
+// SearchUsers gets the users selected by the filter, ordered by ID.
+func (uc *UserCollection) SearchUsers(f *UserFilter) ([]*User, error) {
+	query := "SELECT " + userColumns("") + " FROM users WHERE (LOWER(name) LIKE ? ESCAPE '!' OR LOWER(email) LIKE ? ESCAPE '!')"
+	args := []interface{}{likePattern(f.Query), likePattern(f.Query)}
+	if f.Role != "" {
+		query += " AND role = ?"
+		args = append(args, f.Role)
+	}
+	query += " ORDER BY id LIMIT ? OFFSET ?"
+	args = append(args, f.Limit, f.Offset)
+
+	rows, err := uc.DB.Query(uc.Dialect.Rebind(query), args...)
+	if err != nil {
+		log.Printf("Failed to search users: %s", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []*User{}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			log.Printf("Failed to scan user: %s", err.Error())
+			return nil, err
+		}
+		users = append(users, u)
+	}
+
+	return users, rows.Err()
+}
+
+// GetUsersDueForDeletion gets the IDs of the users whose deletion was scheduled before now.
+func (uc *UserCollection) GetUsersDueForDeletion(now time.Time) ([]int, error) {
+	query := "SELECT id FROM users WHERE deletion_scheduled_at IS NOT NULL AND deletion_scheduled_at <= ? ORDER BY id"
+
+	rows, err := uc.DB.Query(uc.Dialect.Rebind(query), now)
+	if err != nil {
+		log.Printf("Failed to get users due for deletion: %s", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	userIDs := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			log.Printf("Failed to scan user id: %s", err.Error())
+			return nil, err
+		}
+		userIDs = append(userIDs, id)
+	}
+
+	return userIDs, rows.Err()
+}
+
+// userDataDeletes delete everything belonging to a user, in an order respecting the foreign keys.
+// Token revocations are left to expire, they must outlive the user to refuse its remaining tokens.
+var userDataDeletes = []string{
+	"DELETE FROM todo_tags WHERE tag_id IN (SELECT id FROM tags WHERE user_id = ?)",
+	"DELETE FROM tags WHERE user_id = ?",
+	// MySQL checks the foreign keys row by row, the subtasks must not reference their deleted parents
+	"UPDATE todos SET parent_id = NULL WHERE user_id = ?",
+	"DELETE FROM todos WHERE user_id = ?",
+	"DELETE FROM projects WHERE user_id = ?",
+	"DELETE FROM refresh_tokens WHERE user_id = ?",
+	"DELETE FROM personal_access_tokens WHERE user_id = ?",
+	"DELETE FROM oauth_states WHERE link_user_id = ?",
+	"DELETE FROM user_identities WHERE user_id = ?",
+	"DELETE FROM users WHERE id = ?",
+}
+
+// DeleteUser deletes a User of a given userID with their todo items, tags, projects, tokens and identities,
+// in one transaction.
+// Returns ErrNotFound if there is no such user.
+func (uc *UserCollection) DeleteUser(userID int) error {
+	tx, err := uc.DB.Begin()
+	if err != nil {
+		log.Printf("Failed to begin transaction: %s", err.Error())
+		return err
+	}
+	defer tx.Rollback()
+
+	var result sql.Result
+	for _, query := range userDataDeletes {
+		if result, err = tx.Exec(uc.Dialect.Rebind(query), userID); err != nil {
+			log.Printf("Failed to delete user data: %s", err.Error())
+			return err
+		}
+	}
+
+	// The last statement deletes the user itself
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("Failed to get rows affected: %s", err.Error())
+		return err
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("user %d: %w", userID, ErrNotFound)
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Failed to commit user deletion: %s", err.Error())
+		return err
+	}
+
+	return nil
+}
@@ -1,21 +1,24 @@
 package route
 
 import (
-	"database/sql"
-
 	"github.com/gorilla/mux"
 	"github.com/mystardustcaptain/mattodo/pkg/controller"
 )
 
 // InitializeRoutes initializes the routes for the application.
 // Any new routes should be registered here.
-func InitializeRoutes(db *sql.DB) *mux.Router {
+func InitializeRoutes(c *controller.Controller) *mux.Router {
 	router := mux.NewRouter()
-	c := controller.NewController(db)
 
 	c.RegisterRoutes(router)
 	c.RegisterTodoRoutes(router)
+	c.RegisterTagRoutes(router)
+	c.RegisterProjectRoutes(router)
 	c.RegisterAuthRoutes(router)
+	c.RegisterMeRoutes(router)
+	c.RegisterExportRoutes(router)
+	c.RegisterTokenRoutes(router)
+	c.RegisterAdminRoutes(router)
 
 	return router
 }